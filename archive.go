@@ -0,0 +1,439 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveWriter складывает зeркало в один архив tar, tar.gz или zip вместо
+// дерева файлов. Пока идет обход, содержимое файлов дописывается в один
+// временный файл spool, а индекс путей files и dirs заменяет дерево
+// каталогов: по нему проверяются столкновения файлов с каталогами,
+// переносы и перезаписи. Записи архива создаются в finish, по одной на
+// путь, так что распакованный архив совпадает с деревом, которое
+// получилось бы без --output-archive
+type archiveWriter struct {
+	mu    sync.Mutex
+	root  string // каталог загрузки: имена записей - пути относительно него
+	f     *os.File
+	gz    *gzip.Writer
+	tar   *tar.Writer
+	zip   *zip.Writer
+	spool *os.File // содержимое файлов, без имени на диске
+	end   int64    // конец занятой части spool
+	files map[string]archiveFile
+	dirs  map[string]bool
+}
+
+// archiveFile - содержимое файла зеркала в spool
+type archiveFile struct {
+	offset  int64
+	size    int64
+	modTime time.Time
+}
+
+// errIsDir - запись файла на место каталога
+var errIsDir = errors.New("is a directory")
+
+// newArchiveWriter создает архив; формат определяется расширением
+func newArchiveWriter(path string, root string) (*archiveWriter, error) {
+	name := strings.ToLower(path)
+	kind := ""
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		kind = "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		kind = "tar"
+	case strings.HasSuffix(name, ".zip"):
+		kind = "zip"
+	default:
+		return nil, fmt.Errorf("unknown archive format %q (want .tar.gz, .tgz, .tar or .zip)", path)
+	}
+
+	spool, err := os.CreateTemp("", "webmirror-archive-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(spool.Name())
+	f, err := os.Create(path)
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+	a := &archiveWriter{
+		root:  root,
+		f:     f,
+		spool: spool,
+		files: make(map[string]archiveFile),
+		dirs:  map[string]bool{root: true},
+	}
+	switch kind {
+	case "tar.gz":
+		a.gz = gzip.NewWriter(f)
+		a.tar = tar.NewWriter(a.gz)
+	case "tar":
+		a.tar = tar.NewWriter(f)
+	case "zip":
+		a.zip = zip.NewWriter(f)
+	}
+	return a, nil
+}
+
+// archiveInfo - fs.FileInfo файла или каталога из индекса путей
+type archiveInfo struct {
+	name string
+	file archiveFile
+	dir  bool
+}
+
+func (i archiveInfo) Name() string       { return i.name }
+func (i archiveInfo) Size() int64        { return i.file.size }
+func (i archiveInfo) ModTime() time.Time { return i.file.modTime }
+func (i archiveInfo) IsDir() bool        { return i.dir }
+func (i archiveInfo) Sys() any           { return nil }
+
+func (i archiveInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// stat ищет файл или каталог в индексе путей
+func (a *archiveWriter) stat(path string) (fs.FileInfo, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if file, ok := a.files[path]; ok {
+		return archiveInfo{name: filepath.Base(path), file: file}, nil
+	}
+	if a.dirs[path] {
+		return archiveInfo{name: filepath.Base(path), dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+}
+
+// mkdirAll отмечает каталог dir и все каталоги над ним
+func (a *archiveWriter) mkdirAll(dir string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for ; !a.dirs[dir]; dir = filepath.Dir(dir) {
+		if _, ok := a.files[dir]; ok {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+		}
+		if !withinDir(a.root, dir) {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrInvalid}
+		}
+		a.dirs[dir] = true
+	}
+	return nil
+}
+
+// reserve выделяет в spool место под size байт файла path
+func (a *archiveWriter) reserve(path string, size int64) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.dirs[path] {
+		return 0, &fs.PathError{Op: "open", Path: path, Err: errIsDir}
+	}
+	offset := a.end
+	a.end += size
+	return offset, nil
+}
+
+// publish делает записанное в spool содержимое файлом path. Прежнее
+// содержимое файла остается в spool неиспользованным
+func (a *archiveWriter) publish(path string, offset int64, size int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.files[path] = archiveFile{offset: offset, size: size, modTime: time.Now()}
+}
+
+// write сохраняет содержимое файла path. Место в spool выделяется заранее,
+// поэтому одновременные записи разных файлов друг друга не ждут
+func (a *archiveWriter) write(path string, content []byte) (int64, error) {
+	offset, err := a.reserve(path, int64(len(content)))
+	if err != nil {
+		return 0, err
+	}
+	n, err := a.spool.WriteAt(content, offset)
+	if err != nil {
+		return int64(n), err
+	}
+	a.publish(path, offset, int64(n))
+	return int64(n), nil
+}
+
+// writeFrom переносит в архив файл src с диска (скачанный .part) как
+// файл path и удаляет src
+func (a *archiveWriter) writeFrom(path string, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset, err := a.reserve(path, info.Size())
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.NewOffsetWriter(a.spool, offset), f, info.Size()); err != nil {
+		return err
+	}
+	a.publish(path, offset, info.Size())
+	f.Close()
+	return os.Remove(src)
+}
+
+// open возвращает содержимое файла path
+func (a *archiveWriter) open(path string) (*io.SectionReader, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file, ok := a.files[path]
+	if !ok {
+		err := fs.ErrNotExist
+		if a.dirs[path] {
+			err = errIsDir
+		}
+		return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+	}
+	return io.NewSectionReader(a.spool, file.offset, file.size), nil
+}
+
+// moveIntoDir переносит файл path внутрь одноименного каталога как moved
+func (a *archiveWriter) moveIntoDir(path string, moved string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.files[moved] = a.files[path]
+	delete(a.files, path)
+	a.dirs[path] = true
+}
+
+// setModTime задает время изменения записи файла path
+func (a *archiveWriter) setModTime(path string, modTime time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if file, ok := a.files[path]; ok {
+		file.modTime = modTime
+		a.files[path] = file
+	}
+}
+
+// finish записывает в архив все файлы зеркала по порядку путей, а за ними
+// файлы extra с диска (индекс и манифест), если они есть, и закрывает архив
+func (a *archiveWriter) finish(extra ...string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	paths := make([]string, 0, len(a.files))
+	for path := range a.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var err error
+	for _, path := range paths {
+		file := a.files[path]
+		if err = a.writeEntry(path, file.size, file.modTime, io.NewSectionReader(a.spool, file.offset, file.size)); err != nil {
+			break
+		}
+	}
+	for _, path := range extra {
+		if err != nil {
+			break
+		}
+		err = a.writeDiskEntry(path)
+	}
+
+	closers := []io.Closer{}
+	if a.tar != nil {
+		closers = append(closers, a.tar)
+	}
+	if a.zip != nil {
+		closers = append(closers, a.zip)
+	}
+	if a.gz != nil {
+		closers = append(closers, a.gz)
+	}
+	closers = append(closers, a.f, a.spool)
+	for _, c := range closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// writeDiskEntry добавляет в архив файл path с диска
+func (a *archiveWriter) writeDiskEntry(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return a.writeEntry(path, info.Size(), info.ModTime(), f)
+}
+
+// writeEntry добавляет запись архива. Вызывается под mu
+func (a *archiveWriter) writeEntry(path string, size int64, modTime time.Time, r io.Reader) error {
+	rel, err := filepath.Rel(a.root, path)
+	if err != nil {
+		return err
+	}
+	name := filepath.ToSlash(rel)
+
+	var w io.Writer
+	if a.tar != nil {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0644,
+			ModTime:  modTime,
+			Format:   tar.FormatPAX,
+		}
+		if err := a.tar.WriteHeader(header); err != nil {
+			return err
+		}
+		w = a.tar
+	} else {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+		header.SetMode(0644)
+		if w, err = a.zip.CreateHeader(header); err != nil {
+			return err
+		}
+	}
+	_, err = io.CopyN(w, r, size)
+	return err
+}
+
+// Файлы зеркала читаются и пишутся через методы ниже: с --output-archive
+// они лежат не на диске, а в архиве
+
+// statSaved - os.Stat для файла зеркала
+func (d *downloader) statSaved(path string) (fs.FileInfo, error) {
+	if d.archive != nil {
+		return d.archive.stat(path)
+	}
+	return os.Stat(path)
+}
+
+// lstatSaved - os.Lstat для файла зеркала
+func (d *downloader) lstatSaved(path string) (fs.FileInfo, error) {
+	if d.archive != nil {
+		return d.archive.stat(path)
+	}
+	return os.Lstat(path)
+}
+
+// mkdirSaved создает каталог зеркала со всеми каталогами над ним
+func (d *downloader) mkdirSaved(dir string) error {
+	if d.archive != nil {
+		return d.archive.mkdirAll(dir)
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// readSaved читает файл зеркала целиком
+func (d *downloader) readSaved(path string) ([]byte, error) {
+	if d.archive != nil {
+		r, err := d.archive.open(path)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+	return os.ReadFile(path)
+}
+
+// writeSaved записывает файл зеркала целиком, заменяя прежний
+func (d *downloader) writeSaved(path string, content []byte) (int64, error) {
+	if d.archive != nil {
+		return d.archive.write(path, content)
+	}
+	return saveFile(path, bytes.NewReader(content))
+}
+
+// placePart делает скачанный .part файлом зеркала path
+func (d *downloader) placePart(partPath string, path string) error {
+	if d.archive != nil {
+		return d.archive.writeFrom(path, partPath)
+	}
+	return os.Rename(partPath, path)
+}
+
+// touchSaved задает время изменения файла зеркала
+func (d *downloader) touchSaved(path string, modTime time.Time) {
+	if d.archive != nil {
+		d.archive.setModTime(path, modTime)
+		return
+	}
+	os.Chtimes(path, time.Now(), modTime)
+}
+
+// hashSaved считает SHA-256 файла зеркала. Файл хешируется целиком уже
+// сохраненным, поэтому сумма верна и для докачанных файлов
+func (d *downloader) hashSaved(path string) (string, error) {
+	var r io.Reader
+	if d.archive != nil {
+		section, err := d.archive.open(path)
+		if err != nil {
+			return "", err
+		}
+		r = section
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// partPath - путь недокачанного файла для savePath. С --output-archive
+// каталогов зеркала на диске нет, и .part лежат прямо в каталоге загрузки
+func (d *downloader) partPath(savePath string) string {
+	if d.archive != nil {
+		sum := sha256.Sum256([]byte(savePath))
+		return filepath.Join(d.downloadDir, hex.EncodeToString(sum[:8])+".part")
+	}
+	return savePath + ".part"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archiveEntry - запись архива
+type archiveEntry struct {
+	content string
+	modTime time.Time
+}
+
+// readArchive читает все записи архива tar, tar.gz или zip по порядку
+func readArchive(t *testing.T, path string) ([]string, map[string]archiveEntry) {
+	t.Helper()
+	var names []string
+	entries := make(map[string]archiveEntry)
+	add := func(name string, r io.Reader, modTime time.Time) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+		entries[name] = archiveEntry{content: string(data), modTime: modTime}
+	}
+
+	if strings.HasSuffix(path, ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			add(f.Name, r, f.Modified)
+			r.Close()
+		}
+		return names, entries
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".tar.gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			t.Errorf("%s: entry type %c, want a regular file", header.Name, header.Typeflag)
+		}
+		add(header.Name, tr, header.ModTime)
+	}
+	return names, entries
+}
+
+func TestOutputArchive(t *testing.T) {
+	srv := manifestSite(t)
+	args := []string{"-e", "robots=off", "-l", "2", "--max-file-size", "1k", "--manifest", "json", srv.URL + "/"}
+
+	// Обычное зеркало того же сайта - образец содержимого архива
+	plain := t.TempDir()
+	if _, err := testMirror(t, plain, args...); err != nil {
+		t.Fatal(err)
+	}
+	want := mirrorFiles(t, plain)
+
+	for _, ext := range []string{".tar.gz", ".tar", ".zip"} {
+		t.Run(ext, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(t.TempDir(), "mirror"+ext)
+			if _, err := testMirror(t, dir, append([]string{"--output-archive", archive}, args...)...); err != nil {
+				t.Fatal(err)
+			}
+
+			names, entries := readArchive(t, archive)
+			sorted := slices.Clone(names)
+			sort.Strings(sorted)
+			if len(slices.Compact(slices.Clone(sorted))) != len(sorted) {
+				t.Errorf("duplicate entries: %q", names)
+			}
+			if !slices.Equal(sorted, want) {
+				t.Errorf("entries:\n%q\nwant the files of a plain mirror:\n%q", sorted, want)
+			}
+
+			// Содержимое совпадает с обычным зеркалом, в том числе у
+			// страницы, перенесенной в одноименный каталог, и у страниц
+			// со ссылками, переписанными в конце обхода. В индексе и
+			// манифесте есть время загрузки, они сверяются только по имени
+			for _, name := range want {
+				if name == indexFileName || name == "manifest.json" {
+					continue
+				}
+				data, err := os.ReadFile(filepath.Join(plain, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := entries[name].content; got != string(data) {
+					t.Errorf("%s = %q, want %q", name, got, data)
+				}
+			}
+			// Манифест считает размеры и суммы по содержимому архива
+			var manifest []manifestEntry
+			if err := json.Unmarshal([]byte(entries["manifest.json"].content), &manifest); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range manifest {
+				sum := sha256.Sum256([]byte(entries[e.Path].content))
+				if e.Size != int64(len(entries[e.Path].content)) || e.SHA256 != hex.EncodeToString(sum[:]) {
+					t.Errorf("manifest entry %+v does not match the archive entry", e)
+				}
+			}
+
+			host := filepath.Base(hostDirOf(plain, srv))
+			if img := entries[host+"/img.png"]; !img.modTime.Equal(time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)) {
+				t.Errorf("img.png modified at %v, want the Last-Modified time", img.modTime)
+			}
+
+			// Файлы зеркала на диск не пишутся: в каталоге загрузки
+			// остаются только индекс и манифест, недокачанных .part нет
+			if files := mirrorFiles(t, dir); !slices.Equal(files, []string{indexFileName, "manifest.json"}) {
+				t.Errorf("download directory holds %q, want only the index and the manifest", files)
+			}
+		})
+	}
+}
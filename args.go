@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// errUsage означает, что командная строка неверна и подсказка по
+// использованию уже выведена
+var errUsage = errors.New("invalid usage")
+
+// errConfigPrinted означает, что по --print-config настройки выведены и
+// загружать ничего не нужно
+var errConfigPrinted = errors.New("configuration printed")
+
+// subcommands задают умолчания режимов работы; флаги каждой подкоманды
+// те же, что и без нее, и могут эти умолчания переопределить
+var subcommands = map[string]func(o *options){
+	// get скачивает указанные URL без обхода ссылок
+	"get": func(o *options) {
+		o.maxDepth = 0
+	},
+	// mirror зеркалирует сайт целиком вместе с ресурсами страниц
+	"mirror": func(o *options) {
+		o.maxDepth = infiniteDepth
+		o.pageRequisites = true
+	},
+	// spider проверяет ссылки, ничего не сохраняя
+	"spider": func(o *options) {
+		o.spider = true
+	},
+}
+
+// parseArgs разбирает аргументы командной строки (без имени программы) и
+// проверяет сочетания настроек до первого сетевого запроса. Возвращает
+// настройки и стартовые URL из позиционных аргументов. Ошибки разбора
+// флагов и подсказка пишутся в output, вывод --print-config - в stdout.
+// Приоритет настроек: флаги, затем переменные WEBMIRROR_*, затем файл
+// настроек, затем умолчания подкоманды
+func parseArgs(args []string, stdout io.Writer, output io.Writer) (options, []string, error) {
+	opts := defaultOptions()
+
+	// Без подкоманды поведение прежнее
+	name := "webmirror"
+	command := ""
+	if len(args) > 0 {
+		if apply, ok := subcommands[args[0]]; ok {
+			command = args[0]
+			name += " " + command
+			apply(&opts)
+			args = args[1:]
+		}
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	var commands stringList
+	fs.StringVar(&opts.configFile, "config", "", "read default options from this TOML `file` instead of ~/.config/unixwget/config.toml")
+	fs.BoolVar(&opts.printConfig, "print-config", false, "print the effective configuration after merging the config file, WEBMIRROR_* variables and flags, then exit")
+	fs.BoolVar(&opts.quiet, "q", opts.quiet, "same as --quiet")
+	fs.BoolVar(&opts.quiet, "quiet", opts.quiet, "print only errors and the final summary")
+	fs.Var(&opts.verbose, "v", "same as --verbose")
+	fs.Var(&opts.verbose, "verbose", "log every URL; repeat (-v -v) for debug output")
+	fs.BoolVar(&opts.debug, "vv", opts.debug, "same as --debug")
+	fs.BoolVar(&opts.debug, "debug", opts.debug, "also log request and response headers and why links are followed or skipped")
+	fs.StringVar(&opts.logFile, "o", opts.logFile, "same as --output-file")
+	fs.StringVar(&opts.logFile, "output-file", opts.logFile, "write the log to `file`, replacing it; errors are also printed to stderr")
+	fs.StringVar(&opts.appendLogFile, "a", opts.appendLogFile, "same as --append-output")
+	fs.StringVar(&opts.appendLogFile, "append-output", opts.appendLogFile, "append the log to `file`; errors are also printed to stderr")
+	fs.StringVar(&opts.progress, "progress", opts.progress, "progress `display`: bar (live, on a terminal), lines (a summary line every --progress-interval), none, or auto for bar on a terminal and lines otherwise")
+	fs.Var((*secondsFlag)(&opts.progressInterval), "progress-interval", "`time` between progress lines with --progress=lines")
+	fs.IntVar(&opts.progressFD, "progress-fd", opts.progressFD, "write progress events as JSON lines to file descriptor `fd` (queued, started, finished, skipped, failed, summary)")
+	fs.StringVar(&opts.progressFile, "progress-file", opts.progressFile, "write progress events as JSON lines to `file`")
+	fs.StringVar(&opts.logFormat, "log-format", opts.logFormat, "log `format`: text, or json for one JSON object per event")
+	fs.StringVar(&opts.warcFile, "warc-file", opts.warcFile, "also record every request and response in `prefix`.warc.gz")
+	fs.Var((*bytesFlag)(&opts.warcMaxSize), "warc-max-size", "start a new numbered WARC file (prefix-00000.warc.gz, ...) after `size` bytes (k, m and g suffixes allowed)")
+	fs.BoolVar(&opts.warcOnly, "warc-only", opts.warcOnly, "write only the WARC file, not the directory tree")
+	fs.BoolVar(&opts.singleFile, "single-file", opts.singleFile, "save the page given to -O as one self-contained HTML file with images, fonts, styles and scripts embedded")
+	fs.StringVar(&opts.mhtml, "mhtml", opts.mhtml, "save the page and its images, styles and scripts to `file` as an MHTML archive (- for stdout)")
+	fs.Var((*bytesFlag)(&opts.maxInlineSize), "max-inline-size", "with --single-file, keep links to resources larger than `size` bytes instead of embedding them (k, m and g suffixes allowed; 0 for no limit)")
+	fs.StringVar(&opts.outputArchive, "output-archive", opts.outputArchive, "save the mirror into one `archive` (.tar.gz, .tgz, .tar or .zip) instead of a directory tree")
+	fs.StringVar(&opts.manifest, "manifest", opts.manifest, "`format` of the manifest of downloaded files in the download directory: json, csv or none")
+	fs.StringVar(&opts.summary, "summary", opts.summary, "end-of-run summary `format`: text in the log, or json on stdout")
+	fs.Var((*sitemapFlag)(&opts.sitemap), "sitemap", "also crawl the pages listed in /sitemap.xml of each start host, or in the sitemap or sitemap index at --sitemap=`URL`; with -N, <lastmod> skips unchanged pages")
+	fs.BoolVar(&opts.robots, "robots", opts.robots, "honor robots.txt (same as -e robots=on/off)")
+	fs.Var(&commands, "e", "execute a wgetrc-style `command`, e.g. robots=off (repeatable)")
+	fs.BoolVar(&opts.spider, "spider", opts.spider, "crawl and check URLs without saving anything; prints status, size, type and URL for each")
+	fs.StringVar(&opts.spiderFormat, "spider-format", opts.spiderFormat, "output `format` for --spider: text or json")
+	fs.StringVar(&opts.brokenLinks, "broken-links", opts.brokenLinks, "write internal links that returned 404, 410 or 5xx, with the pages linking to them, to `file` (- for stdout)")
+	fs.StringVar(&opts.brokenLinksFormat, "broken-links-format", opts.brokenLinksFormat, "`format` of the --broken-links report: text or csv")
+	fs.IntVar(&opts.maxDepth, "l", opts.maxDepth, "same as --level")
+	fs.IntVar(&opts.maxDepth, "level", opts.maxDepth, "how many links to follow from the start pages: 0 for the pages only, -1 for no limit")
+	fs.IntVar(&opts.maxDepth, "depth", opts.maxDepth, "same as --level")
+	fs.BoolVar(&opts.noTrapDetection, "no-trap-detection", opts.noTrapDetection, "follow links that look like crawler traps: paths deeper than 32 segments or repeating a segment 3 times, and more than --max-query-variants queries of one path")
+	fs.IntVar(&opts.maxQueryVariants, "max-query-variants", opts.maxQueryVariants, "follow at most `number` different query strings of one path, such as calendar pages ?month=...&year=... or session IDs; 0 for no limit")
+	fs.StringVar(&opts.downloadDir, "P", opts.downloadDir, "same as --directory-prefix")
+	fs.StringVar(&opts.downloadDir, "directory-prefix", opts.downloadDir, "`directory` to save the mirror in")
+	fs.StringVar(&opts.downloadDir, "dir", opts.downloadDir, "same as --directory-prefix")
+	fs.IntVar(&opts.maxConcurrent, "concurrency", opts.maxConcurrent, "maximum `number` of simultaneous downloads across all hosts")
+	fs.StringVar(&opts.outputDocument, "O", opts.outputDocument, "write the single downloaded document to `file` (- for stdout) instead of mirroring")
+	fs.StringVar(&opts.outputDocument, "output-document", opts.outputDocument, "same as -O")
+	fs.StringVar(&opts.inputFile, "i", opts.inputFile, "read start URLs from `file`, one per line (# starts a comment); - streams them from stdin")
+	fs.StringVar(&opts.inputFile, "input-file", opts.inputFile, "same as -i")
+	fs.BoolVar(&opts.forceHTML, "F", opts.forceHTML, "same as --force-html")
+	fs.BoolVar(&opts.forceHTML, "force-html", opts.forceHTML, "treat the -i file as an HTML page and start from its links")
+	fs.IntVar(&opts.tolerateErrors, "tolerate-errors", opts.tolerateErrors, "exit successfully if at most `number` downloads failed")
+	fs.IntVar(&opts.tries, "tries", opts.tries, "maximum `number` of attempts per URL")
+	fs.Var((*secondsFlag)(&opts.retryDelay), "retry-delay", "initial `delay` before retrying a failed request, doubled on each attempt")
+	fs.Var((*secondsFlag)(&opts.waitRetry), "waitretry", "maximum `delay` between retries")
+	fs.Var((*secondsFlag)(&opts.maxRetryAfter), "max-retry-after", "upper bound for `delays` requested by Retry-After, 0 for no limit")
+	fs.BoolVar(&opts.timestamping, "N", opts.timestamping, "only re-download files that changed on the server")
+	fs.BoolVar(&opts.timestamping, "timestamping", opts.timestamping, "same as -N")
+	fs.BoolVar(&opts.noClobber, "nc", opts.noClobber, "skip files that already exist locally; their links are followed but not converted again")
+	fs.BoolVar(&opts.noClobber, "no-clobber", opts.noClobber, "same as -nc")
+	fs.BoolVar(&opts.contentDisposition, "content-disposition", opts.contentDisposition, "name files after the Content-Disposition header when the server sends one")
+	fs.BoolVar(&opts.pageRequisites, "p", opts.pageRequisites, "download images, stylesheets and scripts needed to display saved pages, even beyond the depth limit")
+	fs.BoolVar(&opts.pageRequisites, "page-requisites", opts.pageRequisites, "same as -p")
+	fs.BoolVar(&opts.noMetaRefresh, "no-meta-refresh", opts.noMetaRefresh, "do not follow <meta http-equiv=\"refresh\"> redirects in pages")
+	fs.BoolVar(&opts.noAlternateStyles, "no-alternate-stylesheets", opts.noAlternateStyles, "do not download alternate stylesheets (<link rel=\"alternate stylesheet\">); links to them point at the site")
+	fs.BoolVar(&opts.noFavicon, "no-favicon", opts.noFavicon, "do not request /favicon.ico from every mirrored host")
+	fs.BoolVar(&opts.noMetaAssets, "no-meta-assets", opts.noMetaAssets, "do not download og:image, twitter:image and other social preview assets (on other hosts they also need --span-hosts)")
+	fs.BoolVar(&opts.structuredData, "structured-data", opts.structuredData, "also download images and other files named in JSON-LD (<script type=\"application/ld+json\">) blocks and rewrite them there")
+	fs.BoolVar(&opts.scanJS, "scan-js", opts.scanJS, "also download files whose paths appear as string literals in scripts (heuristic; failures are not counted as errors)")
+	fs.BoolVar(&opts.rewriteJS, "rewrite-js", opts.rewriteJS, "with --scan-js, rewrite those string literals to paths relative to the script")
+	fs.BoolVar(&opts.extractDataURIs, "extract-data-uris", opts.extractDataURIs, "move data: URIs from pages into files under _data/ next to the page")
+	fs.Var((*bytesFlag)(&opts.dataURIMinSize), "data-uri-min-size", "with --extract-data-uris, keep data: URIs smaller than `size` bytes inline (k, m and g suffixes allowed)")
+	fs.BoolVar(&opts.respectNofollow, "respect-nofollow", opts.respectNofollow, "do not follow <a> and <area> links marked rel=\"nofollow\"; they still point at the local copy if it is downloaded another way")
+	fs.Var(&opts.robotsMeta, "respect-robots-meta", "honor nofollow in <meta name=\"robots\"> and X-Robots-Tag (default: on when robots.txt is honored)")
+	fs.BoolVar(&opts.skipNoindex, "skip-noindex", opts.skipNoindex, "with --respect-robots-meta, do not save pages marked noindex, noarchive or none; their links are still followed unless nofollow")
+	fs.BoolVar(&opts.honorCanonical, "honor-canonical", opts.honorCanonical, "save copies of a page that share a <link rel=\"canonical\"> as one file named after the canonical URL")
+	fs.BoolVar(&opts.spanHosts, "H", opts.spanHosts, "follow links to other hosts (see --domains)")
+	fs.BoolVar(&opts.spanHosts, "span-hosts", opts.spanHosts, "same as -H")
+	fs.Var((*listFlag)(&opts.domains), "D", "same as --domains")
+	fs.Var((*listFlag)(&opts.domains), "domains", "comma-separated `domains` that --span-hosts may follow, including their subdomains")
+	fs.Var((*listFlag)(&opts.excludeDomains), "exclude-domains", "comma-separated `domains` never to download from")
+	fs.Var((*listFlag)(&opts.accept), "A", "same as --accept")
+	fs.Var((*listFlag)(&opts.accept), "accept", "comma-separated file name `suffixes` or patterns (e.g. pdf,*.tar.*) to keep; pages are still crawled")
+	fs.Var((*listFlag)(&opts.reject), "R", "same as --reject")
+	fs.Var((*listFlag)(&opts.reject), "reject", "comma-separated file name `suffixes` or patterns not to keep")
+	fs.Var(&opts.acceptRegex, "accept-regex", "only download URLs matching this `regexp` (repeatable)")
+	fs.Var(&opts.rejectRegex, "reject-regex", "never download URLs matching this `regexp` (repeatable, wins over --accept-regex)")
+	fs.Var((*listFlag)(&opts.acceptMime), "accept-mime", "comma-separated content `types` to keep, e.g. image/*,application/pdf")
+	fs.Var((*listFlag)(&opts.rejectMime), "reject-mime", "comma-separated content `types` not to keep")
+	fs.BoolVar(&opts.mimeProbe, "mime-probe", opts.mimeProbe, "send HEAD first so bodies rejected by --accept-mime/--reject-mime are never started")
+	fs.BoolVar(&opts.ignoreCase, "ignore-case", opts.ignoreCase, "match --accept and --reject case-insensitively")
+	fs.BoolVar(&opts.includeSubdomains, "include-subdomains", opts.includeSubdomains, "treat every host of the start URL's registrable domain (www.example.com, static.example.com) as the start host")
+	fs.BoolVar(&opts.treatWWWAsSame, "treat-www-as-same", opts.treatWWWAsSame, "treat www.example.com and example.com as one site stored in the directory of the start host")
+	fs.StringVar(&opts.idnDirs, "idn-dirs", opts.idnDirs, "name directories of internationalized hosts in `form` ascii (punycode, xn--...) or unicode")
+	fs.StringVar(&opts.restrictFileNames, "restrict-file-names", opts.restrictFileNames, "`mode` for characters file names may not contain: unix (control characters), windows (also \\ : * \" < > |, trailing dots and device names like CON; ? becomes @), ascii (unix plus non-ASCII) or percent (windows plus ascii); they are written as %XX")
+	fs.StringVar(&opts.unicodeFileNames, "unicode-file-names", opts.unicodeFileNames, "Unicode normalization `form` of file names and links to them: nfc (composed, as on Linux and Windows), nfd (decomposed, as on older macOS) or none (as in the URL)")
+	fs.BoolVar(&opts.noParent, "np", opts.noParent, "never ascend above the directory of the start URL")
+	fs.BoolVar(&opts.noParent, "no-parent", opts.noParent, "same as -np")
+	fs.BoolVar(&opts.stripQuery, "strip-query", opts.stripQuery, "drop query strings from links, so ?page=1 and ?page=2 are fetched once")
+	fs.Var((*listFlag)(&opts.stripParams), "strip-params", "comma-separated query parameter names or `patterns` (utm_*,fbclid) to drop from links, so tracking variants of a page are fetched once")
+	fs.BoolVar(&opts.stripTracking, "strip-tracking", opts.stripTracking, "drop common tracking parameters (utm_*, fbclid, gclid, msclkid and others) from links")
+	fs.BoolVar(&opts.noSortQuery, "no-sort-query", opts.noSortQuery, "keep the order of query parameters; by default ?b=2&a=1 and ?a=1&b=2 are fetched once")
+	fs.IntVar(&opts.maxRedirects, "max-redirects", opts.maxRedirects, "maximum `number` of redirects to follow for one URL")
+	fs.StringVar(&opts.crossHostRedirects, "cross-host-redirects", opts.crossHostRedirects, "what to do with redirects to other hosts: refuse, follow (save without recursion) or recurse (only for --redirect-hosts)")
+	fs.Var((*listFlag)(&opts.redirectHosts), "redirect-hosts", "comma-separated `hosts` that --cross-host-redirects=recurse may follow")
+	fs.StringVar(&opts.loadCookies, "load-cookies", opts.loadCookies, "load cookies from a Netscape cookies.txt `file` before the first request")
+	fs.StringVar(&opts.saveCookies, "save-cookies", opts.saveCookies, "save cookies to a Netscape cookies.txt `file` after the crawl")
+	fs.BoolVar(&opts.keepSessionCookies, "keep-session-cookies", opts.keepSessionCookies, "also save session cookies with --save-cookies")
+	fs.StringVar(&opts.httpUser, "http-user", opts.httpUser, "`user` name for HTTP basic authentication on the start host")
+	fs.StringVar(&opts.httpPassword, "http-password", opts.httpPassword, "`password` for HTTP basic authentication on the start host")
+	fs.StringVar(&opts.proxy, "proxy", opts.proxy, "send requests through this proxy `URL` (http, https, socks5 or socks5h; user:password@ for proxy auth) instead of the one from the environment")
+	fs.BoolVar(&opts.noCheckCertificate, "no-check-certificate", opts.noCheckCertificate, "do not verify TLS certificates (insecure)")
+	fs.StringVar(&opts.caCertificate, "ca-certificate", opts.caCertificate, "PEM `file` with additional CA certificates to trust")
+	fs.StringVar(&opts.certificate, "certificate", opts.certificate, "client certificate PEM `file` for mutual TLS")
+	fs.StringVar(&opts.privateKey, "private-key", opts.privateKey, "private key PEM `file` for --certificate")
+	fs.BoolVar(&opts.saveCompressed, "save-compressed", opts.saveCompressed, "save response bodies exactly as sent, without undoing Content-Encoding; pages, styles and other files whose links are followed are still decoded")
+	fs.StringVar(&opts.userAgent, "user-agent", opts.userAgent, "User-Agent `string` to send; empty to send none")
+	fs.StringVar(&opts.referer, "referer", opts.referer, "send this `URL` as Referer instead of the linking page")
+	fs.BoolVar(&opts.noReferer, "no-referer", opts.noReferer, "never send a Referer header")
+	fs.Var(&opts.headers, "header", "add a `\"Name: value\"` header to every request (repeatable, later values win)")
+	fs.BoolVar(&opts.trustServerNames, "trust-server-names", opts.trustServerNames, "name redirected files after the final URL instead of the requested one")
+	fs.IntVar(&opts.hostConnections, "host-connections", opts.hostConnections, "maximum `number` of simultaneous requests to one host")
+	fs.Var((*bytesFlag)(&opts.maxFileSize), "max-file-size", "skip files larger than `size` bytes (k, m and g suffixes allowed)")
+	fs.Var((*bytesFlag)(&opts.quota), "Q", "same as --quota")
+	fs.Var((*bytesFlag)(&opts.quota), "quota", "stop starting new downloads after `size` bytes have been saved (k, m and g suffixes allowed)")
+	fs.Int64Var(&opts.maxFiles, "max-files", opts.maxFiles, "save at most `number` files; failed requests and 404s do not count, 0 for no limit")
+	fs.Var((*bytesFlag)(&opts.limitRate), "limit-rate", "limit the total download speed to `rate` bytes per second across all connections (k, m and g suffixes allowed, e.g. 500k)")
+	fs.Var((*secondsFlag)(&opts.dnsTimeout), "dns-timeout", "maximum `time` to resolve a host name, 0 for the system default")
+	fs.Var((*secondsFlag)(&opts.connectTimeout), "connect-timeout", "maximum `time` to establish a TCP connection")
+	fs.Var((*secondsFlag)(&opts.tlsTimeout), "tls-timeout", "maximum `time` for a TLS handshake")
+	fs.Var((*secondsFlag)(&opts.responseHeaderTimeout), "response-header-timeout", "maximum `time` to wait for response headers after sending a request")
+	fs.Var((*secondsFlag)(&opts.readTimeout), "read-timeout", "abort a download when no data arrives for this `time`, 0 to wait forever")
+	fs.Var((*secondsFlag)(&opts.totalTimeout), "total-timeout", "maximum `time` for a whole request including the body, 0 for no limit")
+	fs.Var((*secondsFlag)(&opts.wait), "wait", "minimum `delay` between requests to the same host (seconds or duration, e.g. 2 or 500ms)")
+	fs.Usage = func() {
+		if command != "" {
+			fmt.Fprintf(output, "Usage: ./%s [options] <URL>...\n", name)
+			fmt.Fprintf(output, "       ./%s [options] -i <file> [URL...]\n", name)
+		} else {
+			fmt.Fprintln(output, "Usage: ./webmirror [get|mirror|spider] [options] <URL>...")
+			fmt.Fprintln(output, "       ./webmirror [get|mirror|spider] [options] -i <file> [URL...]")
+			fmt.Fprintln(output, "       ./webmirror <URL> [depth] [download_dir]  (old form, without options)")
+			fmt.Fprintln(output, "       ./webmirror serve [--port N] [dir]")
+			fmt.Fprintln(output, "Commands:")
+			fmt.Fprintln(output, "  get     download the given URLs only (--level 0)")
+			fmt.Fprintln(output, "  mirror  mirror whole sites with page requisites (--level -1 -p)")
+			fmt.Fprintln(output, "  spider  check links without saving anything (--spider)")
+			fmt.Fprintln(output, "  serve   preview a downloaded mirror over HTTP")
+		}
+		fs.PrintDefaults()
+	}
+	urls, err := parseInterleaved(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return opts, nil, err
+		}
+		return opts, nil, errUsage
+	}
+
+	// Старая форма "<URL> [depth] [download_dir]" понимается, только
+	// если нет ни подкоманды, ни флагов, а второй аргумент - число
+	if command == "" && fs.NFlag() == 0 && len(urls) >= 2 && len(urls) <= 3 {
+		if depth, err := strconv.Atoi(urls[1]); err == nil {
+			opts.maxDepth = depth
+			if len(urls) == 3 {
+				opts.downloadDir = urls[2]
+			}
+			urls = urls[:1]
+		}
+	}
+
+	// Файл настроек применяется после разбора флагов: так видно, какие
+	// из них заданы явно и должны остаться как есть
+	configPath, explicit := opts.configFile, opts.configFile != ""
+	if path, ok := os.LookupEnv(envName("config")); ok && !explicit {
+		configPath, explicit = path, true
+	}
+	if !explicit {
+		configPath = defaultConfigPath()
+	}
+	if configPath != "" {
+		c, err := loadConfig(configPath, explicit)
+		if err != nil {
+			return opts, nil, fmt.Errorf("failed to read config: %v", err)
+		}
+		if c != nil {
+			if err := c.apply(fs, &opts); err != nil {
+				return opts, nil, fmt.Errorf("failed to read config: %v", err)
+			}
+		}
+	}
+	if err := applyEnv(fs, os.LookupEnv); err != nil {
+		return opts, nil, err
+	}
+
+	for _, command := range commands {
+		if err := opts.applyCommand(command); err != nil {
+			return opts, nil, fmt.Errorf("invalid -e command: %v", err)
+		}
+	}
+
+	if err := opts.validate(); err != nil {
+		return opts, nil, err
+	}
+
+	if opts.printConfig {
+		if err := writeConfig(stdout, fs, &opts); err != nil {
+			return opts, nil, err
+		}
+		return opts, nil, errConfigPrinted
+	}
+
+	if len(urls) == 0 && opts.inputFile == "" {
+		fs.Usage()
+		return opts, nil, errUsage
+	}
+
+	// --mhtml - это -O с другим форматом файла
+	if opts.mhtml != "" {
+		opts.outputDocument = opts.mhtml
+	}
+
+	// -O скачивает ровно один документ без обхода ссылок
+	if opts.outputDocument != "" {
+		if len(urls) > 1 || opts.streamInput() {
+			return opts, nil, errors.New("-O takes exactly one URL")
+		}
+		depthSet := false
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "l" || f.Name == "level" || f.Name == "depth" {
+				depthSet = true
+			}
+		})
+		if depthSet && opts.maxDepth != 0 {
+			return opts, nil, errors.New("-O downloads a single document and cannot be used with --level")
+		}
+		opts.maxDepth = 0
+	}
+
+	return opts, urls, nil
+}
+
+// parseInterleaved разбирает флаги вперемешку с позиционными аргументами,
+// чтобы работало "webmirror URL -l 2": flag останавливается на первом
+// позиционном, и разбор продолжается после него. После "--" все
+// аргументы позиционные. Отрицательное число после позиционного тоже
+// позиционное: это глубина в старой форме "<URL> -1"
+func parseInterleaved(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if consumed := args[:len(args)-len(rest)]; len(consumed) > 0 && consumed[len(consumed)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		for len(rest) > 0 && (len(rest[0]) < 2 || rest[0][0] != '-' || isNumber(rest[0])) {
+			positional = append(positional, rest[0])
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		args = rest
+	}
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// validate проверяет значения и сочетания настроек
+func (o *options) validate() error {
+	if err := validRedirectPolicy(o.crossHostRedirects); err != nil {
+		return err
+	}
+	if o.timestamping && o.noClobber {
+		return errors.New("-N and --no-clobber cannot be used together")
+	}
+	for _, p := range o.stripParams {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid --strip-params pattern %q: %v", p, err)
+		}
+	}
+	if err := validRestrictFileNames(o.restrictFileNames); err != nil {
+		return err
+	}
+	if o.unicodeFileNames != unicodeNFC && o.unicodeFileNames != unicodeNFD && o.unicodeFileNames != unicodeAsIs {
+		return fmt.Errorf("unknown --unicode-file-names %q (want %s, %s or %s)", o.unicodeFileNames, unicodeNFC, unicodeNFD, unicodeAsIs)
+	}
+	if o.maxQueryVariants < 0 {
+		return fmt.Errorf("--max-query-variants must not be negative, got %d", o.maxQueryVariants)
+	}
+	if o.idnDirs != idnDirsASCII && o.idnDirs != idnDirsUnicode {
+		return fmt.Errorf("unknown --idn-dirs %q (want %s or %s)", o.idnDirs, idnDirsASCII, idnDirsUnicode)
+	}
+	if o.spiderFormat != "text" && o.spiderFormat != "json" {
+		return fmt.Errorf("unknown --spider-format %q (want text or json)", o.spiderFormat)
+	}
+	switch o.progress {
+	case progressAuto, progressBar, progressLines, progressNone:
+	default:
+		return fmt.Errorf("unknown --progress %q (want auto, bar, lines or none)", o.progress)
+	}
+	if o.progressFD >= 0 && o.progressFile != "" {
+		return errors.New("--progress-fd and --progress-file cannot be used together")
+	}
+	if o.logFile != "" && o.appendLogFile != "" {
+		return errors.New("-o and -a cannot be used together")
+	}
+	if o.logFormat != "text" && o.logFormat != "json" {
+		return fmt.Errorf("unknown --log-format %q (want text or json)", o.logFormat)
+	}
+	if (o.warcOnly || o.warcMaxSize > 0) && o.warcFile == "" {
+		return errors.New("--warc-only and --warc-max-size require --warc-file")
+	}
+	if o.sitemap != "" && (o.outputDocument != "" || o.mhtml != "") {
+		return errors.New("--sitemap cannot be used with -O or --mhtml")
+	}
+	if o.mhtml != "" && (o.outputDocument != "" || o.singleFile) {
+		return errors.New("--mhtml cannot be combined with -O or --single-file")
+	}
+	if o.rewriteJS && !o.scanJS {
+		return errors.New("--rewrite-js requires --scan-js")
+	}
+	if o.skipNoindex && !o.respectRobotsMeta() {
+		return errors.New("--skip-noindex requires --respect-robots-meta")
+	}
+	if o.singleFile && o.outputDocument == "" {
+		return errors.New("--single-file requires -O")
+	}
+	if o.outputArchive != "" && (o.spider || o.outputDocument != "" || o.warcOnly) {
+		return errors.New("--output-archive cannot be combined with --spider, -O or --warc-only")
+	}
+	if o.warcOnly && o.outputDocument != "" {
+		return errors.New("--warc-only and -O cannot be used together")
+	}
+	switch o.manifest {
+	case manifestJSON, manifestCSV, manifestNone:
+	default:
+		return fmt.Errorf("unknown --manifest %q (want json, csv or none)", o.manifest)
+	}
+	if o.summary != "text" && o.summary != "json" {
+		return fmt.Errorf("unknown --summary %q (want text or json)", o.summary)
+	}
+	// --mhtml копируется в -O только после проверки, поэтому смотрим оба
+	if o.summary == "json" && (o.spider || o.outputDocument == stdoutDocument || o.mhtml == stdoutDocument || o.brokenLinks == "-") {
+		return errors.New("--summary=json writes to stdout and cannot be combined with --spider, -O -, --mhtml - or --broken-links -")
+	}
+	if o.brokenLinksFormat != "text" && o.brokenLinksFormat != "csv" {
+		return fmt.Errorf("unknown --broken-links-format %q (want text or csv)", o.brokenLinksFormat)
+	}
+	if o.hostConnections < 1 {
+		return errors.New("--host-connections must be at least 1")
+	}
+	if o.maxConcurrent < 1 {
+		return errors.New("--concurrency must be at least 1")
+	}
+	if o.maxDepth < infiniteDepth {
+		return fmt.Errorf("invalid depth %d: use -1 for no limit", o.maxDepth)
+	}
+	if o.outputDocument != "" && o.spider {
+		return errors.New("-O and --spider cannot be used together")
+	}
+	return nil
+}
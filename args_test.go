@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseArgsInterleaved(t *testing.T) {
+	t.Setenv("WEBMIRROR_CONFIG", "")
+	tests := []struct {
+		args  string
+		urls  string
+		depth int
+		dir   string
+	}{
+		{"-l 2 http://a/", "http://a/", 2, "downloads"},
+		{"http://a/ -l 2", "http://a/", 2, "downloads"},
+		{"http://a/ -l 2 http://b/ -P out", "http://a/ http://b/", 2, "out"},
+		{"mirror http://a/ -l 3", "http://a/", 3, "downloads"},
+		{"-l 1 -- http://a/ -P", "http://a/ -P", 1, "downloads"},
+		// Старая форма без флагов
+		{"http://a/ 2 out", "http://a/", 2, "out"},
+		{"http://a/ -1 out", "http://a/", -1, "out"},
+	}
+	for _, tt := range tests {
+		opts, urls, err := parseArgs(strings.Fields(tt.args), io.Discard, io.Discard)
+		if err != nil {
+			t.Errorf("parseArgs(%q): %v", tt.args, err)
+			continue
+		}
+		if got := strings.Join(urls, " "); got != tt.urls {
+			t.Errorf("parseArgs(%q) urls = %q, want %q", tt.args, got, tt.urls)
+		}
+		if opts.maxDepth != tt.depth || opts.downloadDir != tt.dir {
+			t.Errorf("parseArgs(%q) depth %d, dir %q; want %d, %q", tt.args, opts.maxDepth, opts.downloadDir, tt.depth, tt.dir)
+		}
+	}
+
+	if _, _, err := parseArgs([]string{"http://a/", "--no-such-flag"}, io.Discard, io.Discard); err != errUsage {
+		t.Errorf("unknown flag after URL: err = %v, want errUsage", err)
+	}
+}
+
+func TestSubcommands(t *testing.T) {
+	t.Setenv("WEBMIRROR_CONFIG", "")
+	tests := []struct {
+		args       string
+		depth      int
+		requisites bool
+		spider     bool
+	}{
+		// Без подкоманды умолчания прежние
+		{"http://a/", 1, false, false},
+		{"get http://a/", 0, false, false},
+		{"mirror http://a/", infiniteDepth, true, false},
+		{"spider http://a/", 1, false, true},
+		// Флаги подкоманды переопределяют ее умолчания
+		{"get -l 2 http://a/", 2, false, false},
+		{"mirror --level 3 http://a/", 3, true, false},
+		{"spider -p -l 0 http://a/", 0, true, true},
+		// Имя подкоманды на втором месте - обычный URL
+		{"http://a/ get", 1, false, false},
+	}
+	for _, tt := range tests {
+		opts, urls, err := parseArgs(append(strings.Fields(tt.args), "-P", t.TempDir()), io.Discard, io.Discard)
+		if err != nil {
+			t.Errorf("parseArgs(%q): %v", tt.args, err)
+			continue
+		}
+		if len(urls) == 0 || urls[0] != "http://a/" {
+			t.Errorf("parseArgs(%q) urls = %q", tt.args, urls)
+		}
+		d, err := newDownloader(urls, opts)
+		if err != nil {
+			t.Errorf("newDownloader(%q): %v", tt.args, err)
+			continue
+		}
+		if d.maxDepth != tt.depth || d.pageRequisites != tt.requisites || d.spider != tt.spider {
+			t.Errorf("%q: depth %d, requisites %v, spider %v; want %d, %v, %v", tt.args,
+				d.maxDepth, d.pageRequisites, d.spider, tt.depth, tt.requisites, tt.spider)
+		}
+	}
+
+	// Ошибки разбора называют подкоманду
+	var output strings.Builder
+	if _, _, err := parseArgs([]string{"get", "--no-such-flag", "http://a/"}, io.Discard, &output); err != errUsage {
+		t.Errorf("unknown flag: err = %v, want errUsage", err)
+	}
+	if !strings.Contains(output.String(), "webmirror get") {
+		t.Errorf("usage does not name the subcommand:\n%s", output.String())
+	}
+}
+
+func TestSummaryJSONStdout(t *testing.T) {
+	t.Setenv("WEBMIRROR_CONFIG", "")
+	tests := []struct {
+		args string
+		ok   bool
+	}{
+		{"--summary=json http://a/", true},
+		{"--summary=json -O out.html http://a/", true},
+		{"--summary=json --mhtml out.mht http://a/", true},
+		{"--summary=json --broken-links broken.txt http://a/", true},
+		{"--mhtml - http://a/", true},
+		// Итоги в JSON не должны дописываться к другому выводу в stdout
+		{"--summary=json -O - http://a/", false},
+		{"--summary=json --mhtml - http://a/", false},
+		{"--summary=json --single-file -O - http://a/", false},
+		{"--summary=json --spider http://a/", false},
+		{"--summary=json --broken-links - http://a/", false},
+	}
+	for _, tt := range tests {
+		_, _, err := parseArgs(append(strings.Fields(tt.args), "-P", t.TempDir()), io.Discard, io.Discard)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseArgs(%q): err = %v, want ok %v", tt.args, err, tt.ok)
+		}
+		if !tt.ok && err != nil && !strings.Contains(err.Error(), "stdout") {
+			t.Errorf("parseArgs(%q): err = %v, want a stdout conflict", tt.args, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// canonicalLink находит в <head> страницы <link rel="canonical"> и
+// возвращает его адрес, разрешенный от pageURL (или от <base href>)
+func canonicalLink(content []byte, pageURL *url.URL) (*url.URL, bool) {
+	base := pageURL
+	var href string
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for href == "" {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		token := z.Token()
+		switch token.Data {
+		case "body":
+			// rel=canonical действует только в <head>
+			return nil, false
+		case "base":
+			if u, err := pageURL.Parse(strings.TrimSpace(tokenAttr(token, "href"))); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+				base = u
+			}
+		case "link":
+			for _, rel := range strings.Fields(strings.ToLower(tokenAttr(token, "rel"))) {
+				if rel == "canonical" {
+					href = strings.TrimSpace(tokenAttr(token, "href"))
+				}
+			}
+		}
+	}
+	if href == "" {
+		return nil, false
+	}
+	u, err := base.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, false
+	}
+	return u, true
+}
+
+// headerCanonical находит в заголовках Link ссылку rel="canonical"
+// (RFC 8288: </a>; rel="canonical", </b>; rel=next) и возвращает ее адрес,
+// разрешенный от pageURL
+func headerCanonical(header http.Header, pageURL *url.URL) (*url.URL, bool) {
+	for _, value := range header.Values("Link") {
+		for _, link := range splitLinkHeader(value) {
+			target, params, ok := strings.Cut(link, ">")
+			if !ok || !strings.HasPrefix(target, "<") {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				key, val, _ := strings.Cut(param, "=")
+				if !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+				rels := strings.Fields(strings.ToLower(strings.Trim(strings.TrimSpace(val), `"`)))
+				if !slices.Contains(rels, "canonical") {
+					continue
+				}
+				u, err := pageURL.Parse(strings.TrimSpace(target[1:]))
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					return nil, false
+				}
+				return u, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// splitLinkHeader делит значение Link на ссылки. Запятая разделяет ссылки,
+// только если за ней идет следующая <...>, а не продолжение параметра
+func splitLinkHeader(value string) []string {
+	var links []string
+	for _, part := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(part); strings.HasPrefix(trimmed, "<") || len(links) == 0 {
+			links = append(links, trimmed)
+		} else {
+			links[len(links)-1] += "," + part
+		}
+	}
+	return links
+}
+
+// tokenAttr возвращает значение атрибута тега или пустую строку
+func tokenAttr(token html.Token, key string) string {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// canonicalTarget возвращает с --honor-canonical адрес, под которым
+// хранится страница rawURL (после редиректов - pageURL): ее rel=canonical
+// из <head> или, если его там нет, из заголовка Link, если он в пределах
+// зеркала и отличается от самой страницы
+func (d *downloader) canonicalTarget(rawURL string, content []byte, pageURL *url.URL, header http.Header) (*url.URL, bool) {
+	if !d.honorCanonical {
+		return nil, false
+	}
+	link, ok := canonicalLink(content, pageURL)
+	if !ok {
+		if link, ok = headerCanonical(header, pageURL); !ok {
+			return nil, false
+		}
+	}
+	key, ok := d.linkKey(link.String())
+	if !ok || key == rawURL || key == pageURL.String() {
+		return nil, false
+	}
+	canonical, err := url.Parse(key)
+	if err != nil || !d.inScope(canonical) {
+		return nil, false
+	}
+	return canonical, true
+}
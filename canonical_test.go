@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHeaderCanonical(t *testing.T) {
+	page, _ := url.Parse("http://example.com/post/1?ref=home")
+	tests := []struct {
+		values []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{`</post/1>; rel="canonical"`}, "http://example.com/post/1"},
+		{[]string{`<http://example.com/post/1>; rel=canonical`}, "http://example.com/post/1"},
+		{[]string{`</post/1>; REL="Canonical"`}, "http://example.com/post/1"},
+		{[]string{`</post/2>; rel="next", </post/1>; rel="canonical"`}, "http://example.com/post/1"},
+		{[]string{`</style.css>; rel=preload; as=style`, `</post/1>; title="a, b"; rel="canonical"`}, "http://example.com/post/1"},
+		{[]string{`</post/1>; rel="alternate canonical"`}, "http://example.com/post/1"},
+		{[]string{`</post/2>; rel="next"`}, ""},
+		{[]string{`<mailto:a@example.com>; rel="canonical"`}, ""},
+	}
+	for _, tt := range tests {
+		header := make(http.Header)
+		for _, v := range tt.values {
+			header.Add("Link", v)
+		}
+		got := ""
+		if u, ok := headerCanonical(header, page); ok {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("headerCanonical(%q) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+// canonicalSite - сайт с копиями страниц: у /post/1 канонический адрес
+// объявлен в <link rel="canonical">, у /post/2 - в заголовке Link, а у
+// /ext указывает на другой хост
+func canonicalSite(t *testing.T) (*httptest.Server, func(path string) int) {
+	var mu sync.Mutex
+	requested := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.RequestURI()]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/post/1">1</a> <a href="/post/1/">1/</a> <a href="/post/1?ref=home">1?ref</a>
+<a href="/post/2?ref=home">2?ref</a> <a href="/post/2">2</a> <a href="/ext">ext</a> <a href="/ext?ref=home">ext?ref</a>`))
+		case "/post/1", "/post/1/":
+			w.Write([]byte(`<html><head><link rel="canonical" href="/post/1"></head><body><p>post 1</p></body></html>`))
+		case "/post/2":
+			w.Header().Set("Link", `</post/2>; rel="canonical"`)
+			w.Write([]byte(`<p>post 2</p>`))
+		case "/ext":
+			w.Write([]byte(`<html><head><link rel="canonical" href="http://other.example/ext"></head><body><p>ext</p></body></html>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requested[path]
+	}
+}
+
+func TestHonorCanonical(t *testing.T) {
+	srv, requested := canonicalSite(t)
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "--concurrency", "1", "--honor-canonical", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	host := hostDirOf(dir, srv)
+
+	// Копии хранятся одним файлом под каноническим адресом, а канонический
+	// адрес на другом хосте не учитывается
+	var files []string
+	for _, f := range mirrorFiles(t, dir) {
+		if f != indexFileName {
+			files = append(files, strings.TrimPrefix(f, filepath.Base(host)+"/"))
+		}
+	}
+	want := []string{"ext.html", "ext?ref=home.html", "index.html", "post/1.html", "post/2.html"}
+	if !slices.Equal(files, want) {
+		t.Errorf("files %q, want %q", files, want)
+	}
+	if got := readMirrorFile(t, host, "post/1.html"); !strings.Contains(got, "post 1") {
+		t.Errorf("post/1.html = %q", got)
+	}
+	if got := readMirrorFile(t, host, "post/2.html"); !strings.Contains(got, "post 2") {
+		t.Errorf("post/2.html = %q", got)
+	}
+	for _, path := range []string{"/post/1", "/post/1/", "/post/1?ref=home", "/post/2", "/post/2?ref=home"} {
+		if n := requested(path); n > 1 {
+			t.Errorf("%s requested %d times", path, n)
+		}
+	}
+
+	// Ссылки на все копии ведут на канонический файл
+	index := readMirrorFile(t, host, "index.html")
+	for _, link := range []string{`href="post/1.html">1<`, `href="post/1.html">1/<`, `href="post/1.html">1?ref<`, `href="post/2.html">2?ref<`, `href="post/2.html">2<`} {
+		if !strings.Contains(index, link) {
+			t.Errorf("index.html has no %s:\n%s", link, index)
+		}
+	}
+}
+
+func TestHonorCanonicalOrder(t *testing.T) {
+	// Копия, найденная раньше канонической страницы, дает тот же
+	// результат: один файл под каноническим адресом
+	srv, requested := canonicalSite(t)
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "--concurrency", "1", "--honor-canonical", srv.URL+"/post/2?ref=home", srv.URL+"/post/2"); err != nil {
+		t.Fatal(err)
+	}
+	host := hostDirOf(dir, srv)
+	var files []string
+	for _, f := range mirrorFiles(t, dir) {
+		if f != indexFileName {
+			files = append(files, strings.TrimPrefix(f, filepath.Base(host)+"/"))
+		}
+	}
+	if !slices.Equal(files, []string{"post/2.html"}) {
+		t.Errorf("files %q, want only post/2.html", files)
+	}
+	if requested("/post/2?ref=home") != 1 || requested("/post/2") > 1 {
+		t.Errorf("requests: ?ref=home %d, canonical %d", requested("/post/2?ref=home"), requested("/post/2"))
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Файл и каталог с одним именем: /v1.0 сохраняется в файл v1.0, а
+// /v1.0/users - в каталог v1.0. Правило одно при любом порядке загрузки:
+// файл, на месте которого нужен каталог, хранится внутри него как
+// v1.0/index.0 (index с расширением самого файла)
+
+// dirIndex - путь файла savePath внутри одноименного каталога
+func dirIndex(savePath string) string {
+	return filepath.Join(savePath, "index"+filepath.Ext(savePath))
+}
+
+// prepareSavePath создает каталоги для файла savePath. Файлы, которые
+// мешают создать каталог, переносятся внутрь него, а если на месте самого
+// файла уже есть каталог, возвращается путь внутри каталога. При ошибке
+// путь остается прежним
+func (d *downloader) prepareSavePath(savePath string) (string, error) {
+	d.namesMutex.Lock()
+	defer d.namesMutex.Unlock()
+
+	if rel, err := filepath.Rel(d.downloadDir, filepath.Dir(savePath)); err == nil && withinDir(d.downloadDir, filepath.Dir(savePath)) {
+		dir := d.downloadDir
+		for _, s := range strings.Split(rel, string(filepath.Separator)) {
+			dir = filepath.Join(dir, s)
+			if info, err := d.lstatSaved(dir); err == nil && info.Mode().IsRegular() {
+				if err := d.moveIntoDir(dir); err != nil {
+					return savePath, err
+				}
+			}
+		}
+	}
+
+	if info, err := d.statSaved(savePath); err == nil && info.IsDir() {
+		savePath = dirIndex(savePath)
+	}
+	return savePath, d.mkdirSaved(filepath.Dir(savePath))
+}
+
+// placeFile записывает файл savePath функцией write и учитывает его в
+// индексе зеркала, а страницу (page) - и среди сохраненных страниц. Если,
+// пока файл загружался и разбирался, на его месте появился каталог, файл
+// кладется внутрь по тому же правилу, а ссылки страницы перестраиваются.
+// Все это под namesMutex, чтобы prepareSavePath не перенес файл раньше,
+// чем он учтен. Возвращает путь, под которым файл сохранен
+func (d *downloader) placeFile(rawURL string, savePath string, header http.Header, page bool, write func(path string) (int64, error)) (string, int64, error) {
+	d.namesMutex.Lock()
+	defer d.namesMutex.Unlock()
+
+	path := savePath
+	if info, err := d.statSaved(savePath); err == nil && info.IsDir() {
+		path = dirIndex(savePath)
+	}
+	n, err := write(path)
+	if err != nil {
+		return savePath, n, err
+	}
+	if path != savePath {
+		d.verbosef(logEntry{event: "saved", url: rawURL}, "Saving %s as %s: a directory of the same name appeared", savePath, path)
+		d.movedFiles[savePath] = path
+	}
+	d.recordSaved(rawURL, path, header)
+	if page {
+		d.addSavedPage(path)
+		if path != savePath {
+			d.rebaseLinks(savePath, path)
+		}
+	}
+	return path, n, nil
+}
+
+// saveContent сохраняет прочитанный целиком файл через placeFile
+func (d *downloader) saveContent(rawURL string, savePath string, content []byte, header http.Header, page bool) (string, int64, error) {
+	return d.placeFile(rawURL, savePath, header, page, func(path string) (int64, error) {
+		return d.writeSaved(path, content)
+	})
+}
+
+// moveIntoDir заменяет файл path каталогом, в который переносит сам файл.
+// Вызывается под namesMutex
+func (d *downloader) moveIntoDir(path string) error {
+	moved := dirIndex(path)
+	if d.archive != nil {
+		d.archive.moveIntoDir(path, moved)
+	} else {
+		tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp-dir")
+		if err := os.Rename(path, tmp); err != nil {
+			return fmt.Errorf("failed to move %q into a directory: %w", path, err)
+		}
+		if err := os.Mkdir(path, 0755); err != nil {
+			os.Rename(tmp, path)
+			return fmt.Errorf("failed to move %q into a directory: %w", path, err)
+		}
+		if err := os.Rename(tmp, moved); err != nil {
+			return fmt.Errorf("failed to move %q into a directory: %w", path, err)
+		}
+	}
+
+	d.verbosef(logEntry{event: "saved"}, "Moved %s to %s: a directory of the same name is needed", path, moved)
+	d.movedFiles[path] = moved
+	d.index.move(d.indexPath(path), d.indexPath(moved))
+	if d.manifest != nil {
+		d.manifest.move(d.indexPath(path), d.indexPath(moved))
+	}
+
+	d.visitedMutex.Lock()
+	page := false
+	for i, p := range d.savedPages {
+		if p == path {
+			d.savedPages[i] = moved
+			page = true
+		}
+	}
+	d.visitedMutex.Unlock()
+	if page {
+		d.rebaseLinks(path, moved)
+	}
+	return nil
+}
+
+// rebaseLinks переписывает относительные ссылки страницы, перенесенной из
+// oldPath в moved, чтобы они вели на те же файлы
+func (d *downloader) rebaseLinks(oldPath string, moved string) {
+	content, err := d.readSaved(moved)
+	if err != nil {
+		return
+	}
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return
+	}
+
+	walkLinks(doc, func(_ *html.Node, attr *html.Attribute, _ resourceKind) {
+		ref, err := url.Parse(attr.Val)
+		if err != nil || ref.IsAbs() || ref.Host != "" || ref.Path == "" || strings.HasPrefix(ref.Path, "/") {
+			return
+		}
+		target := filepath.Join(filepath.Dir(oldPath), filepath.FromSlash(ref.Path))
+		if target == oldPath {
+			target = moved
+		}
+		if relPath, err := filepath.Rel(filepath.Dir(moved), target); err == nil {
+			attr.Val = localLink(relPath, ref.Fragment)
+		}
+	})
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		d.errorf(logEntry{event: "parse", err: err}, "Failed to render HTML: %v", err)
+		return
+	}
+	if _, err := d.writeSaved(moved, buf.Bytes()); err != nil {
+		d.errorf(logEntry{event: "save", err: err}, "Failed to save %q: %v", moved, err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collisionSite - API, у которого /v1.0 - страница, а /v1.0/users.json -
+// файл в одноименном каталоге. С dirFirst ссылка на /v1.0 находится только
+// на второй глубине, поэтому каталог создается раньше файла
+func collisionSite(dirFirst bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			if dirFirst {
+				w.Write([]byte(`<a href="/v1.0/users.json">users</a><a href="/changes.html">changes</a>`))
+			} else {
+				w.Write([]byte(`<a href="/v1.0">v1.0</a>`))
+			}
+		case "/changes.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/v1.0#api">v1.0</a>`))
+		case "/v1.0":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<h1>v1.0</h1><a href="/v1.0/users.json">users</a><a href="/changes.html">changes</a>`))
+		case "/v1.0/users.json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"name": "alice"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestFileDirCollision(t *testing.T) {
+	for _, dirFirst := range []bool{false, true} {
+		srv := collisionSite(dirFirst)
+		defer srv.Close()
+		dir := t.TempDir()
+		stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "3", srv.URL+"/")
+		if err != nil || stats.Failed != 0 {
+			t.Fatalf("dirFirst=%v: Failed = %d, %v", dirFirst, stats.Failed, err)
+		}
+
+		// Правило одно при любом порядке: файл лежит внутри каталога
+		host := hostDirOf(dir, srv)
+		if got := readMirrorFile(t, host, "v1.0/users.json"); got != `[{"name": "alice"}]` {
+			t.Errorf("dirFirst=%v: v1.0/users.json = %q", dirFirst, got)
+		}
+		page := readMirrorFile(t, host, "v1.0/index.0")
+		if !strings.Contains(page, "<h1>v1.0</h1>") {
+			t.Errorf("dirFirst=%v: v1.0/index.0 = %q", dirFirst, page)
+		}
+		// Ссылки страницы в каталоге ведут на те же файлы
+		for _, want := range []string{`href="users.json"`, `href="../changes.html"`} {
+			if !strings.Contains(page, want) {
+				t.Errorf("dirFirst=%v: v1.0/index.0 has no %s:\n%s", dirFirst, want, page)
+			}
+		}
+		// И ссылки на нее ведут внутрь каталога
+		if got := readMirrorFile(t, host, "changes.html"); !strings.Contains(got, `href="v1.0/index.0#api"`) {
+			t.Errorf("dirFirst=%v: changes.html:\n%s", dirFirst, got)
+		}
+		if !dirFirst {
+			if got := readMirrorFile(t, host, "index.html"); !strings.Contains(got, `href="v1.0/index.0"`) {
+				t.Errorf("index.html:\n%s", got)
+			}
+		}
+
+		// Временные файлы переноса не остаются
+		entries, err := os.ReadDir(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if isTempFile(e.Name()) {
+				t.Errorf("dirFirst=%v: %s left behind", dirFirst, filepath.Join(host, e.Name()))
+			}
+		}
+
+		// Повторный запуск находит файлы там же и ничего не переносит заново
+		stats, err = testMirror(t, dir, "-e", "robots=off", "-l", "3", "-nc", srv.URL+"/")
+		if err != nil || stats.Failed != 0 {
+			t.Errorf("dirFirst=%v: second run: Failed = %d, %v", dirFirst, stats.Failed, err)
+		}
+	}
+}
+
+// Каталог появляется, пока файл еще загружается или разбирается: страница
+// ссылается на файл в одноименном каталоге, а длинный ответ дописывается
+// после того, как файл в каталоге уже сохранен
+func TestDirAppearsWhileSaving(t *testing.T) {
+	filler := strings.Repeat("<p>filler</p>", 100000)
+	readmeSaved := make(chan struct{})
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/v1.0">v1.0</a><a href="/files/v2.zip">archive</a><a href="/files/v2.zip/readme.txt">readme</a>`))
+		case "/v1.0":
+			// Ссылка в начале большой страницы: файл в каталоге v1.0
+			// скачивается, пока страница еще переписывается
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<h1>v1.0</h1><a href="/v1.0/users.json">users</a><a href="/">home</a>` + filler))
+		case "/v1.0/users.json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[]`))
+		case "/files/v2.zip":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("archive "))
+			w.(http.Flusher).Flush()
+			select {
+			case <-readmeSaved:
+			case <-time.After(5 * time.Second):
+			}
+			w.Write([]byte("data"))
+		case "/files/v2.zip/readme.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("readme"))
+			w.(http.Flusher).Flush()
+			once.Do(func() {
+				// Даем файлу лечь на диск, прежде чем отпустить архив
+				time.AfterFunc(100*time.Millisecond, func() { close(readmeSaved) })
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "2", srv.URL+"/")
+	if err != nil || stats.Failed != 0 {
+		t.Fatalf("Failed = %d, %v", stats.Failed, err)
+	}
+	host := hostDirOf(dir, srv)
+	page := readMirrorFile(t, host, "v1.0/index.0")
+	for _, want := range []string{"<h1>v1.0</h1>", `href="users.json"`, `href="../index.html"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("v1.0/index.0 has no %s", want)
+		}
+	}
+	if got := readMirrorFile(t, host, "files/v2.zip/index.zip"); got != "archive data" {
+		t.Errorf("files/v2.zip/index.zip = %q", got)
+	}
+	if got := readMirrorFile(t, host, "files/v2.zip/readme.txt"); got != "readme" {
+		t.Errorf("files/v2.zip/readme.txt = %q", got)
+	}
+	index := readMirrorFile(t, host, "index.html")
+	for _, want := range []string{`href="v1.0/index.0"`, `href="files/v2.zip/index.zip"`, `href="files/v2.zip/readme.txt"`} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index.html has no %s:\n%s", want, index)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// siteConfig - настройки отдельного сайта из секции [site."host"]
+type siteConfig struct {
+	wait         time.Duration
+	hasWait      bool
+	httpUser     string
+	httpPassword string
+}
+
+// configValue - значение ключа конфигурации; массив дает несколько значений
+type configValue struct {
+	key    string
+	values []string
+	line   int
+}
+
+// config - разобранный файл настроек: общие ключи и секции сайтов
+type config struct {
+	path   string
+	global []configValue
+	sites  map[string][]configValue
+}
+
+// defaultConfigPath возвращает ~/.config/unixwget/config.toml
+// (с учетом XDG_CONFIG_HOME)
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "unixwget", "config.toml")
+}
+
+// loadConfig читает файл настроек. Отсутствие файла по умолчанию не
+// ошибка: тогда возвращается nil
+func loadConfig(path string, explicit bool) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseConfig(f, path)
+}
+
+// parseConfig разбирает подмножество TOML: строки "ключ = значение",
+// где значение - строка в кавычках, число, true/false или однострочный
+// массив, и секции [site."host"]. Ключи - длинные имена флагов
+func parseConfig(r io.Reader, path string) (*config, error) {
+	c := &config{path: path, sites: make(map[string][]configValue)}
+	site := ""
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			host, err := parseSiteHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, lineNo, err)
+			}
+			site = host
+			if _, ok := c.sites[site]; !ok {
+				c.sites[site] = nil
+			}
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, lineNo)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("%s:%d: missing key", path, lineNo)
+		}
+		values, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: key %q: %v", path, lineNo, key, err)
+		}
+
+		v := configValue{key: key, values: values, line: lineNo}
+		if site != "" {
+			c.sites[site] = append(c.sites[site], v)
+		} else {
+			c.global = append(c.global, v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseSiteHeader разбирает заголовок секции [site."example.com"]
+func parseSiteHeader(line string) (string, error) {
+	name, ok := strings.CutSuffix(stripComment(line), "]")
+	if !ok {
+		return "", fmt.Errorf("unterminated section header %q", line)
+	}
+	name = strings.TrimSpace(strings.TrimPrefix(name, "["))
+
+	rest, ok := strings.CutPrefix(name, "site.")
+	if !ok {
+		return "", fmt.Errorf("unknown section %q (want [site.\"host\"])", name)
+	}
+	host, err := parseConfigString(strings.TrimSpace(rest))
+	if err != nil || host == "" {
+		return "", fmt.Errorf("invalid site name in section %q", name)
+	}
+	return strings.ToLower(host), nil
+}
+
+// parseConfigValue возвращает значения ключа в виде строк для flag.Value.Set
+func parseConfigValue(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, errors.New("missing value")
+	}
+
+	if !strings.HasPrefix(raw, "[") {
+		value, rest, err := parseConfigScalar(raw)
+		if err != nil {
+			return nil, err
+		}
+		if stripComment(rest) != "" {
+			return nil, fmt.Errorf("unexpected %q after value", strings.TrimSpace(rest))
+		}
+		return []string{value}, nil
+	}
+
+	var values []string
+	rest := strings.TrimSpace(raw[1:])
+	for {
+		if strings.HasPrefix(rest, "]") {
+			break
+		}
+		value, after, err := parseConfigScalar(rest)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		rest = strings.TrimSpace(after)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+			continue
+		}
+		if !strings.HasPrefix(rest, "]") {
+			return nil, errors.New("unterminated array")
+		}
+	}
+	if stripComment(rest[1:]) != "" {
+		return nil, fmt.Errorf("unexpected %q after array", strings.TrimSpace(rest[1:]))
+	}
+	return values, nil
+}
+
+// parseConfigScalar разбирает строку, число или true/false в начале raw
+// и возвращает остаток
+func parseConfigScalar(raw string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		for i := 1; i < len(raw); i++ {
+			switch raw[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(raw[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", raw[:i+1])
+				}
+				return value, raw[i+1:], nil
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	case strings.HasPrefix(raw, "'"):
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return raw[1 : end+1], raw[end+2:], nil
+	}
+
+	end := strings.IndexAny(raw, ",]#")
+	if end < 0 {
+		end = len(raw)
+	}
+	value := strings.TrimSpace(raw[:end])
+	if value == "" {
+		return "", "", errors.New("missing value")
+	}
+	if value != "true" && value != "false" {
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", "", fmt.Errorf("invalid value %q (strings must be quoted)", value)
+		}
+	}
+	return value, raw[end:], nil
+}
+
+// parseConfigString разбирает строку в двойных или одинарных кавычках
+func parseConfigString(raw string) (string, error) {
+	if !strings.HasPrefix(raw, `"`) && !strings.HasPrefix(raw, "'") {
+		return "", errors.New("expected a quoted string")
+	}
+	value, rest, err := parseConfigScalar(raw)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return "", fmt.Errorf("unexpected %q after string", rest)
+	}
+	return value, nil
+}
+
+// stripComment отбрасывает комментарий # в конце строки
+func stripComment(s string) string {
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// apply переносит общие ключи в флаги, которые не заданы в командной
+// строке: явные флаги важнее файла, а файл важнее умолчаний. Синонимы
+// (-l, --level, --depth) пишут в одно поле, поэтому явно заданным
+// считается поле, а не имя флага
+func (c *config) apply(fs *flag.FlagSet, opts *options) error {
+	explicit := explicitTargets(fs)
+	for _, v := range c.global {
+		f := fs.Lookup(v.key)
+		if f == nil || v.key == "config" {
+			return fmt.Errorf("%s:%d: unknown key %q", c.path, v.line, v.key)
+		}
+		if explicit[flagTarget(f)] {
+			continue
+		}
+		for _, value := range v.values {
+			if err := f.Value.Set(value); err != nil {
+				return fmt.Errorf("%s:%d: invalid value for %q: %v", c.path, v.line, v.key, err)
+			}
+		}
+	}
+
+	for host, values := range c.sites {
+		site, err := c.site(values)
+		if err != nil {
+			return err
+		}
+		opts.sites[host] = site
+	}
+	return nil
+}
+
+// flagTarget возвращает адрес поля, в которое пишет флаг
+func flagTarget(f *flag.Flag) uintptr {
+	return reflect.ValueOf(f.Value).Pointer()
+}
+
+// explicitTargets возвращает поля, заданные флагами командной строки
+func explicitTargets(fs *flag.FlagSet) map[uintptr]bool {
+	explicit := make(map[uintptr]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[flagTarget(f)] = true
+	})
+	return explicit
+}
+
+// site собирает настройки секции сайта: задержку и учетные данные
+func (c *config) site(values []configValue) (siteConfig, error) {
+	var site siteConfig
+	for _, v := range values {
+		if len(v.values) != 1 {
+			return site, fmt.Errorf("%s:%d: key %q takes a single value", c.path, v.line, v.key)
+		}
+		value := v.values[0]
+
+		switch v.key {
+		case "wait":
+			d, err := parseSeconds(value)
+			if err != nil {
+				return site, fmt.Errorf("%s:%d: invalid value for %q: %v", c.path, v.line, v.key, err)
+			}
+			site.wait, site.hasWait = d, true
+		case "http-user":
+			site.httpUser = value
+		case "http-password":
+			site.httpPassword = value
+		default:
+			return site, fmt.Errorf("%s:%d: unknown site key %q (want wait, http-user or http-password)", c.path, v.line, v.key)
+		}
+	}
+	return site, nil
+}
+
+// writeConfig выводит итоговые настройки в формате файла настроек, так
+// что вывод --print-config можно использовать как --config. Из синонимов
+// выводится самое длинное имя
+func writeConfig(w io.Writer, fs *flag.FlagSet, opts *options) error {
+	names := make(map[uintptr]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if len(f.Name) > len(names[flagTarget(f)]) {
+			names[flagTarget(f)] = f.Name
+		}
+	})
+
+	var b strings.Builder
+	fs.VisitAll(func(f *flag.Flag) {
+		if names[flagTarget(f)] != f.Name || len(f.Name) < 2 || f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+
+		var values []string
+		switch v := f.Value.(type) {
+		case *stringList:
+			values = *v
+		case *listFlag:
+			values = *v
+		default:
+			fmt.Fprintf(&b, "%s = %s\n", f.Name, configLiteral(f))
+			return
+		}
+		quoted := make([]string, len(values))
+		for i, value := range values {
+			quoted[i] = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, "%s = [%s]\n", f.Name, strings.Join(quoted, ", "))
+	})
+
+	hosts := make([]string, 0, len(opts.sites))
+	for host := range opts.sites {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		site := opts.sites[host]
+		fmt.Fprintf(&b, "\n[site.%s]\n", strconv.Quote(host))
+		if site.hasWait {
+			fmt.Fprintf(&b, "wait = %q\n", site.wait.String())
+		}
+		if site.httpUser != "" {
+			fmt.Fprintf(&b, "http-user = %q\n", site.httpUser)
+		}
+		if site.httpPassword != "" {
+			fmt.Fprintf(&b, "http-password = %q\n", site.httpPassword)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// configLiteral записывает значение флага литералом TOML: булевы значения
+// и числа без кавычек, остальное строкой
+func configLiteral(f *flag.Flag) string {
+	value := f.Value.String()
+	if getter, ok := f.Value.(flag.Getter); ok {
+		switch getter.Get().(type) {
+		case bool, int, int64, uint, uint64, float64:
+			return value
+		}
+	}
+	return strconv.Quote(value)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// httpOnlyPrefix помечает HttpOnly-куки в cookies.txt, как это делают curl и браузеры
+const httpOnlyPrefix = "#HttpOnly_"
+
+// savedCookie - кука в том виде, в каком она хранится в cookies.txt
+type savedCookie struct {
+	domain     string
+	subdomains bool
+	path       string
+	secure     bool
+	httpOnly   bool
+	expires    time.Time // нулевое время - сессионная кука
+	name       string
+	value      string
+}
+
+func (c savedCookie) key() string {
+	return c.domain + "\t" + c.path + "\t" + c.name
+}
+
+// cookieJar - обертка над cookiejar.Jar, которая помнит установленные куки,
+// чтобы их можно было сохранить: стандартный Jar не умеет их перечислять
+type cookieJar struct {
+	jar *cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]savedCookie
+}
+
+func newCookieJar() (*cookieJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	return &cookieJar{jar: jar, cookies: make(map[string]savedCookie)}, nil
+}
+
+func (j *cookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	host := asciiHost(u.Hostname())
+	for _, c := range cookies {
+		// Куку, которую отверг Jar, не сохраняем: иначе после
+		// --load-cookies она ушла бы хостам, которые ее не ставили
+		domain, hostOnly, ok := cookieDomain(host, c.Domain)
+		if !ok {
+			continue
+		}
+		sc := savedCookie{
+			domain:   domain,
+			path:     c.Path,
+			secure:   c.Secure,
+			httpOnly: c.HttpOnly,
+			expires:  c.Expires,
+			name:     c.Name,
+			value:    c.Value,
+		}
+		if !hostOnly {
+			sc.domain = "." + domain
+			sc.subdomains = true
+		}
+		if sc.path == "" || !strings.HasPrefix(sc.path, "/") {
+			sc.path = defaultCookiePath(u.Path)
+		}
+
+		switch {
+		case c.MaxAge < 0:
+			delete(j.cookies, sc.key())
+			continue
+		case c.MaxAge > 0:
+			sc.expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		if !sc.expires.IsZero() && !sc.expires.After(now) {
+			delete(j.cookies, sc.key())
+			continue
+		}
+
+		j.cookies[sc.key()] = sc
+	}
+}
+
+// cookieDomain проверяет атрибут Domain куки от хоста host так же, как
+// cookiejar.Jar (RFC 6265, раздел 5.3): возвращает домен куки и признак
+// куки только для этого хоста. Чужой домен и публичный суффикс (co.uk)
+// не принимаются
+func cookieDomain(host string, domain string) (string, bool, bool) {
+	if domain == "" {
+		return host, true, true
+	}
+	if net.ParseIP(host) != nil {
+		return host, true, domain == host
+	}
+	domain = strings.TrimPrefix(domain, ".")
+	if domain == "" || domain[0] == '.' || domain[len(domain)-1] == '.' {
+		return "", false, false
+	}
+	domain = asciiHost(domain)
+	if ps, _ := publicsuffix.PublicSuffix(domain); ps != "" && !strings.HasSuffix(domain, "."+ps) {
+		// Кука на публичный суффикс допустима только от него самого
+		return host, true, host == domain
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+	return domain, false, true
+}
+
+// defaultCookiePath вычисляет путь куки по RFC 6265, раздел 5.1.4
+func defaultCookiePath(urlPath string) string {
+	if urlPath == "" || urlPath[0] != '/' {
+		return "/"
+	}
+	dir := path.Dir(urlPath)
+	if dir == "." {
+		return "/"
+	}
+	return dir
+}
+
+// load читает cookies.txt в формате Netscape. Просроченные куки пропускаются
+func (j *cookieJar) load(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cookies, err := parseCookiesTxt(f, time.Now())
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	for _, sc := range cookies {
+		u := &url.URL{Scheme: "http", Host: strings.TrimPrefix(sc.domain, "."), Path: sc.path}
+		if sc.secure {
+			u.Scheme = "https"
+		}
+
+		c := &http.Cookie{
+			Name:     sc.name,
+			Value:    sc.value,
+			Path:     sc.path,
+			Secure:   sc.secure,
+			HttpOnly: sc.httpOnly,
+			Expires:  sc.expires,
+		}
+		if sc.subdomains {
+			c.Domain = sc.domain
+		}
+		j.SetCookies(u, []*http.Cookie{c})
+	}
+	return nil
+}
+
+func parseCookiesTxt(r io.Reader, now time.Time) ([]savedCookie, error) {
+	var cookies []savedCookie
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := false
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+			httpOnly = true
+		} else if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			// Кука с пустым значением может потерять последний столбец
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", lineNo, len(fields))
+		}
+
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", lineNo, fields[4])
+		}
+
+		sc := savedCookie{
+			domain:     strings.ToLower(fields[0]),
+			subdomains: strings.EqualFold(fields[1], "TRUE"),
+			path:       fields[2],
+			secure:     strings.EqualFold(fields[3], "TRUE"),
+			httpOnly:   httpOnly,
+			name:       fields[5],
+			value:      fields[6],
+		}
+		if expiry > 0 {
+			sc.expires = time.Unix(expiry, 0)
+			if !sc.expires.After(now) {
+				continue
+			}
+		}
+		cookies = append(cookies, sc)
+	}
+	return cookies, scanner.Err()
+}
+
+// save записывает куки в cookies.txt. Сессионные куки сохраняются только
+// при keepSession, просроченные - никогда
+func (j *cookieJar) save(filename string, keepSession bool) error {
+	now := time.Now()
+
+	j.mu.Lock()
+	cookies := make([]savedCookie, 0, len(j.cookies))
+	for _, sc := range j.cookies {
+		if sc.expires.IsZero() && !keepSession {
+			continue
+		}
+		if !sc.expires.IsZero() && !sc.expires.After(now) {
+			continue
+		}
+		cookies = append(cookies, sc)
+	}
+	j.mu.Unlock()
+
+	sort.Slice(cookies, func(a, b int) bool {
+		return cookies[a].key() < cookies[b].key()
+	})
+
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n")
+	b.WriteString("# Generated by webmirror. Edit at your own risk.\n\n")
+	for _, sc := range cookies {
+		if sc.httpOnly {
+			b.WriteString(httpOnlyPrefix)
+		}
+		var expiry int64
+		if !sc.expires.IsZero() {
+			expiry = sc.expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			sc.domain, netscapeBool(sc.subdomains), sc.path, netscapeBool(sc.secure), expiry, sc.name, sc.value)
+	}
+
+	return os.WriteFile(filename, []byte(b.String()), 0600)
+}
+
+func netscapeBool(v bool) string {
+	if v {
+		return "TRUE"
+	}
+	return "FALSE"
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func cookieNames(cookies []*http.Cookie) string {
+	names := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestCookieJarSaveLoad(t *testing.T) {
+	jar, err := newCookieJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	jar.SetCookies(mustParseURL(t, "http://www.example.com/docs/page"), []*http.Cookie{
+		{Name: "hostonly", Value: "1", Expires: expires},
+		{Name: "domain", Value: "2", Domain: "example.com", Path: "/", Expires: expires},
+		{Name: "secure", Value: "3", Path: "/", Secure: true, HttpOnly: true, Expires: expires},
+		{Name: "session", Value: "4", Path: "/"},
+		// Эти куки Jar отвергает, и в файл они попасть не должны
+		{Name: "foreign", Value: "5", Domain: "other.org", Path: "/", Expires: expires},
+		{Name: "suffix", Value: "6", Domain: ".com", Path: "/", Expires: expires},
+		{Name: "sibling", Value: "7", Domain: "api.example.com", Path: "/", Expires: expires},
+	})
+
+	file := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := jar.save(file, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"session", "foreign", "suffix", "sibling"} {
+		if strings.Contains(string(data), "\t"+name+"\t") {
+			t.Errorf("cookie %q saved:\n%s", name, data)
+		}
+	}
+
+	loaded, err := newCookieJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.load(file); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://www.example.com/docs/other", "domain,hostonly"},
+		{"https://www.example.com/", "domain,secure"},
+		{"http://www.example.com/", "domain"},
+		{"http://sub.example.com/docs/", "domain"},
+		{"http://api.example.com/", "domain"},
+		{"http://other.org/", ""},
+		{"http://example.net/", ""},
+	}
+	for _, tt := range tests {
+		if got := cookieNames(loaded.Cookies(mustParseURL(t, tt.url))); got != tt.want {
+			t.Errorf("Cookies(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestCookieJarKeepSession(t *testing.T) {
+	jar, err := newCookieJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := mustParseURL(t, "http://example.com/")
+	jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "1"}})
+
+	file := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := jar.save(file, true); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := newCookieJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.load(file); err != nil {
+		t.Fatal(err)
+	}
+	if got := cookieNames(loaded.Cookies(u)); got != "session" {
+		t.Errorf("Cookies = %q, want session", got)
+	}
+}
+
+func TestCookieDomain(t *testing.T) {
+	tests := []struct {
+		host, domain string
+		want         string
+		hostOnly, ok bool
+	}{
+		{"www.example.com", "", "www.example.com", true, true},
+		{"www.example.com", "example.com", "example.com", false, true},
+		{"www.example.com", ".EXAMPLE.com", "example.com", false, true},
+		{"example.com", "example.com", "example.com", false, true},
+		{"www.example.com", "other.org", "", false, false},
+		{"www.example.com", "com", "", false, false},
+		{"www.example.co.uk", "co.uk", "", false, false},
+		{"www.example.com", "ww.example.com", "", false, false},
+		{"www.example.com", "example.com.", "", false, false},
+		{"127.0.0.1", "127.0.0.1", "127.0.0.1", true, true},
+		{"127.0.0.1", "0.0.1", "127.0.0.1", true, false},
+	}
+	for _, tt := range tests {
+		domain, hostOnly, ok := cookieDomain(tt.host, tt.domain)
+		if ok != tt.ok || (ok && (domain != tt.want || hostOnly != tt.hostOnly)) {
+			t.Errorf("cookieDomain(%q, %q) = %q, %v, %v; want %q, %v, %v", tt.host, tt.domain, domain, hostOnly, ok, tt.want, tt.hostOnly, tt.ok)
+		}
+	}
+}
+
+func TestParseCookiesTxt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	input := "# Netscape HTTP Cookie File\n" +
+		"\n" +
+		"example.com\tFALSE\t/\tFALSE\t0\tsession\tv\n" +
+		"#HttpOnly_.example.com\tTRUE\t/\tTRUE\t1800000000\tid\tabc\n" +
+		".example.com\tTRUE\t/\tFALSE\t1600000000\texpired\tx\n" +
+		"example.com\tFALSE\t/\tFALSE\t0\tempty\n"
+	cookies, err := parseCookiesTxt(strings.NewReader(input), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 3 {
+		t.Fatalf("got %d cookies, want 3: %+v", len(cookies), cookies)
+	}
+	if c := cookies[1]; !c.httpOnly || !c.secure || !c.subdomains || c.domain != ".example.com" || c.value != "abc" {
+		t.Errorf("HttpOnly cookie = %+v", c)
+	}
+	if c := cookies[2]; c.name != "empty" || c.value != "" {
+		t.Errorf("cookie without value = %+v", c)
+	}
+
+	if _, err := parseCookiesTxt(strings.NewReader("example.com\tFALSE\t/\n"), now); err == nil {
+		t.Error("short line accepted")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// cssURLPattern находит ссылки в CSS: url(...) в любых кавычках или без
+// них и @import "..." без url(). Внутри ссылки допускаются экранированные
+// символы, в том числе кавычки и скобки
+var cssURLPattern = regexp.MustCompile(`url\(\s*(?:"((?:[^"\\]|\\.)*)"|'((?:[^'\\]|\\.)*)'|((?:[^)'"\s\\]|\\[0-9a-fA-F]{1,6}\s?|\\.)*))\s*\)|@import\s+(?:"((?:[^"\\]|\\.)*)"|'((?:[^'\\]|\\.)*)')`)
+
+// rewriteCSSURLs заменяет каждую ссылку в CSS на результат fn. fn
+// получает ссылку с раскрытым экранированием CSS. Ссылки data:, пустые и
+// на фрагмент того же документа (url(#id) в SVG) не трогаются, как и те,
+// что fn вернула без изменений
+func rewriteCSSURLs(css string, fn func(ref string) string) string {
+	return cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		groups := cssURLPattern.FindStringSubmatch(match)
+		ref := unescapeCSS(strings.TrimSpace(groups[1] + groups[2] + groups[3] + groups[4] + groups[5]))
+		if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(strings.ToLower(ref), "data:") {
+			return match
+		}
+		replaced := fn(ref)
+		if replaced == ref {
+			return match
+		}
+
+		// Кавычки остаются прежними: в атрибуте style двойные кавычки
+		// превратились бы в &#34;
+		quote := `"`
+		switch {
+		case groups[2] != "" || groups[5] != "":
+			quote = `'`
+		case groups[3] != "":
+			quote = ""
+		}
+		if quote == "" && strings.ContainsAny(replaced, " \t\n()'\"\\") {
+			quote = `"`
+		}
+		replaced = strings.NewReplacer(`"`, "%22", `'`, "%27", `\`, "%5C").Replace(replaced)
+		if strings.HasPrefix(match, "@import") {
+			return "@import " + quote + replaced + quote
+		}
+		return "url(" + quote + replaced + quote + ")"
+	})
+}
+
+// unescapeCSS раскрывает экранирование CSS: \" или \) - сам символ,
+// \26 и \000026 - символ с этим шестнадцатеричным кодом (пробел после
+// кода - часть экранирования), \ перед переводом строки - перенос
+func unescapeCSS(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		j := i
+		for j < len(s) && j-i < 6 && isHexDigit(s[j]) {
+			j++
+		}
+		if j == i {
+			if s[i] != '\n' {
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		code, _ := strconv.ParseUint(s[i:j], 16, 32)
+		if code == 0 || code > unicode.MaxRune {
+			code = unicode.ReplacementChar
+		}
+		b.WriteRune(rune(code))
+		if j < len(s) && (s[j] == ' ' || s[j] == '\t' || s[j] == '\n') {
+			j++
+		}
+		i = j - 1
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// cssRefs вызывает fn для каждой ссылки в CSS
+func cssRefs(css string, fn func(ref string)) {
+	rewriteCSSURLs(css, func(ref string) string {
+		fn(ref)
+		return ref
+	})
+}
+
+// walkStyles заменяет текст каждого <style> и атрибута style документа
+// на результат fn
+func walkStyles(n *html.Node, fn func(css string) string) {
+	if n.Type == html.ElementNode {
+		if n.Data == "noscript" {
+			walkNoscript(n, func(c *html.Node) { walkStyles(c, fn) })
+		}
+		if n.Data == "style" {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.TextNode {
+					c.Data = fn(c.Data)
+				}
+			}
+		}
+		for i := range n.Attr {
+			if n.Attr[i].Key == "style" && n.Attr[i].Val != "" {
+				n.Attr[i].Val = fn(n.Attr[i].Val)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkStyles(c, fn)
+	}
+}
+
+// processCSS ставит в очередь картинки, шрифты и импортированные стили из
+// CSS как ресурсы страницы и переписывает ссылки на них относительно
+// файла savePath. Ссылки в CSS разрешаются относительно baseURL - адреса
+// самого стиля, а для <style> и style - адреса страницы
+func (d *downloader) processCSS(css string, baseURL *url.URL, savePath string, depth int) string {
+	// Нужны ли ресурсы на этой глубине, решает enqueue: с -p они
+	// скачиваются и для стилей, загруженных глубже лимита
+	return rewriteCSSURLs(css, func(ref string) string {
+		return d.rewriteLink(ref, baseURL, savePath, depth, true, kindRequisite)
+	})
+}
+
+// saveCSS переписывает ссылки в стиле и сохраняет его
+func (d *downloader) saveCSS(rawURL string, attempts int, content []byte, pageURL *url.URL, savePath string, depth int, resp *http.Response, start time.Time) {
+	content = []byte(d.processCSS(string(content), pageURL, savePath, depth))
+
+	savePath, n, err := d.saveContent(rawURL, savePath, content, resp.Header, false)
+	d.addBytes(n)
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to save %q: %w", savePath, err))
+		return
+	}
+	d.saved(rawURL, pageURL.String(), resp.StatusCode, savePath, n, resp.Header, false)
+	d.verbosef(logEntry{event: "saved", url: rawURL, status: resp.StatusCode, bytes: n, duration: time.Since(start)},
+		"Saved %s (%d bytes)", savePath, n)
+}
+
+// processLocalCSS продолжает обход по уже сохраненному стилю
+func (d *downloader) processLocalCSS(savePath string, pageURL *url.URL, depth int) {
+	content, err := d.readSaved(savePath)
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: pageURL.String(), err: err}, "Failed to read %q: %v", savePath, err)
+		return
+	}
+	cssRefs(string(content), func(ref string) {
+		d.followLocalLink(ref, pageURL, savePath, depth, kindRequisite)
+	})
+}
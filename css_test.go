@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestRewriteCSSURLs(t *testing.T) {
+	local := func(ref string) string { return "local/" + strings.TrimPrefix(ref, "/") }
+	tests := []struct {
+		css, want string
+	}{
+		{`a{background:url(/img/a.png)}`, `a{background:url(local/img/a.png)}`},
+		{`a{background:url("/img/a.png")}`, `a{background:url("local/img/a.png")}`},
+		{`a{background:url('/img/a.png')}`, `a{background:url('local/img/a.png')}`},
+		{`a{background:url(  "/img/a.png"  )}`, `a{background:url("local/img/a.png")}`},
+		{`a{background:URL(/img/a.png)}`, `a{background:URL(/img/a.png)}`},
+		{`@import "base.css";`, `@import "local/base.css";`},
+		{`@import 'base.css' screen;`, `@import 'local/base.css' screen;`},
+		{`@import url(base.css);`, `@import url(local/base.css);`},
+		// Экранированные символы раскрываются перед разрешением ссылки
+		{`a{background:url(/img/a\(1\).png)}`, `a{background:url("local/img/a(1).png")}`},
+		{`a{background:url("/img/\"q\".png")}`, `a{background:url("local/img/%22q%22.png")}`},
+		{`a{background:url(/img/\61 .png)}`, `a{background:url(local/img/a.png)}`},
+		// data:, пустые ссылки и фрагменты не трогаются
+		{`a{background:url(data:image/png;base64,iVBORw0KGgo=)}`, `a{background:url(data:image/png;base64,iVBORw0KGgo=)}`},
+		{`a{background:url("DATA:image/svg+xml,<svg xmlns='http://www.w3.org/2000/svg'/>")}`, `a{background:url("DATA:image/svg+xml,<svg xmlns='http://www.w3.org/2000/svg'/>")}`},
+		{`a{clip-path:url(#clip)}`, `a{clip-path:url(#clip)}`},
+		{`a{background:url()}`, `a{background:url()}`},
+		{`a{background:url("")}`, `a{background:url("")}`},
+		// Несколько ссылок в одном свойстве
+		{`@font-face{src:url(a.woff2) format("woff2"),url('a.woff') format("woff")}`, `@font-face{src:url(local/a.woff2) format("woff2"),url('local/a.woff') format("woff")}`},
+	}
+	for _, tt := range tests {
+		if got := rewriteCSSURLs(tt.css, local); got != tt.want {
+			t.Errorf("rewriteCSSURLs(%q) = %q, want %q", tt.css, got, tt.want)
+		}
+	}
+
+	// Без кавычек ссылку с пробелом или скобкой нужно взять в кавычки
+	if got := rewriteCSSURLs(`a{background:url(x.png)}`, func(string) string { return "my file (1).png" }); got != `a{background:url("my file (1).png")}` {
+		t.Errorf("unquoted rewrite = %q", got)
+	}
+	if got := rewriteCSSURLs(`a{background:url('x.png')}`, func(string) string { return "it's.png" }); got != `a{background:url('it%27s.png')}` {
+		t.Errorf("single-quoted rewrite = %q", got)
+	}
+	// Ссылка, которую fn не изменила, остается в исходной записи
+	if got := rewriteCSSURLs(`a{background:url( /x.png )}`, func(ref string) string { return ref }); got != `a{background:url( /x.png )}` {
+		t.Errorf("unchanged rewrite = %q", got)
+	}
+}
+
+func TestUnescapeCSS(t *testing.T) {
+	tests := map[string]string{
+		`plain`:        "plain",
+		`a\(b\)`:       "a(b)",
+		`\"`:           `"`,
+		`\26 b`:        "&b",
+		`\000026b`:     "&b",
+		`\0`:           "�",
+		`\110000`:      "�",
+		"line\\\nnext": "linenext",
+		`trailing\`:    `trailing\`,
+	}
+	for in, want := range tests {
+		if got := unescapeCSS(in); got != want {
+			t.Errorf("unescapeCSS(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNestedCSSImports(t *testing.T) {
+	files := map[string]struct{ contentType, body string }{
+		"/":                   {"text/html", `<link rel="stylesheet" href="/css/style.css"><p>page</p>`},
+		"/css/style.css":      {"text/css", `@import "sub/a.css"; body { background: url(/img/bg.png) }`},
+		"/css/sub/a.css":      {"text/css", `@import url('../../deep/b.css'); .a { background: url("img/a.png") }`},
+		"/deep/b.css":         {"text/css", `@font-face { src: url(fonts/f.woff2) format("woff2") } .b { background: url(data:image/gif;base64,R0lGOD==); clip-path: url(#c) }`},
+		"/img/bg.png":         {"image/png", "bg"},
+		"/css/sub/img/a.png":  {"image/png", "a"},
+		"/deep/fonts/f.woff2": {"font/woff2", "font"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", f.contentType)
+		w.Write([]byte(f.body))
+	}))
+	defer srv.Close()
+
+	// С -p ресурсы стилей скачиваются на любой глубине вложенности
+	dir := t.TempDir()
+	stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "-p", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Assets != 6 {
+		t.Errorf("Assets = %d, want 6", stats.Assets)
+	}
+	host := hostDirOf(dir, srv)
+	for name, want := range map[string]string{
+		"css/style.css":      `@import "sub/a.css"; body { background: url(../img/bg.png) }`,
+		"css/sub/a.css":      `@import url('../../deep/b.css'); .a { background: url("img/a.png") }`,
+		"deep/b.css":         files["/deep/b.css"].body,
+		"img/bg.png":         "bg",
+		"css/sub/img/a.png":  "a",
+		"deep/fonts/f.woff2": "font",
+	} {
+		if got := readMirrorFile(t, host, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	// Ни одна ссылка не ведет на исходный сервер
+	for _, name := range []string{"index.html", "css/style.css", "css/sub/a.css", "deep/b.css"} {
+		if got := readMirrorFile(t, host, name); strings.Contains(got, srv.URL) {
+			t.Errorf("%s still points at the server: %q", name, got)
+		}
+	}
+}
+
+func TestWalkStyles(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><head><style>a{color:red}</style></head>
+<body><div style="b"></div><p style="">empty</p><span title="c">t</span></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	walkStyles(doc, func(css string) string {
+		seen = append(seen, css)
+		return "[" + css + "]"
+	})
+	if got := strings.Join(seen, " "); got != "a{color:red} b" {
+		t.Errorf("walkStyles saw %q", got)
+	}
+	var buf bytes.Buffer
+	html.Render(&buf, doc)
+	for _, want := range []string{`<style>[a{color:red}]</style>`, `<div style="[b]">`, `<p style="">`, `<span title="c">`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("rendered document has no %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestInlineStyles(t *testing.T) {
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/blog/post.html":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<html><head><style>
+@import "theme.css";
+body { background: url('/img/body.png') }
+.x { background: url(%s/img/full.png) }
+.ext { background: url(%s/ext.png) }
+</style></head><body>
+<div style="background:url(../img/hero.jpg)">relative</div>
+<div style='background:url("/img/quoted.png")'>double quotes inside</div>
+<div style="background:url('img/single.png')">single quotes</div>
+<div style="background:url(/img/a\(1\).png)">escaped</div>
+<div style="background:url(data:image/gif;base64,R0lGOD==)">data</div>
+</body></html>`, srv.URL, other.URL)
+		case r.URL.Path == "/blog/theme.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte(`p { color: red }`))
+		case strings.HasPrefix(r.URL.Path, "/img/"), strings.HasPrefix(r.URL.Path, "/blog/img/"):
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("image"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "-p", srv.URL+"/blog/post.html"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for _, path := range []string{"/blog/theme.css", "/img/body.png", "/img/full.png", "/img/hero.jpg", "/img/quoted.png", "/blog/img/single.png", "/img/a(1).png"} {
+		if !requested[path] {
+			t.Errorf("%s not requested", path)
+		}
+	}
+	mu.Unlock()
+
+	page := readMirrorFile(t, hostDirOf(dir, srv), "blog/post.html")
+	for _, want := range []string{
+		`@import "theme.css";`,
+		`url('../img/body.png')`,
+		`url(../img/full.png)`,
+		// Внешний ресурс не скачивается, и ссылка остается абсолютной
+		`url(` + other.URL + `/ext.png)`,
+		`<div style="background:url(../img/hero.jpg)">`,
+		`<div style="background:url(&#34;../img/quoted.png&#34;)">`,
+		`<div style="background:url(&#39;img/single.png&#39;)">`,
+		`<div style="background:url(../img/a%281%29.png)">`,
+		`url(data:image/gif;base64,R0lGOD==)`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("post.html has no %q:\n%s", want, page)
+		}
+	}
+	if got := readMirrorFile(t, hostDirOf(dir, srv), "img/a(1).png"); got != "image" {
+		t.Errorf("img/a(1).png = %q", got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// dataDir - каталог рядом со страницей, куда --extract-data-uris выносит
+// содержимое data: URI
+const dataDir = "_data"
+
+// dataExts - расширения для частых типов. mime.ExtensionsByType для них
+// выбирает не самое привычное (.jfif для image/jpeg)
+var dataExts = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/avif":    ".avif",
+	"image/svg+xml": ".svg",
+	"image/x-icon":  ".ico",
+	"font/woff":     ".woff",
+	"font/woff2":    ".woff2",
+	"text/css":      ".css",
+	"text/plain":    ".txt",
+}
+
+// decodeDataURI разбирает data:[<type>][;base64],<data> и возвращает тип
+// и содержимое. Тип по умолчанию - text/plain
+func decodeDataURI(uri string) (string, []byte, error) {
+	if len(uri) < 5 || !strings.EqualFold(uri[:5], "data:") {
+		return "", nil, errors.New("not a data: URI")
+	}
+	header, data, ok := strings.Cut(uri[5:], ",")
+	if !ok {
+		return "", nil, errors.New("data: URI without a comma")
+	}
+	isBase64 := false
+	if params := strings.Split(header, ";"); strings.EqualFold(strings.TrimSpace(params[len(params)-1]), "base64") {
+		isBase64 = true
+		header = strings.Join(params[:len(params)-1], ";")
+	}
+	contentType := mediaType(header)
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	unescaped, err := url.PathUnescape(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if !isBase64 {
+		return contentType, []byte(unescaped), nil
+	}
+	// Пробелы и переводы строк внутри base64 браузер пропускает
+	unescaped = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' {
+			return -1
+		}
+		return r
+	}, unescaped)
+	content, err := base64.StdEncoding.DecodeString(unescaped)
+	if err != nil {
+		// Встречается base64 без дополнения =
+		content, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(unescaped, "="))
+	}
+	return contentType, content, err
+}
+
+// dataExt - расширение файла для типа содержимого
+func dataExt(contentType string) string {
+	if ext, ok := dataExts[contentType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// extractDataURI выносит содержимое data: URI не меньше --data-uri-min-size
+// в файл _data/<sha256><ext> рядом со страницей savePath и возвращает
+// путь к нему относительно страницы. Одинаковое содержимое записывается
+// один раз
+func (d *downloader) extractDataURI(uri string, savePath string) (string, bool) {
+	contentType, content, err := decodeDataURI(uri)
+	if err != nil {
+		d.debugf(logEntry{event: "parse", err: err}, "Leaving data: URI inline: %v", err)
+		return uri, false
+	}
+	if int64(len(content)) < d.dataURIMinSize {
+		return uri, false
+	}
+
+	sum := sha256.Sum256(content)
+	name := hex.EncodeToString(sum[:16]) + dataExt(contentType)
+	path := filepath.Join(filepath.Dir(savePath), dataDir, name)
+	if _, err := d.statSaved(path); err != nil {
+		if err := d.mkdirSaved(filepath.Dir(path)); err != nil {
+			d.errorf(logEntry{event: "save", err: err}, "Failed to create directory for %q: %v", path, err)
+			return uri, false
+		}
+		if _, err := d.writeSaved(path, content); err != nil {
+			d.errorf(logEntry{event: "save", err: err}, "Failed to save %q: %v", path, err)
+			return uri, false
+		}
+		d.verbosef(logEntry{event: "saved", bytes: int64(len(content))}, "Extracted data: URI to %s (%d bytes)", path, len(content))
+	}
+	return dataDir + "/" + name, true
+}
+
+// isExtractedData проверяет, ведет ли ссылка сохраненной страницы на
+// файл, вынесенный из data: URI
+func isExtractedData(ref string) bool {
+	return strings.HasPrefix(ref, dataDir+"/")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDecodeDataURI(t *testing.T) {
+	binary := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(binary)
+	encoded := base64.StdEncoding.EncodeToString(binary)
+
+	tests := []struct {
+		uri      string
+		wantType string
+		want     []byte
+	}{
+		{"data:image/png;base64," + encoded, "image/png", binary},
+		{"DATA:Image/PNG;BASE64," + encoded, "image/png", binary},
+		// Переводы строк внутри base64 и base64 без дополнения =
+		{"data:image/png;base64," + encoded[:100] + "\n  " + encoded[100:], "image/png", binary},
+		{"data:application/octet-stream;base64," + strings.TrimRight(base64.StdEncoding.EncodeToString(binary[:998]), "="), "application/octet-stream", binary[:998]},
+		{"data:image/svg+xml;charset=utf-8,%3Csvg%20xmlns%3D%22http%3A%2F%2Fwww.w3.org%2F2000%2Fsvg%22%2F%3E", "image/svg+xml", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`)},
+		{"data:,Hello%2C%20World", "text/plain", []byte("Hello, World")},
+		{"data:;base64,SGk=", "text/plain", []byte("Hi")},
+	}
+	for _, tt := range tests {
+		gotType, got, err := decodeDataURI(tt.uri)
+		if err != nil || gotType != tt.wantType || !bytes.Equal(got, tt.want) {
+			t.Errorf("decodeDataURI(%.40q) = %q, %d bytes, %v; want %q, %d bytes", tt.uri, gotType, len(got), err, tt.wantType, len(tt.want))
+		}
+	}
+
+	for _, uri := range []string{"http://example.com/a.png", "data:image/png;base64", "data:image/png;base64,!!!!", "data:,%zz"} {
+		if _, _, err := decodeDataURI(uri); err == nil {
+			t.Errorf("decodeDataURI(%q) accepted", uri)
+		}
+	}
+}
+
+func TestDataExt(t *testing.T) {
+	tests := map[string]string{
+		"image/jpeg":               ".jpg",
+		"image/svg+xml":            ".svg",
+		"font/woff2":               ".woff2",
+		"application/pdf":          ".pdf",
+		"application/x-unknown-xx": ".bin",
+	}
+	for contentType, want := range tests {
+		if got := dataExt(contentType); got != want {
+			t.Errorf("dataExt(%q) = %q, want %q", contentType, got, want)
+		}
+	}
+}
+
+func TestExtractDataURIs(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	big := make([]byte, 8<<10)
+	rng.Read(big)
+	jpeg := make([]byte, 5<<10)
+	rng.Read(jpeg)
+	small := []byte("tiny gif")
+	svg := `<svg xmlns="http://www.w3.org/2000/svg">` + strings.Repeat(`<rect width="1" height="1"/>`, 200) + `</svg>`
+
+	bigURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString(big)
+	jpegURI := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(jpeg)
+	smallURI := "data:image/gif;base64," + base64.StdEncoding.EncodeToString(small)
+	svgURI := "data:image/svg+xml," + url.PathEscape(svg)
+	page := `<html><body>
+<img src="` + bigURI + `" alt="big">
+<img src="` + bigURI + `" alt="again">
+<img src="` + smallURI + `" alt="small">
+<picture><source srcset="/img/a.png"><img src="` + jpegURI + `" alt="jpeg"></picture>
+<object data="` + svgURI + `"></object>
+<a href="/docs/other.html">other</a>
+</body></html>`
+
+	var mu sync.Mutex
+	requested := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path]++
+		mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, ".html"):
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("image"))
+		}
+	}))
+	defer srv.Close()
+
+	name := func(content []byte, ext string) string {
+		sum := sha256.Sum256(content)
+		return dataDir + "/" + hex.EncodeToString(sum[:16]) + ext
+	}
+
+	// Без --extract-data-uris страница не меняется
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "-p", srv.URL+"/docs/page.html"); err != nil {
+		t.Fatal(err)
+	}
+	host := hostDirOf(dir, srv)
+	if got := readMirrorFile(t, host, "docs/page.html"); !strings.Contains(got, bigURI) || strings.Contains(got, dataDir) {
+		t.Error("data: URIs changed without --extract-data-uris")
+	}
+	if _, err := os.Stat(filepath.Join(host, "docs", dataDir)); err == nil {
+		t.Errorf("%s created without --extract-data-uris", dataDir)
+	}
+
+	dir = t.TempDir()
+	stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "--extract-data-uris", srv.URL+"/docs/page.html")
+	if err != nil || stats.Failed != 0 {
+		t.Fatalf("Failed = %d, %v", stats.Failed, err)
+	}
+	host = hostDirOf(dir, srv)
+	got := readMirrorFile(t, host, "docs/page.html")
+	for _, want := range []string{
+		`<img src="` + name(big, ".png") + `" alt="big"/>`,
+		`<img src="` + name(big, ".png") + `" alt="again"/>`,
+		`<img src="` + smallURI + `" alt="small"/>`,
+		`<img src="` + name(jpeg, ".jpg") + `" alt="jpeg"/>`,
+		`<object data="` + name([]byte(svg), ".svg") + `"></object>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("page.html has no %.80q", want)
+		}
+	}
+
+	// Файлы совпадают с декодированным содержимым байт в байт
+	for file, want := range map[string][]byte{
+		name(big, ".png"):         big,
+		name(jpeg, ".jpg"):        jpeg,
+		name([]byte(svg), ".svg"): []byte(svg),
+	} {
+		data, err := os.ReadFile(filepath.Join(host, "docs", filepath.FromSlash(file)))
+		if err != nil || !bytes.Equal(data, want) {
+			t.Errorf("%s: %d bytes, %v; want %d bytes", file, len(data), err, len(want))
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(host, "docs", dataDir))
+	if err != nil || len(entries) != 3 {
+		t.Errorf("%s has %d files, %v; want 3", dataDir, len(entries), err)
+	}
+	// Страница other.html с тем же содержимым ссылается на те же файлы
+	if got := readMirrorFile(t, host, "docs/other.html"); !strings.Contains(got, name(big, ".png")) {
+		t.Error("other.html does not reference the extracted file")
+	}
+
+	// Повторный обход сохраненных страниц не запрашивает _data/ с сервера
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "-nc", "--extract-data-uris", srv.URL+"/docs/page.html"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for path := range requested {
+		if strings.Contains(path, dataDir) {
+			t.Errorf("%s requested", path)
+		}
+	}
+	mu.Unlock()
+
+	// С порогом выше размера картинок все остается в странице
+	dir = t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "--extract-data-uris", "--data-uri-min-size", "1m", srv.URL+"/docs/page.html"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMirrorFile(t, hostDirOf(dir, srv), "docs/page.html"); !strings.Contains(got, bigURI) || !strings.Contains(got, jpegURI) {
+		t.Error("data: URIs below --data-uri-min-size extracted")
+	}
+}
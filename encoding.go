@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptEncoding перечисляет кодировки, которые умеет распаковывать decodeBody
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// decodedBody закрывает и распаковщики, и исходное тело ответа
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	var err error
+	for _, c := range b.closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// decodeBody распаковывает тело ответа согласно Content-Encoding и убирает
+// из заголовков кодировку и длину, которые больше не относятся к телу.
+// Сервер может сжать ответ, даже если мы об этом не просили, поэтому
+// распаковывается любой ответ с известной кодировкой
+func decodeBody(resp *http.Response) error {
+	value := resp.Header.Get("Content-Encoding")
+	if value == "" {
+		return nil
+	}
+
+	var encodings []string
+	for _, e := range strings.Split(value, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" && e != "identity" {
+			encodings = append(encodings, e)
+		}
+	}
+
+	body := &decodedBody{Reader: resp.Body, closers: []io.Closer{resp.Body}}
+
+	// Кодировки применялись в порядке перечисления, снимаем их с конца
+	for i := len(encodings) - 1; i >= 0; i-- {
+		r, err := newDecoder(encodings[i], body.Reader)
+		if err != nil {
+			body.Close()
+			return err
+		}
+		body.Reader = r
+		if c, ok := r.(io.Closer); ok {
+			body.closers = append([]io.Closer{c}, body.closers...)
+		}
+	}
+
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+func newDecoder(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// По стандарту deflate - это zlib, но некоторые серверы присылают
+		// "сырой" deflate без заголовка
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(r), nil
+	case "zstd":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+}
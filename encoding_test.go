@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compress сжимает data кодировкой encoding из Content-Encoding
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	plain := []byte(strings.Repeat("<p>compressed page</p>", 100))
+	tests := []struct {
+		header string
+		body   []byte
+	}{
+		{"gzip", compress(t, "gzip", plain)},
+		{"X-Gzip", compress(t, "gzip", plain)},
+		{"deflate", compress(t, "deflate", plain)},
+		// Сырой deflate без заголовка zlib
+		{"deflate", compress(t, "raw-deflate", plain)},
+		{"br", compress(t, "br", plain)},
+		{"zstd", compress(t, "zstd", plain)},
+		// Кодировки снимаются в обратном порядке
+		{"gzip, br", compress(t, "br", compress(t, "gzip", plain))},
+		{"identity", plain},
+		{"", plain},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{
+			Header:        http.Header{"Content-Encoding": {tt.header}, "Content-Length": {"1"}},
+			Body:          io.NopCloser(bytes.NewReader(tt.body)),
+			ContentLength: int64(len(tt.body)),
+		}
+		if tt.header == "" {
+			resp.Header.Del("Content-Encoding")
+		}
+		if err := decodeBody(resp); err != nil {
+			t.Errorf("%q: %v", tt.header, err)
+			continue
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%q: decoded %d bytes, %v", tt.header, len(got), err)
+		}
+		if tt.header != "" && (resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Length") != "" || resp.ContentLength != -1) {
+			t.Errorf("%q: headers not cleared: %v, ContentLength %d", tt.header, resp.Header, resp.ContentLength)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Content-Encoding": {"compress"}}, Body: io.NopCloser(strings.NewReader("x"))}
+	if err := decodeBody(resp); err == nil {
+		t.Error("unsupported encoding accepted")
+	}
+}
+
+func TestCompressedMirror(t *testing.T) {
+	// Повторы сжимаются в обратные ссылки, и в сжатом виде текста целиком нет
+	index := []byte("<html><body>" + strings.Repeat(`<a href="/page.html">page</a>`, 20) + `<a href="/data.bin">data</a></body></html>`)
+	page := []byte("<p>" + strings.Repeat("page ", 50) + "</p>")
+	data := []byte(strings.Repeat("binary data ", 100))
+	var mu sync.Mutex
+	var gotAccept []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gotAccept = append(gotAccept, r.Header.Get("Accept-Encoding"))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			// Сжимает, даже если клиент об этом не просил
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compress(t, "gzip", index))
+		case "/page.html":
+			// Сжимает, только если клиент просил br
+			w.Header().Set("Content-Type", "text/html")
+			if strings.Contains(r.Header.Get("Accept-Encoding"), "br") {
+				w.Header().Set("Content-Encoding", "br")
+				w.Write(compress(t, "br", page))
+				return
+			}
+			w.Write(page)
+		case "/data.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Encoding", "zstd")
+			w.Write(compress(t, "zstd", data))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, saveCompressed := range []bool{false, true} {
+		dir := t.TempDir()
+		args := []string{"-e", "robots=off", "-l", "1"}
+		if saveCompressed {
+			args = append(args, "--save-compressed")
+		}
+		if _, err := testMirror(t, dir, append(args, srv.URL+"/")...); err != nil {
+			t.Fatal(err)
+		}
+		host := hostDirOf(dir, srv)
+		// Страницы распаковываются и с --save-compressed: в них переписываются ссылки
+		if got := readMirrorFile(t, host, "index.html"); strings.Count(got, `href="page.html"`) != 20 {
+			t.Errorf("save-compressed=%v: index.html = %q", saveCompressed, got)
+		}
+		if got := readMirrorFile(t, host, "page.html"); !strings.Contains(got, string(page)) {
+			t.Errorf("save-compressed=%v: page.html = %q", saveCompressed, got)
+		}
+		want := data
+		if saveCompressed {
+			want = compress(t, "zstd", data)
+		}
+		if got := readMirrorFile(t, host, "data.bin"); got != string(want) {
+			t.Errorf("save-compressed=%v: data.bin has %d bytes, want %d", saveCompressed, len(got), len(want))
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, got := range gotAccept {
+		if got != acceptEncoding {
+			t.Errorf("Accept-Encoding = %q, want %q", got, acceptEncoding)
+		}
+	}
+}
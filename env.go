@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// envPrefix - префикс переменных окружения с настройками
+const envPrefix = "WEBMIRROR_"
+
+// envName возвращает переменную окружения для флага:
+// --max-file-size задается через WEBMIRROR_MAX_FILE_SIZE
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv переносит переменные WEBMIRROR_* в флаги, которые не заданы в
+// командной строке. Значения разбираются так же, как значения флагов.
+// Однобуквенные флаги не отображаются: -p и -P дали бы одну переменную,
+// а у каждого из них есть длинный синоним
+func applyEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	explicit := explicitTargets(fs)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || len(f.Name) < 2 || f.Name == "print-config" || explicit[flagTarget(f)] {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := lookup(name)
+		if !ok {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid %s=%q: %v", name, value, setErr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Поток событий для --progress-fd и --progress-file: по объекту JSON в
+// строке, независимо от журнала. Схема стабильна, новые поля могут только
+// добавляться. Общие поля: "event" и "time" (RFC 3339). События:
+//
+//	queued    URL прошел проверки и поставлен в очередь: url, depth
+//	started   воркер взял URL: url, depth
+//	finished  URL обработан: url, status (если был ответ), bytes (если
+//	          что-то записано), path (файл с содержимым)
+//	skipped   URL взят, но не сохранен: url, status, reason
+//	failed    загрузка не удалась: url, status (для ошибок HTTP), error
+//	summary   последнее событие: summary с итогами, как у --summary=json
+//
+// За started всегда следует ровно одно из finished, skipped или failed,
+// если обход не прерван
+type progressEvent struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	URL     string    `json:"url,omitempty"`
+	Depth   *int      `json:"depth,omitempty"`
+	Status  int       `json:"status,omitempty"`
+	Bytes   int64     `json:"bytes,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Summary *runStats `json:"summary,omitempty"`
+}
+
+// eventBuffer - сколько событий может ждать записи, прежде чем воркеры
+// начнут ждать писателя
+const eventBuffer = 1024
+
+// eventStream пишет события из одной горутины, так что строки от разных
+// воркеров не перемешиваются
+type eventStream struct {
+	events chan progressEvent
+	done   chan struct{}
+	err    error // первая ошибка записи; после нее события отбрасываются
+}
+
+func newEventStream(w io.Writer) *eventStream {
+	s := &eventStream{events: make(chan progressEvent, eventBuffer), done: make(chan struct{})}
+	go s.write(w)
+	return s
+}
+
+func (s *eventStream) write(w io.Writer) {
+	defer close(s.done)
+	enc := json.NewEncoder(w)
+	for e := range s.events {
+		if s.err == nil {
+			s.err = enc.Encode(e)
+		}
+	}
+}
+
+// close дожидается записи всех событий и возвращает ошибку записи
+func (s *eventStream) close() error {
+	close(s.events)
+	<-s.done
+	return s.err
+}
+
+// emit отправляет событие в поток, если он включен
+func (d *downloader) emit(e progressEvent) {
+	if d.events == nil {
+		return
+	}
+	e.Time = time.Now()
+	d.events.events <- e
+}
+
+// skipURL учитывает пропуск уже взятого в работу URL: в статистике, в
+// потоке событий и для ссылок на него, переписанных в локальные
+func (d *downloader) skipURL(rawURL string, status int, reason string) {
+	d.skip(reason)
+	d.addNotSaved(rawURL)
+	d.emit(progressEvent{Event: "skipped", URL: rawURL, Status: status, Reason: reason})
+}
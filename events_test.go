@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readEvents разбирает каждую строку потока событий. Неизвестные поля -
+// ошибка: схема меняется только вместе с тестом
+func readEvents(t *testing.T, path string) []progressEvent {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 || data[len(data)-1] != '\n' {
+		t.Fatalf("event stream does not end with a newline: %q", data)
+	}
+	var events []progressEvent
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		dec := json.NewDecoder(strings.NewReader(scanner.Text()))
+		dec.DisallowUnknownFields()
+		var e progressEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("bad event line %q: %v", scanner.Text(), err)
+		}
+		if e.Event == "" || e.Time.IsZero() {
+			t.Errorf("event without a name or time: %q", scanner.Text())
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestProgressEvents(t *testing.T) {
+	asset := strings.Repeat("a", 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/a.html">a</a> <a href="/file.bin">file</a> <a href="/big.bin">big</a> <a href="/missing.html">missing</a>`))
+		case "/a.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<p>a</p>`))
+		case "/file.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte(asset))
+		case "/big.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(bytes.Repeat([]byte("b"), 4096))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// Файл --progress-file открывает main, здесь поток подключается так же
+	dir := t.TempDir()
+	eventsFile := filepath.Join(t.TempDir(), "events.ndjson")
+	f, err := os.Create(eventsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	opts, urls, err := parseArgs([]string{"-P", dir, "-q", "--progress", "none", "--no-favicon", "--manifest", "none",
+		"-e", "robots=off", "-l", "1", "--concurrency", "4", "--tries", "1", "--max-file-size", "1k", srv.URL + "/"}, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.events = newEventStream(f)
+	if opts.logger, err = newLogger("text", io.Discard, levelError, nil); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDownloader(urls, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := d.Wait()
+	if err == nil {
+		t.Fatal("no error for the missing page")
+	}
+	events := readEvents(t, eventsFile)
+
+	// Последнее событие - итоги, как у --summary=json
+	last := events[len(events)-1]
+	if last.Event != "summary" || last.Summary == nil {
+		t.Fatalf("last event = %+v, want summary", last)
+	}
+	if last.Summary.Pages != stats.Pages || last.Summary.Assets != stats.Assets || last.Summary.Pages != 2 || last.Summary.Assets != 1 {
+		t.Errorf("summary = %+v, want 2 pages and 1 asset as in %+v", *last.Summary, stats)
+	}
+
+	queued := make(map[string]bool)
+	started := make(map[string]int)
+	outcome := make(map[string]progressEvent)
+	for _, e := range events[:len(events)-1] {
+		switch e.Event {
+		case "queued":
+			if e.Depth == nil {
+				t.Errorf("queued %s without depth", e.URL)
+			}
+			queued[e.URL] = true
+		case "started":
+			if !queued[e.URL] {
+				t.Errorf("%s started before it was queued", e.URL)
+			}
+			if e.Depth == nil {
+				t.Errorf("started %s without depth", e.URL)
+			}
+			started[e.URL]++
+		case "finished", "skipped", "failed":
+			// За started следует ровно одно итоговое событие
+			if started[e.URL] != 1 {
+				t.Errorf("%s %s without a started event", e.Event, e.URL)
+			}
+			if prev, ok := outcome[e.URL]; ok {
+				t.Errorf("%s: both %s and %s", e.URL, prev.Event, e.Event)
+			}
+			outcome[e.URL] = e
+		default:
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+	for _, path := range []string{"/", "/a.html", "/file.bin", "/big.bin", "/missing.html"} {
+		if started[srv.URL+path] != 1 || outcome[srv.URL+path].Event == "" {
+			t.Errorf("%s: started %d times, outcome %q", path, started[srv.URL+path], outcome[srv.URL+path].Event)
+		}
+	}
+
+	// finished: код, размер и путь к файлу с содержимым
+	file := outcome[srv.URL+"/file.bin"]
+	if file.Event != "finished" || file.Status != http.StatusOK || file.Bytes != int64(len(asset)) {
+		t.Errorf("file.bin = %+v, want finished, status 200, %d bytes", file, len(asset))
+	}
+	if data, err := os.ReadFile(file.Path); err != nil || string(data) != asset {
+		t.Errorf("file.bin path %q: %v", file.Path, err)
+	}
+	if page := outcome[srv.URL+"/a.html"]; page.Event != "finished" || page.Path != filepath.Join(hostDirOf(dir, srv), "a.html") {
+		t.Errorf("a.html = %+v, want finished with the saved path", page)
+	}
+
+	// skipped: код ответа и причина
+	if big := outcome[srv.URL+"/big.bin"]; big.Event != "skipped" || big.Reason != "--max-file-size" || big.Status != http.StatusOK {
+		t.Errorf("big.bin = %+v, want skipped by --max-file-size", big)
+	}
+
+	// failed: код и текст ошибки
+	missing := outcome[srv.URL+"/missing.html"]
+	if missing.Event != "failed" || missing.Status != http.StatusNotFound || !strings.Contains(missing.Error, "404") {
+		t.Errorf("missing.html = %+v, want failed with status 404", missing)
+	}
+	if missing.Path != "" || missing.Bytes != 0 {
+		t.Errorf("missing.html = %+v, want no path and bytes", missing)
+	}
+}
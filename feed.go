@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// isFeedRoot распознает RSS (0.9x, 1.0, 2.0) и Atom по корневому элементу
+func isFeedRoot(root string) bool {
+	return root == "rss" || root == "feed" || root == "RDF"
+}
+
+// feedLinkAttrs - атрибуты со ссылками в элементах лент (по локальному
+// имени элемента) и вид ресурса. Ссылки на записи и вложения обходятся
+// как ссылки страницы, картинки канала - как ее ресурсы
+var feedLinkAttrs = map[string]struct {
+	attr string
+	kind resourceKind
+}{
+	"link":      {"href", kindPage},      // Atom
+	"enclosure": {"url", kindPage},       // RSS 2.0
+	"content":   {"url", kindPage},       // Media RSS
+	"thumbnail": {"url", kindRequisite},  // Media RSS
+	"image":     {"href", kindRequisite}, // iTunes
+}
+
+// feedSkippedRels - ссылки Atom, которые не ведут к содержимому
+var feedSkippedRels = map[string]bool{"self": true, "hub": true}
+
+// rewriteFeed вызывает fn для каждой ссылки ленты и подставляет в текст
+// то, что fn вернет. Лента правится на месте, поэтому пространства имен,
+// CDATA и форматирование сохраняются. Ссылки берутся из <link> (текстом
+// в RSS, атрибутом href в Atom), <enclosure url>, <content src> в Atom,
+// <media:content url>, <media:thumbnail url>, <itunes:image href> и
+// <image><url> канала RSS
+func rewriteFeed(content []byte, fn func(ref string, kind resourceKind) string) ([]byte, error) {
+	dec := newXMLDecoder(content)
+
+	var edits []xmlEdit
+	var stack []string
+	// Текст элемента-ссылки: смещение начала, текст и вид ресурса
+	textStart, textKind := -1, kindPage
+	var text strings.Builder
+
+	for {
+		offset := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return content, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			textStart = -1
+			end := int(dec.InputOffset())
+			name := t.Name.Local
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			stack = append(stack, name)
+
+			if link, ok := feedLinkAttrs[name]; ok {
+				attr := link.attr
+				if name == "content" && attrOf(t, "src") != "" {
+					attr = "src" // Atom
+				}
+				if feedSkippedRels[strings.ToLower(attrOf(t, "rel"))] {
+					continue
+				}
+				if value := strings.TrimSpace(attrOf(t, attr)); value != "" {
+					if start, stop, ok := attrValueOffsets(content[offset:end], attr); ok {
+						edits = append(edits, xmlEdit{offset + start, offset + stop, escapeXML(fn(value, link.kind))})
+					}
+					continue
+				}
+			}
+			switch {
+			case name == "link":
+				textStart, textKind = end, kindPage
+			case name == "url" && parent == "image":
+				textStart, textKind = end, kindRequisite
+			}
+			text.Reset()
+		case xml.CharData:
+			if textStart >= 0 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if textStart >= 0 {
+				if value := strings.TrimSpace(text.String()); value != "" {
+					edits = append(edits, xmlEdit{textStart, offset, escapeXML(fn(value, textKind))})
+				}
+				textStart = -1
+			}
+		}
+	}
+
+	return applyXMLEdits(content, edits), nil
+}
+
+func attrOf(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name && attr.Name.Space == "" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// feedAttrPatterns находят значение атрибута со ссылкой в тексте тега
+var feedAttrPatterns = map[string]*regexp.Regexp{
+	"href": regexp.MustCompile(`\shref\s*=\s*(?:"([^"]*)"|'([^']*)')`),
+	"url":  regexp.MustCompile(`\surl\s*=\s*(?:"([^"]*)"|'([^']*)')`),
+	"src":  regexp.MustCompile(`\ssrc\s*=\s*(?:"([^"]*)"|'([^']*)')`),
+}
+
+// attrValueOffsets находит значение атрибута (без кавычек) в тексте тега
+func attrValueOffsets(tag []byte, name string) (int, int, bool) {
+	m := feedAttrPatterns[name].FindSubmatchIndex(tag)
+	if m == nil {
+		return 0, 0, false
+	}
+	if m[2] >= 0 {
+		return m[2], m[3], true
+	}
+	return m[4], m[5], true
+}
+
+// processFeed ставит в очередь записи и вложения ленты (если recurse) и
+// переписывает ссылки на них относительно файла ленты savePath, как
+// processHTML делает со страницами. Записи ленты считаются ссылками
+// страницы и для учета глубины
+func (d *downloader) processFeed(content []byte, baseURL *url.URL, savePath string, depth int, recurse bool) []byte {
+	rewritten, err := rewriteFeed(content, func(ref string, kind resourceKind) string {
+		return d.rewriteLink(ref, baseURL, savePath, depth, recurse, kind)
+	})
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: baseURL.String(), err: err}, "Failed to parse feed: %v", err)
+		return content
+	}
+	return rewritten
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRewriteFeed(t *testing.T) {
+	rss := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+  <title>Podcast &amp; blog</title>
+  <link>https://example.com/</link>
+  <atom:link href="https://example.com/feed.rss" rel="self"/>
+  <image><url>/logo.png</url><link>https://example.com/</link></image>
+  <itunes:image href="/cover.jpg"/>
+  <item>
+    <title><![CDATA[Episode <1>]]></title>
+    <link> /posts/1.html </link>
+    <enclosure url="/audio/ep1.mp3" length="100" type="audio/mpeg"/>
+    <media:content url='/video/ep1.mp4'/>
+    <media:thumbnail url="/thumbs/ep1.jpg"/>
+    <description>&lt;p&gt;text&lt;/p&gt;</description>
+  </item>
+</channel>
+</rss>`
+	atom := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <link rel="self" href="/atom.xml"/>
+  <link rel="hub" href="https://hub.example.com/"/>
+  <link href="/"/>
+  <entry>
+    <link rel="alternate" href="/posts/2.html?a=1&amp;b=2"/>
+    <link rel="enclosure" href="/audio/ep2.mp3"/>
+    <content type="text/html" src="/posts/2/full.html"/>
+  </entry>
+</feed>`
+
+	tests := []struct {
+		feed    string
+		refs    []string
+		kept    []string
+		changed []string
+	}{
+		{
+			rss,
+			[]string{
+				"page https://example.com/", "requisite /logo.png", "page https://example.com/", "requisite /cover.jpg",
+				"page /posts/1.html", "page /audio/ep1.mp3", "page /video/ep1.mp4", "requisite /thumbs/ep1.jpg",
+			},
+			[]string{`<![CDATA[Episode <1>]]>`, `href="https://example.com/feed.rss" rel="self"`, `xmlns:media="http://search.yahoo.com/mrss/"`, `&lt;p&gt;text&lt;/p&gt;`},
+			[]string{`<link>local:/posts/1.html</link>`, `<enclosure url="local:/audio/ep1.mp3"`, `<media:content url='local:/video/ep1.mp4'/>`, `<url>local:/logo.png</url>`},
+		},
+		{
+			atom,
+			[]string{"page /", "page /posts/2.html?a=1&b=2", "page /audio/ep2.mp3", "page /posts/2/full.html"},
+			[]string{`<link rel="self" href="/atom.xml"/>`, `href="https://hub.example.com/"`},
+			[]string{`href="local:/posts/2.html?a=1&amp;b=2"`, `src="local:/posts/2/full.html"`},
+		},
+	}
+	for _, tt := range tests {
+		var refs []string
+		got, err := rewriteFeed([]byte(tt.feed), func(ref string, kind resourceKind) string {
+			name := "page"
+			if kind == kindRequisite {
+				name = "requisite"
+			}
+			refs = append(refs, name+" "+ref)
+			return "local:" + ref
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(refs, "\n") != strings.Join(tt.refs, "\n") {
+			t.Errorf("refs:\n%s\nwant:\n%s", strings.Join(refs, "\n"), strings.Join(tt.refs, "\n"))
+		}
+		for _, want := range append(tt.kept, tt.changed...) {
+			if !strings.Contains(string(got), want) {
+				t.Errorf("rewritten feed has no %q:\n%s", want, got)
+			}
+		}
+	}
+
+	for root, want := range map[string]bool{"rss": true, "feed": true, "RDF": true, "svg": false, "html": false} {
+		if isFeedRoot(root) != want {
+			t.Errorf("isFeedRoot(%q) = %v", root, !want)
+		}
+	}
+}
+
+func TestFeedMirror(t *testing.T) {
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/feed.rss":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprintf(w, `<rss version="2.0"><channel><link>/</link>
+<item><link>/posts/1.html</link><enclosure url="/audio/ep1.mp3" type="audio/mpeg"/></item>
+<item><link>%s/external.html</link><enclosure url="%s/external.mp3"/></item>
+</channel></rss>`, other.URL, other.URL)
+		case r.URL.Path == "/atom.xml":
+			// Лента с общим типом XML распознается по корневому элементу
+			w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+			w.Write([]byte(`<feed xmlns="http://www.w3.org/2005/Atom"><entry><link href="/posts/2.html"/></entry></feed>`))
+		case strings.HasPrefix(r.URL.Path, "/posts/"):
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/deeper.html">deeper</a>`))
+		case r.URL.Path == "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<p>home</p>`))
+		default:
+			w.Write([]byte("data " + r.URL.Path))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", srv.URL+"/feed.rss", srv.URL+"/atom.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := hostDirOf(dir, srv)
+	for _, name := range []string{"index.html", "posts/1.html", "posts/2.html", "audio/ep1.mp3"} {
+		if _, err := os.Stat(filepath.Join(host, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s not saved: %v", name, err)
+		}
+	}
+	// Записи ленты - ссылки страницы: ссылки с них уже за пределом глубины
+	mu.Lock()
+	if requested["/deeper.html"] {
+		t.Error("link from a feed item followed beyond -l 1")
+	}
+	mu.Unlock()
+	if stats.Pages != 5 {
+		t.Errorf("Pages = %d, want 5 (two feeds and three pages)", stats.Pages)
+	}
+
+	feed := readMirrorFile(t, host, "feed.rss")
+	for _, want := range []string{
+		"<link>index.html</link>",
+		"<link>posts/1.html</link>",
+		`<enclosure url="audio/ep1.mp3"`,
+		// Внешние ссылки остаются абсолютными
+		"<link>" + other.URL + "/external.html</link>",
+		`<enclosure url="` + other.URL + `/external.mp3"/>`,
+	} {
+		if !strings.Contains(feed, want) {
+			t.Errorf("feed.rss has no %q:\n%s", want, feed)
+		}
+	}
+	if got := readMirrorFile(t, host, "atom.xml"); !strings.Contains(got, `<link href="posts/2.html"/>`) {
+		t.Errorf("atom.xml = %s", got)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Правила --restrict-file-names: какие символы имени файла экранируются
+// как %XX
+const (
+	restrictUnix    = "unix"    // только управляющие символы
+	restrictWindows = "windows" // еще \ : * ? " < > |, . и пробел в конце, имена устройств
+	restrictASCII   = "ascii"   // как unix, и все байты вне ASCII
+	restrictPercent = "percent" // windows и ascii вместе
+)
+
+// defaultRestrictFileNames - правила для файловой системы, на которой
+// запущена программа
+func defaultRestrictFileNames() string {
+	if runtime.GOOS == "windows" {
+		return restrictWindows
+	}
+	return restrictUnix
+}
+
+func validRestrictFileNames(mode string) error {
+	switch mode {
+	case restrictUnix, restrictWindows, restrictASCII, restrictPercent:
+		return nil
+	}
+	return fmt.Errorf("unknown --restrict-file-names %q (want %s, %s, %s or %s)", mode, restrictUnix, restrictWindows, restrictASCII, restrictPercent)
+}
+
+// decodeSegment раскрывает %XX в сегменте пути, чтобы /caf%C3%A9 и /café
+// сохранялись в читаемый файл café. Закодированными остаются разделители
+// каталогов, NUL, сам % (так имя café не совпадет с /caf%25C3%25A9) и
+// байты, которые не складываются в UTF-8
+func decodeSegment(s string) string {
+	unescaped, err := url.PathUnescape(s)
+	if err != nil {
+		return s
+	}
+	unescaped = strings.ReplaceAll(unescaped, "%", "%25")
+	unescaped = segmentEscaper.Replace(unescaped)
+	if utf8.ValidString(unescaped) {
+		return unescaped
+	}
+
+	var b strings.Builder
+	for len(unescaped) > 0 {
+		r, size := utf8.DecodeRuneInString(unescaped)
+		if r == utf8.RuneError && size == 1 {
+			fmt.Fprintf(&b, "%%%02X", unescaped[0])
+		} else {
+			b.WriteString(unescaped[:size])
+		}
+		unescaped = unescaped[size:]
+	}
+	return b.String()
+}
+
+// Нормализация Unicode в именах файлов (--unicode-file-names). Одна буква
+// бывает записана одним символом (é, NFC) или буквой с диакритикой (e +
+// U+0301, NFD). Файловая система macOS сама приводит имена к своей форме,
+// и без нормализации имя файла на диске и ссылка на него расходятся
+const (
+	unicodeNFC  = "nfc"
+	unicodeNFD  = "nfd"
+	unicodeAsIs = "none"
+)
+
+// unicodeFileName приводит имя файла к форме form
+func unicodeFileName(name string, form string) string {
+	switch form {
+	case unicodeNFC:
+		return norm.NFC.String(name)
+	case unicodeNFD:
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// unicodePath приводит к форме form сегменты экранированного пути URL.
+// Так записи одного адреса в NFC и NFD - один URL в очереди и в списке
+// посещенных, как и один файл. Сегменты, которые нормализация не меняет,
+// остаются как были
+func unicodePath(escaped string, form string) string {
+	if form == unicodeAsIs || !strings.Contains(escaped, "%") {
+		return escaped
+	}
+	segments := strings.Split(escaped, "/")
+	for i, s := range segments {
+		unescaped, err := url.PathUnescape(s)
+		if err != nil || !utf8.ValidString(unescaped) {
+			continue
+		}
+		if normalized := unicodeFileName(unescaped, form); normalized != unescaped {
+			segments[i] = url.PathEscape(normalized)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// windowsDevices - имена, которые Windows не дает файлам ни с каким
+// расширением (nul.html тоже нельзя)
+var windowsDevices = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// restrictFileName приводит имя файла через / к правилам mode. Замена
+// зависит только от имени, поэтому при повторных запусках и при
+// переписывании ссылок получается то же имя
+func restrictFileName(name string, mode string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = restrictSegment(s, mode)
+	}
+	return strings.Join(segments, "/")
+}
+
+// fileSegment готовит имя файла не из URL (например, из
+// Content-Disposition) так же, как сегменты путей из URL: форма Unicode,
+// --restrict-file-names и предел длины
+func (d *downloader) fileSegment(name string) string {
+	return shortenSegment(restrictSegment(unicodeFileName(name, d.unicodeFileNames), d.restrictFileNames), maxSegmentBytes)
+}
+
+// restrictSegment применяет правила mode к одному сегменту пути
+func restrictSegment(s string, mode string) string {
+	windows := mode == restrictWindows || mode == restrictPercent
+	ascii := mode == restrictASCII || mode == restrictPercent
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c < 0x20 || c == 0x7f,
+			ascii && c >= 0x80,
+			windows && strings.IndexByte(`\:*"<>|`, c) >= 0:
+			fmt.Fprintf(&b, "%%%02X", c)
+		case windows && c == '?':
+			// Как в wget: query отделяется @
+			b.WriteByte('@')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	s = b.String()
+	if !windows || s == "" {
+		return s
+	}
+
+	// Точку и пробел в конце имени Windows отбрасывает
+	if last := s[len(s)-1]; last == '.' || last == ' ' {
+		s = fmt.Sprintf("%s%%%02X", s[:len(s)-1], last)
+	}
+	base, _, _ := strings.Cut(s, ".")
+	if windowsDevices[strings.ToLower(strings.TrimRight(base, " "))] {
+		s = fmt.Sprintf("%%%02X%s", s[0], s[1:])
+	}
+	return s
+}
+
+// Пределы длины пути. Имя файла короче 255 байт (ext4, NTFS) на запас для
+// .part и временного .name.tmp-*, а весь путь на Windows - короче MAX_PATH
+const (
+	maxSegmentBytes = 255 - 16
+	windowsMaxPath  = 260 - 1 - 16
+)
+
+// shortenSegment укорачивает сегмент пути до max байт: обрезает его по
+// границе символа UTF-8 и добавляет хеш полного имени, так что разные
+// длинные имена не совпадают. Расширение сохраняется
+func shortenSegment(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	sum := sha256.Sum256([]byte(s))
+	suffix := "-" + hex.EncodeToString(sum[:4])
+	ext := path.Ext(s)
+	if len(ext) > 16 || len(ext)+len(suffix) >= max {
+		ext = ""
+	}
+	keep := max - len(suffix) - len(ext)
+	if keep < 1 {
+		keep = 1
+	}
+	for keep > 1 && !utf8.RuneStart(s[keep]) {
+		keep--
+	}
+	return s[:keep] + suffix + ext
+}
+
+// shortenName укорачивает слишком длинные сегменты имени через /
+func shortenName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = shortenSegment(s, maxSegmentBytes)
+	}
+	return strings.Join(segments, "/")
+}
+
+// fitWindowsPath укорачивает имя через / так, чтобы путь к нему от dir
+// уложился в MAX_PATH: сначала файл, затем каталоги от нижнего к верхнему.
+// Длина считается в байтах, для не-ASCII - с запасом
+func fitWindowsPath(dir string, name string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	overflow := len(dir) + 1 + len(name) - windowsMaxPath
+	if overflow <= 0 {
+		return name
+	}
+	segments := strings.Split(name, "/")
+	for i := len(segments) - 1; i >= 0 && overflow > 0; i-- {
+		s := segments[i]
+		// Короче хеша с расширением сегмент не станет
+		min := 1 + 9 + len(path.Ext(s))
+		if len(s)-overflow > min {
+			min = len(s) - overflow
+		}
+		if min >= len(s) {
+			continue
+		}
+		segments[i] = shortenSegment(s, min)
+		overflow -= len(s) - len(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
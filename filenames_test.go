@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRestrictFileName(t *testing.T) {
+	tests := []struct {
+		name, mode, want string
+	}{
+		{"a/b.html", restrictUnix, "a/b.html"},
+		{"a\x01b.html", restrictUnix, "a%01b.html"},
+		{"a:b*c.html", restrictUnix, "a:b*c.html"},
+		{"café.html", restrictUnix, "café.html"},
+		{"a:b*c.html", restrictWindows, "a%3Ab%2Ac.html"},
+		{`q"<>|.html`, restrictWindows, "q%22%3C%3E%7C.html"},
+		{"p?a=1.html", restrictWindows, "p@a=1.html"},
+		{"dir./name ", restrictWindows, "dir%2E/name%20"},
+		{"CON.html", restrictWindows, "%43ON.html"},
+		{"con", restrictWindows, "%63on"},
+		{"lpt1.txt/com9", restrictWindows, "%6Cpt1.txt/%63om9"},
+		{"console.html", restrictWindows, "console.html"},
+		{"café.html", restrictWindows, "café.html"},
+		{"café.html", restrictASCII, "caf%C3%A9.html"},
+		{"a:b.html", restrictASCII, "a:b.html"},
+		{"café:CON.html", restrictPercent, "caf%C3%A9%3ACON.html"},
+		{"aux.html", restrictPercent, "%61ux.html"},
+	}
+	for _, tt := range tests {
+		if got := restrictFileName(tt.name, tt.mode); got != tt.want {
+			t.Errorf("restrictFileName(%q, %s) = %q, want %q", tt.name, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestValidRestrictFileNames(t *testing.T) {
+	for _, mode := range []string{restrictUnix, restrictWindows, restrictASCII, restrictPercent} {
+		if err := validRestrictFileNames(mode); err != nil {
+			t.Errorf("validRestrictFileNames(%s) = %v", mode, err)
+		}
+	}
+	if err := validRestrictFileNames("dos"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+// Имена из URL и из Content-Disposition проходят одни правила, а ссылки
+// ведут на файлы под измененными именами
+func TestRestrictFileNamesMirror(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/CON">con</a><a href="/p?a=1">query</a><a href="/x:y.css">css</a><a href="/download">file</a>`))
+		case "/download":
+			w.Header().Set("Content-Disposition", `attachment; filename="re:port*?.txt"`)
+			w.Write([]byte("report"))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("page"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "--restrict-file-names", "windows", "--content-disposition", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	host := restrictSegment(filepath.Base(hostDirOf(dir, srv)), restrictWindows)
+	for _, name := range []string{"index.html", "%43ON.html", "p@a=1.html", "x%3Ay.css", "re%3Aport%2A@.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, host, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	index := readMirrorFile(t, dir, host+"/index.html")
+	for _, link := range []string{`href="%2543ON.html"`, `href="p@a=1.html"`, `href="x%253Ay.css"`} {
+		if !strings.Contains(index, link) {
+			t.Errorf("index.html has no %s:\n%s", link, index)
+		}
+	}
+}
+
+func TestShortenSegment(t *testing.T) {
+	if got := shortenSegment("short.html", 20); got != "short.html" {
+		t.Errorf("short name changed to %q", got)
+	}
+	if got := shortenSegment(strings.Repeat("a", 20), 20); got != strings.Repeat("a", 20) {
+		t.Errorf("name of exactly max bytes changed to %q", got)
+	}
+
+	long := strings.Repeat("very-long-slug-", 30) + ".html"
+	got := shortenSegment(long, maxSegmentBytes)
+	if len(got) != maxSegmentBytes || !strings.HasSuffix(got, ".html") || !strings.HasPrefix(got, "very-long-slug-") {
+		t.Errorf("shortenSegment(long) = %q (%d bytes)", got, len(got))
+	}
+	// Тот же URL всегда дает то же имя, а разные - разные
+	if again := shortenSegment(long, maxSegmentBytes); again != got {
+		t.Errorf("shortenSegment is not stable: %q and %q", got, again)
+	}
+	if other := shortenSegment(strings.Repeat("very-long-slug-", 30)+"2.html", maxSegmentBytes); other == got {
+		t.Errorf("different names shortened to the same %q", got)
+	}
+
+	// Обрезка не разрывает символы UTF-8
+	for shift := 0; shift < 4; shift++ {
+		name := strings.Repeat("x", shift) + strings.Repeat("длинный-заголовок-", 20) + ".html"
+		got := shortenSegment(name, maxSegmentBytes)
+		if !utf8.ValidString(got) || len(got) > maxSegmentBytes || !strings.HasSuffix(got, ".html") {
+			t.Errorf("shift %d: shortenSegment = %q (%d bytes, valid UTF-8 %v)", shift, got, len(got), utf8.ValidString(got))
+		}
+	}
+
+	// Слишком длинное расширение не сохраняется
+	name := strings.Repeat("a", 300) + "." + strings.Repeat("b", 40)
+	if got := shortenSegment(name, maxSegmentBytes); len(got) != maxSegmentBytes || strings.Contains(got, ".") {
+		t.Errorf("shortenSegment with a long extension = %q (%d bytes)", got, len(got))
+	}
+}
+
+func TestShortenName(t *testing.T) {
+	long := strings.Repeat("d", 300)
+	got := shortenName("a/" + long + "/" + long + ".css")
+	want := "a/" + shortenSegment(long, maxSegmentBytes) + "/" + shortenSegment(long+".css", maxSegmentBytes)
+	if got != want {
+		t.Errorf("shortenName = %q, want %q", got, want)
+	}
+	if !strings.HasSuffix(got, ".css") || len(got) != 2+2*maxSegmentBytes+1 {
+		t.Errorf("shortenName = %q (%d bytes)", got, len(got))
+	}
+}
+
+func TestFitWindowsPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "example.com")
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fitWindowsPath(dir, "a/b.html"); got != "a/b.html" {
+		t.Errorf("short path changed to %q", got)
+	}
+
+	name := strings.Repeat("section", 10) + "/" + strings.Repeat("chapter", 10) + "/" + strings.Repeat("page", 30) + ".html"
+	got := fitWindowsPath(dir, name)
+	if n := len(abs) + 1 + len(got); n > windowsMaxPath {
+		t.Errorf("fitWindowsPath = %q: path has %d bytes, want at most %d", got, n, windowsMaxPath)
+	}
+	if !strings.HasSuffix(got, ".html") || strings.Count(got, "/") != 2 {
+		t.Errorf("fitWindowsPath = %q", got)
+	}
+	if again := fitWindowsPath(dir, name); again != got {
+		t.Errorf("fitWindowsPath is not stable: %q and %q", got, again)
+	}
+}
+
+func TestLongNamesMirror(t *testing.T) {
+	slug := strings.Repeat("very-long-slug-", 30)
+	unicodeSlug := strings.Repeat("длинный-заголовок-", 20)
+	pages := []string{"/blog/" + slug, "/blog/" + slug + "2", "/blog/" + url.PathEscape(unicodeSlug)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			for _, page := range pages {
+				fmt.Fprintf(w, `<a href="%s">page</a>`, page)
+			}
+			return
+		}
+		w.Write([]byte("page " + r.URL.EscapedPath()))
+	}))
+	defer srv.Close()
+
+	names := []string{
+		shortenSegment(slug+".html", maxSegmentBytes),
+		shortenSegment(slug+"2.html", maxSegmentBytes),
+		shortenSegment(unicodeSlug+".html", maxSegmentBytes),
+	}
+	for run := 0; run < 2; run++ {
+		dir := t.TempDir()
+		stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", srv.URL+"/")
+		if err != nil || stats.Failed != 0 {
+			t.Fatalf("Failed = %d, %v", stats.Failed, err)
+		}
+		host := hostDirOf(dir, srv)
+		index := readMirrorFile(t, host, "index.html")
+		for i, name := range names {
+			if got := readMirrorFile(t, host, "blog/"+name); !strings.Contains(got, "page "+pages[i]) {
+				t.Errorf("run %d: blog/%s = %q", run, name, got)
+			}
+			// Ссылка ведет на укороченное имя
+			link := `href="` + (&url.URL{Path: "blog/" + name}).EscapedPath() + `"`
+			if !strings.Contains(index, link) {
+				t.Errorf("run %d: index.html has no %s:\n%s", run, link, index)
+			}
+		}
+	}
+}
+
+func TestDecodeSegment(t *testing.T) {
+	tests := []struct{ segment, want string }{
+		{"caf%C3%A9-review", "café-review"},
+		{"caf%c3%a9-review", "café-review"},
+		{"café-review", "café-review"},
+		{"%7Euser", "~user"},
+		{"a%20b", "a b"},
+		{"a+b", "a+b"},
+		// Разделители, NUL и сам % не раскрываются
+		{"a%2Fb", "a%2Fb"},
+		{"a%2fb", "a%2Fb"},
+		{"a%5Cb", "a%5Cb"},
+		{"a%00b", "a%00b"},
+		{"100%25", "100%25"},
+		{"caf%25C3%25A9", "caf%25C3%25A9"},
+		// Байты, которые не складываются в UTF-8
+		{"bad%FF", "bad%FF"},
+		{"%E2%82", "%E2%82"},
+		{"%E2%82%ACeuro%FF", "€euro%FF"},
+		// Испорченная последовательность % остается как есть
+		{"100%zz", "100%zz"},
+	}
+	for _, tt := range tests {
+		if got := decodeSegment(tt.segment); got != tt.want {
+			t.Errorf("decodeSegment(%q) = %q, want %q", tt.segment, got, tt.want)
+		}
+	}
+}
+
+func TestDecodedNamesMirror(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.EscapedPath()]++
+		mu.Unlock()
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/articles/caf%C3%A9-review">encoded</a>
+<a href="/articles/café-review">literal</a>
+<a href="/articles/caf%c3%a9-review">lower case</a>
+<a href="/files/100%25.txt">percent</a>
+<a href="/files/a%2Fb.txt">slash</a>
+<a href="/files/a%00b.txt">nul</a>`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("content of " + r.URL.EscapedPath()))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		args  []string
+		files []string
+		links []string
+	}{
+		{
+			files: []string{"articles/café-review.html", "files/100%25.txt", "files/a%2Fb.txt", "files/a%00b.txt"},
+			links: []string{`href="articles/caf%C3%A9-review.html"`, `href="files/100%2525.txt"`, `href="files/a%252Fb.txt"`, `href="files/a%2500b.txt"`},
+		},
+		{
+			// С --restrict-file-names ascii не-ASCII остается закодированным
+			args:  []string{"--restrict-file-names", "ascii"},
+			files: []string{"articles/caf%C3%A9-review.html", "files/100%25.txt", "files/a%2Fb.txt", "files/a%00b.txt"},
+			links: []string{`href="articles/caf%25C3%25A9-review.html"`},
+		},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		clear(requested)
+		mu.Unlock()
+		dir := t.TempDir()
+		stats, err := testMirror(t, dir, append(append([]string{"-e", "robots=off", "-l", "1"}, tt.args...), srv.URL+"/")...)
+		if err != nil || stats.Failed != 0 {
+			t.Fatalf("%q: Failed = %d, %v", tt.args, stats.Failed, err)
+		}
+		mu.Lock()
+		// Три записи одного адреса - один запрос
+		if n := requested["/articles/caf%C3%A9-review"]; n != 1 || len(requested) != 5 {
+			t.Errorf("%q: requested %v", tt.args, requested)
+		}
+		mu.Unlock()
+
+		host := hostDirOf(dir, srv)
+		files := slices.DeleteFunc(mirrorFiles(t, host), func(name string) bool { return name == "index.html" })
+		want := slices.Clone(tt.files)
+		slices.Sort(want)
+		if !slices.Equal(files, want) {
+			t.Errorf("%q: files %q, want %q", tt.args, files, want)
+		}
+		if got := readMirrorFile(t, host, tt.files[0]); got != "content of /articles/caf%C3%A9-review" {
+			t.Errorf("%q: %s = %q", tt.args, tt.files[0], got)
+		}
+
+		index := readMirrorFile(t, host, "index.html")
+		if n := strings.Count(index, tt.links[0]); n != 3 {
+			t.Errorf("%q: %d links %s, want 3:\n%s", tt.args, n, tt.links[0], index)
+		}
+		for _, link := range tt.links[1:] {
+			if !strings.Contains(index, link) {
+				t.Errorf("%q: index.html has no %s:\n%s", tt.args, link, index)
+			}
+		}
+	}
+}
+
+func TestUnicodeFileName(t *testing.T) {
+	const composed, decomposed = "caf\u00e9", "cafe\u0301"
+	tests := []struct {
+		name, form, want string
+	}{
+		{decomposed, unicodeNFC, composed},
+		{composed, unicodeNFC, composed},
+		{composed, unicodeNFD, decomposed},
+		{decomposed, unicodeNFD, decomposed},
+		{decomposed, unicodeAsIs, decomposed},
+		{composed, unicodeAsIs, composed},
+		{"plain/ascii.html", unicodeNFD, "plain/ascii.html"},
+	}
+	for _, tt := range tests {
+		if got := unicodeFileName(tt.name, tt.form); got != tt.want {
+			t.Errorf("unicodeFileName(%q, %s) = %q, want %q", tt.name, tt.form, got, tt.want)
+		}
+	}
+}
+
+func TestUnicodeFileNamesMirror(t *testing.T) {
+	// Одна страница под составной (NFC) и разложенной (NFD) записью é
+	const nfcPath, nfdPath = "/caf%C3%A9", "/cafe%CC%81"
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="` + nfcPath + `">composed</a><a href="` + nfdPath + `">decomposed</a>`))
+		case nfcPath, nfdPath:
+			mu.Lock()
+			requests++
+			mu.Unlock()
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<p>café</p>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		args     []string
+		files    []string
+		links    []string
+		requests int
+	}{
+		{nil, []string{"caf\u00e9.html"}, []string{"caf%C3%A9.html", "caf%C3%A9.html"}, 1},
+		{[]string{"--unicode-file-names", "nfd"}, []string{"cafe\u0301.html"}, []string{"cafe%CC%81.html", "cafe%CC%81.html"}, 1},
+		{[]string{"--unicode-file-names", "none"}, []string{"caf\u00e9.html", "cafe\u0301.html"}, []string{"caf%C3%A9.html", "cafe%CC%81.html"}, 2},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		requests = 0
+		mu.Unlock()
+		dir := t.TempDir()
+		stats, err := testMirror(t, dir, append(append([]string{"-e", "robots=off", "-l", "1", "--concurrency", "4"}, tt.args...), srv.URL+"/")...)
+		if err != nil || stats.Failed != 0 {
+			t.Fatalf("%q: Failed = %d, %v", tt.args, stats.Failed, err)
+		}
+		// Обе записи - один URL: страница запрашивается один раз, и два
+		// обработчика не пишут в один файл
+		mu.Lock()
+		if requests != tt.requests {
+			t.Errorf("%q: page requested %d times, want %d", tt.args, requests, tt.requests)
+		}
+		mu.Unlock()
+		host := hostDirOf(dir, srv)
+		files := slices.DeleteFunc(mirrorFiles(t, host), func(name string) bool { return name == "index.html" })
+		want := slices.Clone(tt.files)
+		slices.Sort(want)
+		if !slices.Equal(files, want) {
+			t.Errorf("%q: files %q, want %q", tt.args, files, want)
+		}
+
+		// Ссылка совпадает с именем файла на диске байт в байт
+		index := readMirrorFile(t, host, "index.html")
+		wantIndex := `<a href="` + tt.links[0] + `">composed</a><a href="` + tt.links[1] + `">decomposed</a>`
+		if !strings.Contains(index, wantIndex) {
+			t.Errorf("%q: index.html has no %s:\n%s", tt.args, wantIndex, index)
+		}
+		for _, link := range tt.links {
+			name, err := url.PathUnescape(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(host, name)); err != nil {
+				t.Errorf("%q: link %s: %v", tt.args, link, err)
+			}
+		}
+	}
+
+	if _, _, err := parseArgs([]string{"--unicode-file-names", "nfkc", "http://example.com/"}, io.Discard, io.Discard); err == nil {
+		t.Error("--unicode-file-names nfkc accepted")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// fileRules - списки -A/-R. Элемент со знаками *, ? или [ сравнивается
+// с именем файла как шаблон, остальные - как суффикс имени
+type fileRules struct {
+	accept     []string
+	reject     []string
+	ignoreCase bool
+}
+
+// allowed проверяет имя файла из пути URL: при непустом -A имя должно
+// подойти под один из его элементов и ни под один элемент -R
+func (r fileRules) allowed(u *url.URL) bool {
+	name := path.Base(u.Path)
+	if strings.HasSuffix(u.Path, "/") || name == "." || name == "/" {
+		name = ""
+	}
+	if len(r.accept) > 0 && !r.match(name, r.accept) {
+		return false
+	}
+	return !r.match(name, r.reject)
+}
+
+func (r fileRules) match(name string, patterns []string) bool {
+	if r.ignoreCase {
+		name = strings.ToLower(name)
+	}
+	for _, p := range patterns {
+		if r.ignoreCase {
+			p = strings.ToLower(p)
+		}
+		if strings.ContainsAny(p, "*?[") {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		} else if name != "" && strings.HasSuffix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayBeHTML угадывает по пути, может ли URL оказаться страницей: каталоги,
+// файлы без расширения и типичные расширения страниц
+func mayBeHTML(u *url.URL) bool {
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return true
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case "", ".html", ".htm", ".xhtml", ".shtml", ".php", ".asp", ".aspx", ".jsp", ".cgi":
+		return true
+	}
+	return false
+}
+
+// urlFilters - --accept-regex и --reject-regex, проверяемые по полному URL.
+// Совпадение с --reject-regex важнее совпадения с --accept-regex
+type urlFilters struct {
+	accept []*regexp.Regexp
+	reject []*regexp.Regexp
+}
+
+func compileFilters(accept, reject []string) (urlFilters, error) {
+	var f urlFilters
+	for _, expr := range accept {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return f, fmt.Errorf("invalid --accept-regex %q: %v", expr, err)
+		}
+		f.accept = append(f.accept, re)
+	}
+	for _, expr := range reject {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return f, fmt.Errorf("invalid --reject-regex %q: %v", expr, err)
+		}
+		f.reject = append(f.reject, re)
+	}
+	return f, nil
+}
+
+func (f urlFilters) allowed(rawURL string) bool {
+	for _, re := range f.reject {
+		if re.MatchString(rawURL) {
+			return false
+		}
+	}
+	if len(f.accept) == 0 {
+		return true
+	}
+	for _, re := range f.accept {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// skip учитывает URL, пропущенный фильтром reason, для итоговой статистики
+func (d *downloader) skip(reason string) {
+	d.skippedMutex.Lock()
+	defer d.skippedMutex.Unlock()
+	d.skipped[reason]++
+}
+
+// countScheme учитывает ссылку со схемой, по которой ничего не
+// скачивается (mailto:, tel:, javascript:)
+func (d *downloader) countScheme(scheme string) {
+	d.skippedMutex.Lock()
+	defer d.skippedMutex.Unlock()
+	d.schemes[scheme]++
+}
+
+// Schemes возвращает число оставленных как есть ссылок по схемам
+func (d *downloader) Schemes() map[string]int {
+	d.skippedMutex.Lock()
+	defer d.skippedMutex.Unlock()
+
+	schemes := make(map[string]int, len(d.schemes))
+	for scheme, n := range d.schemes {
+		schemes[scheme] = n
+	}
+	return schemes
+}
+
+// Skipped возвращает число пропущенных URL по причинам
+func (d *downloader) Skipped() map[string]int {
+	d.skippedMutex.Lock()
+	defer d.skippedMutex.Unlock()
+
+	skipped := make(map[string]int, len(d.skipped))
+	for reason, n := range d.skipped {
+		skipped[reason] = n
+	}
+	return skipped
+}
+
+// mimeRules - --accept-mime и --reject-mime. Шаблон вида image/* подходит
+// ко всем подтипам
+type mimeRules struct {
+	accept []string
+	reject []string
+}
+
+func newMimeRules(accept, reject []string) mimeRules {
+	r := mimeRules{}
+	for _, p := range accept {
+		r.accept = append(r.accept, strings.ToLower(strings.TrimSpace(p)))
+	}
+	for _, p := range reject {
+		r.reject = append(r.reject, strings.ToLower(strings.TrimSpace(p)))
+	}
+	return r
+}
+
+func (r mimeRules) empty() bool {
+	return len(r.accept) == 0 && len(r.reject) == 0
+}
+
+// allowed проверяет Content-Type ответа без учета параметров
+func (r mimeRules) allowed(contentType string) bool {
+	mediaType := mediaType(contentType)
+	if len(r.accept) > 0 && !matchMime(mediaType, r.accept) {
+		return false
+	}
+	return !matchMime(mediaType, r.reject)
+}
+
+// mediaType возвращает тип из Content-Type без параметров
+func mediaType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		return t
+	}
+	t, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+func matchMime(mediaType string, patterns []string) bool {
+	for _, p := range patterns {
+		if p == "*/*" || p == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// probeRejected с --mime-probe отправляет HEAD и сообщает, что ответ будет
+// отвергнут по типу, чтобы не начинать загрузку тела. При любой ошибке
+// решение откладывается до ответа на GET
+func (d *downloader) probeRejected(rawURL string, host string, header http.Header, kind resourceKind) bool {
+	req, err := d.newRequest(http.MethodHead, rawURL)
+	if err != nil {
+		return false
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	d.acquire(host)
+	resp, err := d.client.Do(req)
+	d.release(host)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if resp.StatusCode != http.StatusOK || contentType == "" || d.mimeRules.allowed(contentType) {
+		return false
+	}
+	// Страницу все равно нужно скачать ради ссылок на ней
+	return kind == kindRequisite || mediaType(contentType) != "text/html"
+}
+
+// errFileTooLarge прерывает чтение тела, превысившего --max-file-size
+var errFileTooLarge = errors.New("file exceeds --max-file-size")
+
+// cappedBody обрывает чтение, как только прочитано больше remaining байт
+type cappedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// capBody ограничивает тело ответа остатком --max-file-size после offset
+// уже скачанных байт. Нужно для ответов без Content-Length и сжатых ответов
+func (d *downloader) capBody(body io.ReadCloser, offset int64) io.ReadCloser {
+	if d.maxFileSize <= 0 {
+		return body
+	}
+	return &cappedBody{ReadCloser: body, remaining: d.maxFileSize - offset}
+}
+
+// tooLarge сообщает, оборвана ли загрузка по --max-file-size, и если да,
+// удаляет недокачанный файл и учитывает пропуск
+func (d *downloader) tooLarge(rawURL string, status int, partPath string, err error) bool {
+	if !errors.Is(err, errFileTooLarge) {
+		return false
+	}
+	removePart(partPath)
+	d.verbosef(logEntry{event: "skip", url: rawURL, status: status, err: err}, "Aborted %s: more than %d bytes received, exceeds --max-file-size", rawURL, d.maxFileSize)
+	d.skipURL(rawURL, status, "--max-file-size")
+	return true
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFileRules(t *testing.T) {
+	tests := []struct {
+		rules fileRules
+		path  string
+		want  bool
+	}{
+		{fileRules{}, "/a.zip", true},
+		{fileRules{accept: []string{"pdf"}}, "/docs/a.pdf", true},
+		{fileRules{accept: []string{"pdf"}}, "/docs/a.PDF", false},
+		{fileRules{accept: []string{"pdf"}, ignoreCase: true}, "/docs/a.PDF", true},
+		{fileRules{accept: []string{".pdf"}}, "/a.pdf", true},
+		{fileRules{accept: []string{"pdf"}}, "/a.html", false},
+		// Каталог и корень не подходят ни под один суффикс
+		{fileRules{accept: []string{"pdf"}}, "/docs/", false},
+		{fileRules{accept: []string{"pdf"}}, "", false},
+		{fileRules{reject: []string{"zip", "mp4"}}, "/a.zip", false},
+		{fileRules{reject: []string{"zip", "mp4"}}, "/movie.MP4", true},
+		{fileRules{reject: []string{"zip", "mp4"}, ignoreCase: true}, "/movie.MP4", false},
+		{fileRules{reject: []string{"zip"}}, "/", true},
+		{fileRules{accept: []string{"*.tar.*"}}, "/src/x-1.0.tar.gz", true},
+		{fileRules{accept: []string{"*.tar.*"}}, "/src/x-1.0.tar", false},
+		{fileRules{accept: []string{"report-??.csv"}}, "/report-01.csv", true},
+		{fileRules{accept: []string{"report-[0-9].csv"}}, "/report-a.csv", false},
+		{fileRules{accept: []string{"*.TXT"}, ignoreCase: true}, "/notes.txt", true},
+		// -R важнее -A
+		{fileRules{accept: []string{"pdf"}, reject: []string{"draft*"}}, "/draft-1.pdf", false},
+		{fileRules{accept: []string{"pdf"}, reject: []string{"draft*"}}, "/final.pdf", true},
+	}
+	for _, tt := range tests {
+		if got := tt.rules.allowed(mustParseURL(t, "http://example.com"+tt.path)); got != tt.want {
+			t.Errorf("%+v allowed(%q) = %v, want %v", tt.rules, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMayBeHTML(t *testing.T) {
+	for path, want := range map[string]bool{
+		"":            true,
+		"/":           true,
+		"/docs/":      true,
+		"/about":      true,
+		"/page.HTML":  true,
+		"/index.php":  true,
+		"/a.pdf":      false,
+		"/style.css":  false,
+		"/x.tar.gz":   false,
+		"/v1.2/notes": true,
+	} {
+		if got := mayBeHTML(mustParseURL(t, "http://example.com"+path)); got != want {
+			t.Errorf("mayBeHTML(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+// fileSite - сайт с документацией из страниц и файлов разных типов,
+// который запоминает запрошенные пути
+type fileSite struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requested []string
+}
+
+func newFileSite(t *testing.T) *fileSite {
+	pages := map[string]string{
+		"/":                `<a href="/docs/">docs</a><a href="/a.pdf">a</a><a href="/b.zip">b</a><a href="/c.mp4">c</a><a href="/upper.PDF">upper</a><a href="/x.tar.gz">x</a>`,
+		"/docs/":           `<a href="guide.html">guide</a><a href="/docs/d.pdf">d</a>`,
+		"/docs/guide.html": `<a href="e.pdf">e</a><img src="logo.png">`,
+	}
+	s := &fileSite{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requested = append(s.requested, r.URL.Path)
+		s.mu.Unlock()
+		if page, ok := pages[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte("file " + r.URL.Path))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fileSite) paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := append([]string(nil), s.requested...)
+	sort.Strings(paths)
+	return paths
+}
+
+// mirrorFiles возвращает отсортированные пути файлов зеркала через /
+func mirrorFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, e os.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestAcceptRejectMirror(t *testing.T) {
+	tests := []struct {
+		args      []string
+		files     string
+		requested string
+	}{
+		{
+			// Страницы скачиваются ради ссылок и удаляются
+			[]string{"-A", "pdf"},
+			"a.pdf docs/d.pdf docs/e.pdf",
+			"/ /a.pdf /docs/ /docs/d.pdf /docs/e.pdf /docs/guide.html",
+		},
+		{
+			[]string{"-A", "pdf", "--ignore-case"},
+			"a.pdf docs/d.pdf docs/e.pdf upper.PDF",
+			"/ /a.pdf /docs/ /docs/d.pdf /docs/e.pdf /docs/guide.html /upper.PDF",
+		},
+		{
+			[]string{"-A", "pdf,*.tar.*,html"},
+			"a.pdf docs/d.pdf docs/e.pdf docs/guide.html x.tar.gz",
+			"/ /a.pdf /docs/ /docs/d.pdf /docs/e.pdf /docs/guide.html /x.tar.gz",
+		},
+		{
+			[]string{"-R", "zip,mp4"},
+			"a.pdf docs/d.pdf docs/e.pdf docs/guide.html docs/index.html docs/logo.png index.html upper.PDF x.tar.gz",
+			"/ /a.pdf /docs/ /docs/d.pdf /docs/e.pdf /docs/guide.html /docs/logo.png /upper.PDF /x.tar.gz",
+		},
+	}
+	for _, tt := range tests {
+		site := newFileSite(t)
+		dir := t.TempDir()
+		stats, err := testMirror(t, dir, append(append([]string{"-e", "robots=off", "-l", "-1"}, tt.args...), site.URL+"/")...)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.args, err)
+		}
+		if got := strings.Join(mirrorFiles(t, hostDirOf(dir, site.Server)), " "); got != tt.files {
+			t.Errorf("%q: saved %s, want %s", tt.args, got, tt.files)
+		}
+		if got := strings.Join(site.paths(), " "); got != tt.requested {
+			t.Errorf("%q: requested %s, want %s", tt.args, got, tt.requested)
+		}
+		if stats.Skipped["-A/-R"] == 0 {
+			t.Errorf("%q: no -A/-R skips counted: %v", tt.args, stats.Skipped)
+		}
+	}
+}
+
+func TestURLFilters(t *testing.T) {
+	tests := []struct {
+		accept, reject []string
+		url            string
+		want           bool
+	}{
+		{nil, nil, "http://example.com/any", true},
+		{[]string{`/docs/`}, nil, "http://example.com/docs/a.html", true},
+		{[]string{`/docs/`}, nil, "http://example.com/blog/a.html", false},
+		// Достаточно совпадения с одним из --accept-regex
+		{[]string{`/docs/`, `\.pdf$`}, nil, "http://example.com/files/a.pdf", true},
+		{nil, []string{`format=csv`}, "http://example.com/api/v1/export?format=csv", false},
+		{nil, []string{`format=csv`}, "http://example.com/api/v1/export?format=json", true},
+		// --reject-regex важнее --accept-regex
+		{[]string{`/api/`}, []string{`format=csv`}, "http://example.com/api/v1/export?format=csv", false},
+		{[]string{`/api/`}, []string{`format=csv`}, "http://example.com/api/v1/export?format=json", true},
+		// Проверяется весь URL, включая схему и хост
+		{[]string{`^https://`}, nil, "http://example.com/", false},
+		{nil, []string{`^http://cdn\.`}, "http://cdn.example.com/a.js", false},
+	}
+	for _, tt := range tests {
+		f, err := compileFilters(tt.accept, tt.reject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.allowed(tt.url); got != tt.want {
+			t.Errorf("accept %q reject %q: allowed(%q) = %v, want %v", tt.accept, tt.reject, tt.url, got, tt.want)
+		}
+	}
+
+	for _, args := range [][]string{{"--accept-regex", "("}, {"--reject-regex", "[a-"}} {
+		opts, urls, err := parseArgs(append(args, "-P", t.TempDir(), "http://example.com/"), io.Discard, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newDownloader(urls, opts); err == nil || !strings.Contains(err.Error(), args[0]) {
+			t.Errorf("%q: newDownloader error %v", args, err)
+		}
+	}
+}
+
+func TestURLFiltersMirror(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.RequestURI())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			// Ссылка на CSV записана ненормализованной и с фрагментом
+			w.Write([]byte(`<a href="/x/../api/v1/export?format=csv#top">csv</a>
+<a href="/api/v1/export?format=json">json</a>
+<a href="/docs/a.html">a</a>
+<a href="/docs/private/b.html">b</a>
+<a href="/blog/c.html">c</a>
+<img src="/img/x.png">`))
+		}
+	}))
+	defer srv.Close()
+
+	stats, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-l", "1",
+		"--accept-regex", "/docs/", "--accept-regex", "/api/",
+		"--reject-regex", "private", "--reject-regex", `/api/v1/export\?format=csv$`,
+		srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	sort.Strings(requested)
+	got := strings.Join(requested, " ")
+	mu.Unlock()
+	// Стартовый URL скачивается, хотя ни под один шаблон не подходит
+	if want := "/ /api/v1/export?format=json /docs/a.html"; got != want {
+		t.Errorf("requested %s, want %s", got, want)
+	}
+	if n := stats.Skipped["--accept-regex/--reject-regex"]; n != 4 {
+		t.Errorf("Skipped = %v, want 4 by --accept-regex/--reject-regex", stats.Skipped)
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	chunk := bytes.Repeat([]byte("x"), 64<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunks := 8
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/declared.iso">iso</a><a href="/chunked.bin">bin</a><a href="/chunked.html">page</a><a href="/small.bin">small</a>`))
+			return
+		case "/declared.iso":
+			w.Header().Set("Content-Length", fmt.Sprint(chunks*len(chunk)))
+		case "/chunked.html":
+			w.Header().Set("Content-Type", "text/html")
+		case "/small.bin":
+			chunks = 1
+		}
+		// Без Content-Length ответ уходит кусками с chunked-кодированием
+		for i := 0; i < chunks; i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	args := []string{"-P", dir, "--progress", "none", "--no-favicon", "--manifest", "none", "-v", "--log-format", "json",
+		"-e", "robots=off", "-l", "1", "--max-file-size", "100k", srv.URL + "/"}
+	opts, urls, err := parseArgs(args, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logBuf bytes.Buffer
+	if opts.logger, err = newLogger("json", &logBuf, levelVerbose, nil); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDownloader(urls, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := d.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Недокачанные файлы удалены
+	if got := strings.Join(mirrorFiles(t, hostDirOf(dir, srv)), " "); got != "index.html small.bin" {
+		t.Errorf("saved %s, want index.html small.bin", got)
+	}
+	if stats.Failed != 0 || stats.Skipped["--max-file-size"] != 3 {
+		t.Errorf("Failed = %d, Skipped = %v; want 3 skipped by --max-file-size", stats.Failed, stats.Skipped)
+	}
+	for _, want := range []string{
+		"Skipping " + srv.URL + "/declared.iso: size 524288 exceeds --max-file-size 102400",
+		"Aborted " + srv.URL + "/chunked.bin: more than 102400 bytes received",
+		"Aborted " + srv.URL + "/chunked.html: more than 102400 bytes received",
+	} {
+		if !strings.Contains(logBuf.String(), want) {
+			t.Errorf("log has no %q:\n%s", want, logBuf.String())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "sync"
+
+// frontier - очередь URL на загрузку. Задачи выдаются в порядке
+// поступления, так что сайт обходится в ширину. Очередь считается
+// исчерпанной, когда она пуста и ни одна выданная задача не выполняется:
+// пока задача выполняется, она может добавить новые
+type frontier struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   []job
+	active int // выданные, но еще не завершенные задачи
+}
+
+func newFrontier() *frontier {
+	f := &frontier{}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// push добавляет задачу. Задачи, найденные на странице, добавляются из
+// той же задачи до вызова done, поэтому active не обнуляется, пока у
+// обхода есть продолжение, и pop не может завершить обход раньше времени
+func (f *frontier) push(j job) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.jobs = append(f.jobs, j)
+	f.cond.Signal()
+}
+
+// pop ждет следующую задачу. false означает, что обход закончен
+func (f *frontier) pop() (job, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.jobs) == 0 {
+		if f.active == 0 {
+			return job{}, false
+		}
+		f.cond.Wait()
+	}
+
+	j := f.jobs[0]
+	f.jobs[0] = job{}
+	f.jobs = f.jobs[1:]
+	f.active++
+	return j, true
+}
+
+// hold удерживает очередь открытой, как невыполненная задача, пока
+// источник задач вне обхода не вызовет done
+func (f *frontier) hold() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.active++
+}
+
+// len возвращает число задач, ожидающих в очереди
+func (f *frontier) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.jobs)
+}
+
+// done отмечает завершение задачи, полученной из pop
+func (f *frontier) done() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.active--
+	if f.active == 0 && len(f.jobs) == 0 {
+		// Будим всех ожидающих, чтобы они увидели конец обхода
+		f.cond.Broadcast()
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFrontierEmpty(t *testing.T) {
+	f := newFrontier()
+	if _, ok := f.pop(); ok {
+		t.Fatal("pop on an empty frontier returned a job")
+	}
+}
+
+func TestFrontierOrder(t *testing.T) {
+	f := newFrontier()
+	for i := 0; i < 3; i++ {
+		f.push(job{url: strconv.Itoa(i)})
+	}
+	for i := 0; i < 3; i++ {
+		j, ok := f.pop()
+		if !ok || j.url != strconv.Itoa(i) {
+			t.Fatalf("pop %d = %q, %v", i, j.url, ok)
+		}
+		f.done()
+	}
+	if _, ok := f.pop(); ok {
+		t.Fatal("frontier not finished after all jobs are done")
+	}
+}
+
+// Воркеры добавляют задачи из выполняемых задач, а внешние источники -
+// под hold. Обход заканчивается, только когда выполнено все
+func TestFrontierConcurrent(t *testing.T) {
+	const (
+		producers = 16
+		perSource = 100
+		workers   = 8
+		maxDepth  = 3
+	)
+	f := newFrontier()
+	for i := 0; i < producers; i++ {
+		f.hold()
+	}
+
+	var processed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j, ok := f.pop()
+				if !ok {
+					return
+				}
+				processed.Add(1)
+				if j.depth < maxDepth {
+					f.push(job{url: j.url + "/a", depth: j.depth + 1})
+					f.push(job{url: j.url + "/b", depth: j.depth + 1})
+				}
+				f.done()
+			}
+		}()
+	}
+
+	var sources sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		sources.Add(1)
+		go func(i int) {
+			defer sources.Done()
+			defer f.done()
+			for k := 0; k < perSource; k++ {
+				f.push(job{url: fmt.Sprintf("/%d/%d", i, k)})
+			}
+		}(i)
+	}
+	sources.Wait()
+	wg.Wait()
+
+	// Каждая задача источника дает 1 + 2 + 4 + 8 задач
+	if want := int64(producers * perSource * 15); processed.Load() != want {
+		t.Errorf("processed %d jobs, want %d", processed.Load(), want)
+	}
+	if n := f.len(); n != 0 {
+		t.Errorf("%d jobs left in the frontier", n)
+	}
+}
+
+// Много воркеров обходят сайт, где страницы ссылаются друг на друга
+func TestMirrorConcurrentWorkers(t *testing.T) {
+	const pages = 200
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/p"), ".html"))
+		if r.URL.Path == "/" {
+			n, err = 0, nil
+		}
+		if err != nil || n >= pages {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		for k := 1; k <= 3; k++ {
+			fmt.Fprintf(w, `<a href="/p%d.html">%d</a>`, (n*3+k)%pages, k)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	stats, err := testMirror(t, dir, "--concurrency", "16", "-e", "robots=off", "-l", "-1", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pages != pages+1 || stats.Failed != 0 {
+		t.Errorf("saved %d pages with %d failures, want %d", stats.Pages, stats.Failed, pages+1)
+	}
+	for n := 0; n < pages; n++ {
+		if _, err := os.Stat(hostDirOf(dir, srv) + fmt.Sprintf("/p%d.html", n)); err != nil {
+			t.Error(err)
+		}
+	}
+}
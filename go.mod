@@ -2,4 +2,9 @@ module L2.16
 
 go 1.23.6
 
-require golang.org/x/net v0.42.0
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.17.11
+	golang.org/x/net v0.42.0
+	golang.org/x/text v0.27.0
+)
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hostState - состояние, общее для всех запросов к одному хосту
+type hostState struct {
+	robotsOnce   sync.Once
+	robotsLoaded atomic.Bool // robots уже загружен
+	robots       *robotsRules
+
+	// slots ограничивает число одновременных запросов к хосту
+	slots chan struct{}
+
+	mu    sync.Mutex
+	delay time.Duration
+	next  time.Time
+}
+
+func (d *downloader) host(name string) *hostState {
+	d.hostsMutex.Lock()
+	defer d.hostsMutex.Unlock()
+
+	h, ok := d.hosts[name]
+	if !ok {
+		h = &hostState{slots: make(chan struct{}, d.hostConnections)}
+		d.hosts[name] = h
+	}
+	return h
+}
+
+// robotsAllowed проверяет URL по robots.txt его хоста, загружая файл
+// при первом обращении к хосту. Файл запрашивается как обычный URL, через
+// слоты и паузы хоста, поэтому вызывается только воркером, не занявшим
+// слотов сам
+func (d *downloader) robotsAllowed(u *url.URL) bool {
+	if !d.respectRobots {
+		return true
+	}
+
+	h := d.host(u.Host)
+	h.robotsOnce.Do(func() {
+		h.robots = d.fetchRobots(u)
+		if h.robots != nil && h.robots.crawlDelay > 0 {
+			h.mu.Lock()
+			if h.robots.crawlDelay > h.delay {
+				h.delay = h.robots.crawlDelay
+			}
+			h.mu.Unlock()
+		}
+		h.robotsLoaded.Store(true)
+	})
+	return h.robots.allowed(robotsPath(u))
+}
+
+// robotsForbids сообщает, запрещает ли URL уже загруженный robots.txt его
+// хоста. Файл не загружается: так можно проверять ссылки, пока воркер
+// держит слоты, а окончательно URL проверит robotsAllowed перед загрузкой
+func (d *downloader) robotsForbids(u *url.URL) bool {
+	if !d.respectRobots {
+		return false
+	}
+	h := d.host(u.Host)
+	return h.robotsLoaded.Load() && !h.robots.allowed(robotsPath(u))
+}
+
+// robotsPath - путь URL с query в том виде, в каком его сравнивают с
+// правилами robots.txt
+func robotsPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+func (d *downloader) fetchRobots(u *url.URL) *robotsRules {
+	robotsURL := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}).String()
+
+	resp, _, err := d.fetch(http.MethodGet, robotsURL, u.Host, nil)
+	// Отсутствие robots.txt означает отсутствие ограничений
+	if statusOf(err) != 0 {
+		return nil
+	}
+	if err != nil {
+		if d.ctx.Err() == nil {
+			d.infof(logEntry{event: "robots", url: robotsURL, err: err}, "Failed to fetch %q: %v", robotsURL, err)
+		}
+		return nil
+	}
+	defer d.release(u.Host)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	if err := decodeBody(resp); err != nil {
+		d.infof(logEntry{event: "robots", url: robotsURL, err: err}, "Failed to decode %q: %v", robotsURL, err)
+		return nil
+	}
+
+	return parseRobots(io.LimitReader(resp.Body, 512<<10), productName)
+}
+
+// site возвращает настройки сайта из файла настроек; секция может быть
+// записана как с портом, так и без него
+func (d *downloader) site(host string) (siteConfig, bool) {
+	host = strings.ToLower(host)
+	if s, ok := d.sites[host]; ok {
+		return s, true
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		s, ok := d.sites[name]
+		return s, ok
+	}
+	return siteConfig{}, false
+}
+
+// waitTurn выдерживает минимальный интервал между запросами к хосту
+// (наибольший из --wait или wait сайта и Crawl-delay).
+// Каждый вызов резервирует себе момент старта, поэтому одновременные
+// запросы выстраиваются в очередь, а другие хосты не блокируются
+func (d *downloader) waitTurn(name string) {
+	h := d.host(name)
+
+	h.mu.Lock()
+	wait := d.wait
+	if s, ok := d.site(name); ok && s.hasWait {
+		wait = s.wait
+	}
+	delay := h.delay
+	if wait > delay {
+		delay = wait
+	}
+	now := time.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(delay)
+	h.mu.Unlock()
+
+	d.sleep(time.Until(start))
+}
+
+// pauseHost откладывает все последующие запросы к хосту минимум на delay
+func (d *downloader) pauseHost(name string, delay time.Duration) {
+	h := d.host(name)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if until := time.Now().Add(delay); until.After(h.next) {
+		h.next = until
+	}
+}
+
+// acquire занимает слот хоста, дожидается очереди к нему и занимает слот
+// общего семафора. Общий слот берется последним, чтобы запросы, ждущие
+// занятый или медленный хост, не отнимали места у запросов к другим хостам
+func (d *downloader) acquire(host string) {
+	h := d.host(host)
+	h.slots <- struct{}{}
+	d.waitTurn(host)
+	d.semaphore <- struct{}{}
+}
+
+func (d *downloader) release(host string) {
+	<-d.semaphore
+	<-d.host(host).slots
+}
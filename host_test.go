@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// timedSite - сайт из страницы со ссылками на pages страниц, который
+// запоминает время каждого запроса страницы
+type timedSite struct {
+	*httptest.Server
+	mu    sync.Mutex
+	times []time.Time
+}
+
+func newTimedSite(t *testing.T, pages int) *timedSite {
+	s := &timedSite{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.Lock()
+		s.times = append(s.times, time.Now())
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			for i := 0; i < pages; i++ {
+				fmt.Fprintf(w, `<a href="/p%d.html">%d</a>`, i, i)
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// gaps возвращает промежутки между запросами по порядку
+func (s *timedSite) gaps() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	times := append([]time.Time(nil), s.times...)
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	var gaps []time.Duration
+	for i := 1; i < len(times); i++ {
+		gaps = append(gaps, times[i].Sub(times[i-1]))
+	}
+	return gaps
+}
+
+func TestWaitBetweenRequests(t *testing.T) {
+	const wait = 100 * time.Millisecond
+	a, b := newTimedSite(t, 4), newTimedSite(t, 4)
+
+	start := time.Now()
+	stats, err := testMirror(t, t.TempDir(), "-l", "1", "--wait", "100ms", "--concurrency", "10", a.URL+"/", b.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if stats.Pages != 10 {
+		t.Errorf("Pages = %d, want 10", stats.Pages)
+	}
+
+	for _, s := range []*timedSite{a, b} {
+		gaps := s.gaps()
+		if len(gaps) != 4 {
+			t.Fatalf("%s: %d requests, want 5", s.URL, len(gaps)+1)
+		}
+		for _, gap := range gaps {
+			// Небольшой допуск на разрешение таймера
+			if gap < wait-5*time.Millisecond {
+				t.Errorf("%s: requests %v apart, want at least %v", s.URL, gap, wait)
+			}
+		}
+	}
+	// Хосты ждут параллельно: 4 промежутка на каждом, а не 8 подряд
+	if elapsed > 8*wait {
+		t.Errorf("crawl took %v: waiting for one host blocked the other", elapsed)
+	}
+}
+
+func TestWaitTurnReservesSlots(t *testing.T) {
+	d := testDownloader(t, "--wait", "50ms", "http://example.com/")
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.waitTurn("example.com")
+		}()
+	}
+	wg.Wait()
+	// Три одновременных запроса стартуют в 0, 50 и 100 мс
+	if elapsed := time.Since(start); elapsed < 95*time.Millisecond {
+		t.Errorf("three turns took %v, want at least 100ms", elapsed)
+	}
+	// Другой хост очереди не ждет
+	start = time.Now()
+	d.waitTurn("example.org")
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("first turn on another host took %v", elapsed)
+	}
+}
+
+// busySite - сайт из страницы со ссылками на pages медленных страниц,
+// который считает одновременные запросы к нему
+type busySite struct {
+	*httptest.Server
+	inFlight, peak atomic.Int32
+}
+
+func newBusySite(t *testing.T, pages int) *busySite {
+	s := &busySite{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		for {
+			peak := s.peak.Load()
+			if n <= peak || s.peak.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			for i := 0; i < pages; i++ {
+				fmt.Fprintf(w, `<a href="/p%d.html">%d</a>`, i, i)
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestHostConnections(t *testing.T) {
+	for _, limit := range []int{1, 2, 4} {
+		a, b := newBusySite(t, 20), newBusySite(t, 20)
+		stats, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-l", "1", "--concurrency", "10",
+			"--host-connections", fmt.Sprint(limit), a.URL+"/", b.URL+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Pages != 42 {
+			t.Errorf("limit %d: Pages = %d, want 42", limit, stats.Pages)
+		}
+		for _, s := range []*busySite{a, b} {
+			// Под нагрузкой лимит достигается, но не превышается
+			if peak := s.peak.Load(); peak != int32(limit) {
+				t.Errorf("limit %d: %s had %d requests in flight", limit, s.URL, peak)
+			}
+		}
+	}
+
+	if _, _, err := parseArgs([]string{"--host-connections", "0", "http://example.com/"}, io.Discard, io.Discard); err == nil {
+		t.Error("--host-connections 0 accepted")
+	}
+}
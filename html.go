@@ -0,0 +1,672 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// resourceKind - как ресурс был найден на странице
+type resourceKind int
+
+const (
+	kindPage      resourceKind = iota // ссылка на другую страницу
+	kindRequisite                     // картинка, стиль или скрипт, нужные для отображения
+	kindRefresh                       // <meta http-equiv="refresh">: страница, на которую браузер перейдет сам
+)
+
+// linkDepth - глубина ресурса по ссылке со страницы глубины depth. Переход
+// по meta refresh глубину не увеличивает: это та же страница по другому адресу
+func linkDepth(depth int, kind resourceKind) int {
+	if kind == kindRefresh {
+		return depth
+	}
+	return depth + 1
+}
+
+// willDownload сообщает, будет ли ресурс скачан с указанной глубины
+// или уже был поставлен в очередь раньше
+func (d *downloader) willDownload(u *url.URL, depth int, kind resourceKind) bool {
+	if !d.inScope(u) || !d.belowParent(u, kind) || d.pathTrap(u) || !d.fileRules.allowed(u) || !d.urlFilters.allowed(u.String()) || d.robotsForbids(u) {
+		return false
+	}
+	// URL, файл которого оказался бы вне зеркала, downloadURL пропустит
+	if _, ok := d.safeSavePath(u); !ok {
+		return false
+	}
+	if d.withinDepth(depth, kind) {
+		return true
+	}
+
+	d.visitedMutex.Lock()
+	defer d.visitedMutex.Unlock()
+	return d.visitedURLs[u.String()]
+}
+
+// withinDepth проверяет лимит глубины. С --page-requisites ресурсы страницы
+// скачиваются на любой глубине, но сами обход не продолжают
+func (d *downloader) withinDepth(depth int, kind resourceKind) bool {
+	if d.maxDepth == infiniteDepth || depth <= d.maxDepth {
+		return true
+	}
+	return d.pageRequisites && kind == kindRequisite
+}
+
+// linkAttr сообщает, содержит ли атрибут элемента ссылку, и вид ресурса
+func linkAttr(n *html.Node, key string) (bool, resourceKind) {
+	switch n.Data {
+	case "a", "area":
+		return key == "href", kindPage
+	case "iframe", "frame":
+		return key == "src", kindPage
+	case "link":
+		isLink, kind := linkRel(n)
+		return isLink && key == "href", kind
+	case "img", "script":
+		return key == "src", kindRequisite
+	// <source> встречается и в <picture> (со srcset), и в <video>/<audio>
+	// (с src); и то и другое нужно для отображения страницы
+	case "source", "audio", "track":
+		return key == "src", kindRequisite
+	case "video":
+		return key == "src" || key == "poster", kindRequisite
+	case "embed":
+		return key == "src", kindRequisite
+	case "object":
+		return key == "data", kindRequisite
+	case "meta":
+		return key == "content" && isMetaAsset(n), kindRequisite
+	}
+	return false, kindPage
+}
+
+// walkNoscript вызывает fn для содержимого <noscript>. Парсер, как и
+// браузер с включенными скриптами, оставляет его текстом, поэтому текст
+// разбирается как фрагмент HTML, а после fn записывается обратно
+func walkNoscript(n *html.Node, fn func(c *html.Node)) {
+	text := n.FirstChild
+	if text == nil || text.Type != html.TextNode || text.NextSibling != nil || strings.TrimSpace(text.Data) == "" {
+		return
+	}
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(text.Data), body)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	for _, c := range nodes {
+		fn(c)
+		if err := html.Render(&buf, c); err != nil {
+			return
+		}
+	}
+	text.Data = buf.String()
+}
+
+// metaAssets - свойства Open Graph и Twitter card, в content которых URL
+// картинки, видео или звука для превью страницы
+var metaAssets = map[string]bool{
+	"og:image":              true,
+	"og:image:url":          true,
+	"og:image:secure_url":   true,
+	"og:video":              true,
+	"og:video:url":          true,
+	"og:video:secure_url":   true,
+	"og:audio":              true,
+	"og:audio:url":          true,
+	"og:audio:secure_url":   true,
+	"twitter:image":         true,
+	"twitter:image:src":     true,
+	"twitter:player:stream": true,
+}
+
+// isMetaAsset проверяет, ссылается ли <meta> на ресурс превью. Open Graph
+// задает свойство в property, Twitter - в name, но встречается и наоборот
+func isMetaAsset(n *html.Node) bool {
+	return metaAssets[strings.ToLower(strings.TrimSpace(attrValue(n, "property")))] ||
+		metaAssets[strings.ToLower(strings.TrimSpace(attrValue(n, "name")))]
+}
+
+// isRequisiteLink проверяет, подключает ли <link> стиль или иконку
+func isRequisiteLink(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		if attr.Key != "rel" {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.ToLower(attr.Val)) {
+			switch rel {
+			case "stylesheet", "icon", "apple-touch-icon":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// linkRel определяет по rel (и as для предзагрузки), на что ведет <link>.
+// dns-prefetch и preconnect указывают на источник, а не на документ, и
+// ссылками не считаются
+func linkRel(n *html.Node) (bool, resourceKind) {
+	if isRequisiteLink(n) {
+		return true, kindRequisite
+	}
+	rels := strings.Fields(strings.ToLower(attrValue(n, "rel")))
+	origins := len(rels) > 0
+	for _, rel := range rels {
+		switch rel {
+		case "modulepreload", "manifest":
+			return true, kindRequisite
+		case "preload", "prefetch":
+			// Без as (или с as=document) prefetch загружает следующую страницу
+			switch preloadAs(n) {
+			case "document":
+				return true, kindPage
+			case "":
+				if rel == "prefetch" {
+					return true, kindPage
+				}
+			}
+			return true, kindRequisite
+		case "dns-prefetch", "preconnect":
+			// только источник
+		default:
+			origins = false
+		}
+	}
+	return !origins, kindPage
+}
+
+// preloadAs - тип предзагружаемого ресурса из атрибута as
+func preloadAs(n *html.Node) string {
+	return strings.ToLower(strings.TrimSpace(attrValue(n, "as")))
+}
+
+// skipLink сообщает, что ссылку элемента n не нужно обходить из-за
+// --no-meta-refresh, --no-alternate-stylesheets или --no-meta-assets
+func (d *downloader) skipLink(n *html.Node, kind resourceKind) bool {
+	switch {
+	case kind == kindRefresh:
+		return d.noMetaRefresh
+	case n.Data == "link":
+		return d.noAlternateStyles && isAlternateStyle(n)
+	case n.Data == "meta":
+		return d.noMetaAssets
+	}
+	return false
+}
+
+// isNofollow проверяет, помечена ли ссылка <a> или <area> rel="nofollow"
+func isNofollow(n *html.Node) bool {
+	return (n.Data == "a" || n.Data == "area") && hasRel(n, "nofollow")
+}
+
+// isAlternateStyle проверяет, подключает ли <link> альтернативный стиль
+func isAlternateStyle(n *html.Node) bool {
+	return n.Data == "link" && hasRel(n, "alternate") && hasRel(n, "stylesheet")
+}
+
+// walkLinks вызывает fn для каждого атрибута документа, содержащего ссылку,
+// вместе с его элементом. Пустые ссылки и якоря пропускаются. Каждый
+// вариант из srcset передается отдельным атрибутом, а то, что fn в нем
+// изменит, попадает в srcset
+func walkLinks(n *html.Node, fn func(n *html.Node, attr *html.Attribute, kind resourceKind)) {
+	if n.Type == html.ElementNode {
+		if n.Data == "noscript" {
+			walkNoscript(n, func(c *html.Node) { walkLinks(c, fn) })
+		}
+		if n.Data == "object" {
+			resolveCodebase(n)
+		}
+		for i := range n.Attr {
+			attr := &n.Attr[i]
+			isLink, kind := linkAttr(n, attr.Key)
+			if !isLink || attr.Val == "" || strings.HasPrefix(attr.Val, "#") {
+				continue
+			}
+			fn(n, attr, kind)
+		}
+		if n.Data == "meta" && strings.EqualFold(strings.TrimSpace(attrValue(n, "http-equiv")), "refresh") {
+			for i := range n.Attr {
+				if n.Attr[i].Key != "content" {
+					continue
+				}
+				prefix, quote, ref, ok := splitRefresh(n.Attr[i].Val)
+				if !ok {
+					continue
+				}
+				target := html.Attribute{Key: "content", Val: ref}
+				fn(n, &target, kindRefresh)
+				n.Attr[i].Val = prefix + quote + target.Val + quote
+			}
+		}
+		if n.Data == "img" || n.Data == "source" {
+			for i := range n.Attr {
+				if n.Attr[i].Key != "srcset" {
+					continue
+				}
+				n.Attr[i].Val = rewriteSrcset(n.Attr[i].Val, func(ref string) string {
+					candidate := html.Attribute{Key: "src", Val: ref}
+					fn(n, &candidate, kindRequisite)
+					return candidate.Val
+				})
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkLinks(c, fn)
+	}
+}
+
+// metaRefreshPattern делит content meta refresh на задержку с необязательным
+// "url=" и сам адрес: "0; url=/home", "0;URL='/home'", "5, /home"
+var metaRefreshPattern = regexp.MustCompile(`(?i)^(\s*[\d.]+\s*[;,]?\s*(?:url\s*=\s*)?)(.*)$`)
+
+// splitRefresh разбирает content meta refresh: prefix - задержка вместе с
+// "url=", quote - кавычка вокруг адреса, если была. ok = false, если адреса
+// нет (обновление той же страницы) или content не разобран
+func splitRefresh(content string) (prefix, quote, ref string, ok bool) {
+	m := metaRefreshPattern.FindStringSubmatch(content)
+	if m == nil {
+		return "", "", "", false
+	}
+	prefix, ref = m[1], strings.TrimSpace(m[2])
+	if ref != "" && (ref[0] == '\'' || ref[0] == '"') {
+		quote = ref[:1]
+		ref = ref[1:]
+		if i := strings.Index(ref, quote); i >= 0 {
+			ref = ref[:i]
+		}
+	}
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return "", "", "", false
+	}
+	return prefix, quote, ref, true
+}
+
+// resolveCodebase переносит codebase <object> в data: относительный data
+// разрешается от codebase, а не от страницы. Сам codebase удаляется, иначе
+// браузер применил бы его и к переписанной ссылке
+func resolveCodebase(n *html.Node) {
+	codebase, data := attrValue(n, "codebase"), attrValue(n, "data")
+	if codebase == "" || data == "" {
+		return
+	}
+	base, err1 := url.Parse(codebase)
+	ref, err2 := url.Parse(data)
+	if err1 != nil || err2 != nil || ref.IsAbs() || ref.Host != "" || strings.HasPrefix(ref.Path, "/") {
+		return
+	}
+
+	var resolved string
+	if base.IsAbs() || base.Host != "" || strings.HasPrefix(base.Path, "/") {
+		resolved = base.ResolveReference(ref).String()
+	} else {
+		// Относительный codebase сам разрешается от страницы, поэтому
+		// относительные пути просто склеиваются
+		resolved = codebase[:strings.LastIndex(codebase, "/")+1] + data
+	}
+	for i := range n.Attr {
+		if n.Attr[i].Key == "data" {
+			n.Attr[i].Val = resolved
+		}
+	}
+	removeAttr(n, "codebase")
+}
+
+// rewriteSrcset заменяет URL каждого варианта в srcset на результат fn,
+// не трогая дескрипторы (1x, 800w) и разделители. Разбор следует правилам
+// HTML: URL - все до пробела, запятые в конце URL его завершают, а
+// дескрипторы идут до запятой вне скобок. Непонятное остается как есть
+func rewriteSrcset(srcset string, fn func(ref string) string) string {
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+	}
+
+	var b strings.Builder
+	last, pos := 0, 0
+	for {
+		for pos < len(srcset) && (isSpace(srcset[pos]) || srcset[pos] == ',') {
+			pos++
+		}
+		if pos == len(srcset) {
+			break
+		}
+
+		start := pos
+		for pos < len(srcset) && !isSpace(srcset[pos]) {
+			pos++
+		}
+		end := pos
+		if srcset[end-1] == ',' {
+			for end > start && srcset[end-1] == ',' {
+				end--
+			}
+		} else {
+			inParens := false
+			for ; pos < len(srcset); pos++ {
+				c := srcset[pos]
+				if c == '(' {
+					inParens = true
+				} else if c == ')' {
+					inParens = false
+				} else if c == ',' && !inParens {
+					pos++
+					break
+				}
+			}
+		}
+
+		if ref := srcset[start:end]; ref != "" && !strings.HasPrefix(ref, "#") {
+			b.WriteString(srcset[last:start])
+			b.WriteString(fn(ref))
+			last = end
+		}
+	}
+	b.WriteString(srcset[last:])
+	return b.String()
+}
+
+// processHTML ставит в очередь ресурсы страницы (если recurse) и
+// переписывает ссылки на них относительно файла страницы savePath.
+// Возвращает и указания robots из <meta> и заголовков ответа header
+func (d *downloader) processHTML(content []byte, baseURL *url.URL, savePath string, depth int, recurse bool, header http.Header) ([]byte, robotsDirectives) {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: baseURL.String(), err: err}, "Failed to parse HTML: %v", err)
+		return content, headerRobots(header)
+	}
+	robots := d.pageRobots(doc, header)
+	if robots.nofollow && recurse {
+		d.verbosef(logEntry{event: "skip", url: baseURL.String()}, "Not following links of %s: robots nofollow", baseURL)
+	}
+
+	// Ссылки разрешаются от <base href>, но переписываются в пути
+	// относительно самого файла, поэтому <base> в копии не нужен
+	base, baseNode := documentBase(doc, baseURL)
+	resolve := func(ref string) string {
+		if !fetchableScheme(ref) {
+			return ref
+		}
+		if u, err := base.Parse(ref); err == nil {
+			return u.String()
+		}
+		return ref
+	}
+	walkLinks(doc, func(n *html.Node, attr *html.Attribute, kind resourceKind) {
+		// С --no-meta-refresh переход не обходится, но и без сайта ведет туда
+		// же. Так же и с альтернативными стилями при --no-alternate-stylesheets
+		// и превью при --no-meta-assets
+		if d.skipLink(n, kind) {
+			attr.Val = resolve(attr.Val)
+			return
+		}
+		if d.extractDataURIs && len(attr.Val) > 5 && strings.EqualFold(attr.Val[:5], "data:") {
+			attr.Val, _ = d.extractDataURI(attr.Val, savePath)
+			return
+		}
+		// Данные для fetch() сохраняются под своим именем, без .html
+		if n.Data == "link" && preloadAs(n) == "fetch" {
+			d.keepName(resolve(attr.Val))
+		}
+		if n.Data == "link" && hasRel(n, "manifest") {
+			d.noteWebManifest(resolve(attr.Val))
+		}
+		// С --respect-nofollow по ссылке обход не идет, а с nofollow для всей
+		// страницы - по всем, кроме ее ресурсов. Локальный путь в ссылке
+		// остается, только если файл скачается по другой ссылке
+		if d.respectNofollow && isNofollow(n) || robots.nofollow && kind != kindRequisite {
+			if recurse {
+				d.skip("nofollow")
+			}
+			ref := resolve(attr.Val)
+			attr.Val = d.rewriteLink(ref, baseURL, savePath, depth, false, kind)
+			if key, ok := d.linkKey(ref); ok {
+				d.addNotSaved(key)
+			}
+			return
+		}
+		attr.Val = d.rewriteLink(resolve(attr.Val), baseURL, savePath, depth, recurse, kind)
+	})
+	walkStyles(doc, func(css string) string {
+		return rewriteCSSURLs(css, func(ref string) string {
+			return d.rewriteLink(resolve(ref), baseURL, savePath, depth, true, kindRequisite)
+		})
+	})
+	if d.structuredData {
+		d.processStructuredData(doc, base, baseURL, savePath, depth)
+	}
+	dropBase(baseNode)
+	if recurse {
+		d.requestFavicon(baseURL, depth)
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		d.errorf(logEntry{event: "parse", url: baseURL.String(), err: err}, "Failed to render HTML: %v", err)
+		return content, robots
+	}
+	return buf.Bytes(), robots
+}
+
+// documentBase возвращает URL, от которого браузер разрешает ссылки
+// страницы pageURL: href первого <base> с href, разрешенный от pageURL,
+// или сам pageURL. Второе значение - найденный <base> или nil
+func documentBase(doc *html.Node, pageURL *url.URL) (*url.URL, *html.Node) {
+	var find func(n *html.Node) *html.Node
+	find = func(n *html.Node) *html.Node {
+		if n.Type == html.ElementNode && n.Data == "base" && hasAttr(n, "href") {
+			return n
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if found := find(c); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	n := find(doc)
+	if n == nil {
+		return pageURL, nil
+	}
+	// Как и браузер, base с data: или javascript: не учитываем
+	base, err := pageURL.Parse(strings.TrimSpace(attrValue(n, "href")))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return pageURL, n
+	}
+	return base, n
+}
+
+// dropBase убирает <base> из сохраняемой копии: переписанные ссылки
+// относительны файлу и разрешались бы от base второй раз. Если у <base>
+// есть target, остается он один
+func dropBase(n *html.Node) {
+	if n == nil {
+		return
+	}
+	removeAttr(n, "href")
+	if len(n.Attr) == 0 && n.Parent != nil {
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// refScheme возвращает схему ссылки в нижнем регистре или "" для
+// относительной ссылки. В отличие от url.Parse, не спотыкается о
+// javascript: с пробелами и прочие ссылки, которые не являются URL
+func refScheme(ref string) string {
+	ref = strings.TrimSpace(ref)
+	for i := 0; i < len(ref); i++ {
+		c := ref[i]
+		switch {
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		case i > 0 && c == ':':
+			return strings.ToLower(ref[:i])
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// fetchableScheme проверяет, можно ли скачать ресурс по ссылке: годятся
+// только http, https и относительные ссылки
+func fetchableScheme(ref string) bool {
+	switch refScheme(ref) {
+	case "", "http", "https":
+		return true
+	}
+	return false
+}
+
+// rewriteLink ставит в очередь ресурс по ссылке ref со страницы baseURL
+// (если recurse) и возвращает ссылку, переписанную относительно файла
+// страницы savePath, или абсолютную, если ресурс не будет скачан
+func (d *downloader) rewriteLink(ref string, baseURL *url.URL, savePath string, depth int, recurse bool, kind resourceKind) string {
+	// mailto:, tel:, javascript: и прочие ссылки остаются как были
+	if !fetchableScheme(ref) {
+		d.countScheme(refScheme(ref))
+		d.debugf(logEntry{event: "skip", url: baseURL.String()}, "Leaving %s: link as is", refScheme(ref))
+		return ref
+	}
+
+	// Разрешаем относительные URL
+	absoluteURL, err := baseURL.Parse(ref)
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: baseURL.String(), err: err}, "Failed to parse URL %q: %v", ref, err)
+		return ref
+	}
+	fragment := absoluteURL.Fragment
+
+	// Нормализуем URL
+	d.normalizeURL(absoluteURL)
+	absoluteURL.User = nil
+	absoluteURL.Fragment = ""
+	if d.stripQuery {
+		absoluteURL.RawQuery = ""
+	}
+
+	if d.inScope(absoluteURL) {
+		d.addReference(baseURL.String(), absoluteURL.String())
+	}
+
+	// Загружаем ресурс
+	if recurse {
+		d.enqueue(job{url: absoluteURL.String(), depth: linkDepth(depth, kind), referer: baseURL.String(), kind: kind})
+	}
+
+	// Ссылки на то, что не будет скачано, делаем абсолютными
+	if !d.willDownload(absoluteURL, linkDepth(depth, kind), kind) {
+		absoluteURL.Fragment = fragment
+		return absoluteURL.String()
+	}
+
+	// Заменяем ссылку на локальный путь к файлу, под которым ресурс
+	// сохраняется с учетом редиректов
+	if canonical, err := url.Parse(d.resolveAlias(absoluteURL.String())); err == nil {
+		absoluteURL = canonical
+	}
+	localPath := d.getSavePath(absoluteURL)
+	relPath, err := filepath.Rel(filepath.Dir(savePath), localPath)
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: baseURL.String(), err: err}, "Failed to calculate relative path: %v", err)
+		return ref
+	}
+
+	return localLink(relPath, fragment)
+}
+
+// processLocalHTML продолжает обход по уже сохраненной странице. Ссылки в
+// ней переписаны на локальные пути, поэтому исходные URL восстанавливаются
+// по индексу зеркала; сама страница не изменяется
+func (d *downloader) processLocalHTML(savePath string, pageURL *url.URL, depth int) {
+	content, err := d.readSaved(savePath)
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: pageURL.String(), err: err}, "Failed to read %q: %v", savePath, err)
+		return
+	}
+
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: pageURL.String(), err: err}, "Failed to parse HTML: %v", err)
+		return
+	}
+
+	robots := d.pageRobots(doc, nil)
+	walkLinks(doc, func(n *html.Node, attr *html.Attribute, kind resourceKind) {
+		if d.skipLink(n, kind) || isExtractedData(attr.Val) || d.respectNofollow && isNofollow(n) || robots.nofollow && kind != kindRequisite {
+			return
+		}
+		if n.Data == "link" && hasRel(n, "manifest") {
+			if rawURL, ok := d.localLinkURL(attr.Val, pageURL, savePath); ok {
+				d.noteWebManifest(rawURL)
+			}
+		}
+		d.followLocalLink(attr.Val, pageURL, savePath, depth, kind)
+	})
+	walkStyles(doc, func(css string) string {
+		cssRefs(css, func(ref string) {
+			d.followLocalLink(ref, pageURL, savePath, depth, kindRequisite)
+		})
+		return css
+	})
+	if d.structuredData {
+		d.followStructuredData(doc, pageURL, savePath, depth)
+	}
+	d.requestFavicon(pageURL, depth)
+}
+
+// followLocalLink ставит в очередь ресурс по ссылке из уже сохраненной
+// страницы savePath
+func (d *downloader) followLocalLink(value string, pageURL *url.URL, savePath string, depth int, kind resourceKind) {
+	rawURL, ok := d.localLinkURL(value, pageURL, savePath)
+	if !ok {
+		return
+	}
+	if u, err := url.Parse(rawURL); err == nil && d.inScope(u) {
+		d.addReference(pageURL.String(), rawURL)
+	}
+	d.enqueue(job{url: rawURL, depth: linkDepth(depth, kind), referer: pageURL.String(), kind: kind})
+}
+
+// localLinkURL возвращает URL ресурса по ссылке из уже сохраненной
+// страницы savePath. Локальные пути переводятся в URL по индексу зеркала
+func (d *downloader) localLinkURL(value string, pageURL *url.URL, savePath string) (string, bool) {
+	if !fetchableScheme(value) {
+		return "", false
+	}
+	ref, err := url.Parse(value)
+	if err != nil {
+		return "", false
+	}
+
+	if !ref.IsAbs() && ref.Host == "" {
+		localPath := filepath.Join(filepath.Dir(savePath), filepath.FromSlash(ref.Path))
+		if rawURL, ok := d.index.urlForPath(d.indexPath(localPath)); ok {
+			return rawURL, true
+		}
+	}
+
+	absoluteURL := pageURL.ResolveReference(ref)
+	d.normalizeURL(absoluteURL)
+	absoluteURL.User = nil
+	absoluteURL.Fragment = ""
+	if d.stripQuery {
+		absoluteURL.RawQuery = ""
+	}
+	return absoluteURL.String(), true
+}
+
+// localLink превращает относительный путь к файлу в значение атрибута,
+// экранируя символы, которые браузер иначе воспримет как часть URL
+func localLink(relPath string, fragment string) string {
+	link := &url.URL{Path: filepath.ToSlash(relPath), Fragment: fragment}
+	return link.String()
+}
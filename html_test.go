@@ -0,0 +1,732 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestRewriteSrcset(t *testing.T) {
+	upper := func(ref string) string { return "L(" + ref + ")" }
+	tests := []struct {
+		srcset string
+		want   string
+	}{
+		{"a.jpg 1x, b.jpg 2x, c.jpg 800w", "L(a.jpg) 1x, L(b.jpg) 2x, L(c.jpg) 800w"},
+		{"a.jpg", "L(a.jpg)"},
+		{"  a.jpg  1x ,\n\tb.jpg 2x  ", "  L(a.jpg)  1x ,\n\tL(b.jpg) 2x  "},
+		// URL идет до пробела: запятая в конце его завершает, а в
+		// середине остается частью URL, как в HTML
+		{"a.jpg,b.jpg 2x", "L(a.jpg,b.jpg) 2x"},
+		{"a.jpg, b.jpg 2x", "L(a.jpg), L(b.jpg) 2x"},
+		{"a,b.jpg 1x", "L(a,b.jpg) 1x"},
+		{"data:image/png;base64,AAA= 1x, b.jpg 2x", "L(data:image/png;base64,AAA=) 1x, L(b.jpg) 2x"},
+		// Запятая в скобках дескриптора не разделяет варианты
+		{"a.jpg (x, y) 1x, b.jpg", "L(a.jpg) (x, y) 1x, L(b.jpg)"},
+		{"a.jpg 1x,", "L(a.jpg) 1x,"},
+		// Непонятное остается как есть
+		{"", ""},
+		{" , , ", " , , "},
+		{",a.jpg", ",L(a.jpg)"},
+		{"#frag 1x, b.jpg 2x", "#frag 1x, L(b.jpg) 2x"},
+		{"a.jpg 1x 2x garbage", "L(a.jpg) 1x 2x garbage"},
+	}
+	for _, tt := range tests {
+		if got := rewriteSrcset(tt.srcset, upper); got != tt.want {
+			t.Errorf("rewriteSrcset(%q) = %q, want %q", tt.srcset, got, tt.want)
+		}
+	}
+}
+
+func TestSrcsetMirror(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		if r.URL.Path == "/gallery/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<img src="small.jpg" srcset="small.jpg 1x, /img/large.jpg 2x" sizes="(max-width: 600px) 480px, 800px">
+<picture><source srcset="wide.webp 1200w,narrow.webp 600w" sizes="100vw" type="image/webp"><img src="fallback.jpg"></picture>
+<img srcset="data:image/gif;base64,R0lGOD 1x, hidpi.jpg 2x">`))
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("image"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "-p", srv.URL+"/gallery/"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for _, path := range []string{"/gallery/small.jpg", "/img/large.jpg", "/gallery/wide.webp", "/gallery/narrow.webp", "/gallery/fallback.jpg", "/gallery/hidpi.jpg"} {
+		if !requested[path] {
+			t.Errorf("%s not requested", path)
+		}
+	}
+	mu.Unlock()
+
+	page := readMirrorFile(t, hostDirOf(dir, srv), "gallery/index.html")
+	for _, want := range []string{
+		`srcset="small.jpg 1x, ../img/large.jpg 2x"`,
+		// sizes не трогается
+		`sizes="(max-width: 600px) 480px, 800px"`,
+		`<source srcset="wide.webp 1200w,narrow.webp 600w" sizes="100vw" type="image/webp"/>`,
+		`srcset="data:image/gif;base64,R0lGOD 1x, hidpi.jpg 2x"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("index.html has no %q:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, srv.URL) {
+		t.Errorf("index.html still points at the server:\n%s", page)
+	}
+}
+
+func TestMediaMirror(t *testing.T) {
+	video := strings.Repeat("v", 200<<10)
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<video poster="/media/poster.jpg" controls>
+<source src="/media/clip.webm" type="video/webm"><source src="/media/clip.mp4" type="video/mp4">
+<track src="/media/subs.vtt" kind="subtitles" srclang="en">
+</video>
+<video src="/media/direct.mp4"></video>
+<audio src="/media/song.mp3"><source src="/media/song.ogg"></audio>`))
+		case "/media/poster.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("poster"))
+		case "/media/subs.vtt":
+			w.Header().Set("Content-Type", "text/vtt")
+			w.Write([]byte("WEBVTT"))
+		case "/media/song.mp3", "/media/song.ogg":
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write([]byte("song"))
+		default:
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write([]byte(video))
+		}
+	}))
+	defer srv.Close()
+
+	all := []string{"media/poster.jpg", "media/clip.webm", "media/clip.mp4", "media/subs.vtt", "media/direct.mp4", "media/song.mp3", "media/song.ogg"}
+	tests := []struct {
+		args  []string
+		saved []string
+	}{
+		{nil, all},
+		// Видео отсекается фильтрами, а постер и субтитры все равно скачиваются
+		{[]string{"--max-file-size", "100k"}, []string{"media/poster.jpg", "media/subs.vtt", "media/song.mp3", "media/song.ogg"}},
+		{[]string{"--reject-mime", "video/*"}, []string{"media/poster.jpg", "media/subs.vtt", "media/song.mp3", "media/song.ogg"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if _, err := testMirror(t, dir, append(append([]string{"-e", "robots=off", "-l", "0", "-p"}, tt.args...), srv.URL+"/")...); err != nil {
+			t.Fatalf("%q: %v", tt.args, err)
+		}
+		host := hostDirOf(dir, srv)
+		files := strings.Join(mirrorFiles(t, host), " ")
+		for _, name := range all {
+			want := false
+			for _, s := range tt.saved {
+				want = want || s == name
+			}
+			if got := strings.Contains(" "+files+" ", " "+name+" "); got != want {
+				t.Errorf("%q: %s saved = %v, want %v (saved %s)", tt.args, name, got, want, files)
+			}
+		}
+
+		page := readMirrorFile(t, host, "index.html")
+		for _, name := range tt.saved {
+			if !strings.Contains(page, `"`+name+`"`) {
+				t.Errorf("%q: index.html does not link to %s:\n%s", tt.args, name, page)
+			}
+		}
+	}
+}
+
+func TestResolveCodebase(t *testing.T) {
+	tests := []struct {
+		codebase, data string
+		want           string
+		kept           bool // codebase остается
+	}{
+		{"assets/", "chart.svg", "assets/chart.svg", false},
+		{"assets/flash/main.swf", "movie.swf", "assets/flash/movie.swf", false},
+		{"/plugins/", "chart.svg", "/plugins/chart.svg", false},
+		{"https://cdn.example.com/v2/", "chart.svg", "https://cdn.example.com/v2/chart.svg", false},
+		// Абсолютный data от codebase не зависит
+		{"assets/", "/root.svg", "/root.svg", true},
+		{"assets/", "https://example.com/x.svg", "https://example.com/x.svg", true},
+		{"", "chart.svg", "chart.svg", false},
+	}
+	for _, tt := range tests {
+		n := &html.Node{Type: html.ElementNode, Data: "object", Attr: []html.Attribute{{Key: "data", Val: tt.data}}}
+		if tt.codebase != "" {
+			n.Attr = append(n.Attr, html.Attribute{Key: "codebase", Val: tt.codebase})
+		}
+		resolveCodebase(n)
+		if got := attrValue(n, "data"); got != tt.want {
+			t.Errorf("codebase %q, data %q: data = %q, want %q", tt.codebase, tt.data, got, tt.want)
+		}
+		if kept := attrValue(n, "codebase") != ""; kept != tt.kept {
+			t.Errorf("codebase %q, data %q: codebase kept = %v", tt.codebase, tt.data, kept)
+		}
+	}
+}
+
+func TestFramesAndObjects(t *testing.T) {
+	pages := map[string]string{
+		"/site/":         `<html><frameset cols="20%,80%"><frame src="nav.html" name="nav"><frame src="main.html" name="main"></frameset></html>`,
+		"/site/nav.html": `<a href="main.html" target="main">main</a><a href="/deep.html">deep</a>`,
+		"/site/main.html": `<a href="nav.html" target="nav">nav</a>
+<iframe src="widget.html"></iframe>
+<object data="chart.svg" codebase="assets/" type="image/svg+xml"></object>
+<embed src="docs/viewer.pdf" type="application/pdf">`,
+		"/site/widget.html": `<p>widget</p>`,
+	}
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		if page, ok := pages[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+			return
+		}
+		w.Write([]byte("file"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "2", srv.URL+"/site/"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for path, want := range map[string]bool{
+		"/site/nav.html": true, "/site/main.html": true, "/site/widget.html": true,
+		"/site/assets/chart.svg": true, "/site/docs/viewer.pdf": true,
+		// Фреймы - страницы: ссылки с них подчиняются глубине
+		"/deep.html": true,
+	} {
+		if requested[path] != want {
+			t.Errorf("%s requested = %v, want %v", path, requested[path], want)
+		}
+	}
+	mu.Unlock()
+
+	host := hostDirOf(dir, srv)
+	for name, want := range map[string][]string{
+		"site/index.html": {`<frame src="nav.html" name="nav">`, `<frame src="main.html" name="main">`},
+		"site/nav.html":   {`<a href="main.html" target="main">`, `<a href="../deep.html">`},
+		"site/main.html":  {`<a href="nav.html" target="nav">`, `<iframe src="widget.html">`, `<object data="assets/chart.svg" type="image/svg+xml">`, `<embed src="docs/viewer.pdf"`},
+	} {
+		page := readMirrorFile(t, host, name)
+		for _, w := range want {
+			if !strings.Contains(page, w) {
+				t.Errorf("%s has no %q:\n%s", name, w, page)
+			}
+		}
+	}
+
+	// На глубине 1 фреймы скачиваются, а ссылки с них уже нет
+	mu.Lock()
+	requested = make(map[string]bool)
+	mu.Unlock()
+	if _, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-l", "1", srv.URL+"/site/"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !requested["/site/nav.html"] || !requested["/site/main.html"] || requested["/deep.html"] || requested["/site/widget.html"] {
+		t.Errorf("-l 1 requested %v", requested)
+	}
+}
+
+func TestDocumentBase(t *testing.T) {
+	page := mustParseURL(t, "http://example.com/pages/index.html")
+	tests := []struct {
+		html string
+		want string
+		node bool
+	}{
+		{`<p>no base</p>`, "http://example.com/pages/index.html", false},
+		{`<base href="../app/">`, "http://example.com/app/", true},
+		{`<base href=" https://cdn.example.org/v2/ ">`, "https://cdn.example.org/v2/", true},
+		{`<base href="/first/"><base href="/second/">`, "http://example.com/first/", true},
+		// base без href не учитывается, с data: или javascript: - тоже
+		{`<base target="_blank">`, "http://example.com/pages/index.html", false},
+		{`<base href="javascript:alert(1)">`, "http://example.com/pages/index.html", true},
+		{`<base href="data:text/html,x">`, "http://example.com/pages/index.html", true},
+	}
+	for _, tt := range tests {
+		doc, err := html.Parse(strings.NewReader(tt.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		base, n := documentBase(doc, page)
+		if base.String() != tt.want || (n != nil) != tt.node {
+			t.Errorf("%s: base %s, node %v; want %s, %v", tt.html, base, n != nil, tt.want, tt.node)
+		}
+	}
+}
+
+func TestBaseHref(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("other"))
+	}))
+	defer other.Close()
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/pages/index.html":
+			w.Write([]byte(`<html><head><base href="../app/"></head><body>
+<a href="a.html">a</a><img src="img/logo.png"><a href="/root.html">root</a><a href="#top">top</a></body></html>`))
+		case "/pages/target.html":
+			w.Write([]byte(`<html><head><base href="/app/" target="_blank"></head><body><a href="b.html">b</a></body></html>`))
+		case "/pages/cdn.html":
+			w.Write([]byte(`<html><head><base href="` + other.URL + `/v2/"></head><body><img src="pic.png"></body></html>`))
+		default:
+			w.Write([]byte("page"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "-p",
+		srv.URL+"/pages/index.html", srv.URL+"/pages/target.html", srv.URL+"/pages/cdn.html"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for path, want := range map[string]bool{"/app/a.html": true, "/app/img/logo.png": true, "/root.html": true, "/app/b.html": true, "/pages/a.html": false, "/pages/pic.png": false} {
+		if requested[path] != want {
+			t.Errorf("%s requested = %v, want %v", path, requested[path], want)
+		}
+	}
+	mu.Unlock()
+
+	host := hostDirOf(dir, srv)
+	index := readMirrorFile(t, host, "pages/index.html")
+	for _, want := range []string{`<a href="../app/a.html">`, `<img src="../app/img/logo.png"/>`, `<a href="../root.html">`, `<a href="#top">`} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index.html has no %q:\n%s", want, index)
+		}
+	}
+	// Переписанные ссылки относительны файлу, и base их бы исказил
+	if strings.Contains(index, "<base") {
+		t.Errorf("index.html keeps <base>:\n%s", index)
+	}
+	if got := readMirrorFile(t, host, "pages/target.html"); !strings.Contains(got, `<base target="_blank"/>`) || !strings.Contains(got, `<a href="../app/b.html">`) {
+		t.Errorf("target.html = %s", got)
+	}
+	// Другой хост без -H не скачивается, и ссылка становится абсолютной
+	if got := readMirrorFile(t, host, "pages/cdn.html"); !strings.Contains(got, `<img src="`+other.URL+`/v2/pic.png"/>`) || strings.Contains(got, "<base") {
+		t.Errorf("cdn.html = %s", got)
+	}
+
+	// С -H картинка с другого хоста скачивается и переписывается в его каталог
+	dir = t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "-p", "-H", srv.URL+"/pages/cdn.html"); err != nil {
+		t.Fatal(err)
+	}
+	want := `<img src="../../` + strings.TrimPrefix(other.URL, "http://") + `/v2/pic.png"/>`
+	if got := readMirrorFile(t, hostDirOf(dir, srv), "pages/cdn.html"); !strings.Contains(got, want) {
+		t.Errorf("cdn.html with -H has no %q:\n%s", want, got)
+	}
+	if got := readMirrorFile(t, hostDirOf(dir, other), "v2/pic.png"); got != "other" {
+		t.Errorf("v2/pic.png = %q", got)
+	}
+}
+
+func TestSplitRefresh(t *testing.T) {
+	tests := []struct {
+		content            string
+		prefix, quote, ref string
+		ok                 bool
+	}{
+		{"0; url=/home", "0; url=", "", "/home", true},
+		{"0;URL='/home'", "0;URL=", "'", "/home", true},
+		{`5 ; Url = "/a b.html" `, "5 ; Url = ", `"`, "/a b.html", true},
+		{"5, /home", "5, ", "", "/home", true},
+		{"0.5;url=http://example.com/", "0.5;url=", "", "http://example.com/", true},
+		{"0; url='/home' extra", "0; url=", "'", "/home", true},
+		// Обновление той же страницы и ссылки на фрагмент не переходы
+		{"30", "", "", "", false},
+		{"0; url=", "", "", "", false},
+		{"0; url=#top", "", "", "", false},
+		{"soon; url=/home", "", "", "", false},
+	}
+	for _, tt := range tests {
+		prefix, quote, ref, ok := splitRefresh(tt.content)
+		if prefix != tt.prefix || quote != tt.quote || ref != tt.ref || ok != tt.ok {
+			t.Errorf("splitRefresh(%q) = %q, %q, %q, %v; want %q, %q, %q, %v", tt.content, prefix, quote, ref, ok, tt.prefix, tt.quote, tt.ref, tt.ok)
+		}
+	}
+}
+
+func TestMetaRefresh(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<meta http-equiv="refresh" content="0; URL='/home.html'">`))
+		case "/home.html":
+			w.Write([]byte(`<META HTTP-EQUIV="Refresh" CONTENT="0;url=docs/final.html">`))
+		case "/docs/final.html":
+			w.Write([]byte(`<meta http-equiv="refresh" content="5, /"><a href="/next.html">next</a>`))
+		default:
+			w.Write([]byte("page"))
+		}
+	}))
+	defer srv.Close()
+
+	// Переход - ссылка той же глубины: цепочка проходит и с -l 0
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if !requested["/home.html"] || !requested["/docs/final.html"] || requested["/next.html"] {
+		t.Errorf("requested %v", requested)
+	}
+	mu.Unlock()
+	host := hostDirOf(dir, srv)
+	for name, want := range map[string]string{
+		"index.html":      `content="0; URL=&#39;home.html&#39;"`,
+		"home.html":       `content="0;url=docs/final.html"`,
+		"docs/final.html": `content="5, ../index.html"`,
+	} {
+		if got := readMirrorFile(t, host, name); !strings.Contains(got, want) {
+			t.Errorf("%s has no %q:\n%s", name, want, got)
+		}
+	}
+
+	mu.Lock()
+	requested = make(map[string]bool)
+	mu.Unlock()
+	dir = t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "--no-meta-refresh", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if requested["/home.html"] {
+		t.Error("--no-meta-refresh followed the refresh")
+	}
+	mu.Unlock()
+	// Без копии цели переход ведет на сайт
+	if got := readMirrorFile(t, hostDirOf(dir, srv), "index.html"); !strings.Contains(got, `content="0; URL=&#39;`+srv.URL+`/home.html&#39;"`) {
+		t.Errorf("index.html with --no-meta-refresh = %s", got)
+	}
+}
+
+func TestNoscript(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/blog/post.html":
+			w.Header().Set("Content-Type", "text/html")
+			// Шаблон lazysizes: настоящая картинка только в <noscript>
+			w.Write([]byte(`<html><head><noscript><link rel="stylesheet" href="/css/noscript.css"></noscript></head><body>
+<img class="lazyload" src="data:image/gif;base64,R0lGODlhAQABAAAAACw=" data-src="/img/photo.jpg" alt="photo">
+<noscript><img src="/img/photo.jpg" alt="photo &amp; caption"><div style="background:url(/img/bg.png)">x</div></noscript>
+<noscript>   </noscript>
+<p>after</p></body></html>`))
+		case "/css/noscript.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte(`.lazyload { display: none }`))
+		default:
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("image " + r.URL.Path))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "-p", srv.URL+"/blog/post.html"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for _, path := range []string{"/img/photo.jpg", "/img/bg.png", "/css/noscript.css"} {
+		if !requested[path] {
+			t.Errorf("%s not requested", path)
+		}
+	}
+	mu.Unlock()
+
+	host := hostDirOf(dir, srv)
+	if got := readMirrorFile(t, host, "img/photo.jpg"); got != "image /img/photo.jpg" {
+		t.Errorf("img/photo.jpg = %q", got)
+	}
+	page := readMirrorFile(t, host, "blog/post.html")
+	for _, want := range []string{
+		`<noscript><link rel="stylesheet" href="../css/noscript.css"/></noscript>`,
+		`<noscript><img src="../img/photo.jpg" alt="photo &amp; caption"/><div style="background:url(../img/bg.png)">x</div></noscript>`,
+		`<noscript>   </noscript>`,
+		`src="data:image/gif;base64,R0lGODlhAQABAAAAACw="`,
+		`<p>after</p>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("post.html has no %q:\n%s", want, page)
+		}
+	}
+
+	// Сохраненная страница разбирается так же и при повторном обходе
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs []string
+	walkLinks(doc, func(n *html.Node, attr *html.Attribute, kind resourceKind) {
+		refs = append(refs, attr.Val)
+	})
+	if got := strings.Join(refs, " "); !strings.Contains(got, "../img/photo.jpg") || !strings.Contains(got, "../css/noscript.css") {
+		t.Errorf("links of the saved page: %s", got)
+	}
+}
+
+func TestRefScheme(t *testing.T) {
+	tests := []struct {
+		ref       string
+		scheme    string
+		fetchable bool
+	}{
+		{"mailto:info@example.com", "mailto", false},
+		{"  JavaScript:void(0)", "javascript", false},
+		{"javascript: alert('a b')", "javascript", false},
+		{"tel:+1-555-0100", "tel", false},
+		{"data:text/plain,hi", "data", false},
+		{"about:blank", "about", false},
+		{"ws://example.com/socket", "ws", false},
+		{"git+ssh://example.com/repo.git", "git+ssh", false},
+		{"HTTPS://example.com/", "https", true},
+		{"http://example.com/", "http", true},
+		{"//cdn.example.com/lib.js", "", true},
+		{"/path:with:colons", "", true},
+		{"page.html", "", true},
+		{"1a:b", "", true},
+		{"#top", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := refScheme(tt.ref); got != tt.scheme {
+			t.Errorf("refScheme(%q) = %q, want %q", tt.ref, got, tt.scheme)
+		}
+		if got := fetchableScheme(tt.ref); got != tt.fetchable {
+			t.Errorf("fetchableScheme(%q) = %v, want %v", tt.ref, got, tt.fetchable)
+		}
+	}
+}
+
+func TestNonFetchableSchemes(t *testing.T) {
+	links := []string{
+		`<a href="mailto:info@example.com?subject=Hello%20there">mail</a>`,
+		`<a href="tel:+1-555-0100">call</a>`,
+		`<a href="javascript:void(0)">js</a>`,
+		`<a href="JavaScript: history.back()">back</a>`,
+		`<img src="data:image/gif;base64,R0lGODlhAQABAAAAACw="/>`,
+		`<iframe src="about:blank"></iframe>`,
+		`<a href="ws://example.com/socket">ws</a>`,
+		`<a href="sms:+15550100">sms</a>`,
+	}
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/contact/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body>` + strings.Join(links, "\n") + `<a href="../about.html">about</a></body></html>`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<p>about</p>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := testDownloader(t, "-P", dir, "-e", "robots=off", "-l", "1", "-p", "--no-favicon", "--manifest", "none", srv.URL+"/contact/")
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := d.Wait()
+	if err != nil || stats.Failed != 0 {
+		t.Fatalf("Failed = %d, %v", stats.Failed, err)
+	}
+
+	host := hostDirOf(dir, srv)
+	got := readMirrorFile(t, host, "contact/index.html")
+	for _, link := range links {
+		if !strings.Contains(got, link) {
+			t.Errorf("index.html has no %s:\n%s", link, got)
+		}
+	}
+	if !strings.Contains(got, `<a href="../about.html">about</a>`) {
+		t.Errorf("http link not rewritten:\n%s", got)
+	}
+	if files := strings.Join(mirrorFiles(t, host), " "); files != "about.html contact/index.html" {
+		t.Errorf("files: %s", files)
+	}
+	mu.Lock()
+	if strings.Join(requested, " ") != "/contact/ /about.html" {
+		t.Errorf("requested: %q", requested)
+	}
+	mu.Unlock()
+
+	want := map[string]int{"mailto": 1, "tel": 1, "javascript": 2, "data": 1, "about": 1, "ws": 1, "sms": 1}
+	if got := d.Schemes(); !maps.Equal(got, want) {
+		t.Errorf("Schemes() = %v, want %v", got, want)
+	}
+	if n := len(d.Skipped()); n != 0 {
+		t.Errorf("Skipped() = %v", d.Skipped())
+	}
+}
+
+func TestSchemeRelativeLinks(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	record := func(name string, r *http.Request) {
+		mu.Lock()
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		requested[name+" "+scheme+" "+r.URL.Path] = true
+		mu.Unlock()
+	}
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record("cdn", r)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("cdn " + r.URL.Path))
+	}))
+	defer cdn.Close()
+	cdnHost := strings.TrimPrefix(cdn.URL, "http://")
+
+	handler := func(host func() string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			record("site", r)
+			switch r.URL.Path {
+			case "/blog/post.html":
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte(`<html><head><script src="//` + host() + `/js/app.js"></script></head><body>
+<img src="//` + cdnHost + `/img/logo.png">
+<a href="//` + host() + `/about.html#team">about</a>
+<a href="//` + cdnHost + `/page.html">cdn page</a>
+</body></html>`))
+			case "/js/app.js":
+				w.Header().Set("Content-Type", "application/javascript")
+				w.Write([]byte(`var x = 1`))
+			default:
+				w.Header().Set("Content-Type", "text/html")
+				w.Write([]byte(`<p>about</p>`))
+			}
+		}
+	}
+
+	var site *httptest.Server
+	site = httptest.NewServer(handler(func() string { return strings.TrimPrefix(site.URL, "http://") }))
+	defer site.Close()
+
+	// Без -H ссылки на другой хост остаются абсолютными и получают схему страницы
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "-p", site.URL+"/blog/post.html"); err != nil {
+		t.Fatal(err)
+	}
+	got := readMirrorFile(t, hostDirOf(dir, site), "blog/post.html")
+	for _, want := range []string{
+		`<script src="../js/app.js">`,
+		`<img src="http://` + cdnHost + `/img/logo.png"/>`,
+		`<a href="../about.html#team">`,
+		`<a href="http://` + cdnHost + `/page.html">`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("without -H: post.html has no %q:\n%s", want, got)
+		}
+	}
+	mu.Lock()
+	if !requested["site http /js/app.js"] || !requested["site http /about.html"] || requested["cdn http /img/logo.png"] {
+		t.Errorf("without -H: requested %v", requested)
+	}
+	clear(requested)
+	mu.Unlock()
+
+	// С -H ресурс с другого хоста скачивается в каталог этого хоста
+	dir = t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "-p", "-H", "-D", "127.0.0.1", site.URL+"/blog/post.html"); err != nil {
+		t.Fatal(err)
+	}
+	got = readMirrorFile(t, hostDirOf(dir, site), "blog/post.html")
+	for _, want := range []string{
+		`<img src="../../` + cdnHost + `/img/logo.png"/>`,
+		`<a href="../../` + cdnHost + `/page.html">`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("with -H: post.html has no %q:\n%s", want, got)
+		}
+	}
+	if got := readMirrorFile(t, hostDirOf(dir, cdn), "img/logo.png"); got != "cdn /img/logo.png" {
+		t.Errorf("with -H: logo.png = %q", got)
+	}
+
+	// Страница по https разрешает //host в https, а не в http
+	var secure *httptest.Server
+	secure = httptest.NewTLSServer(handler(func() string { return strings.TrimPrefix(secure.URL, "https://") }))
+	defer secure.Close()
+	secureHost := strings.TrimPrefix(secure.URL, "https://")
+
+	mu.Lock()
+	clear(requested)
+	mu.Unlock()
+	dir = t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "-p", "--no-check-certificate", secure.URL+"/blog/post.html"); err != nil {
+		t.Fatal(err)
+	}
+	got = readMirrorFile(t, filepath.Join(dir, secureHost), "blog/post.html")
+	for _, want := range []string{
+		`<script src="../js/app.js">`,
+		`<img src="https://` + cdnHost + `/img/logo.png"/>`,
+		`<a href="../about.html#team">`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("https: post.html has no %q:\n%s", want, got)
+		}
+	}
+	mu.Lock()
+	if !requested["site https /js/app.js"] || !requested["site https /about.html"] || len(requested) != 3 {
+		t.Errorf("https: requested %v", requested)
+	}
+	mu.Unlock()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// indexFileName - индекс зеркала в корне каталога загрузки. Он хранит
+// то, что не сохраняет файловая система: ETag и соответствие URL файлам
+const indexFileName = ".webmirror-index.json"
+
+type indexEntry struct {
+	Path         string `json:"path"`
+	ContentType  string `json:"content_type,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+type mirrorIndex struct {
+	mu      sync.Mutex
+	file    string
+	entries map[string]indexEntry
+	byPath  map[string]string
+}
+
+func loadIndex(dir string) (*mirrorIndex, error) {
+	x := &mirrorIndex{
+		file:    filepath.Join(dir, indexFileName),
+		entries: make(map[string]indexEntry),
+		byPath:  make(map[string]string),
+	}
+
+	data, err := os.ReadFile(x.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return x, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &x.entries); err != nil {
+		return nil, fmt.Errorf("corrupt index %q: %v", x.file, err)
+	}
+
+	for rawURL, e := range x.entries {
+		x.byPath[e.Path] = rawURL
+	}
+	return x, nil
+}
+
+func (x *mirrorIndex) get(rawURL string) (indexEntry, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	e, ok := x.entries[rawURL]
+	return e, ok
+}
+
+func (x *mirrorIndex) urlForPath(path string) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	rawURL, ok := x.byPath[path]
+	return rawURL, ok
+}
+
+func (x *mirrorIndex) set(rawURL string, e indexEntry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if old, ok := x.entries[rawURL]; ok && x.byPath[old.Path] == rawURL {
+		delete(x.byPath, old.Path)
+	}
+	x.entries[rawURL] = e
+	x.byPath[e.Path] = rawURL
+}
+
+// move переносит запись файла oldPath на newPath
+func (x *mirrorIndex) move(oldPath string, newPath string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	rawURL, ok := x.byPath[oldPath]
+	if !ok {
+		return
+	}
+	e := x.entries[rawURL]
+	e.Path = newPath
+	x.entries[rawURL] = e
+	delete(x.byPath, oldPath)
+	x.byPath[newPath] = rawURL
+}
+
+func (x *mirrorIndex) save() error {
+	x.mu.Lock()
+	data, err := json.MarshalIndent(x.entries, "", "  ")
+	x.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = saveFile(x.file, bytes.NewReader(data))
+	return err
+}
+
+// indexPath переводит путь файла в ключ индекса относительно каталога загрузки
+func (d *downloader) indexPath(savePath string) string {
+	rel, err := filepath.Rel(d.downloadDir, savePath)
+	if err != nil {
+		return filepath.ToSlash(savePath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// recordSaved заносит сохраненный файл в индекс и выставляет ему время
+// изменения из Last-Modified, чтобы -N мог сравнивать его с сервером
+func (d *downloader) recordSaved(rawURL string, savePath string, header http.Header) {
+	d.index.set(rawURL, indexEntry{
+		Path:         d.indexPath(savePath),
+		ContentType:  header.Get("Content-Type"),
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	})
+
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		d.touchSaved(savePath, lastModified)
+	}
+}
+
+// conditionalHeader готовит If-Modified-Since/If-None-Match для уже
+// скачанного файла в режиме -N
+func (d *downloader) conditionalHeader(rawURL string, savePath string) http.Header {
+	header := make(http.Header)
+
+	info, err := d.statSaved(savePath)
+	if err != nil {
+		return header
+	}
+
+	header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+	if e, ok := d.index.get(rawURL); ok && e.ETag != "" {
+		header.Set("If-None-Match", e.ETag)
+	}
+	return header
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// readInputFile читает стартовые URL для -i: по одному в строке, пустые
+// строки и комментарии # пропускаются. С --force-html файл считается
+// HTML-страницей, и стартовыми URL становятся ее ссылки; "-" с
+// --force-html читает страницу из stdin
+func readInputFile(filename string, forceHTML bool, l *slog.Logger) ([]string, error) {
+	if filename == "-" && forceHTML {
+		return htmlLinks(os.Stdin, l)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if forceHTML {
+		return htmlLinks(f, l)
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// parseStartURL разбирает стартовый URL; без схемы подразумевается http
+func parseStartURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %v", raw, err)
+	}
+	if u.Scheme == "" {
+		u, err = url.Parse("http://" + raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %q: %v", raw, err)
+		}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid URL %q: unsupported scheme %q", raw, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q: no host", raw)
+	}
+	normalizeHost(u)
+	return u, nil
+}
+
+// streamSeeds читает стартовые URL из r по мере поступления и сразу ставит
+// их в очередь. Пока поток не закончился, очередь удерживается открытой,
+// поэтому обход не завершится, даже если все полученные URL уже скачаны.
+// Строки, не похожие на URL, пропускаются и учитываются в MalformedInput
+func (d *downloader) streamSeeds(r io.Reader) {
+	d.queue.hold()
+
+	// После прерывания не ждем конца потока: чтение stdin не отменить
+	var once sync.Once
+	release := func() { once.Do(d.queue.done) }
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-d.ctx.Done():
+			release()
+		case <-finished:
+		}
+	}()
+
+	go func() {
+		defer close(finished)
+		defer release()
+
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() && d.ctx.Err() == nil {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			u, err := parseStartURL(line)
+			if err != nil {
+				d.errorf(logEntry{event: "input", err: err}, "Ignoring input line: %v", err)
+				d.malformedInput.Add(1)
+				continue
+			}
+			u.User = nil
+			d.addSeed(u)
+			if err := d.enqueue(job{url: u.String()}); err != nil {
+				d.errorf(logEntry{event: "input", url: u.String(), err: err}, "Ignoring input line: %v", err)
+				d.malformedInput.Add(1)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			d.errorf(logEntry{event: "input", err: err}, "Failed to read URLs from stdin: %v", err)
+		}
+	}()
+}
+
+// MalformedInput возвращает число пропущенных строк из stdin
+func (d *downloader) MalformedInput() int64 {
+	return d.malformedInput.Load()
+}
+
+// htmlLinks извлекает ссылки из локального HTML-файла. Относительные
+// ссылки разрешаются по <base href>, а без него пропускаются
+func htmlLinks(r io.Reader, l *slog.Logger) ([]string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var base *url.URL
+	var find func(n *html.Node)
+	find = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "base" {
+			for _, attr := range n.Attr {
+				if attr.Key == "href" && base == nil {
+					if u, err := url.Parse(attr.Val); err == nil && u.IsAbs() {
+						base = u
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+
+	var urls []string
+	walkLinks(doc, func(_ *html.Node, attr *html.Attribute, _ resourceKind) {
+		u, err := url.Parse(attr.Val)
+		if err != nil {
+			return
+		}
+		if !u.IsAbs() {
+			if base == nil {
+				logEvent(l, levelWarn, logEntry{event: "input", url: attr.Val}, "Skipping relative link %q: the input file has no <base href>", attr.Val)
+				return
+			}
+			u = base.ResolveReference(u)
+		}
+		if u.Scheme == "http" || u.Scheme == "https" {
+			u.Fragment = ""
+			urls = append(urls, u.String())
+		}
+	})
+	return urls, nil
+}
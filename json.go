@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// jsonFrame - открытый объект или массив JSON. В объекте key - последний
+// ключ, а wantKey сообщает, что следующей строкой будет ключ
+type jsonFrame struct {
+	object  bool
+	key     string
+	wantKey bool
+}
+
+// rewriteJSON вызывает fn для каждой строки-значения документа JSON с
+// путем к ней и подставляет в текст то, что fn вернет. Документ
+// разбирается целиком, а правится на месте: порядок ключей и
+// форматирование сохраняются. Документ с ошибками не меняется, и fn для
+// него не вызывается
+func rewriteJSON(content []byte, fn func(stack []jsonFrame, value string) string) ([]byte, error) {
+	var raw json.RawMessage
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return content, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+
+	var stack []jsonFrame
+	var edits []xmlEdit
+	for {
+		offset := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return content, err
+		}
+		end := int(dec.InputOffset())
+
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+		if s, ok := tok.(string); ok && top != nil && top.object && top.wantKey {
+			top.key = s
+			top.wantKey = false
+			continue
+		}
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		// Значение: после него в объекте снова идет ключ
+		if top != nil && top.object {
+			top.wantKey = true
+		}
+		switch t := tok.(type) {
+		case json.Delim:
+			stack = append(stack, jsonFrame{object: t == '{', wantKey: t == '{'})
+		case string:
+			rewritten := fn(stack, t)
+			if rewritten == t {
+				continue
+			}
+			// Между предыдущим токеном и строкой - только пробелы, ':' и ','
+			start := offset + bytes.IndexByte(content[offset:end], '"')
+			edits = append(edits, xmlEdit{start, end, quoteJSON(rewritten)})
+		}
+	}
+	return applyXMLEdits(content, edits), nil
+}
+
+// quoteJSON записывает строку JSON-литералом. В отличие от json.Marshal,
+// не экранирует & и < в ссылках
+func quoteJSON(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
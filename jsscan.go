@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// jsAssetExts - расширения, по которым строка из скрипта считается ссылкой
+// на файл. Без расширения строку от пути маршрута ("/about") не отличить
+var jsAssetExts = map[string]bool{
+	".js": true, ".mjs": true, ".css": true, ".json": true, ".wasm": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true,
+	".webp": true, ".avif": true, ".ico": true, ".bmp": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".mp4": true, ".webm": true, ".mp3": true, ".ogg": true, ".wav": true,
+}
+
+// isJSType проверяет, является ли ответ скриптом
+func isJSType(contentType string) bool {
+	switch mediaType(contentType) {
+	case "application/javascript", "text/javascript", "application/x-javascript", "application/ecmascript", "text/ecmascript":
+		return true
+	}
+	return false
+}
+
+// jsString - строковый литерал скрипта: start и end - границы текста
+// между кавычками, quote - кавычка
+type jsString struct {
+	start, end int
+	quote      byte
+	value      string
+}
+
+// scanJSStrings находит строковые литералы скрипта. Это не разбор
+// JavaScript: пропускаются комментарии и, по предыдущему символу, литералы
+// регулярных выражений, а шаблоны с ${...} и строки с экранированием
+// (кроме \/) не возвращаются
+func scanJSStrings(src []byte) []jsString {
+	var found []jsString
+	var prev byte // последний значимый символ вне строк и комментариев
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				return found
+			}
+			i += end + 3
+			continue
+		case c == '/' && startsRegexp(prev):
+			i = skipRegexp(src, i)
+		case c == '"' || c == '\'' || c == '`':
+			end, plain := skipJSString(src, i)
+			if plain && end > i {
+				raw := string(src[i+1 : end])
+				found = append(found, jsString{i + 1, end, c, strings.ReplaceAll(raw, `\/`, "/")})
+			}
+			i = end
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			continue
+		}
+		prev = src[i]
+	}
+	return found
+}
+
+// startsRegexp угадывает по предыдущему символу, начинает ли / литерал
+// регулярного выражения, а не деление
+func startsRegexp(prev byte) bool {
+	return prev == 0 || strings.IndexByte("(,=:[!&|?{};+-*%<>~^", prev) >= 0
+}
+
+// skipRegexp возвращает индекс закрывающего / литерала, начатого в i
+func skipRegexp(src []byte, i int) int {
+	class := false
+	for i++; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '/':
+			if !class {
+				return i
+			}
+		case '\n':
+			return i
+		}
+	}
+	return len(src) - 1
+}
+
+// skipJSString возвращает индекс закрывающей кавычки строки, начатой в i,
+// и false, если строка содержит экранирование (кроме \/) или подстановки
+func skipJSString(src []byte, i int) (int, bool) {
+	quote := src[i]
+	plain := true
+	depth := 0 // вложенность ${...} в шаблоне
+	for i++; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\\':
+			if i+1 < len(src) && src[i+1] != '/' {
+				plain = false
+			}
+			i++
+		case quote == '`' && c == '$' && i+1 < len(src) && src[i+1] == '{':
+			plain = false
+			depth++
+			i++
+		case depth > 0 && c == '}':
+			depth--
+		case depth == 0 && c == quote:
+			return i, plain
+		case quote != '`' && c == '\n':
+			return i, false
+		}
+	}
+	return len(src) - 1, false
+}
+
+// jsAssetURL возвращает URL файла, на который похожа строка скрипта: путь
+// от корня или абсолютный URL того же хоста, что и скрипт base (в том числе
+// без схемы, "//host/app.js"), с расширением из jsAssetExts
+func jsAssetURL(value string, base *url.URL) (*url.URL, bool) {
+	if value == "" || strings.ContainsAny(value, " \t\n<>\"'") {
+		return nil, false
+	}
+	if !strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		return nil, false
+	}
+	u, err := base.Parse(value)
+	if err != nil || hostKey(u) != hostKey(base) {
+		return nil, false
+	}
+	if !jsAssetExts[strings.ToLower(path.Ext(u.Path))] {
+		return nil, false
+	}
+	u.Fragment = ""
+	return u, true
+}
+
+// processJS ставит в очередь файлы, на которые похожи строки скрипта, как
+// его ресурсы, а с --rewrite-js переписывает эти строки в пути относительно
+// файла скрипта savePath: так их разрешают import() и
+// new URL(..., import.meta.url). Найденное эвристикой может не
+// существовать, поэтому ошибки загрузки не считаются
+func (d *downloader) processJS(content []byte, baseURL *url.URL, savePath string, depth int) []byte {
+	var edits []xmlEdit
+	for _, s := range scanJSStrings(content) {
+		u, ok := jsAssetURL(s.value, baseURL)
+		if !ok {
+			continue
+		}
+		if d.stripQuery {
+			u.RawQuery = ""
+		}
+		d.enqueue(job{url: u.String(), depth: depth + 1, referer: baseURL.String(), kind: kindRequisite, implied: true})
+		if !d.rewriteJS {
+			continue
+		}
+		rewritten := d.rewriteLink(u.String(), baseURL, savePath, depth, false, kindRequisite)
+		if rewritten == u.String() {
+			continue
+		}
+		// import() принимает относительный путь только с ./ или ../
+		if !strings.HasPrefix(rewritten, "../") {
+			rewritten = "./" + rewritten
+		}
+		// В локальном пути бывают только \ из экранирования и кавычки
+		rewritten = strings.ReplaceAll(rewritten, `\`, `\\`)
+		rewritten = strings.ReplaceAll(rewritten, string(s.quote), `\`+string(s.quote))
+		edits = append(edits, xmlEdit{s.start, s.end, rewritten})
+	}
+	return applyXMLEdits(content, edits)
+}
+
+// saveJS просматривает скрипт и сохраняет его
+func (d *downloader) saveJS(rawURL string, attempts int, content []byte, pageURL *url.URL, savePath string, depth int, resp *http.Response, start time.Time) {
+	content = d.processJS(content, pageURL, savePath, depth)
+
+	savePath, n, err := d.saveContent(rawURL, savePath, content, resp.Header, false)
+	d.addBytes(n)
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to save %q: %w", savePath, err))
+		return
+	}
+	d.saved(rawURL, pageURL.String(), resp.StatusCode, savePath, n, resp.Header, false)
+	d.verbosef(logEntry{event: "saved", url: rawURL, status: resp.StatusCode, bytes: n, duration: time.Since(start)},
+		"Saved %s (%d bytes)", savePath, n)
+}
+
+// isSavedJS определяет, является ли уже сохраненный файл скриптом
+func (d *downloader) isSavedJS(rawURL string, savePath string) bool {
+	if e, ok := d.index.get(rawURL); ok && e.ContentType != "" {
+		return isJSType(e.ContentType)
+	}
+	ext := strings.ToLower(filepath.Ext(savePath))
+	return ext == ".js" || ext == ".mjs"
+}
+
+// processLocalJS продолжает обход по уже сохраненному скрипту. С
+// --rewrite-js строки в нем - относительные пути к файлам зеркала
+func (d *downloader) processLocalJS(savePath string, pageURL *url.URL, depth int) {
+	content, err := d.readSaved(savePath)
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: pageURL.String(), err: err}, "Failed to read %q: %v", savePath, err)
+		return
+	}
+	for _, s := range scanJSStrings(content) {
+		rawURL := ""
+		if u, ok := jsAssetURL(s.value, pageURL); ok {
+			rawURL = u.String()
+		} else if ref, err := url.Parse(s.value); err == nil && !ref.IsAbs() && ref.Host == "" && jsAssetExts[strings.ToLower(path.Ext(ref.Path))] {
+			if _, err := d.statSaved(filepath.Join(filepath.Dir(savePath), filepath.FromSlash(ref.Path))); err == nil {
+				rawURL, _ = d.localLinkURL(s.value, pageURL, savePath)
+			}
+		}
+		if rawURL != "" {
+			d.enqueue(job{url: rawURL, depth: depth + 1, referer: pageURL.String(), kind: kindRequisite, implied: true})
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestScanJSStrings(t *testing.T) {
+	src := `// "/line-comment.js"
+/* "/block-comment.js" */
+var a = "/static/a.js", b = '/static/b.css';
+var c = a / 2 / "x";
+var re = /"\/static\/regexp.js"/g, m = x.match(/'/);
+var d = "\/static\/escaped-slash.png";
+var e = "/static/\"quoted\".png", f = "line\nbreak";
+var g = ` + "`/static/template.js`, h = `/static/${name}.js`, i = `${a}` + `/after-template.js`" + `;
+var j = "";
+var k = "unterminated
+var l = '/static/after-unterminated.js';
+`
+	var got []string
+	for _, s := range scanJSStrings([]byte(src)) {
+		if src[s.start-1] != s.quote || src[s.end] != s.quote {
+			t.Errorf("%q: bounds %d-%d do not point at the quotes", s.value, s.start, s.end)
+		}
+		got = append(got, s.value)
+	}
+	want := []string{"/static/a.js", "/static/b.css", "x", "/static/escaped-slash.png", "/static/template.js", "/after-template.js", "", "/static/after-unterminated.js"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("scanJSStrings =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestJSAssetURL(t *testing.T) {
+	base := mustParseURL(t, "https://example.com/static/js/main.js")
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"/static/media/logo.svg", "https://example.com/static/media/logo.svg"},
+		{"/static/js/chunk.JS?v=1#x", "https://example.com/static/js/chunk.JS?v=1"},
+		{"https://example.com/fonts/a.woff2", "https://example.com/fonts/a.woff2"},
+		{"//example.com/app.mjs", "https://example.com/app.mjs"},
+		{"https://cdn.example.net/lib.js", ""},
+		{"//cdn.example.net/lib.js", ""},
+		{"static/js/relative.js", ""},
+		{"./relative.js", ""},
+		{"/about", ""},
+		{"/api/users.php", ""},
+		{"/a b.png", ""},
+		{"text/javascript", ""},
+	}
+	for _, tt := range tests {
+		u, ok := jsAssetURL(tt.value, base)
+		got := ""
+		if ok {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("jsAssetURL(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// webpackMain - точка входа в духе webpack: карта чанков, манифест ассетов
+// и пути маршрутов, которые файлами не являются
+const webpackMain = `(()=>{"use strict";
+var r={},c={179:"main",216:"vendors"};
+r.p="/static/js/";
+r.u=e=>"static/js/"+c[e]+"."+{179:"a1b2",216:"c3d4"}[e]+".chunk.js";
+const manifest={"files":{"main.css":"/static/css/main.8f3a.css","logo.svg":"/static/media/logo.5d5d.svg","missing.png":"/static/media/missing.png"},
+"entrypoints":["/static/js/runtime.js"]};
+const routes=[{path:"/"},{path:"/about"},{path:"/users/:id"}];
+const cdn="https://cdn.example.net/react.js";
+// "/static/js/commented.js"
+const re=/\/static\/js\/[a-z]+\.js/;
+import("/static/js/lazy.chunk.js").then(m=>m.default());
+})();
+`
+
+func TestScanJSMirror(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><div id="root"></div><script src="/static/js/main.js"></script></body></html>`))
+		case "/static/js/main.js":
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+			w.Write([]byte(webpackMain))
+		case "/static/js/lazy.chunk.js":
+			w.Header().Set("Content-Type", "application/javascript")
+			w.Write([]byte(`export default function(){document.body.style.background="url(" + '/static/media/bg.png' + ")"}`))
+		case "/static/js/runtime.js":
+			w.Header().Set("Content-Type", "application/javascript")
+			w.Write([]byte(`console.log("runtime")`))
+		case "/static/css/main.8f3a.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte(`body{margin:0}`))
+		case "/static/media/logo.5d5d.svg", "/static/media/bg.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("image " + r.URL.Path))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	run := func(extra ...string) (string, runStats, error, string) {
+		t.Helper()
+		mu.Lock()
+		clear(requested)
+		mu.Unlock()
+		dir := t.TempDir()
+		args := append([]string{"-P", dir, "--progress", "none", "--no-favicon", "--manifest", "none",
+			"-e", "robots=off", "-l", "1", "-p"}, extra...)
+		opts, urls, err := parseArgs(append(args, srv.URL+"/"), io.Discard, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var logBuf bytes.Buffer
+		if opts.logger, err = newLogger("json", &logBuf, levelDebug, nil); err != nil {
+			t.Fatal(err)
+		}
+		d, err := newDownloader(urls, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Download(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := d.Wait()
+		return hostDirOf(dir, srv), stats, err, logBuf.String()
+	}
+
+	// Без --scan-js скрипт сохраняется как есть, и строки в нем не читаются
+	host, _, err, _ := run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(requested) != 2 {
+		t.Errorf("requested without --scan-js: %v", requested)
+	}
+	mu.Unlock()
+	if got := readMirrorFile(t, host, "static/js/main.js"); got != webpackMain {
+		t.Errorf("main.js changed without --scan-js:\n%s", got)
+	}
+
+	host, stats, err, log := run("--scan-js")
+	// Ненайденный missing.png - не ошибка
+	if err != nil || stats.Failed != 0 {
+		t.Fatalf("Failed = %d, %v", stats.Failed, err)
+	}
+	if !strings.Contains(log, `"level":"DEBUG","msg":"Not downloading `+srv.URL+`/static/media/missing.png`) {
+		t.Errorf("no debug message for missing.png:\n%s", log)
+	}
+	if strings.Contains(log, `"level":"ERROR"`) {
+		t.Errorf("errors logged:\n%s", log)
+	}
+	mu.Lock()
+	for _, path := range []string{"/static/css/main.8f3a.css", "/static/media/logo.5d5d.svg", "/static/media/missing.png",
+		"/static/js/runtime.js", "/static/js/lazy.chunk.js", "/static/media/bg.png"} {
+		if requested[path] != 1 {
+			t.Errorf("%s requested %d times, want 1", path, requested[path])
+		}
+	}
+	for _, path := range []string{"/static/js/", "/about", "/users/:id", "/react.js", "/static/js/commented.js"} {
+		if requested[path] != 0 {
+			t.Errorf("%s requested", path)
+		}
+	}
+	if len(requested) != 8 {
+		t.Errorf("requested: %v", requested)
+	}
+	mu.Unlock()
+	if got := readMirrorFile(t, host, "static/media/bg.png"); got != "image /static/media/bg.png" {
+		t.Errorf("bg.png = %q", got)
+	}
+	// Без --rewrite-js строки не меняются
+	if got := readMirrorFile(t, host, "static/js/main.js"); got != webpackMain {
+		t.Errorf("main.js changed without --rewrite-js:\n%s", got)
+	}
+
+	host, _, err, _ = run("--scan-js", "--rewrite-js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readMirrorFile(t, host, "static/js/main.js")
+	for _, want := range []string{
+		`"main.css":"../css/main.8f3a.css"`,
+		`"logo.svg":"../media/logo.5d5d.svg"`,
+		`"entrypoints":["./runtime.js"]`,
+		`import("./lazy.chunk.js")`,
+		`r.p="/static/js/";`,
+		`{path:"/about"}`,
+		`const cdn="https://cdn.example.net/react.js";`,
+		`// "/static/js/commented.js"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("main.js has no %q:\n%s", want, got)
+		}
+	}
+	if got := readMirrorFile(t, host, "static/js/lazy.chunk.js"); !strings.Contains(got, `'../media/bg.png'`) {
+		t.Errorf("lazy.chunk.js = %q", got)
+	}
+
+	if _, _, err := parseArgs([]string{"--rewrite-js", "http://example.com/"}, io.Discard, io.Discard); err == nil {
+		t.Error("--rewrite-js without --scan-js accepted")
+	}
+}
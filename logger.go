@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Уровни журнала. Между Info и Debug добавлен уровень -v
+const (
+	levelError   = slog.LevelError // только ошибки (-q)
+	levelWarn    = slog.LevelWarn  // предупреждения, скрываются при -q
+	levelInfo    = slog.LevelInfo  // предупреждения и периодический прогресс
+	levelVerbose = slog.Level(-2)  // строка на каждый URL (-v)
+	levelDebug   = slog.LevelDebug // заголовки запросов и ответов, решения по ссылкам (-vv)
+)
+
+// logLevelFor переводит -q, число -v и -vv в уровень журнала
+func logLevelFor(quiet bool, verbose int, debug bool) slog.Level {
+	switch {
+	case quiet:
+		return levelError
+	case debug || verbose >= 2:
+		return levelDebug
+	case verbose == 1:
+		return levelVerbose
+	}
+	return levelInfo
+}
+
+// newLogger создает журнал в формате --log-format, пишущий в w. Если
+// задан mirror, ошибки дублируются и туда: так при -o/-a они видны в
+// терминале
+func newLogger(format string, w io.Writer, level slog.Level, mirror io.Writer) (*slog.Logger, error) {
+	h, err := newHandler(format, w, level)
+	if err != nil {
+		return nil, err
+	}
+	if mirror != nil {
+		errs, _ := newHandler(format, mirror, levelError)
+		h = &mirrorHandler{main: h, errors: errs}
+	}
+	return slog.New(h), nil
+}
+
+// newHandler создает обработчик в формате --log-format: text - прежние
+// строки log.Printf, json - объект JSON на каждое событие
+func newHandler(format string, w io.Writer, level slog.Level) (slog.Handler, error) {
+	switch format {
+	case "text":
+		return &textHandler{out: log.New(w, "", log.LstdFlags), level: level}, nil
+	case "json":
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.LevelKey && a.Value.Any() == levelVerbose {
+					a.Value = slog.StringValue("VERBOSE")
+				}
+				return a
+			},
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown --log-format %q (want text or json)", format)
+}
+
+// textHandler выводит только текст сообщения в формате стандартного log:
+// поля события в нем уже есть
+type textHandler struct {
+	out   *log.Logger
+	level slog.Level
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	h.out.Print(r.Message)
+	return nil
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// mirrorHandler передает записи основному обработчику, а ошибки - еще и
+// второму
+type mirrorHandler struct {
+	main   slog.Handler
+	errors slog.Handler
+}
+
+func (h *mirrorHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.main.Enabled(ctx, level) || h.errors.Enabled(ctx, level)
+}
+
+func (h *mirrorHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	if h.main.Enabled(ctx, r.Level) {
+		err = h.main.Handle(ctx, r)
+	}
+	if h.errors.Enabled(ctx, r.Level) {
+		if mirrorErr := h.errors.Handle(ctx, r.Clone()); err == nil {
+			err = mirrorErr
+		}
+	}
+	return err
+}
+
+func (h *mirrorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &mirrorHandler{main: h.main.WithAttrs(attrs), errors: h.errors.WithAttrs(attrs)}
+}
+
+func (h *mirrorHandler) WithGroup(name string) slog.Handler {
+	return &mirrorHandler{main: h.main.WithGroup(name), errors: h.errors.WithGroup(name)}
+}
+
+// openLogFile открывает файл журнала: для -o с усечением, для -a с
+// дописыванием в конец
+func openLogFile(path string, appendLog bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendLog {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	return os.OpenFile(path, flags, 0644)
+}
+
+// logEntry - поля события для структурированного журнала. Нулевые поля
+// не выводятся
+type logEntry struct {
+	event    string
+	url      string
+	status   int
+	bytes    int64
+	duration time.Duration
+	err      error
+}
+
+// attrs превращает поля события в атрибуты slog
+func (e logEntry) attrs() []slog.Attr {
+	attrs := []slog.Attr{slog.String("event", e.event)}
+	if e.url != "" {
+		attrs = append(attrs, slog.String("url", e.url))
+	}
+	if e.status != 0 {
+		attrs = append(attrs, slog.Int("status", e.status))
+	}
+	if e.bytes != 0 {
+		attrs = append(attrs, slog.Int64("bytes", e.bytes))
+	}
+	if e.duration != 0 {
+		attrs = append(attrs, slog.Float64("duration", e.duration.Seconds()))
+	}
+	if e.err != nil {
+		attrs = append(attrs, slog.String("error", e.err.Error()))
+	}
+	return attrs
+}
+
+// logEvent записывает событие: сообщение для людей и поля для машин
+func logEvent(l *slog.Logger, level slog.Level, e logEntry, format string, args ...any) {
+	if !l.Enabled(context.Background(), level) {
+		return
+	}
+	l.LogAttrs(context.Background(), level, fmt.Sprintf(format, args...), e.attrs()...)
+}
+
+func (d *downloader) errorf(e logEntry, format string, args ...any) {
+	logEvent(d.log, levelError, e, format, args...)
+}
+
+func (d *downloader) warnf(e logEntry, format string, args ...any) {
+	logEvent(d.log, levelWarn, e, format, args...)
+}
+
+func (d *downloader) infof(e logEntry, format string, args ...any) {
+	logEvent(d.log, levelInfo, e, format, args...)
+}
+
+func (d *downloader) verbosef(e logEntry, format string, args ...any) {
+	logEvent(d.log, levelVerbose, e, format, args...)
+}
+
+func (d *downloader) debugf(e logEntry, format string, args ...any) {
+	logEvent(d.log, levelDebug, e, format, args...)
+}
+
+// formatHeader выводит заголовки по одному в строке, по алфавиту.
+// Учетные данные в журнал не попадают
+func formatHeader(header http.Header) string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		for _, value := range header[key] {
+			if key == "Authorization" || key == "Proxy-Authorization" || key == "Cookie" {
+				value = "[redacted]"
+			}
+			fmt.Fprintf(&b, "\n  %s: %s", key, value)
+		}
+	}
+	return b.String()
+}
+
+// countFlag - флаг, который можно повторять: -v -v. Значение можно задать
+// и числом, например --verbose=2 или verbose = 2 в файле настроек
+type countFlag int
+
+func (c *countFlag) String() string {
+	return strconv.Itoa(int(*c))
+}
+
+func (c *countFlag) Set(value string) error {
+	if n, err := strconv.Atoi(value); err == nil {
+		*c = countFlag(n)
+		return nil
+	}
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if on {
+		*c++
+	} else {
+		*c = 0
+	}
+	return nil
+}
+
+func (c *countFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (c *countFlag) Get() any {
+	return int(*c)
+}
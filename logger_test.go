@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logRecords разбирает журнал --log-format json по строкам
+func logRecords(t *testing.T, data []byte) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("bad JSON log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// logEvents разбирает журнал --log-format json в список полей event
+func logEvents(t *testing.T, data []byte) []string {
+	t.Helper()
+	var events []string
+	for _, record := range logRecords(t, data) {
+		event, _ := record["event"].(string)
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestStartupWarningsUseLogger(t *testing.T) {
+	netrcFile := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(netrcFile, []byte("machine example.com login u password p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", netrcFile)
+
+	for _, quiet := range []bool{false, true} {
+		args := []string{"-P", t.TempDir(), "--progress", "none", "--log-format", "json", "--no-check-certificate"}
+		if quiet {
+			args = append(args, "-q")
+		}
+		opts, urls, err := parseArgs(append(args, "http://example.com/"), io.Discard, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if opts.logger, err = newLogger(opts.logFormat, &buf, logLevelFor(opts.quiet, int(opts.verbose), opts.debug), nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newDownloader(urls, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		got := strings.Join(logEvents(t, buf.Bytes()), ",")
+		want := "tls,netrc"
+		if quiet {
+			want = ""
+		}
+		if got != want {
+			t.Errorf("quiet=%v: events %q, want %q", quiet, got, want)
+		}
+	}
+}
+
+func TestInputFileRelativeLinks(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "links.html")
+	page := `<a href="http://example.com/a">a</a> <a href="/b">b</a>`
+	if err := os.WriteFile(file, []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	l, err := newLogger("json", &buf, levelInfo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls, err := readInputFile(file, true, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0] != "http://example.com/a" {
+		t.Errorf("urls = %q", urls)
+	}
+	if got := strings.Join(logEvents(t, buf.Bytes()), ","); got != "input" {
+		t.Errorf("events %q, want input", got)
+	}
+}
+
+// checkLogSchema проверяет поля и их типы в записи журнала: time, level,
+// msg и event есть всегда, url, status, bytes, duration и error - если
+// относятся к событию
+func checkLogSchema(t *testing.T, record map[string]any) {
+	t.Helper()
+	for key, value := range record {
+		ok := false
+		switch key {
+		case "time":
+			s, isString := value.(string)
+			_, err := time.Parse(time.RFC3339Nano, s)
+			ok = isString && err == nil
+		case "level":
+			s, _ := value.(string)
+			ok = slices.Contains([]string{"ERROR", "WARN", "INFO", "VERBOSE", "DEBUG"}, s)
+		case "msg", "url", "error":
+			s, isString := value.(string)
+			ok = isString && (s != "" || key == "msg")
+		case "event":
+			s, isString := value.(string)
+			ok = isString && s != ""
+		case "status", "bytes":
+			n, isNumber := value.(float64)
+			ok = isNumber && n == math.Trunc(n) && n > 0
+		case "duration":
+			n, isNumber := value.(float64)
+			ok = isNumber && n > 0
+		}
+		if !ok {
+			t.Errorf("field %q = %#v (%T) does not match the schema in %v", key, value, value, record)
+		}
+	}
+	for _, key := range []string{"time", "level", "msg", "event"} {
+		if _, ok := record[key]; !ok {
+			t.Errorf("field %q missing in %v", key, record)
+		}
+	}
+}
+
+func TestJSONLogSchema(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/file.bin">file</a> <a href="/missing.html">missing</a>`))
+		case "/file.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte(body))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	args := []string{"-P", t.TempDir(), "--progress", "none", "--no-favicon", "-e", "robots=off", "-v", "--log-format", "json", "-l", "1", srv.URL + "/"}
+	opts, urls, err := parseArgs(args, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if opts.logger, err = newLogger(opts.logFormat, &buf, logLevelFor(opts.quiet, int(opts.verbose), opts.debug), nil); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDownloader(urls, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	records := logRecords(t, buf.Bytes())
+	if len(records) == 0 {
+		t.Fatal("no log records")
+	}
+	var saved, failed map[string]any
+	for _, record := range records {
+		checkLogSchema(t, record)
+		switch {
+		case record["event"] == "saved" && record["url"] == srv.URL+"/file.bin":
+			saved = record
+		case record["event"] == "failed" && record["url"] == srv.URL+"/missing.html":
+			failed = record
+		}
+	}
+
+	// Успешная загрузка: адрес, код, размер и длительность
+	if saved == nil {
+		t.Fatalf("no saved event for file.bin in:\n%s", buf.String())
+	}
+	if saved["level"] != "VERBOSE" || saved["status"] != 200.0 || saved["bytes"] != float64(len(body)) {
+		t.Errorf("saved event = %v, want level VERBOSE, status 200, bytes %d", saved, len(body))
+	}
+	if _, ok := saved["duration"]; !ok {
+		t.Errorf("saved event has no duration: %v", saved)
+	}
+	if _, ok := saved["error"]; ok {
+		t.Errorf("saved event has an error: %v", saved)
+	}
+
+	// Ошибка: адрес, код и текст ошибки
+	if failed == nil {
+		t.Fatalf("no failed event for missing.html in:\n%s", buf.String())
+	}
+	if failed["level"] != "ERROR" || failed["status"] != 404.0 {
+		t.Errorf("failed event = %v, want level ERROR, status 404", failed)
+	}
+	if e, _ := failed["error"].(string); !strings.Contains(e, "404") {
+		t.Errorf("failed event error = %q, want the HTTP status", e)
+	}
+	if _, ok := failed["bytes"]; ok {
+		t.Errorf("failed event has bytes: %v", failed)
+	}
+}
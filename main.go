@@ -1,77 +1,481 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-
-	"golang.org/x/net/html"
 )
 
 type downloader struct {
-	baseURL      *url.URL
-	visitedURLs  map[string]bool
-	visitedMutex sync.Mutex
-	downloadDir  string
-	maxDepth     int
-	client       *http.Client
-	wg           sync.WaitGroup
-	semaphore    chan struct{}
+	authHost           string // хост первого стартового URL, под hostsMutex
+	visitedURLs        map[string]bool
+	queryVariants      map[string]int // число разных запросов к пути, под visitedMutex
+	visitedMutex       sync.Mutex
+	downloadDir        string
+	maxDepth           int
+	noTrapDetection    bool
+	maxQueryVariants   int
+	respectRobots      bool
+	wait               time.Duration
+	retry              retryPolicy
+	maxRetryAfter      time.Duration
+	timestamping       bool
+	noClobber          bool
+	useDisposition     bool
+	stripQuery         bool
+	stripParams        []string // шаблоны имен параметров, убираемых из ссылок
+	noSortQuery        bool
+	trustServerNames   bool
+	maxRedirects       int
+	crossHostRedirects string
+	redirectHosts      map[string]bool
+	seeds              []string
+	seedInput          io.Reader // поток стартовых URL для -i -
+	log                *slog.Logger
+	processed          atomic.Int64  // обработанные задачи, для прогресса
+	stopProgress       chan struct{} // закрывается в Wait
+	progressInterval   time.Duration
+	display            *progressDisplay
+	events             *eventStream // --progress-fd/--progress-file, nil - без них
+	started            time.Time
+	elapsed            time.Duration // длительность обхода, задается в Wait
+	pagesSaved         atomic.Int64
+	assetsSaved        atomic.Int64
+	unchanged          atomic.Int64 // не скачанные заново: 304 и --no-clobber
+	cachedBytes        atomic.Int64
+	malformedInput     atomic.Int64
+	startHosts         map[string][]string // стартовые хосты и их каталоги для --no-parent, под hostsMutex
+	wwwHosts           map[string]string   // --treat-www-as-same: вариант с www или без -> стартовый хост, под hostsMutex
+	aliases            map[string]string
+	notSaved           map[string]bool   // пропущенные после запроса (по размеру, типу, -A/-R) и по nofollow
+	keptNames          map[string]bool   // URL, к имени которых не добавляется .html, под namesMutex
+	webManifests       map[string]bool   // URL из <link rel="manifest">, под namesMutex
+	movedFiles         map[string]string // файлы, перенесенные внутрь одноименного каталога или названные по Content-Disposition, под namesMutex
+	namesMutex         sync.Mutex
+	savedPages         []string
+	index              *mirrorIndex
+	manifest           *manifest      // nil - без манифеста (--manifest=none, --spider, -O)
+	warc               *warcWriter    // --warc-file
+	archive            *archiveWriter // --output-archive
+	cookies            *cookieJar
+	httpUser           string
+	httpPassword       string
+	sites              map[string]siteConfig
+	netrc              *netrc
+	header             http.Header
+	hostHeader         string
+	userAgent          string
+	referer            string
+	noReferer          bool
+	saveCompressed     bool
+	pageRequisites     bool
+	noMetaRefresh      bool
+	noAlternateStyles  bool
+	noFavicon          bool
+	noMetaAssets       bool
+	structuredData     bool
+	scanJS             bool
+	rewriteJS          bool
+	extractDataURIs    bool
+	dataURIMinSize     int64
+	respectNofollow    bool
+	respectRobotsMeta  bool
+	skipNoindex        bool
+	honorCanonical     bool
+	treatWWWAsSame     bool
+	idnDirs            string
+	restrictFileNames  string
+	unicodeFileNames   string
+	noParent           bool
+	spanHosts          bool
+	domains            []string
+	excludeDomains     []string
+	includeSubdomains  bool
+	fileRules          fileRules
+	urlFilters         urlFilters
+	mimeRules          mimeRules
+	mimeProbe          bool
+	maxFileSize        int64
+	quota              int64
+	bytesSaved         atomic.Int64
+	quotaHit           atomic.Bool
+	maxFiles           int64
+	readTimeout        time.Duration
+	spider             bool
+	outputDocument     string // -O: файл или "-" для stdout
+	singleFile         bool
+	mhtml              bool // --mhtml: -O получает архив MHTML
+	sitemap            string
+	maxInlineSize      int64
+	brokenLinksFile    string
+	references         map[string]map[string]bool // цель -> страницы со ссылкой на нее
+	linkStatus         map[string]int
+	reportMutex        sync.Mutex
+	spiderResults      []spiderResult
+	spiderMutex        sync.Mutex
+	tolerateErrors     int
+	filesMutex         sync.Mutex
+	filesSaved         int64 // файлы, сохраненные в пределах --max-files, под filesMutex
+	filesActive        int64 // загрузки, занявшие место в --max-files, под filesMutex
+	notFetched         atomic.Int64
+	skipped            map[string]int
+	schemes            map[string]int // ссылки mailto:, tel: и т. п. по схемам, под skippedMutex
+	skippedMutex       sync.Mutex
+	limiter            *rateLimiter
+	client             *http.Client
+	wg                 sync.WaitGroup // воркеры; завершение обхода определяет queue
+	semaphore          chan struct{}
+	queue              *frontier
+	ctx                context.Context // отменяется при прерывании обхода
+	workers            int
+	hostConnections    int
+	hosts              map[string]*hostState
+	hostsMutex         sync.Mutex
+	failures           []downloadFailure
+	failuresMutex      sync.Mutex
 }
 
-func newDownloader(startURL string, downloadDir string, maxDepth int, maxConcurrent int) (*downloader, error) {
-	parsedURL, err := url.Parse(startURL)
+// newDownloader создает загрузчик для одного или нескольких стартовых URL.
+// Явные учетные данные и userinfo первого URL относятся к хосту первого URL
+func newDownloader(startURLs []string, opts options) (*downloader, error) {
+	// С -i - стартовые URL могут прийти только из stdin
+	if len(startURLs) == 0 && !opts.streamInput() {
+		return nil, fmt.Errorf("no URLs to download")
+	}
+	seeds := make([]*url.URL, 0, len(startURLs))
+	for _, startURL := range startURLs {
+		u, err := parseStartURL(startURL)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, u)
+	}
+
+	// Учетные данные из URL не должны попасть в имена файлов и индекс
+	httpUser, httpPassword := opts.httpUser, opts.httpPassword
+	if len(seeds) > 0 && seeds[0].User != nil {
+		if httpUser == "" {
+			httpUser = seeds[0].User.Username()
+		}
+		if password, ok := seeds[0].User.Password(); ok && httpPassword == "" {
+			httpPassword = password
+		}
+	}
+	for _, u := range seeds {
+		u.User = nil
+	}
+
+	var err error
+
+	// В режимах --spider и -O каталог загрузки не нужен
+	if !opts.spider && opts.outputDocument == "" {
+		err = os.MkdirAll(opts.downloadDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create download directory: %v", err)
+		}
+	}
+
+	index, err := loadIndex(opts.downloadDir)
 	if err != nil {
-		return nil, fmt.Errorf("invalid URL: %v", err)
+		return nil, err
+	}
+	var manifest *manifest
+	if !opts.spider && opts.outputDocument == "" && opts.manifest != manifestNone {
+		if manifest, err = loadManifest(opts.downloadDir, opts.manifest); err != nil {
+			return nil, err
+		}
 	}
 
-	if parsedURL.Scheme == "" {
-		parsedURL.Scheme = "http"
+	filters, err := compileFilters(opts.acceptRegex, opts.rejectRegex)
+	if err != nil {
+		return nil, err
 	}
 
-	err = os.MkdirAll(downloadDir, 0755)
+	header, hostHeader, err := parseHeaders(opts.headers)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create download directory: %v", err)
+		return nil, err
 	}
 
-	return &downloader{
-		baseURL:     parsedURL,
-		visitedURLs: make(map[string]bool),
-		downloadDir: downloadDir,
-		maxDepth:    maxDepth,
+	transport, err := newTransport(opts)
+	if err != nil {
+		return nil, err
+	}
+	var archive *archiveWriter
+	if opts.outputArchive != "" {
+		if archive, err = newArchiveWriter(opts.outputArchive, opts.downloadDir); err != nil {
+			return nil, fmt.Errorf("failed to create archive: %v", err)
+		}
+	}
+
+	var roundTripper http.RoundTripper = transport
+	var warc *warcWriter
+	if opts.warcFile != "" {
+		if warc, err = newWARCWriter(opts.warcFile, opts.warcMaxSize); err != nil {
+			return nil, fmt.Errorf("failed to open WARC file: %v", err)
+		}
+		roundTripper = &warcTransport{next: transport, warc: warc}
+	}
+
+	jar, err := newCookieJar()
+	if err != nil {
+		return nil, err
+	}
+	if opts.loadCookies != "" {
+		if err := jar.load(opts.loadCookies); err != nil {
+			return nil, fmt.Errorf("failed to load cookies: %v", err)
+		}
+	}
+
+	d := &downloader{
+		visitedURLs:   make(map[string]bool),
+		downloadDir:   opts.downloadDir,
+		maxDepth:      opts.maxDepth,
+		respectRobots: opts.robots,
+		wait:          opts.wait,
+		retry: retryPolicy{
+			maxAttempts: opts.tries,
+			baseDelay:   opts.retryDelay,
+			maxDelay:    opts.waitRetry,
+			jitter:      0.5,
+		},
+		maxRetryAfter:      opts.maxRetryAfter,
+		timestamping:       opts.timestamping,
+		noClobber:          opts.noClobber,
+		useDisposition:     opts.contentDisposition,
+		stripQuery:         opts.stripQuery,
+		stripParams:        opts.stripParamPatterns(),
+		noSortQuery:        opts.noSortQuery,
+		trustServerNames:   opts.trustServerNames,
+		maxRedirects:       opts.maxRedirects,
+		crossHostRedirects: opts.crossHostRedirects,
+		redirectHosts:      make(map[string]bool),
+		startHosts:         make(map[string][]string),
+		wwwHosts:           make(map[string]string),
+		skipped:            make(map[string]int),
+		schemes:            make(map[string]int),
+		aliases:            make(map[string]string),
+		notSaved:           make(map[string]bool),
+		keptNames:          make(map[string]bool),
+		movedFiles:         make(map[string]string),
+		webManifests:       make(map[string]bool),
+		index:              index,
+		singleFile:         opts.singleFile,
+		mhtml:              opts.mhtml != "",
+		sitemap:            opts.sitemap,
+		maxInlineSize:      opts.maxInlineSize,
+		manifest:           manifest,
+		warc:               warc,
+		archive:            archive,
+		cookies:            jar,
+		httpUser:           httpUser,
+		httpPassword:       httpPassword,
+		sites:              opts.sites,
+		header:             header,
+		hostHeader:         hostHeader,
+		userAgent:          opts.userAgent,
+		referer:            opts.referer,
+		noReferer:          opts.noReferer,
+		saveCompressed:     opts.saveCompressed,
+		pageRequisites:     opts.pageRequisites,
+		noMetaRefresh:      opts.noMetaRefresh,
+		noAlternateStyles:  opts.noAlternateStyles,
+		noFavicon:          opts.noFavicon,
+		noMetaAssets:       opts.noMetaAssets,
+		structuredData:     opts.structuredData,
+		scanJS:             opts.scanJS,
+		rewriteJS:          opts.rewriteJS,
+		extractDataURIs:    opts.extractDataURIs,
+		dataURIMinSize:     opts.dataURIMinSize,
+		respectNofollow:    opts.respectNofollow,
+		respectRobotsMeta:  opts.respectRobotsMeta(),
+		skipNoindex:        opts.skipNoindex,
+		honorCanonical:     opts.honorCanonical,
+		treatWWWAsSame:     opts.treatWWWAsSame,
+		idnDirs:            opts.idnDirs,
+		restrictFileNames:  opts.restrictFileNames,
+		unicodeFileNames:   opts.unicodeFileNames,
+		noTrapDetection:    opts.noTrapDetection,
+		maxQueryVariants:   opts.maxQueryVariants,
+		queryVariants:      make(map[string]int),
+		noParent:           opts.noParent,
+		spanHosts:          opts.spanHosts,
+		domains:            normalizeDomains(opts.domains),
+		excludeDomains:     normalizeDomains(opts.excludeDomains),
+		includeSubdomains:  opts.includeSubdomains,
+		urlFilters:         filters,
+		mimeRules:          newMimeRules(opts.acceptMime, opts.rejectMime),
+		mimeProbe:          opts.mimeProbe,
+		maxFileSize:        opts.maxFileSize,
+		quota:              opts.quota,
+		maxFiles:           opts.maxFiles,
+		readTimeout:        opts.readTimeout,
+		spider:             opts.spider,
+		outputDocument:     opts.outputDocument,
+		brokenLinksFile:    opts.brokenLinks,
+		references:         make(map[string]map[string]bool),
+		linkStatus:         make(map[string]int),
+		tolerateErrors:     opts.tolerateErrors,
+		fileRules:          fileRules{accept: opts.accept, reject: opts.reject, ignoreCase: opts.ignoreCase},
+		limiter:            newRateLimiter(opts.limitRate),
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   opts.totalTimeout,
+			Transport: roundTripper,
+			Jar:       jar,
 		},
-		semaphore: make(chan struct{}, maxConcurrent),
-	}, nil
+		semaphore:        make(chan struct{}, opts.maxConcurrent),
+		queue:            newFrontier(),
+		ctx:              context.Background(),
+		workers:          opts.maxConcurrent,
+		hostConnections:  opts.hostConnections,
+		display:          opts.display,
+		events:           opts.events,
+		progressInterval: opts.progressInterval,
+		hosts:            make(map[string]*hostState),
+	}
+	d.client.CheckRedirect = d.checkRedirect
+
+	d.log = opts.logger
+	if d.log == nil {
+		d.log, err = newLogger(opts.logFormat, os.Stderr, logLevelFor(opts.quiet, int(opts.verbose), opts.debug), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.noCheckCertificate {
+		d.warnf(logEntry{event: "tls"}, "WARNING: TLS certificate verification is disabled (--no-check-certificate); connections can be intercepted")
+	}
+
+	if !opts.spider && opts.outputDocument == "" {
+		if n := removeStaleTemps(opts.downloadDir); n > 0 {
+			d.verbosef(logEntry{event: "cleanup"}, "Removed %d temporary files left by an interrupted run", n)
+		}
+	}
+
+	// .netrc используется, только если учетные данные не заданы явно
+	if httpUser == "" && httpPassword == "" {
+		d.netrc = d.loadNetrc()
+	}
+
+	for _, host := range opts.redirectHosts {
+		d.redirectHosts[strings.ToLower(host)] = true
+	}
+
+	if opts.streamInput() {
+		d.seedInput = os.Stdin
+	}
+
+	for _, u := range seeds {
+		d.addSeed(u)
+	}
+
+	return d, nil
+}
+
+// job - URL, поставленный в очередь на загрузку
+type job struct {
+	url     string
+	depth   int
+	referer string // страница, на которой найдена ссылка
+	kind    resourceKind
+	sitemap bool      // URL из sitemap: фильтры применяются и на глубине 0
+	implied bool      // URL не из ссылки (/favicon.ico, строка из скрипта): ошибка загрузки не считается
+	lastmod time.Time // <lastmod> из sitemap для -N
+}
+
+// Download ставит стартовые URL в очередь и запускает воркеры. Отмена ctx
+// прекращает обход: новые загрузки не начинаются, текущие прерываются
+func (d *downloader) Download(ctx context.Context) error {
+	d.ctx = ctx
+	for _, seed := range d.seeds {
+		if err := d.enqueue(job{url: seed}); err != nil {
+			return err
+		}
+	}
+	if d.seedInput != nil {
+		d.streamSeeds(d.seedInput)
+	}
+	if d.sitemap != "" {
+		d.seedSitemaps()
+	}
+
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+
+	// Живая область заменяет периодические строки прогресса
+	d.started = time.Now()
+	d.stopProgress = make(chan struct{})
+	switch {
+	case d.display != nil:
+		d.display.start(d.statusLine)
+	case d.progressInterval > 0:
+		go d.reportProgress(d.progressInterval, d.stopProgress)
+	}
+	return nil
 }
 
-func (d *downloader) Download() error {
-	return d.downloadURL(d.baseURL.String(), 0)
+// worker обрабатывает задачи из очереди, пока она не опустеет
+func (d *downloader) worker() {
+	defer d.wg.Done()
+
+	for {
+		j, ok := d.queue.pop()
+		if !ok {
+			return
+		}
+		// После прерывания оставшаяся очередь просто вычерпывается
+		if d.ctx.Err() == nil {
+			d.emit(progressEvent{Event: "started", URL: j.url, Depth: &j.depth})
+			d.downloadURL(j)
+		}
+		d.processed.Add(1)
+		d.queue.done()
+	}
 }
 
-func (d *downloader) downloadURL(rawURL string, depth int) error {
-	if depth > d.maxDepth {
+// enqueue проверяет найденный URL и ставит его в очередь на загрузку
+func (d *downloader) enqueue(j job) error {
+	if d.ctx.Err() != nil {
+		return nil
+	}
+
+	// Один URL записывают по-разному (EXAMPLE.com:80/a/../b, example.com/b)
+	if u, err := url.Parse(j.url); err == nil {
+		d.normalizeURL(u)
+		j.url = u.String()
+	}
+	rawURL, depth := j.url, j.depth
+
+	if !d.withinDepth(depth, j.kind) {
+		d.debugf(logEntry{event: "skip", url: rawURL}, "Skipping %s: beyond the depth limit", rawURL)
 		return nil
 	}
 
 	// Проверяем и добавляем URL в список посещенных
-	d.visitedMutex.Lock()
-	if d.visitedURLs[rawURL] {
-		d.visitedMutex.Unlock()
+	if !d.markVisited(rawURL) {
+		d.debugf(logEntry{event: "skip", url: rawURL}, "Skipping %s: already seen", rawURL)
 		return nil
 	}
-	d.visitedURLs[rawURL] = true
-	d.visitedMutex.Unlock()
 
 	// Обрабатываем URL
 	parsedURL, err := url.Parse(rawURL)
@@ -79,181 +483,942 @@ func (d *downloader) downloadURL(rawURL string, depth int) error {
 		return fmt.Errorf("invalid URL %q: %v", rawURL, err)
 	}
 
-	// Пропускаем внешние ссылки
-	if parsedURL.Host != d.baseURL.Host {
+	// Пропускаем внешние ссылки и ссылки выше стартового каталога
+	if !d.inScope(parsedURL) {
+		d.debugf(logEntry{event: "skip", url: rawURL}, "Skipping %s: outside the mirrored hosts", rawURL)
+		return nil
+	}
+	if !d.belowParent(parsedURL, j.kind) {
+		d.debugf(logEntry{event: "skip", url: rawURL}, "Skipping %s: above the start directory (--no-parent)", rawURL)
+		return nil
+	}
+
+	// Бесконечные календари и пути вида /a/b/a/b/... не обходим
+	if d.pathTrap(parsedURL) {
+		d.verbosef(logEntry{event: "skip", url: rawURL}, "Skipping %s: looks like a crawler trap", rawURL)
+		d.skip("trap detection")
+		return nil
+	}
+	if !d.addQueryVariant(parsedURL) {
+		d.verbosef(logEntry{event: "skip", url: rawURL}, "Skipping %s: more than %d query variants of one path (--max-query-variants)", rawURL, d.maxQueryVariants)
+		d.skipURL(rawURL, 0, "trap detection")
 		return nil
 	}
 
-	d.semaphore <- struct{}{}
-	d.wg.Add(1)
-	go func() {
-		defer func() {
-			<-d.semaphore
-			d.wg.Done()
-		}()
+	// Файл не должен оказаться вне каталога хоста (/..%2F..%2Fetc/passwd)
+	if _, ok := d.safeSavePath(parsedURL); !ok && !d.spider && d.outputDocument == "" {
+		d.verbosef(logEntry{event: "skip", url: rawURL}, "Skipping %s: save path escapes the mirror directory", rawURL)
+		d.skipURL(rawURL, 0, "unsafe path")
+		return nil
+	}
 
-		log.Printf("Downloading: %s (depth %d)", rawURL, depth)
+	// Стартовый URL скачивается независимо от фильтров
+	if (depth > 0 || j.sitemap) && !d.urlFilters.allowed(rawURL) {
+		d.debugf(logEntry{event: "skip", url: rawURL}, "Skipping %s: rejected by --accept-regex/--reject-regex", rawURL)
+		d.skip("--accept-regex/--reject-regex")
+		return nil
+	}
 
-		resp, err := d.client.Get(rawURL)
-		if err != nil {
-			log.Printf("Failed to download %q: %v", rawURL, err)
+	// robots.txt хоста, который еще не загружен, проверит воркер
+	if d.robotsForbids(parsedURL) {
+		d.verbosef(logEntry{event: "skip", url: rawURL}, "Skipping %s: disallowed by robots.txt", rawURL)
+		d.skip("robots.txt")
+		return nil
+	}
+
+	// Отвергнутые -A/-R страницы все равно скачиваются ради ссылок на них,
+	// но не сохраняются. Остальные отвергнутые файлы не запрашиваются
+	rejected := !d.fileRules.allowed(parsedURL)
+	if rejected && (j.kind == kindRequisite || !mayBeHTML(parsedURL)) {
+		d.debugf(logEntry{event: "skip", url: rawURL}, "Skipping %s: rejected by -A/-R", rawURL)
+		d.skip("-A/-R")
+		return nil
+	}
+
+	d.debugf(logEntry{event: "queue", url: rawURL}, "Queued %s (depth %d)", rawURL, depth)
+	d.emit(progressEvent{Event: "queued", URL: rawURL, Depth: &depth})
+	d.queue.push(j)
+	return nil
+}
+
+// downloadURL скачивает и сохраняет URL из очереди. Вызывается воркером
+func (d *downloader) downloadURL(j job) {
+	rawURL, depth := j.url, j.depth
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		d.fail(rawURL, 0, err)
+		return
+	}
+	// Ссылки на URL, запрещенный robots.txt, возвращаются к исходному адресу
+	// в конце обхода
+	if !d.robotsAllowed(parsedURL) {
+		d.verbosef(logEntry{event: "skip", url: rawURL}, "Skipping %s: disallowed by robots.txt", rawURL)
+		d.skipURL(rawURL, 0, "robots.txt")
+		return
+	}
+	if d.outputDocument != "" && d.singleFile {
+		d.downloadSingleFile(j, parsedURL)
+		return
+	}
+	if d.outputDocument != "" && d.mhtml {
+		d.downloadMHTML(j, parsedURL)
+		return
+	}
+	if d.outputDocument != "" {
+		d.downloadDocument(j, parsedURL)
+		return
+	}
+
+	rejected := !d.fileRules.allowed(parsedURL)
+
+	// Определяем путь для сохранения
+	savePath := d.getSavePath(parsedURL)
+
+	// В режиме --no-clobber существующий файл не запрашивается заново.
+	// Страницы из него все равно разбираются, чтобы обход дошел до
+	// ссылок на еще не скачанные файлы, но повторно не переписываются
+	if d.noClobber {
+		if _, err := d.statSaved(savePath); err == nil {
+			d.verbosef(logEntry{event: "skip", url: rawURL}, "Already exists, not downloading: %s", savePath)
+			d.addUnchanged(savePath)
+			d.emit(progressEvent{Event: "finished", URL: rawURL, Path: savePath})
+			d.processSaved(rawURL, savePath, parsedURL, depth)
 			return
 		}
-		defer resp.Body.Close()
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Non-OK status for %q: %d", rawURL, resp.StatusCode)
+	// С -N страница из sitemap, не менявшаяся по <lastmod> с прошлой
+	// загрузки, не запрашивается вовсе
+	if d.timestamping && !j.lastmod.IsZero() {
+		if info, err := d.statSaved(savePath); err == nil && !info.ModTime().Before(j.lastmod) {
+			d.verbosef(logEntry{event: "not_modified", url: rawURL}, "Not modified according to the sitemap: %s", rawURL)
+			d.addUnchanged(savePath)
+			d.emit(progressEvent{Event: "finished", URL: rawURL, Path: savePath})
+			if d.withinDepth(depth, kindPage) {
+				d.processSaved(rawURL, savePath, parsedURL, depth)
+			}
 			return
 		}
+	}
 
-		// Определяем путь для сохранения
-		savePath := d.getSavePath(parsedURL)
-		if err := os.MkdirAll(filepath.Dir(savePath), 0755); err != nil {
-			log.Printf("Failed to create directory for %q: %v", savePath, err)
+	if d.quotaReached() {
+		d.emit(progressEvent{Event: "skipped", URL: rawURL, Reason: "--quota"})
+		return
+	}
+	// Неявные запросы (favicon.ico) в --max-files не считаются
+	if !j.implied {
+		if !d.takeFileSlot() {
+			d.emit(progressEvent{Event: "skipped", URL: rawURL, Reason: "--max-files"})
 			return
 		}
+		defer d.releaseFileSlot()
+	}
+
+	if d.spider {
+		d.spiderURL(j, parsedURL)
+		return
+	}
+
+	d.verbosef(logEntry{event: "download", url: rawURL}, "Downloading: %s (depth %d)", rawURL, depth)
+	start := time.Now()
 
-		// Читаем содержимое
-		content, err := io.ReadAll(resp.Body)
+	if savePath, err = d.prepareSavePath(savePath); err != nil {
+		d.fail(rawURL, 0, fmt.Errorf("failed to create directory for %q: %w", savePath, err))
+		return
+	}
+
+	header := make(http.Header)
+	if d.timestamping {
+		header = d.conditionalHeader(rawURL, savePath)
+	}
+	if referer := d.refererFor(j, parsedURL); referer != "" {
+		header.Set("Referer", referer)
+	}
+
+	if d.mimeProbe && !d.mimeRules.empty() && d.probeRejected(rawURL, parsedURL.Host, header, j.kind) {
+		d.verbosef(logEntry{event: "skip", url: rawURL}, "Rejecting %s: content type not accepted", rawURL)
+		d.skipURL(rawURL, 0, "--accept-mime/--reject-mime")
+		return
+	}
+
+	partPath := d.partPath(savePath)
+	resp, offset, attempts, err := d.fetchResumable(rawURL, parsedURL.Host, partPath, header)
+	if err != nil && j.implied && d.ctx.Err() == nil {
+		d.debugf(logEntry{event: "skip", url: rawURL, status: statusOf(err), err: err}, "Not downloading %s: %v", rawURL, err)
+		d.emit(progressEvent{Event: "skipped", URL: rawURL, Status: statusOf(err), Reason: "implied"})
+		return
+	}
+	if err != nil {
+		d.fail(rawURL, attempts, err)
+		return
+	}
+	defer d.release(parsedURL.Host)
+	resp.Body = d.trackBody(rawURL, d.limitBody(resp.Body), resp.ContentLength, offset)
+	defer resp.Body.Close()
+
+	// После редиректов ссылки страницы разрешаются относительно конечного
+	// URL, а имя файла выбирается по одному из двух URL
+	pageURL := parsedURL
+	// Ресурсы страницы, скачанные глубже лимита, обход не продолжают
+	recurse := d.withinDepth(depth, kindPage)
+	if final := resp.Request.URL; final.String() != rawURL {
+		d.verbosef(logEntry{event: "redirect", url: rawURL, status: resp.StatusCode}, "Redirected: %s", strings.Join(redirectChain(resp.Request), " -> "))
+		canonical, ok := d.redirectTarget(rawURL, final)
+		if !ok {
+			d.verbosef(logEntry{event: "redirect", url: rawURL, status: resp.StatusCode}, "Redirected to %s, which is downloaded separately", final)
+			d.emit(progressEvent{Event: "skipped", URL: rawURL, Status: resp.StatusCode, Reason: "redirect"})
+			return
+		}
+		if canonical != rawURL {
+			var ok bool
+			if savePath, ok = d.safeSavePath(final); !ok {
+				d.verbosef(logEntry{event: "skip", url: rawURL, status: resp.StatusCode}, "Skipping %s: save path of %s escapes the mirror directory", rawURL, final)
+				d.skipURL(rawURL, resp.StatusCode, "unsafe path")
+				return
+			}
+			if savePath, err = d.prepareSavePath(savePath); err != nil {
+				d.fail(rawURL, attempts, fmt.Errorf("failed to create directory for %q: %w", savePath, err))
+				return
+			}
+		}
+		pageURL = final
+
+		// Цель редиректа вне зеркала сохраняется, но не обходится
+		recurse = recurse && d.inScope(final)
+	}
+
+	// Файл не изменился: оставляем его как есть, но продолжаем обход
+	if resp.StatusCode == http.StatusNotModified {
+		d.verbosef(logEntry{event: "not_modified", url: rawURL, status: resp.StatusCode, duration: time.Since(start)}, "Not modified: %s", rawURL)
+		d.addUnchanged(savePath)
+		d.emit(progressEvent{Event: "finished", URL: rawURL, Status: resp.StatusCode, Path: savePath})
+		if recurse || d.isSavedCSS(rawURL, savePath) || d.isSavedWebManifest(rawURL, savePath) || (d.scanJS && d.isSavedJS(rawURL, savePath)) {
+			d.processSaved(rawURL, savePath, pageURL, depth)
+		}
+		return
+	}
+
+	// Заявленный размер проверяем до чтения тела, а без Content-Length
+	// загрузка обрывается, когда лимит превышен
+	if d.maxFileSize > 0 && resp.ContentLength > 0 && offset+resp.ContentLength > d.maxFileSize {
+		d.verbosef(logEntry{event: "skip", url: rawURL, status: resp.StatusCode}, "Skipping %s: size %d exceeds --max-file-size %d", rawURL, offset+resp.ContentLength, d.maxFileSize)
+		removePart(partPath)
+		d.skipURL(rawURL, resp.StatusCode, "--max-file-size")
+		return
+	}
+
+	// Имя файла из Content-Disposition кладется в каталог, выведенный из URL.
+	// Ссылки, уже переписанные на имя из URL, исправит retargetAliases
+	if d.useDisposition {
+		if name := dispositionFilename(resp.Header.Get("Content-Disposition")); name != "" {
+			if p := filepath.Join(filepath.Dir(savePath), d.fileSegment(name)); withinDir(filepath.Dir(savePath), p) && p != savePath {
+				d.namesMutex.Lock()
+				d.movedFiles[savePath] = p
+				d.namesMutex.Unlock()
+				savePath = p
+			}
+		}
+	}
+
+	isHTML := strings.Contains(resp.Header.Get("Content-Type"), "text/html")
+	// Стили, SVG, ленты RSS/Atom и манифесты веб-приложений, как и страницы,
+	// читаются целиком ради ссылок в них
+	isCSS := mediaType(resp.Header.Get("Content-Type")) == "text/css"
+	isXML := !isHTML && mayBeXML(resp.Header.Get("Content-Type"))
+	isWebManifest := !isHTML && !isCSS && !isXML && d.isWebManifest(rawURL, resp.Header.Get("Content-Type"))
+	isJS := d.scanJS && isJSType(resp.Header.Get("Content-Type"))
+	whole := isHTML || isCSS || isXML || isWebManifest || isJS
+
+	// Сохраняем распакованное содержимое, если не просили иного. Файлы,
+	// которые разбираются ради ссылок, распаковываются всегда
+	if !d.saveCompressed || whole {
+		if err := decodeBody(resp); err != nil {
+			d.fail(rawURL, attempts, err)
+			return
+		}
+	}
+	resp.Body = d.capBody(resp.Body, offset)
+
+	// Тело отвергнутого по типу ответа не читается. Страницу, по которой
+	// продолжается обход, разбираем, но не сохраняем
+	if !d.mimeRules.allowed(resp.Header.Get("Content-Type")) {
+		if !isHTML || !recurse || j.kind == kindRequisite {
+			d.verbosef(logEntry{event: "skip", url: rawURL, status: resp.StatusCode}, "Rejecting %s: content type %q not accepted", rawURL, mediaType(resp.Header.Get("Content-Type")))
+			d.skipURL(rawURL, resp.StatusCode, "--accept-mime/--reject-mime")
+			return
+		}
+		rejected = true
+	}
+	if rejected && !isHTML {
+		d.verbosef(logEntry{event: "skip", url: rawURL, status: resp.StatusCode}, "Rejecting %s: not accepted by -A/-R", rawURL)
+		d.skipURL(rawURL, resp.StatusCode, "-A/-R")
+		return
+	}
+
+	// Всё, кроме HTML, пишем на диск потоком через .part, который
+	// переименовывается после полной загрузки
+	if !whole || offset > 0 {
+		n, err := writePart(partPath, resp, offset)
+		d.addBytes(n)
 		if err != nil {
-			log.Printf("Failed to read response body for %q: %v", rawURL, err)
+			if d.tooLarge(rawURL, resp.StatusCode, partPath, err) {
+				return
+			}
+			d.fail(rawURL, attempts, fmt.Errorf("failed to save %q: %w", partPath, err))
+			return
+		}
+		if !whole {
+			if savePath, _, err = d.placeFile(rawURL, savePath, resp.Header, false, func(path string) (int64, error) {
+				return offset + n, d.placePart(partPath, path)
+			}); err != nil {
+				d.fail(rawURL, attempts, fmt.Errorf("failed to save %q: %w", savePath, err))
+				return
+			}
+			os.Remove(partMetaPath(partPath))
+			d.saved(rawURL, pageURL.String(), resp.StatusCode, savePath, offset+n, resp.Header, false)
+			d.verbosef(logEntry{event: "saved", url: rawURL, status: resp.StatusCode, bytes: offset + n, duration: time.Since(start)},
+				"Saved %s (%d bytes)", savePath, offset+n)
 			return
 		}
 
-		// Сохраняем файл
-		if err := os.WriteFile(savePath, content, 0644); err != nil {
-			log.Printf("Failed to save %q: %v", savePath, err)
+	}
+
+	// HTML, стили и ленты читаем целиком: ссылки переписываются до
+	// сохранения. Докачанный файл все равно нужно разобрать целиком
+	var content []byte
+	if offset > 0 {
+		content, err = os.ReadFile(partPath)
+	} else if content, err = io.ReadAll(resp.Body); err == nil {
+		err = checkLength(resp, int64(len(content)))
+	}
+	removePart(partPath)
+	if d.tooLarge(rawURL, resp.StatusCode, partPath, err) {
+		return
+	}
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to read response body: %w", err))
+		return
+	}
+
+	// Отвергнутая страница нужна только для продолжения обхода
+	if rejected {
+		d.processHTML(content, pageURL, savePath, depth, recurse, resp.Header)
+		d.verbosef(logEntry{event: "skip", url: rawURL, status: resp.StatusCode}, "Removing %s since it should be rejected", rawURL)
+		d.emit(progressEvent{Event: "skipped", URL: rawURL, Status: resp.StatusCode, Reason: "-A/-R"})
+		return
+	}
+
+	if isCSS {
+		d.saveCSS(rawURL, attempts, content, pageURL, savePath, depth, resp, start)
+		return
+	}
+	if isJS {
+		d.saveJS(rawURL, attempts, content, pageURL, savePath, depth, resp, start)
+		return
+	}
+	if isWebManifest {
+		d.saveWebManifest(rawURL, attempts, content, pageURL, savePath, depth, recurse, resp, start)
+		return
+	}
+	if isXML {
+		d.saveXML(rawURL, attempts, content, pageURL, savePath, depth, recurse, resp, start)
+		return
+	}
+
+	// С --honor-canonical копии страницы хранятся одним файлом под адресом
+	// из rel=canonical: первая встреченная копия сохраняется за него, а
+	// остальные становятся ссылками на этот файл
+	if canonical, ok := d.canonicalTarget(rawURL, content, pageURL, resp.Header); ok {
+		d.addAlias(rawURL, canonical.String())
+		if !d.markVisited(canonical.String()) {
+			d.verbosef(logEntry{event: "skip", url: rawURL, status: resp.StatusCode}, "Not saving %s: duplicate of %s (rel=canonical)", rawURL, canonical)
+			d.skip("canonical")
+			d.emit(progressEvent{Event: "skipped", URL: rawURL, Status: resp.StatusCode, Reason: "canonical"})
 			return
 		}
+		if savePath, err = d.prepareSavePath(d.getSavePath(canonical)); err != nil {
+			d.fail(rawURL, attempts, fmt.Errorf("failed to create directory for %q: %w", savePath, err))
+			return
+		}
+	}
+
+	if savePath, n, ok := d.saveHTML(rawURL, attempts, content, pageURL, savePath, depth, recurse, resp); ok {
+		d.saved(rawURL, pageURL.String(), resp.StatusCode, savePath, n, resp.Header, true)
+		d.verbosef(logEntry{event: "saved", url: rawURL, status: resp.StatusCode, bytes: n, duration: time.Since(start)},
+			"Saved %s (%d bytes)", savePath, n)
+	}
+}
+
+// saveHTML переписывает ссылки страницы и сохраняет ее. Возвращает путь
+// и размер сохраненной страницы и false, если она не сохранена
+func (d *downloader) saveHTML(rawURL string, attempts int, content []byte, pageURL *url.URL, savePath string, depth int, recurse bool, resp *http.Response) (string, int64, bool) {
+	content, robots := d.processHTML(content, pageURL, savePath, depth, recurse, resp.Header)
+	// С --skip-noindex ссылки страницы обходятся, но сама она не хранится
+	if d.skipNoindex && robots.noindex {
+		d.verbosef(logEntry{event: "skip", url: rawURL, status: resp.StatusCode}, "Not saving %s: robots noindex", rawURL)
+		d.skipURL(rawURL, resp.StatusCode, "noindex")
+		return savePath, 0, false
+	}
+
+	savePath, n, err := d.saveContent(rawURL, savePath, content, resp.Header, true)
+	d.addBytes(n)
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to save %q: %w", savePath, err))
+		return savePath, n, false
+	}
+	return savePath, n, true
+}
+
+// isSavedHTML определяет, является ли уже сохраненный файл HTML-страницей
+func (d *downloader) isSavedHTML(rawURL string, savePath string) bool {
+	if e, ok := d.index.get(rawURL); ok && e.ContentType != "" {
+		return strings.Contains(e.ContentType, "text/html")
+	}
+	ext := strings.ToLower(filepath.Ext(savePath))
+	return ext == ".html" || ext == ".htm"
+}
+
+// isSavedCSS определяет, является ли уже сохраненный файл стилем
+func (d *downloader) isSavedCSS(rawURL string, savePath string) bool {
+	if e, ok := d.index.get(rawURL); ok && e.ContentType != "" {
+		return mediaType(e.ContentType) == "text/css"
+	}
+	return strings.ToLower(filepath.Ext(savePath)) == ".css"
+}
+
+// saveFile записывает поток во временный файл рядом с целевым и
+// переименовывает его только после успешной записи
+func saveFile(path string, r io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return n, err
+	}
+
+	return n, nil
+}
+
+// staleTempAge - возраст, после которого временный файл saveFile считается
+// оставшимся от прерванного запуска, а не записываемым другим процессом
+const staleTempAge = 10 * time.Minute
 
-		// Если это HTML, парсим ссылки
-		if strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
-			d.processHTML(content, parsedURL, depth)
+// isTempFile узнает временные файлы saveFile (.name.tmp-123) и переноса
+// файла в каталог (.name.tmp-dir)
+func isTempFile(name string) bool {
+	i := strings.LastIndex(name, ".tmp-")
+	if !strings.HasPrefix(name, ".") || i <= 0 {
+		return false
+	}
+	suffix := name[i+len(".tmp-"):]
+	if suffix == "dir" {
+		return true
+	}
+	return suffix != "" && strings.Trim(suffix, "0123456789") == ""
+}
+
+// removeStaleTemps удаляет из каталога зеркала временные файлы, которые
+// остались от прерванного запуска, и возвращает их число. Файлы .part не
+// трогаются: с них продолжается докачка
+func removeStaleTemps(dir string) int {
+	removed := 0
+	filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !isTempFile(entry.Name()) {
+			return nil
 		}
-	}()
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > staleTempAge {
+			if os.Remove(path) == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+	return removed
+}
 
-	return nil
+// dispositionFilename извлекает имя файла из Content-Disposition
+// (включая filename* по RFC 5987) и отбрасывает из него любые каталоги
+func dispositionFilename(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	_, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+
+	name := strings.ReplaceAll(params["filename"], "\\", "/")
+	name = path.Base(strings.TrimSpace(name))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
 }
 
+// getSavePath возвращает путь файла для URL. Путь, который вышел бы за
+// каталог хоста, заменяется безопасным, но такие URL не скачиваются:
+// их отсеивает safeSavePath
 func (d *downloader) getSavePath(u *url.URL) string {
-	// Удаляем начальный слэш
-	path := strings.TrimPrefix(u.Path, "/")
+	savePath, ok := d.safeSavePath(u)
+	if !ok {
+		sum := sha256.Sum256([]byte(u.String()))
+		return filepath.Join(d.downloadDir, unsafeDir, hex.EncodeToString(sum[:16]))
+	}
+	return savePath
+}
 
-	// Если путь заканчивается на /, добавляем index.html
-	if path == "" || strings.HasSuffix(path, "/") {
-		path = path + "index.html"
+// unsafeDir - каталог для путей URL, которые вышли бы за пределы зеркала
+const unsafeDir = "_unsafe"
+
+// safeSavePath возвращает путь файла для URL и false, если он оказался бы
+// вне каталога своего хоста
+func (d *downloader) safeSavePath(u *url.URL) (string, bool) {
+	// Разные записи одного URL (например, цель редиректа) дают один файл
+	normalized := *u
+	d.normalizeURL(&normalized)
+	u = &normalized
+
+	d.namesMutex.Lock()
+	keep := d.keptNames[u.String()]
+	d.namesMutex.Unlock()
+
+	name := savedName(u)
+	if keep {
+		name = fileName(u, "")
+	}
+	name = shortenName(restrictFileName(unicodeFileName(name, d.unicodeFileNames), d.restrictFileNames))
+	host := shortenSegment(restrictSegment(d.hostDir(u), d.restrictFileNames), maxSegmentBytes)
+	if host == "" || host == "." || host == ".." || strings.ContainsAny(host, `/\`) {
+		return "", false
+	}
+	hostDir := filepath.Join(d.downloadDir, host)
+	if d.restrictFileNames == restrictWindows || d.restrictFileNames == restrictPercent {
+		name = fitWindowsPath(hostDir, name)
 	}
+	savePath := filepath.Join(hostDir, filepath.FromSlash(name))
+	if !withinDir(hostDir, savePath) {
+		return "", false
+	}
+	// Файл, на месте которого понадобился каталог, хранится внутри него
+	if info, err := d.statSaved(savePath); err == nil && info.IsDir() {
+		savePath = dirIndex(savePath)
+	}
+	return savePath, true
+}
 
-	// Создаем полный путь
-	fullPath := filepath.Join(d.downloadDir, u.Host, path)
+// withinDir проверяет, что path лежит внутри каталога dir, а не совпадает
+// с ним и не выходит из него через ..
+func withinDir(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || filepath.IsAbs(rel) {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
 
-	// Если нет расширения, добавляем .html
-	if filepath.Ext(fullPath) == "" {
-		fullPath += ".html"
+// keepName запоминает, что файл rawURL - не страница (например, JSON из
+// <link rel="preload" as="fetch">) и .html к его имени не добавляется.
+// Имя выбирается при первой встрече URL: если он уже в очереди, имя
+// остается прежним
+func (d *downloader) keepName(rawURL string) {
+	key, ok := d.linkKey(rawURL)
+	if !ok {
+		return
+	}
+	d.visitedMutex.Lock()
+	visited := d.visitedURLs[key]
+	d.visitedMutex.Unlock()
+	if visited {
+		return
 	}
 
-	return fullPath
+	d.namesMutex.Lock()
+	defer d.namesMutex.Unlock()
+	d.keptNames[key] = true
 }
 
-func (d *downloader) processHTML(content []byte, baseURL *url.URL, depth int) {
-	doc, err := html.Parse(bytes.NewReader(content))
+// linkKey приводит абсолютный URL ссылки к виду, в котором rewriteLink
+// ставит его в очередь
+func (d *downloader) linkKey(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		log.Printf("Failed to parse HTML: %v", err)
-		return
+		return "", false
 	}
+	d.normalizeURL(u)
+	u.User = nil
+	u.Fragment = ""
+	if d.stripQuery {
+		u.RawQuery = ""
+	}
+	return u.String(), true
+}
 
-	var processNode func(*html.Node)
-	processNode = func(n *html.Node) {
-		if n.Type == html.ElementNode {
-			var attrName string
-			switch n.Data {
-			case "a", "link":
-				attrName = "href"
-			case "img", "script":
-				attrName = "src"
-			case "iframe":
-				attrName = "src"
-			}
+// savedName - имя файла для URL относительно каталога его хоста, через /
+func savedName(u *url.URL) string {
+	return fileName(u, ".html")
+}
 
-			if attrName != "" {
-				for i, attr := range n.Attr {
-					if attr.Key == attrName {
-						// Пропускаем пустые ссылки и якоря
-						if attr.Val == "" || strings.HasPrefix(attr.Val, "#") {
-							continue
-						}
-
-						// Разрешаем относительные URL
-						absoluteURL, err := baseURL.Parse(attr.Val)
-						if err != nil {
-							log.Printf("Failed to parse URL %q: %v", attr.Val, err)
-							continue
-						}
-
-						// Нормализуем URL
-						absoluteURL.Fragment = ""
-						absoluteURL.RawQuery = ""
-
-						// Заменяем ссылку на локальный путь
-						localPath := d.getSavePath(absoluteURL)
-						relPath, err := filepath.Rel(filepath.Dir(d.getSavePath(baseURL)), localPath)
-						if err != nil {
-							log.Printf("Failed to calculate relative path: %v", err)
-							continue
-						}
-
-						n.Attr[i].Val = filepath.ToSlash(relPath)
-
-						// Загружаем ресурс
-						d.downloadURL(absoluteURL.String(), depth+1)
-					}
-				}
-			}
-		}
+// segmentEscaper экранирует разделители каталогов и NUL внутри имени файла
+var segmentEscaper = strings.NewReplacer("/", "%2F", "\\", "%5C", "\x00", "%00")
 
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			processNode(c)
+// fileName - имя файла для URL, к которому без расширения добавляется
+// defaultExt
+func fileName(u *url.URL, defaultExt string) string {
+	// Путь собирается по сегментам: / и \ из %2F и %5C внутри сегмента не
+	// становятся разделителями каталогов, а сегменты . и .. - ссылками
+	// на каталоги
+	segments := strings.Split(strings.TrimPrefix(u.EscapedPath(), "/"), "/")
+	for i, s := range segments {
+		s = decodeSegment(s)
+		if s == "." || s == ".." {
+			s = strings.ReplaceAll(s, ".", "%2E")
 		}
+		segments[i] = s
+	}
+	path := strings.Join(segments, "/")
+
+	// Если путь заканчивается на /, добавляем index.html
+	if path == "" || strings.HasSuffix(path, "/") {
+		path = path + "index.html"
 	}
 
-	processNode(doc)
+	// Если нет расширения, добавляем defaultExt
+	ext := filepath.Ext(path)
+	if ext == "" {
+		ext = defaultExt
+		path += ext
+	}
+
+	// Query вставляется перед расширением: так ?page=1 и ?page=2 не
+	// перезаписывают друг друга, а локальная копия сохраняет тип файла
+	if u.RawQuery != "" {
+		query := segmentEscaper.Replace(u.RawQuery)
+		path = strings.TrimSuffix(path, ext) + "?" + query + ext
+	}
+	return path
 }
 
-func (d *downloader) Wait() {
+// Wait дожидается окончания обхода. Если обход остановлен квотой,
+// возвращает errQuotaExceeded, если ошибок загрузки больше допустимого -
+// *failuresError; при обеих причинах они объединяются. Итоги запуска
+// возвращаются в любом случае
+func (d *downloader) Wait() (runStats, error) {
 	d.wg.Wait()
+	d.elapsed = time.Since(d.started)
+	close(d.stopProgress)
+	if d.display != nil {
+		d.display.close()
+	}
+
+	// В режимах --spider и -O зеркала нет, и индекс не ведется
+	if !d.spider && d.outputDocument == "" {
+		d.retargetAliases()
+
+		if err := d.index.save(); err != nil {
+			d.errorf(logEntry{event: "index", err: err}, "Failed to save index: %v", err)
+		}
+		if d.manifest != nil {
+			d.refreshManifest()
+			if err := d.manifest.save(); err != nil {
+				d.errorf(logEntry{event: "manifest", err: err}, "Failed to save manifest: %v", err)
+			}
+		}
+	}
+	if d.archive != nil {
+		extra := []string{d.index.file}
+		if d.manifest != nil {
+			extra = append(extra, d.manifest.file)
+		}
+		if err := d.archive.finish(extra...); err != nil {
+			d.errorf(logEntry{event: "archive", err: err}, "Failed to write archive: %v", err)
+		}
+	}
+	if d.warc != nil {
+		if err := d.warc.close(); err != nil {
+			d.errorf(logEntry{event: "warc", err: err}, "Failed to write WARC file: %v", err)
+		}
+	}
+
+	var errs []error
+	if err := d.ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if d.quotaHit.Load() {
+		errs = append(errs, errQuotaExceeded)
+	}
+	if n := len(d.Failures()); n > d.tolerateErrors {
+		errs = append(errs, &failuresError{count: n})
+	}
+	stats := d.Stats()
+	if d.events != nil {
+		d.emit(progressEvent{Event: "summary", Summary: &stats})
+		if err := d.events.close(); err != nil {
+			d.errorf(logEntry{event: "events", err: err}, "Failed to write progress events: %v", err)
+		}
+	}
+	return stats, errors.Join(errs...)
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: ./webmirror <URL> [depth] [download_dir]")
-		os.Exit(1)
+	// serve не скачивает, а раздает готовое зеркало, и флаги у нее свои
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(runServe(os.Args[2:], os.Stderr))
 	}
 
-	startURL := os.Args[1]
-	depth := 1
-	downloadDir := "downloads"
+	opts, args, err := parseArgs(os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp), errors.Is(err, errConfigPrinted):
+		os.Exit(0)
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		log.Fatal(err)
+	}
 
-	if len(os.Args) > 2 {
-		var err error
-		depth, err = strconv.Atoi(os.Args[2])
+	// С -o/-a журнал пишется в файл, а ошибки дублируются в stderr. Файл
+	// открывается до первого запроса, чтобы в него попали и ошибки запуска
+	var logOutput, mirror io.Writer = os.Stderr, nil
+	closeLog := func() {}
+	if opts.logFile != "" || opts.appendLogFile != "" {
+		path, appendLog := opts.logFile, false
+		if opts.appendLogFile != "" {
+			path, appendLog = opts.appendLogFile, true
+		}
+		f, err := openLogFile(path, appendLog)
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		logOutput, mirror = f, os.Stderr
+		closeLog = func() {
+			f.Sync()
+			f.Close()
+		}
+	}
+	// С --warc-only дерево файлов нужно только на время обхода: страницы
+	// разбираются с диска, а в результате остается один WARC. С
+	// --output-archive файлы зеркала на диск не пишутся, во временном
+	// каталоге лежат только .part, индекс и манифест
+	removeTree := func() {}
+	if opts.warcOnly || opts.outputArchive != "" {
+		dir, err := os.MkdirTemp("", "webmirror-tree-*")
 		if err != nil {
-			log.Fatalf("Invalid depth: %v", err)
+			log.Fatalf("Failed to create temporary directory: %v", err)
+		}
+		opts.downloadDir = dir
+		if opts.warcOnly {
+			opts.manifest = manifestNone
 		}
+		removeTree = func() { os.RemoveAll(dir) }
+	}
+	exit := func(code int) {
+		removeTree()
+		closeLog()
+		os.Exit(code)
 	}
 
-	if len(os.Args) > 3 {
-		downloadDir = os.Args[3]
+	// Живая область прогресса рисуется в stderr, и все, что туда пишется,
+	// идет через нее, чтобы строки журнала выводились над областью
+	progress := opts.progress
+	if progress == progressAuto {
+		progress = progressLines
+		if isTerminal(os.Stderr) && !opts.quiet {
+			progress = progressBar
+		}
+	}
+	switch progress {
+	case progressBar:
+		opts.display = newProgressDisplay(os.Stderr)
+		if logOutput == io.Writer(os.Stderr) {
+			logOutput = opts.display
+		} else {
+			mirror = opts.display
+		}
+	case progressNone:
+		opts.progressInterval = 0
 	}
 
-	downloader, err := newDownloader(startURL, downloadDir, depth, 10)
+	// Итоги выводятся и с -q, поэтому у них свой журнал уровня Info.
+	// Через него же идут и оставшиеся вызовы log
+	opts.logger, err = newLogger(opts.logFormat, logOutput, logLevelFor(opts.quiet, int(opts.verbose), opts.debug), mirror)
 	if err != nil {
 		log.Fatal(err)
 	}
+	summaryLog, _ := newLogger(opts.logFormat, logOutput, levelInfo, mirror)
+	if opts.logFormat == "json" {
+		slog.SetDefault(summaryLog)
+	} else {
+		log.SetOutput(logOutput)
+	}
+	summary := func(level slog.Level, e logEntry, format string, args ...any) {
+		logEvent(summaryLog, level, e, format, args...)
+	}
+	fatal := func(format string, args ...any) {
+		summary(levelError, logEntry{event: "fatal"}, format, args...)
+		exit(1)
+	}
 
-	if err := downloader.Download(); err != nil {
-		log.Fatal(err)
+	// События для внешних программ пишутся в отдельный канал, мимо журнала
+	switch {
+	case opts.progressFD >= 0:
+		f := os.NewFile(uintptr(opts.progressFD), "progress-fd")
+		if _, err := f.Stat(); err != nil {
+			fatal("Invalid --progress-fd %d: %v", opts.progressFD, err)
+		}
+		opts.events = newEventStream(f)
+	case opts.progressFile != "":
+		f, err := os.Create(opts.progressFile)
+		if err != nil {
+			fatal("Failed to create progress file: %v", err)
+		}
+		defer f.Close()
+		opts.events = newEventStream(f)
+	}
+
+	var startURLs []string
+	// Из stdin URL читаются по мере поступления уже во время обхода
+	if opts.inputFile != "" && !opts.streamInput() {
+		urls, err := readInputFile(opts.inputFile, opts.forceHTML, opts.logger)
+		if err != nil {
+			fatal("Failed to read input file: %v", err)
+		}
+		startURLs = urls
+	}
+	// Все стартовые URL обходятся за один запуск с общим списком посещенных
+	startURLs = append(startURLs, args...)
+
+	if opts.outputDocument != "" && len(startURLs) != 1 {
+		fatal("-O takes exactly one URL")
+	}
+
+	downloader, err := newDownloader(startURLs, opts)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	// Первый Ctrl-C останавливает обход, не оставляя недописанных файлов:
+	// прерванные загрузки остаются в .part. Второй завершает процесс сразу
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		summary(levelInfo, logEntry{event: "interrupt"}, "Interrupted, stopping downloads; press Ctrl-C again to exit immediately")
+		cancel()
+		<-signals
+		exit(130)
+	}()
+
+	if err := downloader.Download(ctx); err != nil {
+		fatal("%v", err)
+	}
+
+	stats, waitErr := downloader.Wait()
+
+	// Куки сохраняются, даже если часть загрузок не удалась
+	if opts.saveCookies != "" {
+		if err := downloader.cookies.save(opts.saveCookies, opts.keepSessionCookies); err != nil {
+			summary(levelError, logEntry{event: "cookies", err: err}, "Failed to save cookies: %v", err)
+		}
+	}
+
+	if opts.spider {
+		if err := writeSpiderResults(os.Stdout, downloader.SpiderResults(), opts.spiderFormat); err != nil {
+			summary(levelError, logEntry{event: "spider", err: err}, "Failed to write spider results: %v", err)
+		}
+	}
+
+	if opts.brokenLinks != "" {
+		links := downloader.BrokenLinks()
+		if err := writeBrokenLinks(opts.brokenLinks, opts.brokenLinksFormat, links); err != nil {
+			summary(levelError, logEntry{event: "broken_links", err: err}, "Failed to write broken links report: %v", err)
+		} else {
+			summary(levelInfo, logEntry{event: "broken_links"}, "%d broken links found", len(links))
+		}
 	}
 
-	downloader.Wait()
-	log.Println("Download completed!")
+	if failures := downloader.Failures(); len(failures) > 0 {
+		summary(levelInfo, logEntry{event: "summary"}, "%d downloads failed:", len(failures))
+		for _, f := range failures {
+			summary(levelInfo, logEntry{event: "summary_failure", url: f.url, status: statusOf(f.err), err: f.err}, "  %s (%d attempts): %v", f.url, f.attempts, f.err)
+		}
+	}
+	if skipped := downloader.Skipped(); len(skipped) > 0 {
+		reasons := make([]string, 0, len(skipped))
+		for reason := range skipped {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			summary(levelInfo, logEntry{event: "summary"}, "%d URLs skipped by %s", skipped[reason], reason)
+		}
+	}
+	// Ссылки mailto:, tel: и т. п. - не ошибка, их число нужно только для отладки
+	if schemes := downloader.Schemes(); opts.debug && len(schemes) > 0 {
+		names := make([]string, 0, len(schemes))
+		for scheme := range schemes {
+			names = append(names, scheme)
+		}
+		sort.Strings(names)
+		for _, scheme := range names {
+			summary(levelInfo, logEntry{event: "summary"}, "%d %s: links left as is", schemes[scheme], scheme)
+		}
+	}
+	if n := downloader.MalformedInput(); n > 0 {
+		summary(levelInfo, logEntry{event: "summary"}, "%d malformed input lines ignored", n)
+	}
+	if n := downloader.NotFetched(); n > 0 {
+		summary(levelInfo, logEntry{event: "summary"}, "%d URLs discovered but not fetched because of --max-files", n)
+	}
+	if opts.quota > 0 {
+		state := "not reached"
+		if errors.Is(waitErr, errQuotaExceeded) {
+			state = "exceeded"
+		}
+		summary(levelInfo, logEntry{event: "summary", bytes: stats.Bytes}, "Downloaded %d bytes, quota of %d bytes %s", stats.Bytes, opts.quota, state)
+	}
+	if opts.summary == "json" {
+		if err := writeSummary(os.Stdout, stats); err != nil {
+			summary(levelError, logEntry{event: "summary", err: err}, "Failed to write summary: %v", err)
+		}
+	} else {
+		for _, line := range summaryLines(stats) {
+			summary(levelInfo, logEntry{event: "summary", bytes: stats.Bytes, duration: time.Duration(stats.Elapsed * float64(time.Second))}, "%s", line)
+		}
+	}
+	if errors.Is(waitErr, context.Canceled) {
+		summary(levelInfo, logEntry{event: "finished", err: waitErr}, "Download interrupted")
+		exit(130)
+	}
+	if errors.Is(waitErr, errQuotaExceeded) {
+		summary(levelInfo, logEntry{event: "finished", err: errQuotaExceeded}, "Stopped early: %v", errQuotaExceeded)
+		exit(3)
+	}
+	if waitErr != nil {
+		summary(levelInfo, logEntry{event: "finished", err: waitErr}, "Download finished with errors: %v", waitErr)
+		exit(1)
+	}
+	summary(levelInfo, logEntry{event: "finished"}, "Download completed!")
+	removeTree()
+	closeLog()
 }
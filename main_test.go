@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMirror запускает обход с аргументами командной строки args в
+// каталог dir и возвращает итоги запуска
+func testMirror(t *testing.T, dir string, args ...string) (runStats, error) {
+	t.Helper()
+	args = append([]string{"-P", dir, "-q", "--progress", "none", "--no-favicon", "--manifest", "none"}, args...)
+	opts, urls, err := parseArgs(args, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatalf("parseArgs(%q): %v", args, err)
+	}
+	d, err := newDownloader(urls, opts)
+	if err != nil {
+		t.Fatalf("newDownloader: %v", err)
+	}
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	return d.Wait()
+}
+
+// testDownloader создает загрузчик с настройками из аргументов командной
+// строки args, не запуская обход
+func testDownloader(t *testing.T, args ...string) *downloader {
+	t.Helper()
+	args = append([]string{"-P", t.TempDir(), "-q", "--progress", "none"}, args...)
+	opts, urls, err := parseArgs(args, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatalf("parseArgs(%q): %v", args, err)
+	}
+	d, err := newDownloader(urls, opts)
+	if err != nil {
+		t.Fatalf("newDownloader: %v", err)
+	}
+	return d
+}
+
+// hostDirOf - каталог зеркала для тестового сервера
+func hostDirOf(dir string, srv *httptest.Server) string {
+	return filepath.Join(dir, strings.TrimPrefix(srv.URL, "http://"))
+}
+
+// readMirrorFile читает файл зеркала по пути через /
+func readMirrorFile(t *testing.T, dir string, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// peakHeap замеряет наибольший HeapInuse, пока выполняется fn
+func peakHeap(fn func()) uint64 {
+	var peak uint64
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			peak = max(peak, m.HeapInuse)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	fn()
+	close(stop)
+	wg.Wait()
+	return peak
+}
+
+func TestLargeBodyIsStreamed(t *testing.T) {
+	if testing.Short() {
+		t.Skip("downloads 256 MB")
+	}
+	const size = 256 << 20
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		for sent := 0; sent < size; sent += len(chunk) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var stats runStats
+	var err error
+	peak := peakHeap(func() {
+		stats, err = testMirror(t, dir, "-l", "0", srv.URL+"/big.iso")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Failed != 0 {
+		t.Fatalf("Failed = %d", stats.Failed)
+	}
+	info, err := os.Stat(filepath.Join(hostDirOf(dir, srv), "big.iso"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Errorf("saved %d bytes, want %d", info.Size(), size)
+	}
+	if grown := int64(peak) - int64(before.HeapInuse); grown > 32<<20 {
+		t.Errorf("heap grew by %d MB while downloading %d MB", grown>>20, size>>20)
+	}
+}
+
+func TestDispositionFilename(t *testing.T) {
+	tests := []struct{ value, want string }{
+		{`attachment; filename="report.pdf"`, "report.pdf"},
+		{`attachment; filename=report.pdf`, "report.pdf"},
+		{`attachment; filename="my report.pdf"`, "my report.pdf"},
+		{`attachment; filename*=UTF-8''%D0%BE%D1%82%D1%87%D0%B5%D1%82.pdf`, "отчет.pdf"},
+		{`attachment; filename="fallback.pdf"; filename*=UTF-8''%C3%A9t%C3%A9.pdf`, "été.pdf"},
+		{`attachment; filename="../escape.sh"`, "escape.sh"},
+		{`attachment; filename="..\\..\\escape.bat"`, "escape.bat"},
+		{`attachment; filename="/etc/passwd"`, "passwd"},
+		{`attachment; filename=".."`, ""},
+		{`attachment`, ""},
+		{`inline; filename=`, ""},
+		{``, ""},
+		{`attachment; filename="unterminated`, ""},
+	}
+	for _, tt := range tests {
+		if got := dispositionFilename(tt.value); got != tt.want {
+			t.Errorf("dispositionFilename(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestContentDispositionMirror(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/files/download?id=123">report</a><a href="/files/evil">evil</a>`))
+		case "/files/download":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", `attachment; filename="report.pdf"`)
+			w.Write([]byte("%PDF"))
+		case "/files/evil":
+			w.Header().Set("Content-Disposition", `attachment; filename="../../escape.txt"`)
+			w.Write([]byte("evil"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "--content-disposition", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	host := hostDirOf(dir, srv)
+	if got := readMirrorFile(t, host, "files/report.pdf"); got != "%PDF" {
+		t.Errorf("files/report.pdf = %q", got)
+	}
+	if got := readMirrorFile(t, host, "files/escape.txt"); got != "evil" {
+		t.Errorf("files/escape.txt = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escape.txt")); err == nil {
+		t.Error("Content-Disposition name escaped the mirror")
+	}
+	if index := readMirrorFile(t, host, "index.html"); !strings.Contains(index, `href="files/report.pdf"`) {
+		t.Errorf("index.html does not link to files/report.pdf:\n%s", index)
+	}
+
+	// Без --content-disposition имя берется из URL
+	dir = t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(hostDirOf(dir, srv), "files", "report.pdf")); err == nil {
+		t.Error("Content-Disposition used without --content-disposition")
+	}
+}
+
+func TestFileNameQuery(t *testing.T) {
+	tests := []struct{ url, want string }{
+		{"http://example.com/posts?page=2", "posts?page=2.html"},
+		{"http://example.com/style.css?v=3", "style?v=3.css"},
+		{"http://example.com/dir/?q=a/b", "dir/index?q=a%2Fb.html"},
+		{"http://example.com/?a=1&b=2", "index?a=1&b=2.html"},
+		{"http://example.com/posts", "posts.html"},
+	}
+	for _, tt := range tests {
+		if got := savedName(mustParseURL(t, tt.url)); got != tt.want {
+			t.Errorf("savedName(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSafeSavePath(t *testing.T) {
+	d := testDownloader(t, "http://example.com/")
+	tests := []struct{ url, want string }{
+		// Закодированные / и \ остаются частью имени, а не разделителями
+		{"http://example.com/..%2f..%2f..%2fetc/passwd", "..%2F..%2F..%2Fetc/passwd.html"},
+		{"http://example.com/%2E%2E%2F%2E%2E%2Fetc%2Fpasswd", "..%2F..%2Fetc%2Fpasswd"},
+		{"http://example.com/a/..%5C..%5C..%5Cwin.ini", "a/..%5C..%5C..%5Cwin.ini"},
+		{`http://example.com/a\..\..\b.txt`, "a%5C..%5C..%5Cb.txt"},
+		// Закодированные точки - это сегменты . и ..
+		{"http://example.com/%2e%2e/%2e%2e/etc/passwd", "etc/passwd.html"},
+		{"http://example.com/a/%2e", "a/index.html"},
+		{"http://example.com/..", "index.html"},
+		{"http://example.com//etc/passwd", "etc/passwd.html"},
+		{"http://example.com/C:%5CWindows%5Cwin.ini", "C:%5CWindows%5Cwin.ini"},
+		{"http://example.com/C:/Windows/win.ini", "C:/Windows/win.ini"},
+		{"http://example.com/?q=../../x", "index?q=..%2F..%2Fx.html"},
+	}
+	hostDir := filepath.Join(d.downloadDir, "example.com")
+	for _, tt := range tests {
+		got, ok := d.safeSavePath(mustParseURL(t, tt.url))
+		if want := filepath.Join(hostDir, filepath.FromSlash(tt.want)); !ok || got != want {
+			t.Errorf("safeSavePath(%s) = %q, %v; want %q", tt.url, got, ok, want)
+		}
+		if !withinDir(hostDir, got) {
+			t.Errorf("safeSavePath(%s) = %q is outside %q", tt.url, got, hostDir)
+		}
+	}
+
+	// Хост, из которого вышел бы путь вне зеркала, отвергается
+	for _, host := range []string{"..", "."} {
+		u := &url.URL{Scheme: "http", Host: host, Path: "/passwd"}
+		if got, ok := d.safeSavePath(u); ok {
+			t.Errorf("safeSavePath with host %q = %q accepted", host, got)
+		}
+		if got := d.getSavePath(u); !withinDir(filepath.Join(d.downloadDir, unsafeDir), got) {
+			t.Errorf("getSavePath with host %q = %q", host, got)
+		}
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	dir := filepath.Join("mirror", "example.com")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join(dir, "index.html"), true},
+		{filepath.Join(dir, "a", "..", "b.html"), true},
+		{filepath.Join(dir, "..dots.html"), true},
+		{dir, false},
+		{filepath.Join(dir, ".."), false},
+		{filepath.Join(dir, "..", "other.com", "a.html"), false},
+		{filepath.Join(dir, "a", "..", "..", "..", "etc", "passwd"), false},
+		{filepath.Join(string(filepath.Separator), "etc", "passwd"), false},
+	}
+	for _, tt := range tests {
+		if got := withinDir(dir, tt.path); got != tt.want {
+			t.Errorf("withinDir(%q, %q) = %v, want %v", dir, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPathTraversalMirror(t *testing.T) {
+	hostile := []string{
+		"/..%2f..%2fescape-1.txt",
+		"/%2e%2e/%2e%2e/%2e%2e/escape-2.txt",
+		"/%2E%2E%2F%2E%2E%2Fescape-3.txt",
+		"/a/..%5C..%5C..%5Cescape-4.txt",
+		"/C:%5Cescape-5.txt",
+		"/redirect",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			for _, link := range hostile {
+				fmt.Fprintf(w, `<a href="%s">x</a>`, link)
+			}
+		case "/redirect":
+			w.Header().Set("Location", "/..%2F..%2Fescape-6.txt")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("escaped " + r.URL.EscapedPath()))
+		}
+	}))
+	defer srv.Close()
+
+	base := t.TempDir()
+	dir := filepath.Join(base, "mirror")
+	stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "2", srv.URL+"/")
+	if err != nil || stats.Failed != 0 {
+		t.Fatalf("Failed = %d, %v", stats.Failed, err)
+	}
+
+	host := hostDirOf(dir, srv)
+	var saved int
+	err = filepath.WalkDir(base, func(path string, e os.DirEntry, err error) error {
+		if err != nil || e.IsDir() || path == filepath.Join(dir, indexFileName) {
+			return err
+		}
+		if !withinDir(host, path) {
+			t.Errorf("%s saved outside %s", path, host)
+		}
+		// Цель редиректа сохраняется под именем redirect.html
+		if data, err := os.ReadFile(path); err == nil && strings.HasPrefix(string(data), "escaped ") {
+			saved++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != 6 {
+		t.Errorf("%d hostile URLs saved inside the mirror, want 6", saved)
+	}
+}
+
+func TestUnsafeLinkStaysAbsolute(t *testing.T) {
+	d := testDownloader(t, "-H", "-l", "2", "http://example.com/")
+	for _, host := range []string{"..", "."} {
+		u := &url.URL{Scheme: "http", Host: host, Path: "/passwd"}
+		if d.willDownload(u, 1, kindPage) {
+			t.Errorf("willDownload(%s) = true for a path outside the mirror", u)
+		}
+	}
+	if u := mustParseURL(t, "http://example.com/a.html"); !d.willDownload(u, 1, kindPage) {
+		t.Errorf("willDownload(%s) = false", u)
+	}
+
+	// Ссылка, которую downloadURL пропустит как небезопасную, сразу
+	// остается абсолютной, а не ведет в _unsafe
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="http://../passwd">up</a> <a href="/a.html">a</a>`))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-H", "-l", "1", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	index := readMirrorFile(t, hostDirOf(dir, srv), "index.html")
+	if !regexp.MustCompile(`href="http://\.+/passwd"`).MatchString(index) || !strings.Contains(index, `href="a.html"`) {
+		t.Errorf("index.html:\n%s", index)
+	}
+	if _, err := os.Stat(filepath.Join(dir, unsafeDir)); err == nil {
+		t.Errorf("%s created", unsafeDir)
+	}
+}
+
+// paginatedSite - лента /posts?page=N из pages страниц со ссылками на
+// соседние
+func paginatedSite(pages int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/posts?page=1">posts</a>`))
+		case "/posts":
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page < 1 || page > pages {
+				page = 1
+			}
+			fmt.Fprintf(w, "<p>page %d</p>", page)
+			if page > 1 {
+				fmt.Fprintf(w, `<a href="/posts?page=%d">prev</a>`, page-1)
+			}
+			if page < pages {
+				fmt.Fprintf(w, `<a href="?page=%d">next</a>`, page+1)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestPaginatedQueries(t *testing.T) {
+	srv := paginatedSite(3)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "-1", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	host := hostDirOf(dir, srv)
+	for page := 1; page <= 3; page++ {
+		got := readMirrorFile(t, host, fmt.Sprintf("posts?page=%d.html", page))
+		if !strings.Contains(got, fmt.Sprintf("<p>page %d</p>", page)) {
+			t.Errorf("posts?page=%d.html = %q", page, got)
+		}
+	}
+	// ? в ссылке на локальный файл экранируется, иначе браузер примет его за запрос
+	if got := readMirrorFile(t, host, "posts?page=2.html"); !strings.Contains(got, `href="posts%3Fpage=1.html"`) || !strings.Contains(got, `href="posts%3Fpage=3.html"`) {
+		t.Errorf("posts?page=2.html links:\n%s", got)
+	}
+	if got := readMirrorFile(t, host, "index.html"); !strings.Contains(got, `href="posts%3Fpage=1.html"`) {
+		t.Errorf("index.html links:\n%s", got)
+	}
+
+	// --strip-query сводит все страницы к одной
+	dir = t.TempDir()
+	stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "-1", "--strip-query", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pages != 2 {
+		t.Errorf("--strip-query: Pages = %d, want 2", stats.Pages)
+	}
+	if got := readMirrorFile(t, hostDirOf(dir, srv), "posts.html"); !strings.Contains(got, "<p>page 1</p>") {
+		t.Errorf("--strip-query: posts.html = %q", got)
+	}
+}
+
+// checkingReader отдает данные, проверяя перед каждым чтением, что
+// сохраняемый файл еще не тронут, и в конце возвращает err
+type checkingReader struct {
+	data  []byte
+	err   error
+	check func()
+}
+
+func (r *checkingReader) Read(p []byte) (int, error) {
+	r.check()
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data[:min(len(p), len(r.data), 4)])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// tempFiles - временные файлы saveFile в каталоге dir
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var temps []string
+	for _, e := range entries {
+		if isTempFile(e.Name()) {
+			temps = append(temps, e.Name())
+		}
+	}
+	return temps
+}
+
+func TestSaveFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "page.html")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Пока идет запись, на месте файла прежнее содержимое, а данные
+	// пишутся во временный файл рядом
+	unchanged := func() {
+		if data, err := os.ReadFile(path); err != nil || string(data) != "old" {
+			t.Errorf("file = %q, %v while it is being written", data, err)
+		}
+	}
+
+	// Ошибка посреди записи оставляет прежний файл и убирает временный
+	failure := errors.New("connection reset")
+	r := &checkingReader{data: []byte("new content"), err: failure, check: unchanged}
+	if _, err := saveFile(path, r); !errors.Is(err, failure) {
+		t.Fatalf("saveFile error = %v, want %v", err, failure)
+	}
+	unchanged()
+	if temps := tempFiles(t, dir); len(temps) != 0 {
+		t.Errorf("temporary files left after a failed write: %q", temps)
+	}
+
+	var temp string
+	r = &checkingReader{data: []byte("new content"), err: io.EOF, check: func() {
+		unchanged()
+		if temps := tempFiles(t, dir); len(temps) == 1 {
+			temp = temps[0]
+		} else {
+			t.Errorf("temporary files while writing: %q, want one", temps)
+		}
+	}}
+	n, err := saveFile(path, r)
+	if err != nil || n != int64(len("new content")) {
+		t.Fatalf("saveFile = %d, %v", n, err)
+	}
+	if !strings.HasPrefix(temp, ".page.html.tmp-") {
+		t.Errorf("temporary file %q, want .page.html.tmp-N next to the file", temp)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new content" || info.Mode().Perm() != 0644 {
+		t.Errorf("file = %q, mode %v; want the new content, mode 0644", data, info.Mode().Perm())
+	}
+	if temps := tempFiles(t, dir); len(temps) != 0 {
+		t.Errorf("temporary files left: %q", temps)
+	}
+}
+
+func TestIsTempFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{".page.html.tmp-123", true},
+		{".v1.0.tmp-dir", true},
+		{"page.html.tmp-123", false},
+		{".page.html.tmp-", false},
+		{".page.html.tmp-12a", false},
+		{".tmp-123", false},
+		{"page.html.part", false},
+		{".hidden.html", false},
+	}
+	for _, tt := range tests {
+		if got := isTempFile(tt.name); got != tt.want {
+			t.Errorf("isTempFile(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveStaleTemps(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>page</p>"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "example.com", "docs")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-staleTempAge - time.Minute)
+	fresh := time.Now().Add(-staleTempAge + time.Minute)
+	files := []struct {
+		name    string
+		modTime time.Time
+		kept    bool
+	}{
+		{filepath.Join(dir, ".index.html.tmp-123"), old, false},
+		{filepath.Join(sub, ".a.html.tmp-456"), old, false},
+		{filepath.Join(sub, ".v1.0.tmp-dir"), old, false},
+		// Свежий временный файл может писать другой запуск
+		{filepath.Join(sub, ".b.html.tmp-789"), fresh, true},
+		// С .part продолжается докачка
+		{filepath.Join(sub, "big.bin.part"), old, true},
+		{filepath.Join(sub, "notes.tmp-1"), old, true},
+		{filepath.Join(sub, ".hidden.html"), old, true},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.name, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f.name, f.modTime, f.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Уборка идет при запуске зеркалирования
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		_, err := os.Stat(f.name)
+		if kept := err == nil; kept != f.kept {
+			t.Errorf("%s: kept = %v, want %v", f.name, kept, f.kept)
+		}
+	}
+	if n := removeStaleTemps(dir); n != 0 {
+		t.Errorf("second cleanup removed %d files, want 0", n)
+	}
+}
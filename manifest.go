@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Форматы --manifest
+const (
+	manifestJSON = "json"
+	manifestCSV  = "csv"
+	manifestNone = "none"
+)
+
+// manifestColumns - столбцы манифеста в формате CSV
+var manifestColumns = []string{"url", "final_url", "path", "status", "content_type", "size", "sha256", "fetched_at", "last_modified"}
+
+// manifestEntry - запись манифеста о скачанном URL. Путь указан
+// относительно каталога загрузки
+type manifestEntry struct {
+	URL          string    `json:"url"`
+	FinalURL     string    `json:"final_url"`
+	Path         string    `json:"path"`
+	Status       int       `json:"status"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	LastModified string    `json:"last_modified,omitempty"`
+}
+
+// manifest - манифест зеркала: где на диске оказался каждый URL. При
+// повторных запусках записи прежнего манифеста сохраняются, а заново
+// скачанные URL заменяют свои записи
+type manifest struct {
+	mu      sync.Mutex
+	file    string
+	format  string
+	entries map[string]manifestEntry
+}
+
+// loadManifest читает манифест прежнего запуска из каталога загрузки
+func loadManifest(dir string, format string) (*manifest, error) {
+	m := &manifest{
+		file:    filepath.Join(dir, "manifest."+format),
+		format:  format,
+		entries: make(map[string]manifestEntry),
+	}
+
+	data, err := os.ReadFile(m.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []manifestEntry
+	if format == manifestCSV {
+		entries, err = readManifestCSV(bytes.NewReader(data))
+	} else {
+		err = json.Unmarshal(data, &entries)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("corrupt manifest %q: %v", m.file, err)
+	}
+	for _, e := range entries {
+		m.entries[e.URL] = e
+	}
+	return m, nil
+}
+
+func (m *manifest) add(e manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[e.URL] = e
+}
+
+// move переносит запись о файле oldPath на newPath; пути относительно
+// каталога загрузки
+func (m *manifest) move(oldPath string, newPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for rawURL, e := range m.entries {
+		if e.Path == oldPath {
+			e.Path = newPath
+			m.entries[rawURL] = e
+		}
+	}
+}
+
+// refresh пересчитывает функцией measure размер и SHA-256 файлов paths,
+// которые были переписаны уже после того, как попали в манифест
+func (m *manifest) refresh(paths map[string]bool, measure func(path string) (int64, string, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for rawURL, e := range m.entries {
+		if !paths[e.Path] {
+			continue
+		}
+		if size, sum, err := measure(e.Path); err == nil {
+			e.Size, e.SHA256 = size, sum
+			m.entries[rawURL] = e
+		}
+	}
+}
+
+// save записывает манифест целиком, упорядочив записи по URL
+func (m *manifest) save() error {
+	m.mu.Lock()
+	entries := make([]manifestEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	m.mu.Unlock()
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].URL < entries[b].URL
+	})
+
+	var b bytes.Buffer
+	if m.format == manifestCSV {
+		if err := writeManifestCSV(&b, entries); err != nil {
+			return err
+		}
+	} else {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+
+	_, err := saveFile(m.file, &b)
+	return err
+}
+
+func writeManifestCSV(w io.Writer, entries []manifestEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write(manifestColumns)
+	for _, e := range entries {
+		cw.Write([]string{
+			e.URL, e.FinalURL, e.Path, strconv.Itoa(e.Status), e.ContentType,
+			strconv.FormatInt(e.Size, 10), e.SHA256, e.FetchedAt.Format(time.RFC3339), e.LastModified,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func readManifestCSV(r io.Reader) ([]manifestEntry, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	var entries []manifestEntry
+	for i, record := range records[1:] {
+		if len(record) != len(manifestColumns) {
+			return nil, fmt.Errorf("line %d: want %d fields, got %d", i+2, len(manifestColumns), len(record))
+		}
+		status, err := strconv.Atoi(record[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid status %q", i+2, record[3])
+		}
+		size, err := strconv.ParseInt(record[5], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid size %q", i+2, record[5])
+		}
+		fetchedAt, err := time.Parse(time.RFC3339, record[7])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time %q", i+2, record[7])
+		}
+		entries = append(entries, manifestEntry{
+			URL: record[0], FinalURL: record[1], Path: record[2], Status: status, ContentType: record[4],
+			Size: size, SHA256: record[6], FetchedAt: fetchedAt, LastModified: record[8],
+		})
+	}
+	return entries, nil
+}
+
+// saved учитывает сохраненный файл: в счетчиках итогов, в потоке событий
+// и в манифесте. finalURL - конечный URL после
+// редиректов
+func (d *downloader) saved(rawURL string, finalURL string, status int, savePath string, size int64, header http.Header, page bool) {
+	d.countFile()
+	if page {
+		d.pagesSaved.Add(1)
+	} else {
+		d.assetsSaved.Add(1)
+	}
+	d.emit(progressEvent{Event: "finished", URL: rawURL, Status: status, Bytes: size, Path: savePath})
+	if d.manifest != nil {
+		d.addToManifest(rawURL, finalURL, status, savePath, size, header)
+	}
+}
+
+// refreshManifest обновляет в манифесте сохраненные страницы: ссылки в
+// них могли переписываться до самого конца обхода
+func (d *downloader) refreshManifest() {
+	d.visitedMutex.Lock()
+	pages := make(map[string]bool, len(d.savedPages))
+	for _, page := range d.savedPages {
+		pages[d.indexPath(page)] = true
+	}
+	d.visitedMutex.Unlock()
+	d.manifest.refresh(pages, func(path string) (int64, string, error) {
+		path = filepath.Join(d.downloadDir, filepath.FromSlash(path))
+		info, err := d.statSaved(path)
+		if err != nil {
+			return 0, "", err
+		}
+		sum, err := d.hashSaved(path)
+		return info.Size(), sum, err
+	})
+}
+
+// addToManifest заносит сохраненный файл в манифест вместе с его SHA-256
+func (d *downloader) addToManifest(rawURL string, finalURL string, status int, savePath string, size int64, header http.Header) {
+	sum, err := d.hashSaved(savePath)
+	if err != nil {
+		d.errorf(logEntry{event: "manifest", url: rawURL, err: err}, "Failed to hash %s for the manifest: %v", savePath, err)
+	}
+	d.manifest.add(manifestEntry{
+		URL:          rawURL,
+		FinalURL:     finalURL,
+		Path:         d.indexPath(savePath),
+		Status:       status,
+		ContentType:  header.Get("Content-Type"),
+		Size:         size,
+		SHA256:       sum,
+		FetchedAt:    time.Now().UTC().Truncate(time.Second),
+		LastModified: header.Get("Last-Modified"),
+	})
+}
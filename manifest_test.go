@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// manifestSite - сайт со страницами, картинкой, редиректом, query,
+// файлом, который переносится в одноименный каталог, и видео больше
+// --max-file-size, ссылка на которое возвращается к URL
+func manifestSite(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<a href="/a.html">a</a> <img src="/img.png"> <a href="/old">old</a>
+<a href="/list?page=2">list</a> <a href="/movie.mp4">movie</a> <a href="/v1.0">v1.0</a>`))
+		case "/v1.0":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="v1.0/users.json">users</a>`))
+		case "/v1.0/users.json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[]`))
+		case "/a.html", "/new.html", "/extra.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<p>` + r.URL.Path + `</p>`))
+		case "/list":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<p>page ` + r.URL.Query().Get("page") + `</p>`))
+		case "/img.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			w.Write([]byte("\x89PNG image"))
+		case "/movie.mp4":
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write([]byte(strings.Repeat("m", 4096)))
+		case "/old":
+			http.Redirect(w, r, "/new.html", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// checkManifest сверяет манифест с файлами зеркала: каждый сохраненный
+// файл записан ровно один раз, с его размером и SHA-256
+func checkManifest(t *testing.T, dir string, entries []manifestEntry) map[string]manifestEntry {
+	t.Helper()
+	byPath := make(map[string]manifestEntry)
+	byURL := make(map[string]manifestEntry)
+	for _, e := range entries {
+		if _, ok := byPath[e.Path]; ok {
+			t.Errorf("%s listed twice", e.Path)
+		}
+		byPath[e.Path] = e
+		byURL[e.URL] = e
+		if e.FetchedAt.IsZero() || time.Since(e.FetchedAt) > time.Hour {
+			t.Errorf("%s: fetched_at = %v", e.URL, e.FetchedAt)
+		}
+	}
+
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		if rel == indexFileName || strings.HasPrefix(rel, "manifest.") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, ok := byPath[rel]
+		if !ok {
+			t.Errorf("%s is not in the manifest", rel)
+			return nil
+		}
+		delete(byPath, rel)
+		sum := sha256.Sum256(data)
+		if e.Size != int64(len(data)) || e.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: size %d, sha256 %s; file has %d bytes, sha256 %x", rel, e.Size, e.SHA256, len(data), sum)
+		}
+		return nil
+	})
+	for path := range byPath {
+		t.Errorf("%s is in the manifest but not on disk", path)
+	}
+	return byURL
+}
+
+func TestManifest(t *testing.T) {
+	for _, format := range []string{manifestJSON, manifestCSV} {
+		t.Run(format, func(t *testing.T) {
+			srv := manifestSite(t)
+			dir := t.TempDir()
+			if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "2", "--max-file-size", "1k", "--manifest", format, srv.URL+"/"); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := loadManifest(dir, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var entries []manifestEntry
+			for _, e := range m.entries {
+				entries = append(entries, e)
+			}
+			if len(entries) != 7 {
+				t.Errorf("%d entries, want 7: %+v", len(entries), entries)
+			}
+			byURL := checkManifest(t, dir, entries)
+			host := filepath.Base(hostDirOf(dir, srv))
+
+			tests := []struct {
+				url, finalURL, path, contentType string
+			}{
+				{"/", "/", "index.html", "text/html; charset=utf-8"},
+				{"/a.html", "/a.html", "a.html", "text/html"},
+				{"/img.png", "/img.png", "img.png", "image/png"},
+				// Цель редиректа хранится под исходным именем
+				{"/old", "/new.html", "old.html", "text/html"},
+				{"/list?page=2", "/list?page=2", "list?page=2.html", "text/html"},
+				{"/v1.0", "/v1.0", "v1.0/index.0", "text/html"},
+				{"/v1.0/users.json", "/v1.0/users.json", "v1.0/users.json", "application/json"},
+			}
+			for _, tt := range tests {
+				e, ok := byURL[srv.URL+tt.url]
+				if !ok {
+					t.Errorf("%s is not in the manifest", tt.url)
+					continue
+				}
+				if e.FinalURL != srv.URL+tt.finalURL || e.Path != host+"/"+tt.path || e.Status != http.StatusOK || e.ContentType != tt.contentType {
+					t.Errorf("%s: %+v, want final URL %s, path %s, status 200, type %s", tt.url, e, tt.finalURL, tt.path, tt.contentType)
+				}
+			}
+			if e := byURL[srv.URL+"/img.png"]; e.LastModified != "Mon, 02 Jan 2006 15:04:05 GMT" {
+				t.Errorf("img.png last_modified = %q", e.LastModified)
+			}
+			// Несохраненное видео в манифест не попадает
+			if _, ok := byURL[srv.URL+"/movie.mp4"]; ok {
+				t.Error("skipped movie.mp4 is in the manifest")
+			}
+		})
+	}
+}
+
+func TestManifestMerge(t *testing.T) {
+	srv := manifestSite(t)
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "2", "--max-file-size", "1k", "--manifest", "json", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before []manifestEntry
+	if err := json.Unmarshal(first, &before); err != nil {
+		t.Fatal(err)
+	}
+
+	// Второй запуск добавляет свои записи к прежним, а заново скачанный
+	// URL заменяет свою запись
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "--manifest", "json", srv.URL+"/extra.html", srv.URL+"/a.html"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var after []manifestEntry
+	if err := json.Unmarshal(second, &after); err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before)+1 {
+		t.Fatalf("%d entries after the second run, want %d", len(after), len(before)+1)
+	}
+	for i := 1; i < len(after); i++ {
+		if after[i-1].URL >= after[i].URL {
+			t.Errorf("entries not sorted by URL: %s before %s", after[i-1].URL, after[i].URL)
+		}
+	}
+	checkManifest(t, dir, after)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// mhtmlPart - страница или ресурс в архиве MHTML
+type mhtmlPart struct {
+	location    string // Content-Location: по нему браузер сопоставляет ссылки
+	contentType string
+	content     []byte
+}
+
+// downloadMHTML скачивает страницу для --mhtml и сохраняет ее в -O вместе
+// с ресурсами одним архивом multipart/related. Страница сохраняется без
+// изменений: ссылки на ресурсы разрешаются по Content-Location частей
+func (d *downloader) downloadMHTML(j job, u *url.URL) {
+	rawURL := j.url
+	d.verbosef(logEntry{event: "download", url: rawURL}, "Downloading: %s", rawURL)
+	start := time.Now()
+
+	header := make(http.Header)
+	if referer := d.refererFor(j, u); referer != "" {
+		header.Set("Referer", referer)
+	}
+
+	resp, attempts, err := d.fetch(http.MethodGet, rawURL, u.Host, header)
+	if err != nil {
+		d.fail(rawURL, attempts, err)
+		return
+	}
+	content, err := d.readBody(resp, 0)
+	resp.Body.Close()
+	// Слот хоста освобождается до загрузки ресурсов с того же хоста
+	d.release(u.Host)
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to read response body: %w", err))
+		return
+	}
+
+	pageURL := resp.Request.URL
+	contentType := resp.Header.Get("Content-Type")
+	parts := []mhtmlPart{{location: pageURL.String(), contentType: contentType, content: content}}
+	if mediaType(contentType) == "text/html" {
+		parts = append(parts, d.mhtmlResources(content, pageURL)...)
+	}
+
+	var buf bytes.Buffer
+	if err := writeMHTML(&buf, parts); err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to build MHTML: %w", err))
+		return
+	}
+	n, err := writeDocument(d.outputDocument, &buf)
+	d.addBytes(n)
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to write %s: %w", d.outputDocument, err))
+		return
+	}
+	d.saved(rawURL, pageURL.String(), resp.StatusCode, d.outputDocument, n, resp.Header, true)
+	d.infof(logEntry{event: "saved", url: rawURL, status: resp.StatusCode, bytes: n, duration: time.Since(start)},
+		"Saved %s with %d resources to %s (%d bytes)", rawURL, len(parts)-1, d.outputDocument, n)
+}
+
+// mhtmlResources скачивает ресурсы страницы: то, что обход считает
+// ресурсами (картинки, стили, скрипты, иконки), и ссылки url() из стилей,
+// в том числе из скачанных CSS-файлов
+func (d *downloader) mhtmlResources(content []byte, pageURL *url.URL) []mhtmlPart {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: pageURL.String(), err: err}, "Failed to parse HTML: %v", err)
+		return nil
+	}
+
+	// Ссылки разрешаются от <base>, как в браузере. Части архива адресуются
+	// абсолютными URL, поэтому сам <base> остается на месте
+	base, _ := documentBase(doc, pageURL)
+	var queue []*url.URL
+	add := func(base *url.URL, raw string) {
+		if ref, err := base.Parse(raw); err == nil && (ref.Scheme == "http" || ref.Scheme == "https") {
+			ref.Fragment = ""
+			queue = append(queue, ref)
+		}
+	}
+	walkLinks(doc, func(_ *html.Node, attr *html.Attribute, kind resourceKind) {
+		if kind == kindRequisite {
+			add(base, attr.Val)
+		}
+	})
+	walkStyles(doc, func(css string) string {
+		cssRefs(css, func(ref string) { add(base, ref) })
+		return css
+	})
+
+	var parts []mhtmlPart
+	seen := map[string]bool{pageURL.String(): true}
+	for len(queue) > 0 {
+		ref := queue[0]
+		queue = queue[1:]
+		if seen[ref.String()] {
+			continue
+		}
+		seen[ref.String()] = true
+
+		content, contentType, err := d.fetchResource(ref, pageURL, 0)
+		if err != nil {
+			d.verbosef(logEntry{event: "mhtml", url: ref.String(), err: err}, "Not including %s: %v", ref, err)
+			continue
+		}
+		d.verbosef(logEntry{event: "mhtml", url: ref.String(), bytes: int64(len(content))}, "Included %s (%d bytes)", ref, len(content))
+		parts = append(parts, mhtmlPart{location: ref.String(), contentType: contentType, content: content})
+		if contentType == "text/css" {
+			cssRefs(string(content), func(raw string) { add(ref, raw) })
+		}
+	}
+	return parts
+}
+
+// writeMHTML записывает части архивом multipart/related. Первая часть -
+// страница. Текст кодируется quoted-printable, остальное - base64
+func writeMHTML(w io.Writer, parts []mhtmlPart) error {
+	mw := multipart.NewWriter(w)
+	fmt.Fprintf(w, "From: <Saved by webmirror>\r\n")
+	fmt.Fprintf(w, "Snapshot-Content-Location: %s\r\n", parts[0].location)
+	fmt.Fprintf(w, "Subject: %s\r\n", parts[0].location)
+	fmt.Fprintf(w, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(w, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(w, "Content-Type: multipart/related;\r\n\ttype=\"%s\";\r\n\tboundary=\"%s\"\r\n\r\n", mediaType(parts[0].contentType), mw.Boundary())
+
+	for _, p := range parts {
+		contentType := p.contentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		text := strings.HasPrefix(mediaType(contentType), "text/") || strings.HasSuffix(mediaType(contentType), "javascript")
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", contentType)
+		header.Set("Content-Location", p.location)
+		if text {
+			header.Set("Content-Transfer-Encoding", "quoted-printable")
+		} else {
+			header.Set("Content-Transfer-Encoding", "base64")
+		}
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		if text {
+			qw := quotedprintable.NewWriter(pw)
+			if _, err := qw.Write(p.content); err != nil {
+				return err
+			}
+			if err := qw.Close(); err != nil {
+				return err
+			}
+			continue
+		}
+		encoded := base64.StdEncoding.EncodeToString(p.content)
+		for len(encoded) > 76 {
+			io.WriteString(pw, encoded[:76]+"\r\n")
+			encoded = encoded[76:]
+		}
+		if _, err := io.WriteString(pw, encoded+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestMHTML(t *testing.T) {
+	srv := singleFileSite(t)
+	out := filepath.Join(t.TempDir(), "page.mhtml")
+	if _, err := testMirror(t, t.TempDir(), "-e", "robots=off", "--mhtml", out, srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Корень - multipart/related с типом страницы
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rootType != "multipart/related" || params["type"] != "text/html" || params["boundary"] == "" {
+		t.Fatalf("Content-Type = %q, want multipart/related of text/html", msg.Header.Get("Content-Type"))
+	}
+	if loc := msg.Header.Get("Snapshot-Content-Location"); loc != srv.URL+"/" {
+		t.Errorf("Snapshot-Content-Location = %q, want %s/", loc, srv.URL)
+	}
+
+	// Части читаются как есть, без расшифровки multipart.Reader, чтобы
+	// проверить Content-Transfer-Encoding
+	var locations []string
+	parts := make(map[string]string)
+	types := make(map[string]string)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loc := p.Header.Get("Content-Location")
+		if u, err := url.Parse(loc); err != nil || !u.IsAbs() {
+			t.Errorf("part without an absolute Content-Location: %v", p.Header)
+			continue
+		}
+		if _, ok := parts[loc]; ok {
+			t.Errorf("%s included twice", loc)
+		}
+		contentType := mediaType(p.Header.Get("Content-Type"))
+
+		var content []byte
+		switch encoding := p.Header.Get("Content-Transfer-Encoding"); {
+		case strings.HasPrefix(contentType, "text/") || strings.HasSuffix(contentType, "javascript"):
+			if encoding != "quoted-printable" {
+				t.Errorf("%s (%s): encoding %q, want quoted-printable", loc, contentType, encoding)
+			}
+			content, err = io.ReadAll(quotedprintable.NewReader(bytes.NewReader(raw)))
+		default:
+			if encoding != "base64" {
+				t.Errorf("%s (%s): encoding %q, want base64", loc, contentType, encoding)
+			}
+			for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\r\n") {
+				if len(line) > 76 {
+					t.Errorf("%s: base64 line of %d characters", loc, len(line))
+				}
+			}
+			content, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\r\n", ""))
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", loc, err)
+		}
+		locations = append(locations, loc)
+		parts[loc] = string(content)
+		types[loc] = contentType
+	}
+
+	// Первая часть - сама страница без изменений, текст с не-ASCII
+	// символами закодирован, а не оставлен как есть
+	if len(locations) == 0 || locations[0] != srv.URL+"/" || types[locations[0]] != "text/html" {
+		t.Fatalf("parts %q, want the page first", locations)
+	}
+	page := parts[srv.URL+"/"]
+	if !strings.Contains(page, `<link rel="stylesheet" href="/css/main.css" media="screen">`) || !strings.Contains(page, "Страница с картинками") {
+		t.Errorf("page changed in the archive:\n%s", page)
+	}
+	if raw, _ := os.ReadFile(out); bytes.Contains(raw, []byte("Страница")) {
+		t.Error("non-ASCII text of the page is not quoted-printable encoded")
+	}
+	if got := parts[srv.URL+"/img/dot.gif"]; got != "GIF89a" {
+		t.Errorf("dot.gif = %q, want the served bytes", got)
+	}
+
+	// Каждая ссылка на ресурс страницы и стилей, разрешенная от адреса
+	// своей части, ведет на часть архива
+	resolves := func(base string, ref string) {
+		u, err := url.Parse(base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target, err := u.Parse(ref)
+		if err != nil {
+			t.Errorf("bad reference %q in %s", ref, base)
+			return
+		}
+		target.Fragment = ""
+		if _, ok := parts[target.String()]; !ok {
+			t.Errorf("%q in %s resolves to %s, which is not in the archive", ref, base, target)
+		}
+	}
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := 0
+	walkLinks(doc, func(_ *html.Node, attr *html.Attribute, kind resourceKind) {
+		if kind == kindRequisite {
+			refs++
+			resolves(srv.URL+"/", attr.Val)
+		}
+	})
+	walkStyles(doc, func(css string) string {
+		cssRefs(css, func(ref string) {
+			refs++
+			resolves(srv.URL+"/", ref)
+		})
+		return css
+	})
+	for loc, content := range parts {
+		if types[loc] == "text/css" {
+			cssRefs(content, func(ref string) {
+				refs++
+				resolves(loc, ref)
+			})
+		}
+	}
+	if refs < 12 {
+		t.Errorf("checked %d references, want at least 12", refs)
+	}
+	// Страница и все ресурсы, включая вариант из srcset и цепочку
+	// @import, но не страница по ссылке <a>
+	if len(parts) != 12 {
+		t.Errorf("%d parts, want 12: %q", len(parts), locations)
+	}
+	if _, ok := parts[srv.URL+"/other.html"]; ok {
+		t.Error("linked page other.html included as a resource")
+	}
+}
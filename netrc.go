@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type netrcEntry struct {
+	login    string
+	password string
+}
+
+// netrc - учетные данные из ~/.netrc: по хостам и запись default
+type netrc struct {
+	machines map[string]netrcEntry
+	fallback *netrcEntry
+}
+
+// netrcPath возвращает путь к .netrc с учетом переменной NETRC
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// loadNetrc читает .netrc, если он есть. Файл, доступный на чтение группе
+// или остальным, пропускается с предупреждением, как это делает curl
+func (d *downloader) loadNetrc() *netrc {
+	path := netrcPath()
+	if path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		d.warnf(logEntry{event: "netrc"}, "Warning: ignoring %s: it is readable by other users (chmod 600 it)", path)
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		d.errorf(logEntry{event: "netrc", err: err}, "Failed to read %s: %v", path, err)
+		return nil
+	}
+	defer f.Close()
+
+	return parseNetrc(f)
+}
+
+func parseNetrc(r io.Reader) *netrc {
+	n := &netrc{machines: make(map[string]netrcEntry)}
+
+	var tokens []string
+	scanner := bufio.NewScanner(r)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Тело macdef продолжается до пустой строки
+		if inMacro {
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		fields := netrcFields(line)
+		for i, field := range fields {
+			if field == "macdef" {
+				fields = fields[:i]
+				inMacro = true
+				break
+			}
+		}
+		tokens = append(tokens, fields...)
+	}
+
+	var current *netrcEntry
+	var machine string
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if machine == "" {
+			if n.fallback == nil {
+				n.fallback = current
+			}
+		} else if _, ok := n.machines[machine]; !ok {
+			n.machines[machine] = *current
+		}
+		current = nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			flush()
+			if i+1 < len(tokens) {
+				i++
+				machine = strings.ToLower(tokens[i])
+				current = &netrcEntry{}
+			}
+		case "default":
+			flush()
+			machine = ""
+			current = &netrcEntry{}
+		case "login", "password", "account":
+			if i+1 >= len(tokens) {
+				break
+			}
+			i++
+			if current == nil {
+				continue
+			}
+			switch tokens[i-1] {
+			case "login":
+				current.login = tokens[i]
+			case "password":
+				current.password = tokens[i]
+			}
+		}
+	}
+	flush()
+
+	return n
+}
+
+// netrcFields разбивает строку на токены, поддерживая пароли в кавычках
+func netrcFields(line string) []string {
+	var fields []string
+	var b strings.Builder
+	inQuotes, inToken := false, false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && inQuotes && i+1 < len(line):
+			i++
+			b.WriteByte(line[i])
+		case c == '"':
+			inQuotes = !inQuotes
+			inToken = true
+		case !inQuotes && (c == ' ' || c == '\t'):
+			if inToken {
+				fields = append(fields, b.String())
+				b.Reset()
+				inToken = false
+			}
+		default:
+			b.WriteByte(c)
+			inToken = true
+		}
+	}
+	if inToken {
+		fields = append(fields, b.String())
+	}
+	return fields
+}
+
+// lookup ищет учетные данные для хоста, а если их нет - запись default
+func (n *netrc) lookup(host string) (netrcEntry, bool) {
+	if n == nil {
+		return netrcEntry{}, false
+	}
+	if e, ok := n.machines[strings.ToLower(host)]; ok {
+		return e, true
+	}
+	if n.fallback != nil {
+		return *n.fallback, true
+	}
+	return netrcEntry{}, false
+}
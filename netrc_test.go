@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	n := parseNetrc(strings.NewReader(`machine example.com login alice password a1
+machine API.example.org
+	login bob
+	password "with space \"quoted\""
+	account ignored
+macdef init
+	cd /pub
+	machine evil.com login mallory password m
+
+machine example.com login second password s2
+default login anon password guest
+machine late.example.net login carol password c3
+`))
+
+	tests := []struct {
+		host            string
+		login, password string
+	}{
+		{"example.com", "alice", "a1"},
+		{"api.example.org", "bob", `with space "quoted"`},
+		{"API.EXAMPLE.ORG", "bob", `with space "quoted"`},
+		{"late.example.net", "carol", "c3"},
+		// Хосты без своей записи получают default, в том числе хост из macdef
+		{"evil.com", "anon", "guest"},
+		{"www.example.com", "anon", "guest"},
+	}
+	for _, tt := range tests {
+		e, ok := n.lookup(tt.host)
+		if !ok || e.login != tt.login || e.password != tt.password {
+			t.Errorf("lookup(%q) = %+v, %v; want %s/%s", tt.host, e, ok, tt.login, tt.password)
+		}
+	}
+
+	n = parseNetrc(strings.NewReader("machine example.com login alice password a1\n"))
+	if _, ok := n.lookup("other.com"); ok {
+		t.Error("lookup without default found credentials for another host")
+	}
+	var none *netrc
+	if _, ok := none.lookup("example.com"); ok {
+		t.Error("nil netrc found credentials")
+	}
+}
+
+func TestLoadNetrc(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "netrc")
+	t.Setenv("NETRC", file)
+	d := testDownloader(t, "--http-user", "explicit", "http://example.com/")
+
+	if d.loadNetrc() != nil {
+		t.Error("missing file loaded")
+	}
+	if err := os.WriteFile(file, []byte("machine example.com login alice password a1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.loadNetrc(); n == nil {
+		t.Error("file with mode 0600 not loaded")
+	} else if e, ok := n.lookup("example.com"); !ok || e.login != "alice" {
+		t.Errorf("lookup = %+v, %v", e, ok)
+	}
+	for _, mode := range []os.FileMode{0640, 0604} {
+		if err := os.Chmod(file, mode); err != nil {
+			t.Fatal(err)
+		}
+		if d.loadNetrc() != nil {
+			t.Errorf("file with mode %o loaded", mode)
+		}
+	}
+}
+
+func TestNetrcAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || (user+":"+password != "alice:a1" && user+":"+password != "bob:b2") {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(user))
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(file, []byte("machine 127.0.0.1 login alice password a1\ndefault login anon password x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NETRC", file)
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMirrorFile(t, hostDirOf(dir, srv), "index.html"); got != "alice" {
+		t.Errorf("index.html = %q, want alice", got)
+	}
+
+	// Явно заданные учетные данные важнее .netrc
+	dir = t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "--http-user", "bob", "--http-password", "b2", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMirrorFile(t, hostDirOf(dir, srv), "index.html"); got != "bob" {
+		t.Errorf("index.html = %q, want bob", got)
+	}
+}
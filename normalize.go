@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// trackingParams - параметры запроса, которые --strip-tracking убирает
+// из ссылок: метки рекламных кампаний и идентификаторы переходов
+var trackingParams = []string{
+	"utm_*", "fbclid", "gclid", "dclid", "gbraid", "wbraid", "msclkid", "yclid",
+	"mc_cid", "mc_eid", "_ga", "_gl", "igshid", "_hsenc", "_hsmi", "mkt_tok",
+}
+
+// normalizeURL приводит URL к виду, под которым он попадает в очередь, в
+// список посещенных и в имя файла: схема в нижнем регистре, хост в виде
+// siteHost, путь без сегментов . и .., в форме Unicode
+// --unicode-file-names, а %XX - только там, где без них нельзя. Так
+// /a/../b, /./b и /%62 - один URL /b
+func (d *downloader) normalizeURL(u *url.URL) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	d.normalizeSiteHost(u)
+	if u.Opaque != "" {
+		return
+	}
+
+	escaped := unicodePath(removeDotSegments(normalizeEscapes(u.EscapedPath())), d.unicodeFileNames)
+	if p, err := url.PathUnescape(escaped); err == nil {
+		u.Path, u.RawPath = p, escaped
+		// RawPath нужен, только если экранирование отличается от обычного
+		if (&url.URL{Path: p}).EscapedPath() == escaped {
+			u.RawPath = ""
+		}
+	}
+	u.RawQuery = d.normalizeQuery(normalizeEscapes(u.RawQuery))
+}
+
+// stripParamPatterns - шаблоны --strip-params вместе со списком
+// --strip-tracking
+func (o *options) stripParamPatterns() []string {
+	patterns := append([]string(nil), o.stripParams...)
+	if o.stripTracking {
+		patterns = append(patterns, trackingParams...)
+	}
+	return patterns
+}
+
+// normalizeQuery убирает из запроса параметры, имена которых подходят под
+// шаблоны --strip-params (utm_*, fbclid), и, если не задан
+// --no-sort-query, упорядочивает остальные по имени: ?b=2&a=1 и ?a=1&b=2 -
+// один URL. Повторы одного параметра остаются в своем порядке, а сами
+// параметры не перекодируются: %20 и + не меняются, и ?q=a+b и ?q=a%20b -
+// разные URL, как и для сервера, который разбирает + буквально. Пустые
+// параметры (a=1&&b=2, a=1&) убираются: порядка у них нет, и при
+// сортировке они оказались бы в начале (?&a=1)
+func (d *downloader) normalizeQuery(query string) string {
+	if query == "" || (len(d.stripParams) == 0 && d.noSortQuery) {
+		return query
+	}
+	params := strings.Split(query, "&")
+	kept := params[:0]
+	for _, param := range params {
+		if param == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !matchParam(strings.ToLower(name), d.stripParams) {
+			kept = append(kept, param)
+		}
+	}
+	if !d.noSortQuery {
+		sort.SliceStable(kept, func(i, j int) bool {
+			a, _, _ := strings.Cut(kept[i], "=")
+			b, _, _ := strings.Cut(kept[j], "=")
+			return a < b
+		})
+	}
+	return strings.Join(kept, "&")
+}
+
+// matchParam проверяет имя параметра по шаблонам с * и ?
+func matchParam(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeEscapes раскрывает %XX для незарезервированных символов
+// (буквы, цифры, -._~), а в остальных пишет шестнадцатеричные цифры
+// заглавными: %7e и %7E - это ~, %2f - %2F
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteString(strings.ToUpper(s[i+1 : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+// isUnreserved - символы, которые по RFC 3986 экранировать не нужно
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// removeDotSegments убирает из пути от корня сегменты . и .. (RFC 3986,
+// 5.2.4). Выше корня .. не поднимается, а / в конце пути сохраняется
+func removeDotSegments(p string) string {
+	if !strings.Contains(p, ".") {
+		return p
+	}
+	segments := strings.Split(p, "/")
+	out := make([]string, 0, len(segments))
+	for i, s := range segments {
+		last := i == len(segments)-1
+		switch s {
+		case ".":
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, s)
+			continue
+		}
+		if last {
+			out = append(out, "")
+		}
+	}
+	return strings.Join(out, "/")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestHostKey(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/", "example.com"},
+		{"http://EXAMPLE.Com/", "example.com"},
+		{"http://example.com:80/", "example.com"},
+		{"https://example.com:443/", "example.com"},
+		{"http://example.com:443/", "example.com:443"},
+		{"https://example.com:80/", "example.com:80"},
+		{"http://example.com:8080/", "example.com:8080"},
+		{"http://example.com./", "example.com"},
+		{"http://Example.COM.:80/", "example.com"},
+		{"http://bücher.example/", "xn--bcher-kva.example"},
+		{"http://BÜCHER.example:8080/", "xn--bcher-kva.example:8080"},
+		{"http://xn--bcher-kva.example/", "xn--bcher-kva.example"},
+		{"http://127.0.0.1:80/", "127.0.0.1"},
+		{"http://[::1]/", "[::1]"},
+		{"http://[::1]:80/", "[::1]"},
+		{"http://[::1]:8080/", "[::1]:8080"},
+		{"https://[::1]:80/", "[::1]:80"},
+		{"https://[2001:DB8::1]:443/", "[2001:db8::1]"},
+		{"http://[2001:db8::1]:8080/", "[2001:db8::1]:8080"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hostKey(u); got != tt.want {
+			t.Errorf("hostKey(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSiteHost(t *testing.T) {
+	tests := []struct {
+		args []string
+		url  string
+		want string
+	}{
+		{nil, "http://www.example.com/", "www.example.com"},
+		{[]string{"--treat-www-as-same"}, "http://www.example.com/", "example.com"},
+		{[]string{"--treat-www-as-same"}, "http://WWW.Example.com:80/", "example.com"},
+		{[]string{"--treat-www-as-same"}, "http://example.com/", "example.com"},
+		{[]string{"--treat-www-as-same"}, "http://www.example.com:8080/", "www.example.com:8080"},
+		{[]string{"--treat-www-as-same"}, "http://www.other.org/", "www.other.org"},
+		{[]string{"--treat-www-as-same"}, "http://www.bücher.example/", "www.xn--bcher-kva.example"},
+	}
+	for _, tt := range tests {
+		d := testDownloader(t, append(tt.args, "http://example.com/")...)
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.siteHost(u); got != tt.want {
+			t.Errorf("siteHost(%s) with %q = %q, want %q", tt.url, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		args []string
+		url  string
+		want string
+	}{
+		// Схема и хост
+		{nil, "HTTP://Example.COM/", "http://example.com/"},
+		{nil, "http://example.com:80/a", "http://example.com/a"},
+		{nil, "https://example.com:443/a", "https://example.com/a"},
+		{nil, "http://example.com:8080/a", "http://example.com:8080/a"},
+		{nil, "http://example.com./a", "http://example.com/a"},
+		{nil, "http://bücher.example/a", "http://xn--bcher-kva.example/a"},
+		{nil, "http://[::1]:80/a", "http://[::1]/a"},
+		// Сегменты . и ..
+		{nil, "http://example.com/a/./b", "http://example.com/a/b"},
+		{nil, "http://example.com/a/../b", "http://example.com/b"},
+		{nil, "http://example.com/a/b/..", "http://example.com/a/"},
+		{nil, "http://example.com/a/b/.", "http://example.com/a/b/"},
+		{nil, "http://example.com/../../a", "http://example.com/a"},
+		{nil, "http://example.com/a/.b/..c", "http://example.com/a/.b/..c"},
+		// Экранирование
+		{nil, "http://example.com/%7euser", "http://example.com/~user"},
+		{nil, "http://example.com/%7Euser", "http://example.com/~user"},
+		{nil, "http://example.com/%61%62c", "http://example.com/abc"},
+		{nil, "http://example.com/a%2fb", "http://example.com/a%2Fb"},
+		{nil, "http://example.com/a%2Fb", "http://example.com/a%2Fb"},
+		{nil, "http://example.com/caf%c3%a9", "http://example.com/caf%C3%A9"},
+		{nil, "http://example.com/café", "http://example.com/caf%C3%A9"},
+		{nil, "http://example.com/a%20b", "http://example.com/a%20b"},
+		{nil, "http://example.com/%2E%2E/a", "http://example.com/a"},
+		// Форма Unicode пути - как у имен файлов
+		{nil, "http://example.com/cafe%CC%81/caf%C3%A9", "http://example.com/caf%C3%A9/caf%C3%A9"},
+		{[]string{"--unicode-file-names", "nfd"}, "http://example.com/caf%C3%A9", "http://example.com/cafe%CC%81"},
+		{[]string{"--unicode-file-names", "none"}, "http://example.com/cafe%CC%81", "http://example.com/cafe%CC%81"},
+		{nil, "http://example.com/a%2Fcafe%CC%81", "http://example.com/a%2Fcaf%C3%A9"},
+		{nil, "http://example.com/%FF%FE", "http://example.com/%FF%FE"},
+		{nil, "http://example.com/?q=%7e%2f", "http://example.com/?q=~%2F"},
+		// Запрос
+		{nil, "http://example.com/?b=2&a=1", "http://example.com/?a=1&b=2"},
+		{nil, "http://example.com/?a=2&a=1", "http://example.com/?a=2&a=1"},
+		{nil, "http://example.com/?a=&b=1", "http://example.com/?a=&b=1"},
+		{nil, "http://example.com/?b=1&&a=2", "http://example.com/?a=2&b=1"},
+		{nil, "http://example.com/?a=1&", "http://example.com/?a=1"},
+		{nil, "http://example.com/?&a=1", "http://example.com/?a=1"},
+		{nil, "http://example.com/?&&", "http://example.com/"},
+		{[]string{"--no-sort-query"}, "http://example.com/?b=1&&a=2", "http://example.com/?b=1&&a=2"},
+		{[]string{"--strip-tracking"}, "http://example.com/?utm_source=x&&id=1", "http://example.com/?id=1"},
+		{[]string{"--strip-tracking", "--no-sort-query"}, "http://example.com/?b=1&&a=2&", "http://example.com/?b=1&a=2"},
+		// + и %20 не перекодируются: это разные URL, а %2B - всегда
+		// буквальный плюс
+		{nil, "http://example.com/?q=a+b", "http://example.com/?q=a+b"},
+		{nil, "http://example.com/?q=a%20b", "http://example.com/?q=a%20b"},
+		{nil, "http://example.com/?q=a%2bb", "http://example.com/?q=a%2Bb"},
+		{nil, "http://example.com/?q=a+b&p=1", "http://example.com/?p=1&q=a+b"},
+		{nil, "http://example.com/?q=a%20b&p=1", "http://example.com/?p=1&q=a%20b"},
+		{nil, "http://example.com/?q+x=1&q=2", "http://example.com/?q=2&q+x=1"},
+		{[]string{"--no-sort-query"}, "http://example.com/?b=2&a=1", "http://example.com/?b=2&a=1"},
+		{[]string{"--strip-tracking"}, "http://example.com/?utm_source=x&id=1&fbclid=y", "http://example.com/?id=1"},
+		{[]string{"--strip-params", "sid"}, "http://example.com/?SID=1&id=2", "http://example.com/?id=2"},
+	}
+	for _, tt := range tests {
+		d := testDownloader(t, append(tt.args, "http://example.com/")...)
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.normalizeURL(u)
+		if got := u.String(); got != tt.want {
+			t.Errorf("normalizeURL(%s) with %q = %q, want %q", tt.url, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveDotSegments(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"/", "/"},
+		{"/a/b/c", "/a/b/c"},
+		{"/a/./b", "/a/b"},
+		{"/a/../b", "/b"},
+		{"/a/b/../../c", "/c"},
+		{"/..", "/"},
+		{"/a/..", "/"},
+		{"/a/.", "/a/"},
+		{"/a/b/..", "/a/"},
+		{"/a/.../b", "/a/.../b"},
+	}
+	for _, tt := range tests {
+		if got := removeDotSegments(tt.path); got != tt.want {
+			t.Errorf("removeDotSegments(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
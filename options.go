@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// options - настройки загрузчика, заполняемые из командной строки
+type options struct {
+	downloadDir     string
+	maxDepth        int
+	maxConcurrent   int
+	hostConnections int
+	robots          bool
+	wait            time.Duration
+	tries           int
+	retryDelay      time.Duration
+	waitRetry       time.Duration
+	maxRetryAfter   time.Duration
+	timestamping    bool
+	noClobber       bool
+
+	contentDisposition bool
+	stripQuery         bool
+	stripParams        []string
+	stripTracking      bool
+	noSortQuery        bool
+	trustServerNames   bool
+	maxRedirects       int
+	crossHostRedirects string
+	redirectHosts      []string
+	loadCookies        string
+	saveCookies        string
+	keepSessionCookies bool
+	httpUser           string
+	httpPassword       string
+	headers            stringList
+	userAgent          string
+	referer            string
+	noReferer          bool
+	proxy              string
+
+	noCheckCertificate    bool
+	caCertificate         string
+	certificate           string
+	privateKey            string
+	saveCompressed        bool
+	pageRequisites        bool
+	noMetaRefresh         bool
+	noAlternateStyles     bool
+	noFavicon             bool
+	noMetaAssets          bool
+	structuredData        bool
+	scanJS                bool
+	rewriteJS             bool
+	extractDataURIs       bool
+	dataURIMinSize        int64
+	respectNofollow       bool
+	robotsMeta            autoBoolFlag
+	skipNoindex           bool
+	honorCanonical        bool
+	treatWWWAsSame        bool
+	idnDirs               string
+	restrictFileNames     string
+	noTrapDetection       bool
+	maxQueryVariants      int
+	unicodeFileNames      string
+	noParent              bool
+	spanHosts             bool
+	domains               []string
+	excludeDomains        []string
+	includeSubdomains     bool
+	accept                []string
+	reject                []string
+	ignoreCase            bool
+	acceptRegex           stringList
+	rejectRegex           stringList
+	acceptMime            []string
+	rejectMime            []string
+	mimeProbe             bool
+	maxFileSize           int64
+	quota                 int64
+	maxFiles              int64
+	dnsTimeout            time.Duration
+	connectTimeout        time.Duration
+	tlsTimeout            time.Duration
+	responseHeaderTimeout time.Duration
+	readTimeout           time.Duration
+	totalTimeout          time.Duration
+	spider                bool
+	spiderFormat          string
+	brokenLinks           string
+	brokenLinksFormat     string
+	inputFile             string
+	configFile            string
+	printConfig           bool
+	quiet                 bool
+	verbose               countFlag
+	debug                 bool
+	logFormat             string
+	summary               string // text или json в stdout
+	manifest              string // json, csv или none
+	warcFile              string // префикс файлов WARC
+	warcMaxSize           int64
+	warcOnly              bool
+	outputArchive         string // tar, tar.gz или zip вместо дерева файлов
+	singleFile            bool
+	mhtml                 string
+	sitemap               string
+	maxInlineSize         int64
+	progress              string
+	progressInterval      time.Duration
+	display               *progressDisplay // живая область прогресса, nil - без нее
+	progressFD            int              // -1 - без потока событий в дескриптор
+	progressFile          string
+	events                *eventStream          // поток событий, nil - без него
+	logFile               string                // -o
+	appendLogFile         string                // -a
+	logger                *slog.Logger          // nil - журнал по --log-format с уровнем из -q/-v
+	sites                 map[string]siteConfig // настройки сайтов из файла настроек
+	outputDocument        string
+	forceHTML             bool
+	tolerateErrors        int
+	limitRate             int64
+}
+
+// infiniteDepth в качестве глубины снимает ограничение: обход завершится,
+// когда закончатся непосещенные URL в пределах зеркалируемых хостов
+const infiniteDepth = -1
+
+func defaultOptions() options {
+	return options{
+		downloadDir:           "downloads",
+		maxDepth:              1,
+		maxConcurrent:         10,
+		connectTimeout:        30 * time.Second,
+		tlsTimeout:            10 * time.Second,
+		responseHeaderTimeout: time.Minute,
+		readTimeout:           15 * time.Minute,
+		hostConnections:       2,
+		robots:                true,
+		tries:                 3,
+		retryDelay:            time.Second,
+		waitRetry:             10 * time.Second,
+		maxRetryAfter:         5 * time.Minute,
+		maxRedirects:          20,
+		userAgent:             defaultUserAgent,
+		spiderFormat:          "text",
+		idnDirs:               idnDirsASCII,
+		restrictFileNames:     defaultRestrictFileNames(),
+		maxQueryVariants:      defaultQueryVariants,
+		unicodeFileNames:      unicodeNFC,
+		brokenLinksFormat:     "text",
+		logFormat:             "text",
+		summary:               "text",
+		manifest:              manifestJSON,
+		maxInlineSize:         1 << 20,
+		dataURIMinSize:        4 << 10,
+		progress:              progressAuto,
+		progressInterval:      10 * time.Second,
+		progressFD:            -1,
+		sites:                 make(map[string]siteConfig),
+
+		crossHostRedirects: redirectRefuse,
+	}
+}
+
+// streamInput сообщает, что стартовые URL читаются из stdin во время обхода
+func (o *options) streamInput() bool {
+	return o.inputFile == "-" && !o.forceHTML
+}
+
+// applyCommand применяет команду в стиле wgetrc, переданную через -e
+func (o *options) applyCommand(command string) error {
+	name, value, ok := strings.Cut(command, "=")
+	if !ok {
+		return fmt.Errorf("invalid command %q: expected name=value", command)
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	value = strings.TrimSpace(value)
+
+	switch name {
+	case "robots":
+		enabled, err := parseOnOff(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %v", name, err)
+		}
+		o.robots = enabled
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return nil
+}
+
+func parseOnOff(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "yes", "true", "1":
+		return true, nil
+	case "off", "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not on/off", value)
+}
+
+// stringList - флаг, который можно указать несколько раз
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// secondsFlag - длительность, которую можно задать числом секунд, как в wget,
+// или в формате time.ParseDuration
+type secondsFlag time.Duration
+
+func (f *secondsFlag) String() string {
+	return time.Duration(*f).String()
+}
+
+func (f *secondsFlag) Set(value string) error {
+	d, err := parseSeconds(value)
+	if err != nil {
+		return err
+	}
+	*f = secondsFlag(d)
+	return nil
+}
+
+func parseSeconds(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("negative duration %q", value)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", value)
+	}
+	return d, nil
+}
+
+// listFlag - список через запятую; повторное указание флага дополняет его
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// bytesFlag - размер в байтах с необязательным суффиксом k, m или g, как в wget
+type bytesFlag int64
+
+func (f *bytesFlag) String() string {
+	return strconv.FormatInt(int64(*f), 10)
+}
+
+func (f *bytesFlag) Set(value string) error {
+	n, err := parseBytes(value)
+	if err != nil {
+		return err
+	}
+	*f = bytesFlag(n)
+	return nil
+}
+
+func parseBytes(value string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+	multiplier := 1.0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * multiplier), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// stdoutDocument в качестве -O направляет тело ответа в stdout
+const stdoutDocument = "-"
+
+// downloadDocument скачивает URL для -O: тело пишется потоком прямо в
+// заданный файл или в stdout, минуя схему каталогов getSavePath, а ссылки
+// не извлекаются. Журнал идет в stderr, так что stdout остается чистым
+func (d *downloader) downloadDocument(j job, u *url.URL) {
+	rawURL := j.url
+	d.verbosef(logEntry{event: "download", url: rawURL}, "Downloading: %s", rawURL)
+	start := time.Now()
+
+	header := make(http.Header)
+	if referer := d.refererFor(j, u); referer != "" {
+		header.Set("Referer", referer)
+	}
+
+	resp, attempts, err := d.fetch(http.MethodGet, rawURL, u.Host, header)
+	if err != nil {
+		d.fail(rawURL, attempts, err)
+		return
+	}
+	defer d.release(u.Host)
+	resp.Body = d.trackBody(rawURL, d.limitBody(resp.Body), resp.ContentLength, 0)
+	defer resp.Body.Close()
+
+	if d.maxFileSize > 0 && resp.ContentLength > d.maxFileSize {
+		d.verbosef(logEntry{event: "skip", url: rawURL, status: resp.StatusCode}, "Skipping %s: size %d exceeds --max-file-size %d", rawURL, resp.ContentLength, d.maxFileSize)
+		d.skipURL(rawURL, resp.StatusCode, "--max-file-size")
+		return
+	}
+	if !d.saveCompressed {
+		if err := decodeBody(resp); err != nil {
+			d.fail(rawURL, attempts, err)
+			return
+		}
+	}
+	resp.Body = d.capBody(resp.Body, 0)
+
+	n, err := writeDocument(d.outputDocument, resp.Body)
+	d.addBytes(n)
+	if errors.Is(err, errFileTooLarge) {
+		d.verbosef(logEntry{event: "skip", url: rawURL, status: resp.StatusCode, err: err}, "Aborted %s: more than %d bytes received, exceeds --max-file-size", rawURL, d.maxFileSize)
+		d.skipURL(rawURL, resp.StatusCode, "--max-file-size")
+		return
+	}
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to write %s: %w", d.outputDocument, err))
+		return
+	}
+	d.saved(rawURL, resp.Request.URL.String(), resp.StatusCode, d.outputDocument, n, resp.Header, false)
+	d.infof(logEntry{event: "saved", url: rawURL, status: resp.StatusCode, bytes: n, duration: time.Since(start)}, "Saved %s to %s (%d bytes)", rawURL, d.outputDocument, n)
+}
+
+// writeDocument пишет r в файл -O или в stdout. Файл открывается на месте,
+// без временного файла и переименования: -O бывает /dev/null, FIFO или
+// файлом в каталоге, куда нельзя писать
+func writeDocument(path string, r io.Reader) (int64, error) {
+	if path == stdoutDocument {
+		return io.Copy(os.Stdout, r)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
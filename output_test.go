@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteDocumentInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.html")
+	if err := os.WriteFile(path, []byte("old content that is longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := writeDocument(path, strings.NewReader("new"))
+	if err != nil || n != 3 {
+		t.Fatalf("writeDocument = %d, %v", n, err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Файл переписан на месте, а не заменен другим
+	if !os.SameFile(before, after) {
+		t.Error("file replaced instead of written in place")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("content = %q, want %q", data, "new")
+	}
+}
+
+func TestWriteDocumentDevNull(t *testing.T) {
+	if _, err := os.Stat(os.DevNull); err != nil {
+		t.Skip(err)
+	}
+	if _, err := writeDocument(os.DevNull, strings.NewReader("discarded")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(os.DevNull); err != nil || info.Mode()&os.ModeDevice == 0 {
+		t.Fatalf("%s is no longer a device: %v", os.DevNull, err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Режимы --progress
+const (
+	progressAuto  = "auto"  // bar, если stderr - терминал, иначе lines
+	progressBar   = "bar"   // живая область внизу терминала
+	progressLines = "lines" // строка сводки раз в --progress-interval
+	progressNone  = "none"
+)
+
+const (
+	// progressRefresh - период перерисовки живой области
+	progressRefresh = 200 * time.Millisecond
+	// progressTransfers - сколько текущих загрузок показывать
+	progressTransfers = 8
+	// progressWidth - ширина строки, если терминал ее не сообщил
+	progressWidth = 80
+)
+
+// isTerminal сообщает, подключен ли файл к терминалу
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// transfer - текущая загрузка, показываемая в живой области
+type transfer struct {
+	url   string
+	total int64 // -1, если размер неизвестен
+	done  atomic.Int64
+}
+
+// progressDisplay рисует внизу терминала текущие загрузки и общую сводку.
+// Строки журнала пишутся через Write: область стирается, строка выводится
+// над ней, и область рисуется заново
+type progressDisplay struct {
+	out      io.Writer
+	width    int
+	received atomic.Int64 // байты, полученные всеми загрузками, для скорости
+
+	mu        sync.Mutex
+	transfers []*transfer
+	status    func() string
+	lines     int // высота нарисованной области
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+func newProgressDisplay(out io.Writer) *progressDisplay {
+	width := progressWidth
+	if n, err := fmt.Sscan(os.Getenv("COLUMNS"), &width); n != 1 || err != nil || width < 20 {
+		width = progressWidth
+	}
+	return &progressDisplay{out: out, width: width}
+}
+
+// Write выводит строку журнала над живой областью
+func (p *progressDisplay) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+	n, err := p.out.Write(b)
+	p.draw()
+	return n, err
+}
+
+// start запускает перерисовку; status возвращает строку общей сводки
+func (p *progressDisplay) start(status func() string) {
+	p.mu.Lock()
+	p.status = status
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	p.mu.Unlock()
+
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(progressRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.mu.Lock()
+				p.clear()
+				p.draw()
+				p.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// close останавливает перерисовку и стирает область, чтобы итоги
+// выводились в чистый терминал
+func (p *progressDisplay) close() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.status = nil
+}
+
+// add регистрирует загрузку; done уже скачанная часть при докачке
+func (p *progressDisplay) add(url string, total int64, done int64) *transfer {
+	t := &transfer{url: url, total: total}
+	t.done.Store(done)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transfers = append(p.transfers, t)
+	return t
+}
+
+func (p *progressDisplay) remove(t *transfer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, other := range p.transfers {
+		if other == t {
+			p.transfers = append(p.transfers[:i], p.transfers[i+1:]...)
+			return
+		}
+	}
+}
+
+// clear стирает нарисованную область. Вызывается под mu
+func (p *progressDisplay) clear() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.lines)
+		p.lines = 0
+	}
+}
+
+// draw рисует область заново. Вызывается под mu
+func (p *progressDisplay) draw() {
+	if p.status == nil {
+		return
+	}
+
+	var b strings.Builder
+	lines := 0
+	for i, t := range p.transfers {
+		if i == progressTransfers {
+			fmt.Fprintf(&b, "  ... and %d more\n", len(p.transfers)-i)
+			lines++
+			break
+		}
+		b.WriteString(p.fit(transferLine(t)))
+		b.WriteByte('\n')
+		lines++
+	}
+	b.WriteString(p.fit(p.status()))
+	b.WriteByte('\n')
+	lines++
+
+	io.WriteString(p.out, b.String())
+	p.lines = lines
+}
+
+// fit обрезает строку по ширине терминала, чтобы она не переносилась и
+// стирание по числу строк оставалось точным
+func (p *progressDisplay) fit(line string) string {
+	if r := []rune(line); len(r) > p.width-1 {
+		return string(r[:p.width-2]) + "…"
+	}
+	return line
+}
+
+// transferLine - строка текущей загрузки: процент, если размер известен
+func transferLine(t *transfer) string {
+	done := t.done.Load()
+	if t.total > 0 {
+		return fmt.Sprintf("%3d%% %9s / %-9s %s", done*100/t.total, formatSize(done), formatSize(t.total), t.url)
+	}
+	return fmt.Sprintf("     %9s             %s", formatSize(done), t.url)
+}
+
+// formatSize записывает размер в байтах коротко: 512B, 1.5K, 20.3M
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n) / unit
+	for _, suffix := range []string{"K", "M", "G"} {
+		if value < unit || suffix == "G" {
+			return fmt.Sprintf("%.1f%s", value, suffix)
+		}
+		value /= unit
+	}
+	return ""
+}
+
+// trackedBody считает прочитанные байты загрузки для живой области и
+// убирает загрузку из нее при закрытии
+type trackedBody struct {
+	io.ReadCloser
+	t       *transfer
+	display *progressDisplay
+	once    sync.Once
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.t.done.Add(int64(n))
+	b.display.received.Add(int64(n))
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	b.once.Do(func() { b.display.remove(b.t) })
+	return b.ReadCloser.Close()
+}
+
+// trackBody показывает загрузку в живой области, если она включена.
+// offset - уже скачанная часть при докачке
+func (d *downloader) trackBody(rawURL string, body io.ReadCloser, contentLength int64, offset int64) io.ReadCloser {
+	if d.display == nil {
+		return body
+	}
+	total := int64(-1)
+	if contentLength >= 0 {
+		total = offset + contentLength
+	}
+	return &trackedBody{ReadCloser: body, t: d.display.add(rawURL, total, offset), display: d.display}
+}
+
+// statusLine - общая сводка для живой области
+func (d *downloader) statusLine() string {
+	d.failuresMutex.Lock()
+	failed := len(d.failures)
+	d.failuresMutex.Unlock()
+
+	rate := float64(d.display.received.Load()) / time.Since(d.started).Seconds()
+	return fmt.Sprintf("%d done, %d queued, %d failed, %s saved, %s/s",
+		d.processed.Load(), d.queue.len(), failed, formatSize(d.bytesSaved.Load()), formatSize(int64(rate)))
+}
+
+// reportProgress выводит сводку обхода раз в interval, пока не закрыт stop
+func (d *downloader) reportProgress(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			d.failuresMutex.Lock()
+			failed := len(d.failures)
+			d.failuresMutex.Unlock()
+			d.infof(logEntry{event: "progress", bytes: d.bytesSaved.Load()}, "Progress: %d URLs processed, %d queued, %d failed, %d bytes downloaded",
+				d.processed.Load(), d.queue.len(), failed, d.bytesSaved.Load())
+		}
+	}
+}
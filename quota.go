@@ -0,0 +1,74 @@
+package main
+
+import "errors"
+
+// errQuotaExceeded возвращается из Wait, если обход остановлен квотой -Q
+var errQuotaExceeded = errors.New("download quota exceeded")
+
+// addBytes учитывает записанные на диск байты
+func (d *downloader) addBytes(n int64) {
+	d.bytesSaved.Add(n)
+}
+
+// quotaReached проверяет квоту перед началом новой загрузки. Файл, на
+// котором квота превышена, дописывается до конца, как в wget
+func (d *downloader) quotaReached() bool {
+	if d.quota <= 0 || d.bytesSaved.Load() < d.quota {
+		return false
+	}
+	if !d.quotaHit.Swap(true) {
+		d.infof(logEntry{event: "quota", bytes: d.bytesSaved.Load()}, "Download quota of %d bytes exceeded, not starting new downloads", d.quota)
+	}
+	return true
+}
+
+// BytesSaved возвращает число байт, записанных за время работы
+func (d *downloader) BytesSaved() int64 {
+	return d.bytesSaved.Load()
+}
+
+// takeFileSlot занимает место под очередную загрузку в пределах
+// --max-files. Лимит считает сохраненные файлы, а выполняемые загрузки
+// занимают место, пока не закончатся, чтобы параллельные воркеры его не
+// превысили. URL сверх лимита только подсчитываются
+func (d *downloader) takeFileSlot() bool {
+	if d.maxFiles <= 0 {
+		return true
+	}
+	d.filesMutex.Lock()
+	defer d.filesMutex.Unlock()
+	if d.filesSaved+d.filesActive >= d.maxFiles {
+		d.notFetched.Add(1)
+		return false
+	}
+	d.filesActive++
+	return true
+}
+
+// releaseFileSlot освобождает место загрузки. Если файл сохранен,
+// countFile уже учел его, а после ошибки, 404 или пропуска место
+// достается следующему URL
+func (d *downloader) releaseFileSlot() {
+	if d.maxFiles <= 0 {
+		return
+	}
+	d.filesMutex.Lock()
+	defer d.filesMutex.Unlock()
+	d.filesActive--
+}
+
+// countFile учитывает в --max-files сохраненный (или проверенный в
+// --spider) файл
+func (d *downloader) countFile() {
+	if d.maxFiles <= 0 {
+		return
+	}
+	d.filesMutex.Lock()
+	defer d.filesMutex.Unlock()
+	d.filesSaved++
+}
+
+// NotFetched возвращает число найденных URL, не скачанных из-за --max-files
+func (d *downloader) NotFetched() int64 {
+	return d.notFetched.Load()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// --max-files считает сохраненные файлы: 404 и favicon.ico место не
+// занимают
+func TestMaxFilesCountsSaved(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			w.Header().Set("Content-Type", "text/html")
+			for i := 0; i < 5; i++ {
+				fmt.Fprintf(w, `<a href="/missing%d.html">m</a>`, i)
+			}
+			for i := 0; i < 5; i++ {
+				fmt.Fprintf(w, `<a href="/file%d.txt">f</a>`, i)
+			}
+		case strings.HasPrefix(r.URL.Path, "/file"):
+			w.Write([]byte("content"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	stats, _ := testMirror(t, t.TempDir(), "-e", "robots=off", "--no-favicon=false", "--concurrency", "1", "--max-files", "4", srv.URL+"/")
+	if saved := stats.Pages + stats.Assets; saved != 4 {
+		t.Errorf("saved %d files, want 4", saved)
+	}
+	if stats.Assets != 3 {
+		t.Errorf("saved %d assets, want 3 despite 404s", stats.Assets)
+	}
+}
+
+func TestFileSlots(t *testing.T) {
+	d := testDownloader(t, "--max-files", "2", "http://example.com/")
+	if !d.takeFileSlot() || !d.takeFileSlot() {
+		t.Fatal("slots within the limit refused")
+	}
+	if d.takeFileSlot() {
+		t.Fatal("third active download allowed")
+	}
+	// Неудачная загрузка возвращает место
+	d.releaseFileSlot()
+	if !d.takeFileSlot() {
+		t.Fatal("slot of a failed download not returned")
+	}
+	d.countFile()
+	d.releaseFileSlot()
+	d.countFile()
+	d.releaseFileSlot()
+	if d.takeFileSlot() {
+		t.Fatal("slot taken after 2 files were saved")
+	}
+	if n := d.NotFetched(); n != 2 {
+		t.Errorf("NotFetched = %d, want 2", n)
+	}
+}
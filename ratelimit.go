@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateChunk ограничивает размер одного чтения, чтобы ожидание между
+// чтениями было коротким и скорость выравнивалась плавно
+const rateChunk = 16 * 1024
+
+// rateLimiter - общий для всех загрузок token bucket. Емкость корзины равна
+// секундному лимиту: после простоя допускается всплеск не больше секунды,
+// а первая загрузка начинается с пустой корзины
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // байт в секунду
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond), last: time.Now()}
+}
+
+// take списывает n байт и ждет, пока корзина не выйдет из долга. Долг
+// распределяет ожидание между конкурентными загрузками по очереди. При
+// отмене ctx ожидание прерывается
+func (l *rateLimiter) take(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedBody пропускает тело ответа через общий лимитер
+type limitedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rateLimiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if len(p) > rateChunk {
+		p = p[:rateChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := b.limiter.take(b.ctx, n); err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// limitBody оборачивает тело ответа лимитером, если задан --limit-rate
+func (d *downloader) limitBody(body io.ReadCloser) io.ReadCloser {
+	if d.limiter == nil {
+		return body
+	}
+	return &limitedBody{ReadCloser: body, ctx: d.ctx, limiter: d.limiter}
+}
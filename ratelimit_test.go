@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWaits(t *testing.T) {
+	l := newRateLimiter(1000)
+	start := time.Now()
+	if err := l.take(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	}
+	// 100 байт при 1000 байт в секунду - около 100 мс
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("take returned after %v, want about 100ms", elapsed)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l := newRateLimiter(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	// Без отмены ожидание длилось бы 100 секунд
+	err := l.take(ctx, 1000)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("take = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("take returned after %v", elapsed)
+	}
+}
+
+func TestNoRateLimiter(t *testing.T) {
+	if l := newRateLimiter(0); l != nil {
+		t.Errorf("newRateLimiter(0) = %v, want nil", l)
+	}
+}
+
+// filesSite - сайт из страницы со ссылками на files файлов по size байт
+func filesSite(t *testing.T, files int, size int) *httptest.Server {
+	body := bytes.Repeat([]byte("x"), size)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			w.Header().Set("Content-Type", "text/html")
+			for i := 0; i < files; i++ {
+				fmt.Fprintf(w, `<a href="/file%d.bin">%d</a>`, i, i)
+			}
+		case strings.HasPrefix(r.URL.Path, "/file"):
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			w.Write(body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// checkDuration проверяет, что elapsed близко к want: не меньше 90% и не
+// больше чем на половину и 300 мс сверху
+func checkDuration(t *testing.T, elapsed time.Duration, want time.Duration) {
+	t.Helper()
+	if elapsed < want*9/10 || elapsed > want*3/2+300*time.Millisecond {
+		t.Errorf("download took %v, want about %v", elapsed, want)
+	}
+}
+
+func TestLimitRateMirror(t *testing.T) {
+	// 2 MiB при 2 MiB/s - около секунды
+	const size = 2 << 20
+	srv := filesSite(t, 1, size)
+	dir := t.TempDir()
+
+	start := time.Now()
+	stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "--limit-rate", "2m", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDuration(t, time.Since(start), time.Second)
+	if stats.Assets != 1 {
+		t.Errorf("Assets = %d, want 1", stats.Assets)
+	}
+	if info, err := os.Stat(filepath.Join(hostDirOf(dir, srv), "file0.bin")); err != nil || info.Size() != size {
+		t.Errorf("file0.bin: %v, want %d bytes", err, size)
+	}
+}
+
+func TestLimitRateShared(t *testing.T) {
+	// Четыре файла по 512 KiB одновременно: лимит 2 MiB/s общий, и все
+	// вместе скачиваются около секунды, а не за четверть секунды, как
+	// было бы с лимитом на каждое соединение
+	const size = 512 << 10
+	srv := filesSite(t, 4, size)
+
+	start := time.Now()
+	stats, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-l", "1", "--concurrency", "4", "--limit-rate", "2m", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDuration(t, time.Since(start), time.Second)
+	if stats.Assets != 4 {
+		t.Errorf("Assets = %d, want 4", stats.Assets)
+	}
+}
+
+func TestNoLimitRateMirror(t *testing.T) {
+	srv := filesSite(t, 4, 512<<10)
+
+	start := time.Now()
+	if _, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-l", "1", "--concurrency", "4", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	// Без --limit-rate загрузка не ждет лимитера
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("unlimited download took %v", elapsed)
+	}
+}
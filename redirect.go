@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// markVisited отмечает URL посещенным. Возвращает false, если он уже был
+// отмечен раньше
+func (d *downloader) markVisited(rawURL string) bool {
+	d.visitedMutex.Lock()
+	defer d.visitedMutex.Unlock()
+
+	if d.visitedURLs[rawURL] {
+		return false
+	}
+	d.visitedURLs[rawURL] = true
+	return true
+}
+
+// addAlias запоминает, что from хранится на диске под именем to
+func (d *downloader) addAlias(from string, to string) {
+	if from == to {
+		return
+	}
+
+	d.visitedMutex.Lock()
+	defer d.visitedMutex.Unlock()
+
+	d.aliases[from] = to
+}
+
+// addNotSaved запоминает URL, который был в очереди, но не сохранен.
+// Ссылки на него переписываются до того, как это становится известно
+func (d *downloader) addNotSaved(rawURL string) {
+	d.visitedMutex.Lock()
+	defer d.visitedMutex.Unlock()
+
+	d.notSaved[rawURL] = true
+}
+
+// resolveAlias возвращает URL, под именем которого хранится rawURL
+func (d *downloader) resolveAlias(rawURL string) string {
+	d.visitedMutex.Lock()
+	defer d.visitedMutex.Unlock()
+
+	// Ограничиваем число шагов на случай цикла
+	for i := 0; i < 10; i++ {
+		to, ok := d.aliases[rawURL]
+		if !ok {
+			break
+		}
+		rawURL = to
+	}
+	return rawURL
+}
+
+// redirectTarget разбирается с редиректом запроса rawURL на finalURL:
+// отмечает конечный URL посещенным и выбирает единственный URL, по которому
+// определяется имя файла. Если конечный URL уже скачивается по другой
+// ссылке, возвращает false - сохранять ответ второй раз не нужно
+func (d *downloader) redirectTarget(rawURL string, finalURL *url.URL) (string, bool) {
+	normalized := *finalURL
+	d.normalizeURL(&normalized)
+	final := normalized.String()
+	if final == rawURL {
+		return rawURL, true
+	}
+
+	if !d.markVisited(final) {
+		d.addAlias(rawURL, final)
+		return final, false
+	}
+
+	// Файл с другого хоста всегда хранится в каталоге своего хоста
+	original, err := url.Parse(rawURL)
+	if d.trustServerNames || err != nil || hostKey(original) != hostKey(finalURL) {
+		d.addAlias(rawURL, final)
+		return final, true
+	}
+	d.addAlias(final, rawURL)
+	return rawURL, true
+}
+
+func (d *downloader) addSavedPage(savePath string) {
+	d.visitedMutex.Lock()
+	defer d.visitedMutex.Unlock()
+
+	d.savedPages = append(d.savedPages, savePath)
+}
+
+// retargetAliases исправляет ссылки, переписанные до того, как стало
+// известно о редиректе, о пропуске файла, о его переносе в одноименный
+// каталог или под имя из Content-Disposition: локальные пути к
+// файлам-псевдонимам заменяются путями к файлу, под которым содержимое
+// действительно сохранено, а пути к несохраненным файлам (например,
+// видео больше --max-file-size) - их исходными URL
+func (d *downloader) retargetAliases() {
+	d.visitedMutex.Lock()
+	aliasPaths := make(map[string]string)
+	for from, to := range d.aliases {
+		fromURL, err1 := url.Parse(from)
+		toURL, err2 := url.Parse(to)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if fromPath, toPath := d.getSavePath(fromURL), d.getSavePath(toURL); fromPath != toPath {
+			aliasPaths[fromPath] = toPath
+		}
+	}
+	remotePaths := make(map[string]*url.URL)
+	for rawURL := range d.notSaved {
+		if u, err := url.Parse(rawURL); err == nil {
+			remotePaths[d.getSavePath(u)] = u
+		}
+	}
+	pages := append([]string(nil), d.savedPages...)
+	d.visitedMutex.Unlock()
+
+	// Ссылки на файл, который потом перенесен внутрь одноименного каталога
+	d.namesMutex.Lock()
+	for from, to := range d.movedFiles {
+		aliasPaths[from] = to
+	}
+	d.namesMutex.Unlock()
+
+	// Файл, оставшийся от прошлого запуска, остается и целью ссылки
+	for path := range remotePaths {
+		if _, err := d.statSaved(path); err == nil {
+			delete(remotePaths, path)
+		}
+	}
+	if len(aliasPaths) == 0 && len(remotePaths) == 0 {
+		return
+	}
+
+	for _, page := range pages {
+		content, err := d.readSaved(page)
+		if err != nil {
+			continue
+		}
+		doc, err := html.Parse(bytes.NewReader(content))
+		if err != nil {
+			continue
+		}
+
+		changed := false
+		walkLinks(doc, func(_ *html.Node, attr *html.Attribute, _ resourceKind) {
+			ref, err := url.Parse(attr.Val)
+			if err != nil || ref.IsAbs() || ref.Host != "" {
+				return
+			}
+
+			target := filepath.Join(filepath.Dir(page), filepath.FromSlash(ref.Path))
+			if remote, ok := remotePaths[target]; ok {
+				absolute := *remote
+				absolute.Fragment = ref.Fragment
+				attr.Val = absolute.String()
+				changed = true
+				return
+			}
+			canonical, ok := aliasPaths[target]
+			if !ok {
+				return
+			}
+			relPath, err := filepath.Rel(filepath.Dir(page), canonical)
+			if err != nil {
+				return
+			}
+			attr.Val = localLink(relPath, ref.Fragment)
+			changed = true
+		})
+		if !changed {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := html.Render(&buf, doc); err != nil {
+			d.errorf(logEntry{event: "parse", err: err}, "Failed to render HTML: %v", err)
+			continue
+		}
+		if _, err := d.writeSaved(page, buf.Bytes()); err != nil {
+			d.errorf(logEntry{event: "save", err: err}, "Failed to save %q: %v", page, err)
+		}
+	}
+}
+
+// redirectError - отказ следовать по цепочке редиректов
+type redirectError struct {
+	reason string
+	chain  []string
+}
+
+func (e *redirectError) Error() string {
+	return fmt.Sprintf("%s: %s", e.reason, strings.Join(e.chain, " -> "))
+}
+
+// checkRedirect ограничивает длину цепочки редиректов и обрывает циклы
+func (d *downloader) checkRedirect(req *http.Request, via []*http.Request) error {
+	chain := make([]string, 0, len(via)+1)
+	for _, prev := range via {
+		chain = append(chain, prev.URL.String())
+	}
+	chain = append(chain, req.URL.String())
+
+	for _, prev := range via {
+		if prev.URL.String() == req.URL.String() {
+			return &redirectError{reason: "redirect loop", chain: chain}
+		}
+	}
+	// Редирект стартового хоста на вариант с www или без доказывает, что
+	// это один сайт: дальше оба хоста обходятся как стартовый
+	if prev := via[len(via)-1].URL; d.isStartHost(prev) && wwwVariant(prev, req.URL) {
+		d.addSiteHost(hostKey(req.URL), hostKey(prev))
+	}
+	if !d.allowRedirect(req.URL) {
+		return &redirectError{reason: "refusing cross-host redirect", chain: chain}
+	}
+	if len(via) > d.maxRedirects {
+		return &redirectError{reason: fmt.Sprintf("stopped after %d redirects", d.maxRedirects), chain: chain}
+	}
+
+	// net/http ставит в Referer предыдущий URL цепочки
+	if d.noReferer {
+		req.Header.Del("Referer")
+	}
+	// Учетные данные не должны уйти на другой хост вслед за редиректом
+	d.authorize(req)
+	return nil
+}
+
+// redirectChain восстанавливает цепочку редиректов, которая привела к req
+func redirectChain(req *http.Request) []string {
+	var chain []string
+	for r := req; r != nil; {
+		chain = append([]string{r.URL.String()}, chain...)
+		if r.Response == nil {
+			break
+		}
+		r = r.Response.Request
+	}
+	return chain
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNoRefererOnRedirect(t *testing.T) {
+	for _, noReferer := range []bool{false, true} {
+		var mu sync.Mutex
+		var referer string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/old":
+				http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			case "/new":
+				mu.Lock()
+				referer = r.Header.Get("Referer")
+				mu.Unlock()
+				w.Write([]byte("content"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		args := []string{"-e", "robots=off", "-l", "0"}
+		if noReferer {
+			args = append(args, "--no-referer")
+		}
+		if _, err := testMirror(t, t.TempDir(), append(args, srv.URL+"/old")...); err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+
+		mu.Lock()
+		got := referer
+		mu.Unlock()
+		if noReferer && got != "" {
+			t.Errorf("--no-referer: redirect target got Referer %q", got)
+		}
+		if !noReferer && got != srv.URL+"/old" {
+			t.Errorf("redirect target got Referer %q, want %q", got, srv.URL+"/old")
+		}
+	}
+}
+
+// redirectSite ссылается на /a, который через цепочку 301 ведет на /b, и
+// на сам /b
+func redirectSite() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/a">a</a><a href="/b">b</a>`))
+		case "/a":
+			http.Redirect(w, r, "/a2", http.StatusMovedPermanently)
+		case "/a2":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<p>target</p>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestRedirectTargetSavedOnce(t *testing.T) {
+	for _, trust := range []bool{false, true} {
+		srv := redirectSite()
+
+		dir := t.TempDir()
+		args := []string{"-e", "robots=off", "-l", "1"}
+		if trust {
+			args = append(args, "--trust-server-names")
+		}
+		if _, err := testMirror(t, dir, append(args, srv.URL+"/")...); err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+		host := hostDirOf(dir, srv)
+
+		// Какое из имен выбрано без --trust-server-names, зависит от того,
+		// какая ссылка скачана первой, но файл должен быть один
+		var saved []string
+		for _, name := range []string{"a.html", "a2.html", "b.html"} {
+			if _, err := os.Stat(filepath.Join(host, name)); err == nil {
+				saved = append(saved, name)
+			}
+		}
+		if len(saved) != 1 || (trust && saved[0] != "b.html") {
+			t.Fatalf("trust=%v: saved %q", trust, saved)
+		}
+		if got := readMirrorFile(t, host, saved[0]); !strings.Contains(got, "target") {
+			t.Errorf("trust=%v: %s = %q", trust, saved[0], got)
+		}
+		index := readMirrorFile(t, host, "index.html")
+		if strings.Count(index, `href="`+saved[0]+`"`) != 2 {
+			t.Errorf("trust=%v: links in index.html do not both point to %s:\n%s", trust, saved[0], index)
+		}
+	}
+}
+
+func TestRedirectLimits(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/self":
+			http.Redirect(w, r, "/self", http.StatusFound)
+		case r.URL.Path == "/ping":
+			http.Redirect(w, r, "/pong", http.StatusFound)
+		case r.URL.Path == "/pong":
+			http.Redirect(w, r, "/ping", http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, "/chain/"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/chain/"))
+			if n > 0 {
+				http.Redirect(w, r, fmt.Sprintf("/chain/%d", n-1), http.StatusFound)
+				return
+			}
+			w.Write([]byte("end"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	args := []string{"-P", dir, "--progress", "none", "--no-favicon", "--manifest", "none", "--log-format", "json",
+		"-e", "robots=off", "-l", "0", "--tries", "3", "--max-redirects", "3",
+		srv.URL + "/self", srv.URL + "/ping", srv.URL + "/chain/3", srv.URL + "/chain/13"}
+	opts, urls, err := parseArgs(args, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logBuf bytes.Buffer
+	if opts.logger, err = newLogger("json", &logBuf, levelError, nil); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDownloader(urls, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Превышение предела - ошибка загрузки, а не тихий пропуск
+	stats, err := d.Wait()
+	if err == nil || stats.Failed != 3 {
+		t.Errorf("Failed = %d, %v; want 3 failures", stats.Failed, err)
+	}
+	if got := readMirrorFile(t, hostDirOf(dir, srv), "chain/3.html"); got != "end" {
+		t.Errorf("chain/3.html = %q", got)
+	}
+
+	// Цикл обрывается сразу и не повторяется попытками --tries
+	mu.Lock()
+	if requests["/self"] != 1 || requests["/ping"] != 1 || requests["/pong"] != 1 {
+		t.Errorf("loop requests: /self %d, /ping %d, /pong %d; want 1 each", requests["/self"], requests["/ping"], requests["/pong"])
+	}
+	if requests["/chain/3"] != 1 || requests["/chain/10"] != 1 || requests["/chain/9"] != 0 {
+		t.Errorf("chain requests: %v", requests)
+	}
+	mu.Unlock()
+
+	for _, want := range []string{
+		"redirect loop: " + srv.URL + "/ping -> " + srv.URL + "/pong -> " + srv.URL + "/ping",
+		"redirect loop: " + srv.URL + "/self -> " + srv.URL + "/self",
+		"stopped after 3 redirects: " + srv.URL + "/chain/13 -> ",
+	} {
+		if !strings.Contains(logBuf.String(), want) {
+			t.Errorf("log has no %q:\n%s", want, logBuf.String())
+		}
+	}
+}
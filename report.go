@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// brokenLink - внутренняя ссылка со страницы source на недоступный target
+type brokenLink struct {
+	status int
+	target string
+	source string
+}
+
+// addReference запоминает ссылку со страницы source для отчета
+// --broken-links. Внешние ссылки не проверяются и не запоминаются
+func (d *downloader) addReference(source, target string) {
+	if d.brokenLinksFile == "" {
+		return
+	}
+	d.reportMutex.Lock()
+	defer d.reportMutex.Unlock()
+
+	sources := d.references[target]
+	if sources == nil {
+		sources = make(map[string]bool)
+		d.references[target] = sources
+	}
+	sources[source] = true
+}
+
+// recordStatus запоминает HTTP-статус неудачной загрузки
+func (d *downloader) recordStatus(rawURL string, err error) {
+	var statusErr *statusError
+	if d.brokenLinksFile == "" || !errors.As(err, &statusErr) {
+		return
+	}
+	d.reportMutex.Lock()
+	defer d.reportMutex.Unlock()
+	d.linkStatus[rawURL] = statusErr.status
+}
+
+// brokenStatus - статусы, при которых ссылка считается битой
+func brokenStatus(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone || status >= 500
+}
+
+// BrokenLinks возвращает битые ссылки, упорядоченные по цели и источнику
+func (d *downloader) BrokenLinks() []brokenLink {
+	d.reportMutex.Lock()
+	defer d.reportMutex.Unlock()
+
+	var links []brokenLink
+	for target, status := range d.linkStatus {
+		if !brokenStatus(status) {
+			continue
+		}
+		for source := range d.references[target] {
+			links = append(links, brokenLink{status: status, target: target, source: source})
+		}
+	}
+	sort.Slice(links, func(a, b int) bool {
+		if links[a].target != links[b].target {
+			return links[a].target < links[b].target
+		}
+		return links[a].source < links[b].source
+	})
+	return links
+}
+
+// writeBrokenLinks сохраняет отчет в filename ("-" - стандартный вывод)
+// в текстовом виде или, с format=csv, в CSV
+func writeBrokenLinks(filename string, format string, links []brokenLink) error {
+	var w io.Writer = os.Stdout
+	if filename != "-" {
+		f, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"status", "target", "source"})
+		for _, l := range links {
+			cw.Write([]string{strconv.Itoa(l.status), l.target, l.source})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	for _, l := range links {
+		if _, err := fmt.Fprintf(w, "%d %s (linked from %s)\n", l.status, l.target, l.source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
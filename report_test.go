@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBrokenLinks(t *testing.T) {
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/ok.html">ok</a><a href="/missing.html">missing</a>
+<a href="/private/missing.html">filtered</a><a href="` + other.URL + `/missing.html">external</a>
+<a href="/forbidden.html">forbidden</a><link rel="stylesheet" href="/broken.css">`))
+		case "/ok.html":
+			w.Write([]byte(`<a href="/missing.html#top">missing</a><a href="/gone.html">gone</a>`))
+		case "/gone.html":
+			http.Error(w, "gone", http.StatusGone)
+		case "/broken.css":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		case "/forbidden.html":
+			http.Error(w, "forbidden", http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	report := filepath.Join(t.TempDir(), "broken.csv")
+	d := testDownloader(t, "-e", "robots=off", "-l", "2", "--no-favicon", "--manifest", "none", "--tries", "1",
+		"--reject-regex", "/private/", "--broken-links", report, "--broken-links-format", "csv", srv.URL+"/")
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	// Отфильтрованные, внешние и запрещенные (403) ссылки битыми не считаются
+	want := []brokenLink{
+		{http.StatusInternalServerError, srv.URL + "/broken.css", srv.URL + "/"},
+		{http.StatusGone, srv.URL + "/gone.html", srv.URL + "/ok.html"},
+		{http.StatusNotFound, srv.URL + "/missing.html", srv.URL + "/"},
+		{http.StatusNotFound, srv.URL + "/missing.html", srv.URL + "/ok.html"},
+	}
+	links := d.BrokenLinks()
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("BrokenLinks() = %v\nwant %v", links, want)
+	}
+
+	if err := writeBrokenLinks(report, "csv", links); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCSV := "status,target,source\n" +
+		"500," + srv.URL + "/broken.css," + srv.URL + "/\n" +
+		"410," + srv.URL + "/gone.html," + srv.URL + "/ok.html\n" +
+		"404," + srv.URL + "/missing.html," + srv.URL + "/\n" +
+		"404," + srv.URL + "/missing.html," + srv.URL + "/ok.html\n"
+	if string(data) != wantCSV {
+		t.Errorf("CSV report:\n%s\nwant:\n%s", data, wantCSV)
+	}
+
+	text := filepath.Join(t.TempDir(), "broken.txt")
+	if err := writeBrokenLinks(text, "text", links[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(text); string(data) != "500 "+srv.URL+"/broken.css (linked from "+srv.URL+"/)\n" {
+		t.Errorf("text report = %q", data)
+	}
+}
+
+func TestBrokenLinksSingleRow(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/dangling.html">dangling</a>`))
+	}))
+	defer srv.Close()
+
+	d := testDownloader(t, "-e", "robots=off", "--no-favicon", "--tries", "1", "--broken-links", "-", srv.URL+"/")
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Wait(); err == nil {
+		t.Error("dangling link did not fail the run")
+	}
+	want := []brokenLink{{http.StatusNotFound, srv.URL + "/dangling.html", srv.URL + "/"}}
+	if links := d.BrokenLinks(); !reflect.DeepEqual(links, want) {
+		t.Errorf("BrokenLinks() = %v, want %v", links, want)
+	}
+}
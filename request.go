@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// productName - имя программы в User-Agent; под ним же мы ищем свою
+// группу правил в robots.txt
+const (
+	productName = "UNIXWget"
+	version     = "1.0.0"
+
+	defaultUserAgent = productName + "/" + version
+)
+
+// newRequest создает запрос и добавляет к нему общие для всех запросов
+// заголовки
+func (d *downloader) newRequest(method string, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(d.ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.authorize(req)
+
+	// Пустая строка полностью убирает User-Agent из запроса
+	req.Header.Set("User-Agent", d.userAgent)
+
+	// Указав Accept-Encoding сами, мы отключаем автоматическую распаковку
+	// gzip в транспорте и распаковываем ответы в decodeBody
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	for key, values := range d.header {
+		req.Header[key] = values
+	}
+	if d.hostHeader != "" {
+		req.Host = d.hostHeader
+	}
+	return req, nil
+}
+
+// parseHeaders разбирает значения --header вида "Name: value". Более
+// поздние значения заменяют ранние, пустое значение убирает заголовок,
+// а Host возвращается отдельно, так как задается полем запроса
+func parseHeaders(lines []string) (http.Header, string, error) {
+	header := make(http.Header)
+	host := ""
+
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, "", fmt.Errorf("invalid header %q: expected \"Name: value\"", line)
+		}
+		value = strings.TrimSpace(value)
+		name = textproto.CanonicalMIMEHeaderKey(name)
+
+		if name == "Host" {
+			host = value
+			continue
+		}
+		if value == "" {
+			header.Del(name)
+			continue
+		}
+		header.Set(name, value)
+	}
+	return header, host, nil
+}
+
+// authorize добавляет Basic-авторизацию. Учетные данные сайта из файла
+// настроек отправляются только на этот сайт, явно заданные - только на
+// стартовый хост, данные из .netrc - только на хост, для которого они
+// записаны
+func (d *downloader) authorize(req *http.Request) {
+	req.Header.Del("Authorization")
+
+	if s, ok := d.site(req.URL.Host); ok && (s.httpUser != "" || s.httpPassword != "") {
+		req.SetBasicAuth(s.httpUser, s.httpPassword)
+		return
+	}
+
+	if d.httpUser != "" || d.httpPassword != "" {
+		d.hostsMutex.Lock()
+		authHost := d.authHost
+		d.hostsMutex.Unlock()
+		if req.URL.Host == authHost {
+			req.SetBasicAuth(d.httpUser, d.httpPassword)
+		}
+		return
+	}
+
+	if e, ok := d.netrc.lookup(req.URL.Hostname()); ok {
+		req.SetBasicAuth(e.login, e.password)
+	}
+}
+
+// refererFor выбирает Referer для задания. Стартовые URL запрашиваются без
+// него, а адрес https-страницы не раскрывается при переходе на http
+func (d *downloader) refererFor(j job, target *url.URL) string {
+	if d.noReferer {
+		return ""
+	}
+	if d.referer != "" {
+		return d.referer
+	}
+	if j.referer == "" {
+		return ""
+	}
+	if strings.HasPrefix(j.referer, "https:") && target.Scheme != "https" {
+		return ""
+	}
+	return j.referer
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// headerRecorder - сервер, который запоминает заголовок name каждого
+// запроса по пути
+type headerRecorder struct {
+	*httptest.Server
+	mu     sync.Mutex
+	values map[string][]string
+}
+
+func newHeaderRecorder(t *testing.T, name string, handler http.HandlerFunc) *headerRecorder {
+	s := &headerRecorder{values: make(map[string][]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.values[r.URL.Path] = append(s.values[r.URL.Path], r.Header.Get(name))
+		s.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *headerRecorder) get(path string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[path]
+}
+
+func TestBasicAuth(t *testing.T) {
+	other := newHeaderRecorder(t, "Authorization", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("other"))
+	})
+	site := newHeaderRecorder(t, "Authorization", func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "s3cret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="staging"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, `<a href="/page.html">page</a><a href="%s/linked.txt">other</a><a href="/away">away</a>`, other.URL)
+		case "/away":
+			http.Redirect(w, r, other.URL+"/redirected.txt", http.StatusFound)
+		default:
+			w.Write([]byte("page"))
+		}
+	})
+	withUser := strings.Replace(site.URL, "http://", "http://user:s3cret@", 1)
+
+	for _, args := range [][]string{
+		{withUser + "/"},
+		{"--http-user", "user", "--http-password", "s3cret", site.URL + "/"},
+	} {
+		other.mu.Lock()
+		other.values = make(map[string][]string)
+		other.mu.Unlock()
+		dir := t.TempDir()
+		stats, err := testMirror(t, dir, append([]string{"-e", "robots=off", "-l", "1", "-H", "--cross-host-redirects", "follow"}, args...)...)
+		if err != nil {
+			t.Fatalf("%q: %v", args, err)
+		}
+		if stats.Failed != 0 {
+			t.Errorf("%q: Failed = %d", args, stats.Failed)
+		}
+		if got := readMirrorFile(t, hostDirOf(dir, site.Server), "page.html"); got != "page" {
+			t.Errorf("%q: page.html = %q", args, got)
+		}
+		for _, path := range []string{"/linked.txt", "/redirected.txt"} {
+			if got := other.get(path); len(got) != 1 || got[0] != "" {
+				t.Errorf("%q: other host got Authorization %q for %s", args, got, path)
+			}
+		}
+
+		// Пароль из URL не попадает ни в имена файлов, ни в их содержимое
+		filepath.WalkDir(dir, func(path string, e os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.Contains(path, "s3cret") {
+				t.Errorf("%q: password in file name %s", args, path)
+			}
+			if data, err := os.ReadFile(path); err == nil && strings.Contains(string(data), "s3cret") {
+				t.Errorf("%q: password in %s", args, path)
+			}
+			return nil
+		})
+	}
+}
+
+func TestBasicAuthRequired(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	stats, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-l", "0", srv.URL+"/")
+	if err == nil || stats.Failed != 1 {
+		t.Errorf("without credentials: Failed = %d, %v; want 1 failure", stats.Failed, err)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	header, host, err := parseHeaders([]string{
+		"X-Api-Key: first",
+		"accept: application/json",
+		"x-api-key:  second ",
+		"X-Drop: 1",
+		"X-Drop:",
+		"Host: origin.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Values("X-Api-Key"); len(got) != 1 || got[0] != "second" {
+		t.Errorf("X-Api-Key = %q, want [second]", got)
+	}
+	if got := header.Get("Accept"); got != "application/json" {
+		t.Errorf("Accept = %q", got)
+	}
+	if _, ok := header["X-Drop"]; ok {
+		t.Error("empty value did not remove X-Drop")
+	}
+	if _, ok := header["Host"]; ok || host != "origin.example.com" {
+		t.Errorf("Host = %q, header has Host: %v", host, ok)
+	}
+
+	for _, line := range []string{"no colon", ": value", "Bad Name: x"} {
+		if _, _, err := parseHeaders([]string{line}); err == nil {
+			t.Errorf("parseHeaders(%q) accepted", line)
+		}
+	}
+}
+
+func TestCustomHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var got []http.Header
+	var hosts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, r.Header.Clone())
+		hosts = append(hosts, r.Host)
+		mu.Unlock()
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/page.html">page</a>`))
+			return
+		}
+		w.Write([]byte("page"))
+	}))
+	defer srv.Close()
+
+	if _, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-l", "1",
+		"--header", "X-Api-Key: one",
+		"--header", "X-Forwarded-Proto: https",
+		"--header", "Accept: application/xhtml+xml;q=0.9, */*;q=0.1",
+		"--header", "X-Api-Key: two",
+		"--header", "Host: origin.example.com",
+		srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 {
+		t.Fatalf("%d requests, want 2", len(got))
+	}
+	for i, h := range got {
+		if v := h.Values("X-Api-Key"); len(v) != 1 || v[0] != "two" {
+			t.Errorf("request %d: X-Api-Key = %q, want [two]", i, v)
+		}
+		if v := h.Get("X-Forwarded-Proto"); v != "https" {
+			t.Errorf("request %d: X-Forwarded-Proto = %q", i, v)
+		}
+		if v := h.Get("Accept"); v != "application/xhtml+xml;q=0.9, */*;q=0.1" {
+			t.Errorf("request %d: Accept = %q", i, v)
+		}
+		if hosts[i] != "origin.example.com" {
+			t.Errorf("request %d: Host = %q", i, hosts[i])
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{defaultUserAgent}},
+		{[]string{"--user-agent", "Mozilla/5.0 (compatible; test)"}, []string{"Mozilla/5.0 (compatible; test)"}},
+		// Пустое значение убирает заголовок, а не шлет Go-http-client
+		{[]string{"--user-agent", ""}, nil},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		var agents [][]string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			agents = append(agents, r.Header.Values("User-Agent"))
+			mu.Unlock()
+			w.Write([]byte("ok"))
+		}))
+		_, err := testMirror(t, t.TempDir(), append(append([]string{"-l", "0"}, tt.args...), srv.URL+"/")...)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		// robots.txt запрашивается с тем же User-Agent
+		if len(agents) != 2 {
+			t.Errorf("%q: %d requests, want 2", tt.args, len(agents))
+		}
+		for _, got := range agents {
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("%q: User-Agent %q, want %q", tt.args, got, tt.want)
+			}
+		}
+		mu.Unlock()
+	}
+}
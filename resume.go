@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Незавершенные загрузки пишутся в <файл>.part, а валидаторы ответа
+// (ETag и Last-Modified) - в <файл>.part.meta, чтобы при повторном
+// запуске можно было запросить только остаток через Range/If-Range
+
+func partMetaPath(partPath string) string {
+	return partPath + ".meta"
+}
+
+func removePart(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partMetaPath(partPath))
+}
+
+func writePartMeta(partPath string, header http.Header) error {
+	var b strings.Builder
+	for _, key := range []string{"ETag", "Last-Modified"} {
+		if value := header.Get(key); value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", key, value)
+		}
+	}
+	return os.WriteFile(partMetaPath(partPath), []byte(b.String()), 0644)
+}
+
+func readPartMeta(partPath string) http.Header {
+	header := make(http.Header)
+
+	f, err := os.Open(partMetaPath(partPath))
+	if err != nil {
+		return header
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), ":"); ok {
+			header.Set(strings.TrimSpace(key), strings.TrimSpace(value))
+		}
+	}
+	return header
+}
+
+// resumeHeader готовит заголовки для продолжения загрузки из .part.
+// If-Range заставляет сервер вернуть весь файл, если он изменился
+func resumeHeader(partPath string) (http.Header, int64) {
+	info, err := os.Stat(partPath)
+	if err != nil || info.Size() == 0 {
+		return nil, 0
+	}
+
+	meta := readPartMeta(partPath)
+	header := make(http.Header)
+	header.Set("Range", fmt.Sprintf("bytes=%d-", info.Size()))
+
+	// Слабый ETag в If-Range использовать нельзя
+	if etag := meta.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("If-Range", etag)
+	} else if lastModified := meta.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Range", lastModified)
+	} else {
+		// Без валидатора нельзя убедиться, что файл не изменился
+		return nil, 0
+	}
+
+	return header, info.Size()
+}
+
+// fetchResumable запрашивает URL, продолжая загрузку из .part, если это
+// возможно, иначе с заголовками header. Возвращает смещение, с которого
+// начинается тело ответа: 0, если сервер прислал файл целиком
+func (d *downloader) fetchResumable(rawURL string, host string, partPath string, header http.Header) (*http.Response, int64, int, error) {
+	// При докачке условные заголовки -N неуместны: они могут вернуть 304
+	// для файла, который у нас есть лишь частично
+	resume, offset := resumeHeader(partPath)
+	if offset > 0 {
+		header = header.Clone()
+		header.Del("If-Modified-Since")
+		header.Del("If-None-Match")
+		for key, values := range resume {
+			header[key] = values
+		}
+		// Смещение в .part отсчитано в распакованных байтах, поэтому
+		// остаток запрашивается без сжатия
+		header.Set("Accept-Encoding", "identity")
+	}
+	resp, attempts, err := d.fetch(http.MethodGet, rawURL, host, header)
+
+	// 416 означает, что .part не соответствует файлу на сервере
+	var statusErr *statusError
+	if offset > 0 && errors.As(err, &statusErr) && statusErr.status == http.StatusRequestedRangeNotSatisfiable {
+		removePart(partPath)
+		var more int
+		header.Del("Range")
+		header.Del("If-Range")
+		resp, more, err = d.fetch(http.MethodGet, rawURL, host, header)
+		attempts += more
+		offset = 0
+	}
+	if err != nil {
+		return nil, 0, attempts, err
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp, 0, attempts, nil
+	}
+
+	start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+	if err != nil || start != offset {
+		resp.Body.Close()
+		d.release(host)
+		removePart(partPath)
+		return nil, 0, attempts, fmt.Errorf("unexpected Content-Range %q for resume from byte %d", resp.Header.Get("Content-Range"), offset)
+	}
+
+	d.verbosef(logEntry{event: "resume", url: rawURL, bytes: offset}, "Resuming %s from byte %d", rawURL, offset)
+	return resp, offset, attempts, nil
+}
+
+// contentRangeStart извлекает начало диапазона из "bytes 100-999/1000"
+func contentRangeStart(value string) (int64, error) {
+	rest, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	start, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid Content-Range %q", value)
+	}
+	return strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+}
+
+// writePart дописывает тело ответа в .part (при offset > 0) или
+// перезаписывает его целиком. При ошибке .part остается для докачки
+func writePart(partPath string, resp *http.Response, offset int64) (int64, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	} else if err := writePartMeta(partPath, resp.Header); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, resp.Body)
+	if err == nil {
+		err = checkLength(resp, n)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// checkLength сверяет число полученных байт с Content-Length: оборванный
+// ответ не должен занять место готового файла
+func checkLength(resp *http.Response, n int64) error {
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("got %d bytes of %d", n, resp.ContentLength)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resumeServer отдает /big.bin и запоминает заголовки Range запросов
+type resumeServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	ranges []string
+}
+
+func newResumeServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) *resumeServer {
+	s := &resumeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/big.bin" {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// writeTestPart кладет в зеркало недокачанный big.bin с валидатором etag
+func writeTestPart(t *testing.T, dir string, srv *httptest.Server, data []byte, etag string) {
+	t.Helper()
+	partPath := filepath.Join(hostDirOf(dir, srv), "big.bin.part")
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(partPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePartMeta(partPath, http.Header{"Etag": {etag}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkResumed(t *testing.T, dir string, srv *resumeServer, want []byte, wantRanges string) {
+	t.Helper()
+	got, err := os.ReadFile(filepath.Join(hostDirOf(dir, srv.Server), "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("big.bin differs from the served file (%d bytes, want %d)", len(got), len(want))
+	}
+	for _, name := range []string{"big.bin.part", "big.bin.part.meta"} {
+		if _, err := os.Stat(filepath.Join(hostDirOf(dir, srv.Server), name)); err == nil {
+			t.Errorf("%s left behind", name)
+		}
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if got := strings.Join(srv.ranges, ","); got != wantRanges {
+		t.Errorf("Range headers %q, want %q", got, wantRanges)
+	}
+}
+
+func TestResumePartial(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	serve := func(etag string) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", etag)
+			http.ServeContent(w, r, "big.bin", time.Time{}, bytes.NewReader(content))
+		}
+	}
+
+	t.Run("206", func(t *testing.T) {
+		srv := newResumeServer(t, serve(`"v1"`))
+		dir := t.TempDir()
+		writeTestPart(t, dir, srv.Server, content[:40000], `"v1"`)
+		if stats, err := testMirror(t, dir, "-l", "0", srv.URL+"/big.bin"); err != nil || stats.Failed != 0 {
+			t.Fatalf("Failed = %d, %v", stats.Failed, err)
+		}
+		checkResumed(t, dir, srv, content, "bytes=40000-")
+	})
+
+	// Файл на сервере изменился: If-Range возвращает его целиком
+	t.Run("changed", func(t *testing.T) {
+		srv := newResumeServer(t, serve(`"v2"`))
+		dir := t.TempDir()
+		writeTestPart(t, dir, srv.Server, bytes.Repeat([]byte("x"), 40000), `"v1"`)
+		if stats, err := testMirror(t, dir, "-l", "0", srv.URL+"/big.bin"); err != nil || stats.Failed != 0 {
+			t.Fatalf("Failed = %d, %v", stats.Failed, err)
+		}
+		checkResumed(t, dir, srv, content, "bytes=40000-")
+	})
+
+	// Сервер без поддержки Range отвечает 200: .part перезаписывается
+	t.Run("200", func(t *testing.T) {
+		srv := newResumeServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(content)
+		})
+		dir := t.TempDir()
+		writeTestPart(t, dir, srv.Server, content[:40000], `"v1"`)
+		if stats, err := testMirror(t, dir, "-l", "0", srv.URL+"/big.bin"); err != nil || stats.Failed != 0 {
+			t.Fatalf("Failed = %d, %v", stats.Failed, err)
+		}
+		checkResumed(t, dir, srv, content, "bytes=40000-")
+	})
+}
+
+func TestResumeAfterInterruptedRun(t *testing.T) {
+	content := bytes.Repeat([]byte("abcdefghij"), 10000)
+	var broken sync.Once
+	srv := newResumeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		first := false
+		broken.Do(func() { first = true })
+		if first {
+			// Первый ответ обрывается на середине
+			w.Header().Set("Content-Length", "100000")
+			w.Write(content[:30000])
+			return
+		}
+		http.ServeContent(w, r, "big.bin", time.Time{}, bytes.NewReader(content))
+	})
+
+	dir := t.TempDir()
+	stats, err := testMirror(t, dir, "-l", "0", "--tries", "1", srv.URL+"/big.bin")
+	if err == nil || stats.Failed != 1 {
+		t.Fatalf("first run: Failed = %d, %v; want 1 failure", stats.Failed, err)
+	}
+	part, err := os.ReadFile(filepath.Join(hostDirOf(dir, srv.Server), "big.bin.part"))
+	if err != nil || !bytes.Equal(part, content[:30000]) {
+		t.Fatalf("first run left %d bytes in .part: %v", len(part), err)
+	}
+
+	if stats, err := testMirror(t, dir, "-l", "0", srv.URL+"/big.bin"); err != nil || stats.Failed != 0 {
+		t.Fatalf("second run: Failed = %d, %v", stats.Failed, err)
+	}
+	checkResumed(t, dir, srv, content, ",bytes=30000-")
+}
+
+func TestContentRangeStart(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"bytes 100-999/1000", 100, true},
+		{"bytes 0-9/*", 0, true},
+		{"bytes */1000", 0, false},
+		{"items 1-2/3", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := contentRangeStart(tt.value)
+		if (err == nil) != tt.ok || (tt.ok && got != tt.want) {
+			t.Errorf("contentRangeStart(%q) = %d, %v; want %d, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
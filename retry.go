@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryPolicy задает повторы неудачных запросов с экспоненциальной паузой
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	jitter      float64 // доля паузы, которая случайно вычитается
+}
+
+// delay возвращает паузу перед повтором номер attempt (начиная с 1)
+func (p retryPolicy) delay(attempt int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < attempt && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if p.maxDelay > 0 && delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if p.jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.jitter * float64(delay))
+	}
+	return delay
+}
+
+// statusError - ответ сервера с кодом, отличным от 200
+type statusError struct {
+	status int
+}
+
+// statusOf возвращает HTTP-код из ошибки, если ошибка им вызвана
+func statusOf(err error) int {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status
+	}
+	return 0
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP %d %s", e.status, http.StatusText(e.status))
+}
+
+// successStatus сообщает, содержит ли ответ то, что мы запрашивали:
+// весь файл, его остаток или подтверждение, что файл не изменился
+func successStatus(status int) bool {
+	return status == http.StatusOK || status == http.StatusPartialContent || status == http.StatusNotModified
+}
+
+// retryableStatus сообщает, имеет ли смысл повторять запрос с таким ответом
+func retryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// fetch выполняет запрос с дополнительными заголовками и повторами. Каждая
+// попытка выдерживает паузу хоста и занимает слот семафора; при успехе
+// слот остается занятым и должен быть освобожден вызывающим через release,
+// при ошибке он уже освобожден
+func (d *downloader) fetch(method string, rawURL string, host string, header http.Header) (*http.Response, int, error) {
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		req, err := d.newRequest(method, rawURL)
+		if err != nil {
+			return nil, attempt, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		d.acquire(host)
+
+		d.debugf(logEntry{event: "request", url: rawURL}, "Request: %s %s%s", method, rawURL, formatHeader(req.Header))
+		resp, err := d.client.Do(req)
+		if err == nil {
+			d.debugf(logEntry{event: "response", url: rawURL, status: resp.StatusCode}, "Response: %s %s%s", resp.Proto, resp.Status, formatHeader(resp.Header))
+		}
+		if err == nil && successStatus(resp.StatusCode) {
+			resp.Body = d.watchBody(resp.Body)
+			return resp, attempt, nil
+		}
+
+		// Ошибки цепочки редиректов повтор не исправит
+		var redirectErr *redirectError
+		retry := !errors.As(err, &redirectErr)
+		var retryAfter time.Duration
+		if err == nil {
+			resp.Body.Close()
+			err = &statusError{status: resp.StatusCode}
+			retry = retryableStatus(resp.StatusCode)
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+				retryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			}
+		}
+		d.release(host)
+		lastErr = err
+
+		// После прерывания повторять уже нечего
+		if !retry || attempt >= d.retry.maxAttempts || d.ctx.Err() != nil {
+			return nil, attempt, lastErr
+		}
+
+		// Retry-After приостанавливает все запросы к хосту, а не только этот
+		if retryAfter > 0 {
+			if d.maxRetryAfter > 0 && retryAfter > d.maxRetryAfter {
+				retryAfter = d.maxRetryAfter
+			}
+			d.infof(logEntry{event: "retry", url: rawURL, status: statusOf(err), err: err}, "Attempt %d for %q failed: %v, server asked to retry in %v", attempt, rawURL, err, retryAfter)
+			d.pauseHost(host, retryAfter)
+			continue
+		}
+
+		delay := d.retry.delay(attempt)
+		d.infof(logEntry{event: "retry", url: rawURL, status: statusOf(err), err: err}, "Attempt %d for %q failed: %v, retrying in %v", attempt, rawURL, err, delay.Round(time.Millisecond))
+		if !d.sleep(delay) {
+			return nil, attempt, d.ctx.Err()
+		}
+	}
+}
+
+// sleep ждет delay и возвращает false, если обход прерван раньше
+func (d *downloader) sleep(delay time.Duration) bool {
+	if delay <= 0 {
+		return d.ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
+// parseRetryAfter разбирает заголовок Retry-After в обеих формах:
+// число секунд или HTTP-дата
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := t.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// downloadFailure - итоговая ошибка загрузки одного URL
+type downloadFailure struct {
+	url      string
+	attempts int
+	err      error
+}
+
+// fail логирует ошибку и сохраняет ее для итоговой сводки. Ошибки после
+// прерывания обхода - его следствие, а не сбои загрузки
+func (d *downloader) fail(rawURL string, attempts int, err error) {
+	d.emit(progressEvent{Event: "failed", URL: rawURL, Status: statusOf(err), Error: err.Error()})
+	if d.ctx.Err() != nil {
+		d.verbosef(logEntry{event: "interrupted", url: rawURL}, "Interrupted: %s", rawURL)
+		return
+	}
+	d.errorf(logEntry{event: "failed", url: rawURL, status: statusOf(err), err: err}, "Failed to download %q: %v", rawURL, err)
+	d.recordStatus(rawURL, err)
+
+	d.failuresMutex.Lock()
+	d.failures = append(d.failures, downloadFailure{url: rawURL, attempts: attempts, err: err})
+	d.failuresMutex.Unlock()
+}
+
+// Failures возвращает ошибки, накопленные за время работы
+func (d *downloader) Failures() []downloadFailure {
+	d.failuresMutex.Lock()
+	defer d.failuresMutex.Unlock()
+
+	return append([]downloadFailure(nil), d.failures...)
+}
+
+// failuresError - итог обхода, в котором ошибок больше, чем допускает
+// --tolerate-errors
+type failuresError struct {
+	count int
+}
+
+func (e *failuresError) Error() string {
+	return fmt.Sprintf("%d downloads failed", e.count)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"-5", 0, false},
+		{"", 0, false},
+		{"soon", 0, false},
+		{"Tue, 02 Jan 2024 03:04:35 GMT", 30 * time.Second, true},
+		{"Tue, 02 Jan 2024 03:00:00 GMT", 0, true},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		var requests, served atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/file.txt" {
+				http.NotFound(w, r)
+				return
+			}
+			if requests.Add(1) <= 2 {
+				// Сутки ограничиваются --max-retry-after
+				w.Header().Set("Retry-After", "86400")
+				w.WriteHeader(status)
+				return
+			}
+			served.Add(1)
+			w.Write([]byte("data"))
+		}))
+
+		dir := t.TempDir()
+		start := time.Now()
+		stats, err := testMirror(t, dir, "-l", "0", "--tries", "5", "--retry-delay", "10ms", "--max-retry-after", "200ms", srv.URL+"/file.txt")
+		elapsed := time.Since(start)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Failed != 0 || requests.Load() != 3 || served.Load() != 1 {
+			t.Errorf("%d: Failed = %d, %d requests, served %d times; want 0, 3, 1", status, stats.Failed, requests.Load(), served.Load())
+		}
+		if got := readMirrorFile(t, hostDirOf(dir, srv), "file.txt"); got != "data" {
+			t.Errorf("%d: file.txt = %q", status, got)
+		}
+		if elapsed < 400*time.Millisecond || elapsed > 5*time.Second {
+			t.Errorf("%d: took %v, want two pauses of 200ms", status, elapsed)
+		}
+	}
+}
+
+// failingSite - сайт, на котором стиль страницы всегда отвечает 500
+func failingSite(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<link rel="stylesheet" href="/broken.css"><img src="/ok.png">`))
+		case "/broken.css":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFailuresReported(t *testing.T) {
+	srv := failingSite(t)
+	d := testDownloader(t, "-e", "robots=off", "--no-favicon", "--manifest", "none", "--tries", "2", "--retry-delay", "1ms", srv.URL+"/")
+	if err := d.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := d.Wait()
+	var failed *failuresError
+	if !errors.As(err, &failed) || failed.count != 1 {
+		t.Fatalf("Wait error %v, want 1 failed download", err)
+	}
+	if stats.Failed != 1 || stats.Pages != 1 || stats.Assets != 1 {
+		t.Errorf("Failed = %d, Pages = %d, Assets = %d; want 1, 1, 1", stats.Failed, stats.Pages, stats.Assets)
+	}
+	failures := d.Failures()
+	if len(failures) != 1 {
+		t.Fatalf("%d failures, want 1", len(failures))
+	}
+	if f := failures[0]; f.url != srv.URL+"/broken.css" || f.attempts != 2 || statusOf(f.err) != http.StatusInternalServerError {
+		t.Errorf("failure %s after %d attempts: %v", f.url, f.attempts, f.err)
+	}
+
+	// --tolerate-errors оставляет прежнее снисходительное поведение
+	if _, err := testMirror(t, t.TempDir(), "-e", "robots=off", "--tries", "1", "--tolerate-errors", "1", srv.URL+"/"); err != nil {
+		t.Errorf("--tolerate-errors 1: %v", err)
+	}
+}
+
+func TestFailuresExitCode(t *testing.T) {
+	// В дочернем процессе теста выполняется сама программа
+	if args := os.Getenv("WEBMIRROR_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"webmirror"}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+
+	srv := failingSite(t)
+	tests := []struct {
+		args string
+		code int
+		want string
+	}{
+		{"", 1, "Download finished with errors: 1 downloads failed"},
+		{"--tolerate-errors 1", 0, "Download completed!"},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestFailuresExitCode$")
+		cmd.Env = append(os.Environ(), "WEBMIRROR_CONFIG=",
+			"WEBMIRROR_TEST_ARGS=-P "+t.TempDir()+" --progress none -e robots=off --tries 1 "+tt.args+" "+srv.URL+"/")
+		out, err := cmd.CombinedOutput()
+		code := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code || !strings.Contains(string(out), tt.want) {
+			t.Errorf("%q: exit code %d, want %d with %q:\n%s", tt.args, code, tt.code, tt.want, out)
+		}
+		if tt.code != 0 && !strings.Contains(string(out), srv.URL+"/broken.css (1 attempts)") {
+			t.Errorf("%q: summary does not list the failed URL:\n%s", tt.args, out)
+		}
+	}
+}
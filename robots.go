@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// robotsRule - одно правило Allow/Disallow из robots.txt
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+// robotsRules - правила robots.txt, относящиеся к нашему User-Agent
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsGroup - группа правил для набора User-Agent
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// parseRobots разбирает robots.txt и выбирает правила для agent. Имя
+// агента сравнивается с User-agent целиком и без учета регистра; группы
+// с точным совпадением важнее групп "*", а несколько групп для одного
+// агента объединяются
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Несколько User-agent подряд относятся к одной группе
+			if !inAgents {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, robotsProduct(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil || value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{
+				allow:   key == "allow",
+				length:  len(value),
+				pattern: compileRobotsPattern(value),
+			})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	agent = robotsProduct(agent)
+	matched, wildcard := &robotsRules{}, &robotsRules{}
+	found := false
+	for _, g := range groups {
+		switch {
+		case slices.Contains(g.agents, agent):
+			matched.merge(g)
+			found = true
+		case slices.Contains(g.agents, "*"):
+			wildcard.merge(g)
+		}
+	}
+	if !found {
+		return wildcard
+	}
+	return matched
+}
+
+// robotsProduct - имя агента из строки User-agent или из User-Agent
+// запроса: без версии и в нижнем регистре
+func robotsProduct(value string) string {
+	if i := strings.IndexAny(value, "/ \t"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.ToLower(value)
+}
+
+// merge добавляет к правилам правила группы g. Из нескольких Crawl-delay
+// действует наибольший
+func (r *robotsRules) merge(g *robotsGroup) {
+	r.rules = append(r.rules, g.rules...)
+	if g.crawlDelay > r.crawlDelay {
+		r.crawlDelay = g.crawlDelay
+	}
+}
+
+// compileRobotsPattern превращает шаблон пути с * и $ в регулярное выражение
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// allowed проверяет путь (с query) по правилам: побеждает самое длинное
+// совпадение, при равной длине - Allow
+func (r *robotsRules) allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+
+	best := -1
+	allow := true
+	for _, rule := range r.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > best || (rule.length == best && rule.allow) {
+			best = rule.length
+			allow = rule.allow
+		}
+	}
+	return allow
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		name    string
+		robots  string
+		path    string
+		allowed bool
+	}{
+		{"no rules", "", "/a", true},
+		{"prefix", "User-agent: *\nDisallow: /private", "/private/a.html", false},
+		{"prefix other path", "User-agent: *\nDisallow: /private", "/public/private", true},
+		{"empty disallow", "User-agent: *\nDisallow:", "/a", true},
+		{"wildcard", "User-agent: *\nDisallow: /*.php", "/dir/index.php?id=1", false},
+		{"wildcard no match", "User-agent: *\nDisallow: /*.php", "/dir/index.html", true},
+		{"wildcard in the middle", "User-agent: *\nDisallow: /a/*/edit", "/a/42/edit", false},
+		{"query", "User-agent: *\nDisallow: /*?sort=", "/list?sort=name", false},
+		{"end anchor", "User-agent: *\nDisallow: /*.pdf$", "/doc.pdf", false},
+		{"end anchor with query", "User-agent: *\nDisallow: /*.pdf$", "/doc.pdf?download=1", true},
+		{"end anchor longer path", "User-agent: *\nDisallow: /exact$", "/exact/more", true},
+		{"longest match allows", "User-agent: *\nDisallow: /docs\nAllow: /docs/public", "/docs/public/a.html", true},
+		{"longest match disallows", "User-agent: *\nAllow: /docs\nDisallow: /docs/private", "/docs/private/a.html", false},
+		{"tie goes to allow", "User-agent: *\nDisallow: /page\nAllow: /page", "/page", true},
+		{"tie goes to allow in any order", "User-agent: *\nAllow: /page\nDisallow: /page", "/page", true},
+		{"tie with wildcards", "User-agent: *\nDisallow: /*.html\nAllow: /a*.html", "/about.html", true},
+		{"comments", "User-agent: * # everyone\nDisallow: /tmp # scratch", "/tmp/a", false},
+		{"case-insensitive keys", "USER-AGENT: *\nDISALLOW: /tmp", "/tmp/a", false},
+
+		{"own group wins", "User-agent: *\nDisallow: /\n\nUser-agent: UNIXWget\nDisallow: /private", "/public", true},
+		{"own group applies", "User-agent: *\nDisallow: /\n\nUser-agent: UNIXWget\nDisallow: /private", "/private", false},
+		{"own group with empty disallow", "User-agent: *\nDisallow: /\n\nUser-agent: UNIXWget\nDisallow:", "/a", true},
+		{"agent case", "User-agent: *\nDisallow: /\n\nUser-agent: unixwget\nAllow: /", "/a", true},
+		{"agent with version", "User-agent: *\nDisallow: /\n\nUser-agent: UNIXWget/2.0\nAllow: /", "/a", true},
+		{"several agents in a group", "User-agent: otherbot\nUser-agent: UNIXWget\nDisallow: /a", "/a", false},
+		{"substring is not our agent", "User-agent: wget\nDisallow: /\n\nUser-agent: *\nDisallow: /private", "/public", true},
+		{"our name inside another agent", "User-agent: UNIXWgetPro\nDisallow: /\n\nUser-agent: *\nDisallow:", "/a", true},
+		{"other agents only", "User-agent: otherbot\nDisallow: /", "/a", true},
+
+		{"own groups merged", "User-agent: UNIXWget\nDisallow: /a\n\nUser-agent: *\nDisallow: /\n\nUser-agent: UNIXWget\nDisallow: /b", "/b/x", false},
+		{"own groups merged keep first", "User-agent: UNIXWget\nDisallow: /a\n\nUser-agent: *\nDisallow: /\n\nUser-agent: UNIXWget\nDisallow: /b", "/a/x", false},
+		{"own groups merged allow rest", "User-agent: UNIXWget\nDisallow: /a\n\nUser-agent: *\nDisallow: /\n\nUser-agent: UNIXWget\nDisallow: /b", "/c", true},
+		{"merged groups longest match", "User-agent: UNIXWget\nDisallow: /docs\n\nUser-agent: UNIXWget\nAllow: /docs/public", "/docs/public/a", true},
+		{"wildcard groups merged", "User-agent: *\nDisallow: /a\n\nUser-agent: otherbot\nDisallow: /\n\nUser-agent: *\nDisallow: /b", "/b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseRobots(strings.NewReader(tt.robots), productName)
+			if got := rules.allowed(tt.path); got != tt.allowed {
+				t.Errorf("allowed(%q) = %v, want %v\nrobots.txt:\n%s", tt.path, got, tt.allowed, tt.robots)
+			}
+		})
+	}
+}
+
+func TestParseRobotsCrawlDelay(t *testing.T) {
+	tests := []struct {
+		robots string
+		want   time.Duration
+	}{
+		{"User-agent: *\nCrawl-delay: 2", 2 * time.Second},
+		{"User-agent: *\nCrawl-delay: 0.5", 500 * time.Millisecond},
+		{"User-agent: *\nCrawl-delay: soon", 0},
+		{"User-agent: *\nCrawl-delay: 5\n\nUser-agent: UNIXWget\nDisallow: /a", 0},
+		{"User-agent: otherbot\nCrawl-delay: 5", 0},
+		{"User-agent: UNIXWget\nCrawl-delay: 1\n\nUser-agent: UNIXWget\nCrawl-delay: 3", 3 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := parseRobots(strings.NewReader(tt.robots), productName).crawlDelay; got != tt.want {
+			t.Errorf("crawl delay = %v, want %v\nrobots.txt:\n%s", got, tt.want, tt.robots)
+		}
+	}
+}
+
+// robotsSite - сайт с robots.txt, который запоминает время каждого запроса
+type robotsSite struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+	times    map[string]time.Time
+}
+
+func newRobotsSite(t *testing.T, robots string) *robotsSite {
+	s := &robotsSite{times: make(map[string]time.Time)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.Path)
+		s.times[r.URL.Path] = time.Now()
+		s.mu.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte(robots))
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/public.html">public</a> <a href="/private/secret.html">secret</a>`))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<p>page</p>`))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *robotsSite) requested(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, p := range s.requests {
+		if p == path {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRobotsMirror(t *testing.T) {
+	srv := newRobotsSite(t, "User-agent: *\nDisallow: /private/\n")
+	dir := t.TempDir()
+
+	stats, err := testMirror(t, dir, "-l", "1", "--concurrency", "4", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.requested("/robots.txt"); n != 1 {
+		t.Errorf("robots.txt requested %d times, want 1", n)
+	}
+	if n := srv.requested("/private/secret.html"); n != 0 {
+		t.Errorf("disallowed page requested %d times", n)
+	}
+	if n := srv.requested("/public.html"); n != 1 {
+		t.Errorf("allowed page requested %d times, want 1", n)
+	}
+	if stats.Pages != 2 {
+		t.Errorf("Pages = %d, want 2", stats.Pages)
+	}
+	// Ссылка на запрещенную страницу остается абсолютной
+	index := readMirrorFile(t, hostDirOf(dir, srv.Server), "index.html")
+	if !strings.Contains(index, `href="`+srv.URL+`/private/secret.html"`) {
+		t.Errorf("link to the disallowed page was not kept absolute:\n%s", index)
+	}
+	if !strings.Contains(index, `href="public.html"`) {
+		t.Errorf("link to the allowed page was not rewritten:\n%s", index)
+	}
+
+	// С robots=off robots.txt не запрашивается, а запреты не действуют
+	off := newRobotsSite(t, "User-agent: *\nDisallow: /private/\n")
+	if _, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-l", "1", off.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	if n := off.requested("/robots.txt"); n != 0 {
+		t.Errorf("robots=off: robots.txt requested %d times", n)
+	}
+	if n := off.requested("/private/secret.html"); n != 1 {
+		t.Errorf("robots=off: disallowed page requested %d times, want 1", n)
+	}
+}
+
+func TestRobotsFetchWaits(t *testing.T) {
+	const wait = 150 * time.Millisecond
+	srv := newRobotsSite(t, "User-agent: *\nDisallow:\n")
+
+	if _, err := testMirror(t, t.TempDir(), "-l", "0", "--wait", "150ms", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.requests) != 2 || srv.requests[0] != "/robots.txt" {
+		t.Fatalf("requests = %q, want robots.txt first", srv.requests)
+	}
+	// robots.txt - такой же запрос к хосту, как остальные, и --wait
+	// выдерживается и после него
+	if gap := srv.times["/"].Sub(srv.times["/robots.txt"]); gap < wait-5*time.Millisecond {
+		t.Errorf("page requested %v after robots.txt, want at least %v", gap, wait)
+	}
+}
+
+func TestRobotsCrawlDelayMirror(t *testing.T) {
+	srv := newRobotsSite(t, "User-agent: UNIXWget\nCrawl-delay: 0.2\n")
+
+	start := time.Now()
+	if _, err := testMirror(t, t.TempDir(), "-l", "1", "--concurrency", "4", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	// После robots.txt: стартовая страница и две ссылки, две паузы по 200 мс
+	if elapsed := time.Since(start); elapsed < 390*time.Millisecond {
+		t.Errorf("crawl took %v, want at least 400ms with Crawl-delay: 0.2", elapsed)
+	}
+}
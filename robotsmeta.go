@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// robotsDirectives - указания <meta name="robots"> и заголовка
+// X-Robots-Tag, которые касаются зеркала
+type robotsDirectives struct {
+	nofollow bool // не обходить ссылки страницы
+	noindex  bool // noindex или noarchive: страницу не хранить
+}
+
+// merge объединяет указания: действует самое строгое
+func (r robotsDirectives) merge(other robotsDirectives) robotsDirectives {
+	return robotsDirectives{
+		nofollow: r.nofollow || other.nofollow,
+		noindex:  r.noindex || other.noindex,
+	}
+}
+
+// parseRobotsDirectives разбирает список указаний через запятую
+// ("noindex, nofollow"). Указания в X-Robots-Tag бывают с именем робота
+// ("googlebot: nofollow"): чужие пропускаются
+func parseRobotsDirectives(value string) robotsDirectives {
+	var r robotsDirectives
+	agent := ""
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if name, rest, ok := strings.Cut(directive, ":"); ok && !isRobotsDirective(name) {
+			agent = strings.TrimSpace(name)
+			directive = strings.TrimSpace(rest)
+		}
+		if agent != "" && agent != strings.ToLower(productName) {
+			continue
+		}
+		switch directive {
+		case "nofollow":
+			r.nofollow = true
+		case "noindex", "noarchive":
+			r.noindex = true
+		case "none":
+			r.nofollow, r.noindex = true, true
+		}
+	}
+	return r
+}
+
+// isRobotsDirective отличает указание с параметром (unavailable_after:
+// дата) от имени робота перед указаниями
+func isRobotsDirective(name string) bool {
+	switch strings.TrimSpace(name) {
+	case "unavailable_after", "max-snippet", "max-image-preview", "max-video-preview":
+		return true
+	}
+	return false
+}
+
+// headerRobots собирает указания из всех заголовков X-Robots-Tag
+func headerRobots(header http.Header) robotsDirectives {
+	var r robotsDirectives
+	for _, value := range header.Values("X-Robots-Tag") {
+		r = r.merge(parseRobotsDirectives(value))
+	}
+	return r
+}
+
+// metaRobots собирает указания из <meta name="robots"> и <meta> с именем
+// зеркала
+func metaRobots(doc *html.Node) robotsDirectives {
+	var r robotsDirectives
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "meta" {
+			name := strings.ToLower(strings.TrimSpace(attrValue(n, "name")))
+			if name == "robots" || name == strings.ToLower(productName) {
+				r = r.merge(parseRobotsDirectives(attrValue(n, "content")))
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return r
+}
+
+// pageRobots - указания страницы из заголовков ответа и ее <meta>. Без
+// --respect-robots-meta их нет
+func (d *downloader) pageRobots(doc *html.Node, header http.Header) robotsDirectives {
+	if !d.respectRobotsMeta {
+		return robotsDirectives{}
+	}
+	return headerRobots(header).merge(metaRobots(doc))
+}
+
+// autoBoolFlag - флаг вкл/выкл, значение которого по умолчанию зависит от
+// другой настройки: пустая строка значит "не задан"
+type autoBoolFlag string
+
+func (f *autoBoolFlag) String() string {
+	return string(*f)
+}
+
+func (f *autoBoolFlag) Set(value string) error {
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*f = "off"
+	if on {
+		*f = "on"
+	}
+	return nil
+}
+
+func (f *autoBoolFlag) IsBoolFlag() bool {
+	return true
+}
+
+// respectRobotsMeta сообщает, учитываются ли <meta name="robots"> и
+// X-Robots-Tag. По умолчанию - вместе с robots.txt
+func (o *options) respectRobotsMeta() bool {
+	if o.robotsMeta == "" {
+		return o.robots
+	}
+	return o.robotsMeta == "on"
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestParseRobotsDirectives(t *testing.T) {
+	tests := []struct {
+		value string
+		want  robotsDirectives
+	}{
+		{"", robotsDirectives{}},
+		{"all", robotsDirectives{}},
+		{"index, follow", robotsDirectives{}},
+		{"nofollow", robotsDirectives{nofollow: true}},
+		{"noindex", robotsDirectives{noindex: true}},
+		{"noarchive", robotsDirectives{noindex: true}},
+		{"noindex, nofollow", robotsDirectives{nofollow: true, noindex: true}},
+		{"NOINDEX,NOFOLLOW", robotsDirectives{nofollow: true, noindex: true}},
+		{" noindex ,  nofollow ", robotsDirectives{nofollow: true, noindex: true}},
+		{"none", robotsDirectives{nofollow: true, noindex: true}},
+		{"nosnippet, max-snippet: 20", robotsDirectives{}},
+		{"unavailable_after: 2030-01-01, nofollow", robotsDirectives{nofollow: true}},
+
+		// Указания с именем робота: чужие пропускаются до конца значения
+		{"otherbot: nofollow", robotsDirectives{}},
+		{"otherbot: noindex, nofollow", robotsDirectives{}},
+		{"UNIXWget: nofollow", robotsDirectives{nofollow: true}},
+		{"unixwget: none", robotsDirectives{nofollow: true, noindex: true}},
+		{"otherbot: nofollow, unixwget: noindex", robotsDirectives{noindex: true}},
+		{"unixwget: noindex, otherbot: nofollow", robotsDirectives{noindex: true}},
+	}
+	for _, tt := range tests {
+		if got := parseRobotsDirectives(tt.value); got != tt.want {
+			t.Errorf("parseRobotsDirectives(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderRobots(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   robotsDirectives
+	}{
+		{"no header", nil, robotsDirectives{}},
+		{"one header", []string{"noindex, nofollow"}, robotsDirectives{nofollow: true, noindex: true}},
+		{"none", []string{"none"}, robotsDirectives{nofollow: true, noindex: true}},
+		{"other agent", []string{"otherbot: nofollow"}, robotsDirectives{}},
+		{"our agent", []string{"unixwget: nofollow"}, robotsDirectives{nofollow: true}},
+		// Повторные заголовки объединяются, а имя робота действует только
+		// в своем заголовке
+		{"repeated", []string{"noindex", "nofollow"}, robotsDirectives{nofollow: true, noindex: true}},
+		{"repeated with other agent", []string{"otherbot: noindex", "nofollow"}, robotsDirectives{nofollow: true}},
+		{"repeated other agents only", []string{"otherbot: nofollow", "anotherbot: noindex"}, robotsDirectives{}},
+		{"repeated our agent", []string{"otherbot: none", "unixwget: noindex"}, robotsDirectives{noindex: true}},
+	}
+	for _, tt := range tests {
+		header := make(http.Header)
+		for _, v := range tt.values {
+			header.Add("X-Robots-Tag", v)
+		}
+		if got := headerRobots(header); got != tt.want {
+			t.Errorf("%s: headerRobots(%q) = %+v, want %+v", tt.name, tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestMetaRobots(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want robotsDirectives
+	}{
+		{"no meta", `<title>t</title>`, robotsDirectives{}},
+		{"robots", `<meta name="robots" content="noindex, nofollow">`, robotsDirectives{nofollow: true, noindex: true}},
+		{"name case", `<meta name="ROBOTS" content="nofollow">`, robotsDirectives{nofollow: true}},
+		{"none", `<meta name="robots" content="none">`, robotsDirectives{nofollow: true, noindex: true}},
+		{"our name", `<meta name="unixwget" content="noindex">`, robotsDirectives{noindex: true}},
+		{"other robot", `<meta name="googlebot" content="noindex, nofollow">`, robotsDirectives{}},
+		{"other meta", `<meta name="description" content="nofollow">`, robotsDirectives{}},
+		{"several merged", `<meta name="robots" content="noindex"><meta name="unixwget" content="nofollow">`, robotsDirectives{nofollow: true, noindex: true}},
+		{"in body", `</head><body><meta name="robots" content="nofollow">`, robotsDirectives{nofollow: true}},
+	}
+	for _, tt := range tests {
+		doc, err := html.Parse(strings.NewReader("<html><head>" + tt.head + "</head><body></body></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := metaRobots(doc); got != tt.want {
+			t.Errorf("%s: metaRobots = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRobotsNofollowMirror(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<a href="/meta.html">meta</a> <a href="/header.html">header</a> <a href="/other.html">other</a>`))
+		case "/meta.html":
+			w.Write([]byte(`<html><head><meta name="robots" content="noindex, nofollow"></head>
+<body><img src="/meta.png"><a href="/from-meta.html">next</a></body></html>`))
+		case "/header.html":
+			w.Header().Add("X-Robots-Tag", "otherbot: noindex")
+			w.Header().Add("X-Robots-Tag", "nofollow")
+			w.Write([]byte(`<a href="/from-header.html">next</a>`))
+		case "/other.html":
+			// nofollow для другого робота зеркала не касается
+			w.Header().Set("X-Robots-Tag", "otherbot: nofollow")
+			w.Write([]byte(`<a href="/from-other.html">next</a>`))
+		case "/robots.txt":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte(`<p>` + r.URL.Path + `</p>`))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-l", "3", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range []string{"/from-meta.html", "/from-header.html"} {
+		if requested[path] != 0 {
+			t.Errorf("%s requested from a nofollow page", path)
+		}
+	}
+	// Ресурсы страницы nofollow скачиваются: они нужны, чтобы ее показать
+	if requested["/meta.png"] != 1 || requested["/from-other.html"] != 1 {
+		t.Errorf("requests %v, want meta.png and from-other.html", requested)
+	}
+
+	// Ссылки страницы nofollow остаются абсолютными
+	meta := readMirrorFile(t, hostDirOf(dir, srv), "meta.html")
+	if !strings.Contains(meta, `href="`+srv.URL+`/from-meta.html"`) || !strings.Contains(meta, `src="meta.png"`) {
+		t.Errorf("meta.html:\n%s", meta)
+	}
+
+	// Без --respect-robots-meta ссылки обходятся
+	clear(requested)
+	mu.Unlock()
+	_, err := testMirror(t, t.TempDir(), "-l", "3", "--respect-robots-meta=false", srv.URL+"/")
+	mu.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requested["/from-meta.html"] != 1 || requested["/from-header.html"] != 1 {
+		t.Errorf("--respect-robots-meta=false: requests %v, want the links of nofollow pages", requested)
+	}
+}
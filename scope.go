@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Политики для редиректов, уводящих за пределы зеркалируемого хоста
+const (
+	redirectRefuse  = "refuse"  // не следовать
+	redirectFollow  = "follow"  // сохранить цель, но не обходить ее ссылки
+	redirectRecurse = "recurse" // для хостов из списка - сохранить и обходить
+)
+
+func validRedirectPolicy(policy string) error {
+	switch policy {
+	case redirectRefuse, redirectFollow, redirectRecurse:
+		return nil
+	}
+	return fmt.Errorf("unknown cross-host redirect policy %q (want %s, %s or %s)", policy, redirectRefuse, redirectFollow, redirectRecurse)
+}
+
+// defaultPorts - порты, которые подразумеваются схемой и в URL не нужны
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// hostKey приводит хост URL к виду, в котором хосты сравниваются и
+// запрашиваются: имя в нижнем регистре и в punycode, без порта по
+// умолчанию для схемы (example.com:80 и example.com - один хост)
+func hostKey(u *url.URL) string {
+	host, port := asciiHost(u.Hostname()), explicitPort(u)
+	// Адрес IPv6 остается в скобках
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		return host + ":" + port
+	}
+	return host
+}
+
+// asciiHost переводит имя хоста в punycode (bücher.example ->
+// xn--bcher-kva.example). Уже закодированное имя и IP-адреса остаются
+// как есть, кроме регистра. Точка в конце полного имени (example.com.)
+// отбрасывается
+func asciiHost(host string) string {
+	host = strings.TrimSuffix(host, ".")
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil && ascii != "" {
+		return ascii
+	}
+	return strings.ToLower(host)
+}
+
+// explicitPort - порт URL, если он не подразумевается схемой
+func explicitPort(u *url.URL) string {
+	if port := u.Port(); port != defaultPorts[strings.ToLower(u.Scheme)] {
+		return port
+	}
+	return ""
+}
+
+// normalizeHost записывает в URL хост в виде hostKey
+func normalizeHost(u *url.URL) {
+	if u.Host != "" {
+		u.Host = hostKey(u)
+	}
+}
+
+// inScope сообщает, относится ли URL к зеркалируемым хостам
+func (d *downloader) inScope(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := asciiHost(u.Hostname())
+	if matchDomain(host, d.excludeDomains) {
+		return false
+	}
+	if d.isStartHost(u) {
+		return true
+	}
+	if d.includeSubdomains && d.sameSiteAsStart(host) {
+		return true
+	}
+	// С --span-hosts обходятся любые хосты, а --domains сужает их список
+	if d.spanHosts && (len(d.domains) == 0 || matchDomain(host, d.domains)) {
+		return true
+	}
+	return d.crossHostRedirects == redirectRecurse && d.redirectHosts[hostKey(u)]
+}
+
+// addSeed добавляет стартовый URL. Обход каждого стартового URL ограничен
+// его хостом, а с --no-parent - еще и его каталогом
+func (d *downloader) addSeed(u *url.URL) {
+	// Каталог стартового URL сравнивается с нормализованными ссылками:
+	// /%7Euser/./docs/ - это /~user/docs/
+	normalized := *u
+	d.normalizeURL(&normalized)
+
+	d.hostsMutex.Lock()
+	defer d.hostsMutex.Unlock()
+
+	d.seeds = append(d.seeds, u.String())
+	if d.authHost == "" {
+		d.authHost = u.Host
+	}
+	host := hostKey(u)
+	d.startHosts[host] = append(d.startHosts[host], parentDir(&normalized))
+
+	// С --treat-www-as-same вариант хоста с www или без него хранится в
+	// каталоге стартового хоста, если сам не указан стартовым
+	if d.treatWWWAsSame {
+		delete(d.wwwHosts, host)
+		if variant := wwwToggle(host); d.startHosts[variant] == nil {
+			d.wwwHosts[variant] = host
+		}
+	}
+}
+
+// wwwToggle добавляет к хосту www. или убирает его
+func wwwToggle(host string) string {
+	if strings.HasPrefix(host, "www.") {
+		return strings.TrimPrefix(host, "www.")
+	}
+	return "www." + host
+}
+
+// siteHost - хост URL в виде hostKey, а с --treat-www-as-same для
+// варианта стартового хоста с www или без - сам стартовый хост
+func (d *downloader) siteHost(u *url.URL) string {
+	host := hostKey(u)
+	if !d.treatWWWAsSame {
+		return host
+	}
+	d.hostsMutex.Lock()
+	defer d.hostsMutex.Unlock()
+	if start, ok := d.wwwHosts[host]; ok {
+		return start
+	}
+	return host
+}
+
+// Вид имени каталога хоста с международным именем (--idn-dirs)
+const (
+	idnDirsASCII   = "ascii"   // xn--bcher-kva.example
+	idnDirsUnicode = "unicode" // bücher.example
+)
+
+// hostDir - имя каталога, в котором хранятся файлы хоста URL
+func (d *downloader) hostDir(u *url.URL) string {
+	host := d.siteHost(u)
+	if d.idnDirs != idnDirsUnicode {
+		return host
+	}
+	name, port := host, ""
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		name, port = host[:i], host[i:]
+	}
+	if unicode, err := idna.Display.ToUnicode(name); err == nil {
+		name = unicode
+	}
+	return name + port
+}
+
+// normalizeSiteHost записывает в URL хост в виде siteHost
+func (d *downloader) normalizeSiteHost(u *url.URL) {
+	if u.Host != "" {
+		u.Host = d.siteHost(u)
+	}
+}
+
+// isStartHost сообщает, относится ли URL к одному из стартовых хостов или
+// к варианту стартового хоста с www или без, на который тот перенаправил
+func (d *downloader) isStartHost(u *url.URL) bool {
+	host := d.siteHost(u)
+	d.hostsMutex.Lock()
+	defer d.hostsMutex.Unlock()
+	_, ok := d.startHosts[host]
+	return ok
+}
+
+// addSiteHost запоминает хост, оказавшийся тем же сайтом, что и стартовый
+// хост from; ограничения --no-parent переносятся на него
+func (d *downloader) addSiteHost(host string, from string) {
+	d.hostsMutex.Lock()
+	defer d.hostsMutex.Unlock()
+	if _, ok := d.startHosts[host]; !ok {
+		d.startHosts[host] = d.startHosts[from]
+	}
+}
+
+// sameSiteAsStart проверяет, совпадает ли регистрируемый домен хоста с
+// доменом одного из стартовых хостов
+func (d *downloader) sameSiteAsStart(host string) bool {
+	d.hostsMutex.Lock()
+	defer d.hostsMutex.Unlock()
+	for start := range d.startHosts {
+		if sameSite(host, (&url.URL{Host: start}).Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// wwwVariant сообщает, отличаются ли хосты только префиксом www.
+func wwwVariant(a, b *url.URL) bool {
+	if explicitPort(a) != explicitPort(b) {
+		return false
+	}
+	x, y := strings.ToLower(a.Hostname()), strings.ToLower(b.Hostname())
+	return x != y && (x == "www."+y || y == "www."+x)
+}
+
+// sameSite сравнивает регистрируемые домены хостов (example.com для
+// www.example.com и static.example.com)
+func sameSite(a, b string) bool {
+	siteA, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(a))
+	if err != nil {
+		return false
+	}
+	siteB, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(b))
+	return err == nil && siteA == siteB
+}
+
+// matchDomain проверяет, совпадает ли хост с одним из доменов или является
+// его поддоменом
+func matchDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDomains приводит домены из флагов к виду для matchDomain
+func normalizeDomains(domains []string) []string {
+	var result []string
+	for _, domain := range domains {
+		if domain = strings.Trim(asciiHost(domain), "."); domain != "" {
+			result = append(result, domain)
+		}
+	}
+	return result
+}
+
+// parentDir возвращает каталог стартового URL для --no-parent. Последний
+// сегмент пути без завершающего слэша считается именем файла
+func parentDir(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	if strings.HasSuffix(p, "/") {
+		return p
+	}
+	dir := path.Dir(p)
+	if dir == "/" {
+		return dir
+	}
+	return dir + "/"
+}
+
+// belowParent проверяет ограничение --no-parent: страницы стартового хоста
+// должны лежать внутри каталога стартового URL. Ресурсы страниц вроде
+// общих стилей разрешены где угодно, как в wget
+func (d *downloader) belowParent(u *url.URL, kind resourceKind) bool {
+	if !d.noParent || kind == kindRequisite || !d.isStartHost(u) {
+		return true
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+
+	host := d.siteHost(u)
+	d.hostsMutex.Lock()
+	defer d.hostsMutex.Unlock()
+	for _, dir := range d.startHosts[host] {
+		if strings.HasPrefix(p, dir) || p+"/" == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Пределы, за которыми путь считается ловушкой для обхода
+const (
+	maxPathSegments   = 32
+	maxSegmentRepeats = 3
+	// Столько разных запросов к одному пути обходится по умолчанию
+	// (--max-query-variants): календарь ?month=...&year=... бесконечен
+	defaultQueryVariants = 1000
+)
+
+// pathTrap применяет looksLikeTrap, если проверка не отключена
+// --no-trap-detection
+func (d *downloader) pathTrap(u *url.URL) bool {
+	return !d.noTrapDetection && looksLikeTrap(u)
+}
+
+// addQueryVariant учитывает новый URL с запросом и сообщает, не превышен ли
+// --max-query-variants для его пути. Вызывается один раз на URL
+func (d *downloader) addQueryVariant(u *url.URL) bool {
+	if d.noTrapDetection || d.maxQueryVariants <= 0 || u.RawQuery == "" {
+		return true
+	}
+	key := u.Scheme + "://" + u.Host + u.EscapedPath()
+
+	d.visitedMutex.Lock()
+	defer d.visitedMutex.Unlock()
+	if d.queryVariants[key] >= d.maxQueryVariants {
+		return false
+	}
+	d.queryVariants[key]++
+	return true
+}
+
+// looksLikeTrap распознает пути, которые бесконечно растут за счет
+// относительных ссылок: слишком глубокие или с повторяющимися сегментами
+func looksLikeTrap(u *url.URL) bool {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) > maxPathSegments {
+		return true
+	}
+	counts := make(map[string]int, len(segments))
+	for _, s := range segments {
+		if s == "" {
+			continue
+		}
+		counts[s]++
+		if counts[s] >= maxSegmentRepeats {
+			return true
+		}
+	}
+	return false
+}
+
+// allowRedirect применяет политику к редиректу на хост вне зеркала
+func (d *downloader) allowRedirect(target *url.URL) bool {
+	if d.inScope(target) {
+		return true
+	}
+	return d.crossHostRedirects == redirectFollow
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNoParent(t *testing.T) {
+	tests := []struct {
+		seed string
+		url  string
+		kind resourceKind
+		want bool
+	}{
+		{"http://example.com/docs/", "http://example.com/docs/a.html", kindPage, true},
+		{"http://example.com/docs/", "http://example.com/docs", kindPage, true},
+		{"http://example.com/docs/", "http://example.com/other/a.html", kindPage, false},
+		{"http://example.com/docs/", "http://example.com/other/a.css", kindRequisite, true},
+		{"http://example.com/docs/index.html", "http://example.com/docs/b/c.html", kindPage, true},
+		{"http://example.com/docs/index.html", "http://example.com/", kindPage, false},
+		{"http://example.com/%7Euser/./docs/", "http://example.com/~user/docs/a.html", kindPage, true},
+		{"http://example.com/%7euser/docs/../docs/", "http://example.com/%7Euser/docs/a.html", kindPage, true},
+		{"http://example.com/%7Euser/./docs/", "http://example.com/~user/a.html", kindPage, false},
+		{"http://example.com/docs/", "http://other.org/a.html", kindPage, true},
+	}
+	for _, tt := range tests {
+		d := testDownloader(t, "--no-parent", tt.seed)
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.normalizeURL(u)
+		if got := d.belowParent(u, tt.kind); got != tt.want {
+			t.Errorf("seed %s: belowParent(%s) = %v, want %v", tt.seed, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestLooksLikeTrap(t *testing.T) {
+	deepPath := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, "/%d", i)
+		}
+		return b.String()
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", false},
+		{"/a/b/c", false},
+		{"/a/x/a/y", false},
+		{"/a/x/a/y/a", true},
+		{"/cal/2020/cal/2021/cal/2022", true},
+		{deepPath(32), false},
+		{deepPath(33), true},
+	}
+	for _, tt := range tests {
+		if got := looksLikeTrap(&url.URL{Path: tt.path}); got != tt.want {
+			t.Errorf("looksLikeTrap(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNoTrapDetection(t *testing.T) {
+	u := &url.URL{Scheme: "http", Host: "example.com", Path: "/a/x/a/y/a"}
+	if d := testDownloader(t, "http://example.com/"); !d.pathTrap(u) {
+		t.Errorf("pathTrap(%s) = false by default", u)
+	}
+	if d := testDownloader(t, "--no-trap-detection", "http://example.com/"); d.pathTrap(u) {
+		t.Errorf("pathTrap(%s) = true with --no-trap-detection", u)
+	}
+}
+
+func TestQueryVariants(t *testing.T) {
+	d := testDownloader(t, "--max-query-variants", "2", "http://example.com/")
+	for i, tt := range []struct {
+		url  string
+		want bool
+	}{
+		{"http://example.com/cal?m=1", true},
+		{"http://example.com/cal?m=2", true},
+		{"http://example.com/cal?m=3", false},
+		{"http://example.com/other?m=3", true},
+		{"http://example.com/cal", true},
+	} {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.addQueryVariant(u); got != tt.want {
+			t.Errorf("%d: addQueryVariant(%s) = %v, want %v", i, tt.url, got, tt.want)
+		}
+	}
+}
+
+// Бесконечный календарь обходится до --max-query-variants страниц, а без
+// защиты от ловушек - до предела глубины
+func TestCalendarTrap(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		month, _ := strconv.Atoi(r.URL.Query().Get("month"))
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="/calendar?month=%d&amp;year=2024">next</a>`, month+1)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		args  []string
+		pages int64
+	}{
+		{[]string{"-l", "-1", "--max-query-variants", "10"}, 11},
+		{[]string{"-l", "30", "--no-trap-detection"}, 31},
+	}
+	for _, tt := range tests {
+		args := append([]string{"-e", "robots=off"}, tt.args...)
+		stats, err := testMirror(t, t.TempDir(), append(args, srv.URL+"/")...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Pages != tt.pages {
+			t.Errorf("%q: saved %d pages, want %d", tt.args, stats.Pages, tt.pages)
+		}
+	}
+}
+
+// virtualHosts - HTTP-прокси, который сам отвечает за любой хост: так тест
+// обходит example.com:80 или bücher.example без DNS. Запоминает
+// запрошенные URL
+type virtualHosts struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+}
+
+func newVirtualHosts(t *testing.T, handler http.HandlerFunc) *virtualHosts {
+	v := &virtualHosts{}
+	v.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v.mu.Lock()
+		v.requests = append(v.requests, r.URL.String())
+		v.mu.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(v.Close)
+	return v
+}
+
+// requested возвращает запрошенные URL в порядке сортировки
+func (v *virtualHosts) requested() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	sorted := slices.Clone(v.requests)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func TestDefaultPortHosts(t *testing.T) {
+	v := newVirtualHosts(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="http://example.com/a.html">a</a>
+<a href="//EXAMPLE.com:80/b.html">b</a>
+<a href="/c.html">c</a>
+<a href="http://example.com:8080/other.html">other port</a>`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("page " + r.URL.Path))
+	})
+
+	dir := t.TempDir()
+	stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "--proxy", v.URL, "http://Example.COM:80/")
+	if err != nil || stats.Failed != 0 {
+		t.Fatalf("Failed = %d, %v", stats.Failed, err)
+	}
+	if got := strings.Join(v.requested(), " "); got != "http://example.com/ http://example.com/a.html http://example.com/b.html http://example.com/c.html" {
+		t.Errorf("requested %s", got)
+	}
+	if got := strings.Join(mirrorFiles(t, dir), " "); got != ".webmirror-index.json example.com/a.html example.com/b.html example.com/c.html example.com/index.html" {
+		t.Errorf("files: %s", got)
+	}
+	got := readMirrorFile(t, dir, "example.com/index.html")
+	for _, want := range []string{`href="a.html"`, `href="b.html"`, `href="c.html"`, `href="http://example.com:8080/other.html"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("index.html has no %s:\n%s", want, got)
+		}
+	}
+
+	// Порт, отличный от порта по умолчанию, - отдельный хост и каталог
+	dir = t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "-H", "--proxy", v.URL, "http://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMirrorFile(t, dir, "example.com:8080/other.html"); got != "page /other.html" {
+		t.Errorf("other.html = %q", got)
+	}
+}
+
+func TestTreatWWWAsSame(t *testing.T) {
+	v := newVirtualHosts(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="HTTP://EXAMPLE.COM/upper.html">upper</a>
+<a href="http://WWW.example.com/www.html#top">www</a>
+<img src="//Www.Example.Com/img/logo.png">
+<a href="http://www.other.org/page.html">other</a>`))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(r.URL.Host + r.URL.Path))
+		}
+	})
+
+	tests := []struct {
+		args      []string
+		start     string
+		requested string
+		files     string
+		links     []string
+	}{
+		{
+			// Без флага www.example.com - другой сайт, но регистр букв не важен
+			start:     "http://Example.com/",
+			requested: "http://example.com/ http://example.com/upper.html",
+			files:     "example.com/index.html example.com/upper.html",
+			links: []string{`href="upper.html"`, `href="http://www.example.com/www.html#top"`,
+				`src="http://www.example.com/img/logo.png"`, `href="http://www.other.org/page.html"`},
+		},
+		{
+			// Вариант хоста запрашивается у стартового хоста
+			args:      []string{"--treat-www-as-same"},
+			start:     "http://example.com/",
+			requested: "http://example.com/ http://example.com/img/logo.png http://example.com/upper.html http://example.com/www.html",
+			files:     "example.com/img/logo.png example.com/index.html example.com/upper.html example.com/www.html",
+			links:     []string{`href="upper.html"`, `href="www.html#top"`, `src="img/logo.png"`, `href="http://www.other.org/page.html"`},
+		},
+		{
+			// Каталог сайта - по стартовому хосту
+			args:      []string{"--treat-www-as-same"},
+			start:     "http://WWW.EXAMPLE.COM/",
+			requested: "http://www.example.com/ http://www.example.com/img/logo.png http://www.example.com/upper.html http://www.example.com/www.html",
+			files:     "www.example.com/img/logo.png www.example.com/index.html www.example.com/upper.html www.example.com/www.html",
+			links:     []string{`href="upper.html"`, `href="www.html#top"`, `src="img/logo.png"`},
+		},
+	}
+	for _, tt := range tests {
+		v.mu.Lock()
+		v.requests = nil
+		v.mu.Unlock()
+		dir := t.TempDir()
+		args := append([]string{"-e", "robots=off", "-l", "1", "-p", "--proxy", v.URL, "--manifest", "none"}, tt.args...)
+		if _, err := testMirror(t, dir, append(args, tt.start)...); err != nil {
+			t.Fatalf("%q: %v", tt.args, err)
+		}
+		if got := strings.Join(v.requested(), " "); got != tt.requested {
+			t.Errorf("%q %s: requested\n%s\nwant\n%s", tt.args, tt.start, got, tt.requested)
+		}
+		files := slices.DeleteFunc(mirrorFiles(t, dir), func(name string) bool { return strings.HasPrefix(name, ".") })
+		if got := strings.Join(files, " "); got != tt.files {
+			t.Errorf("%q %s: files\n%s\nwant\n%s", tt.args, tt.start, got, tt.files)
+		}
+		host, _, _ := strings.Cut(tt.files, "/")
+		index := readMirrorFile(t, dir, host+"/index.html")
+		for _, want := range tt.links {
+			if !strings.Contains(index, want) {
+				t.Errorf("%q %s: index.html has no %s:\n%s", tt.args, tt.start, want, index)
+			}
+		}
+	}
+}
+
+func TestIDNHosts(t *testing.T) {
+	v := newVirtualHosts(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="http://bücher.example/seite.html">unicode</a>
+<a href="http://BÜCHER.example/gross.html">upper case</a>
+<a href="http://xn--bcher-kva.example/encoded.html">punycode</a>
+<img src="//bücher.example/bild.png">
+<a href="http://straße.example/other.html">other</a>`))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(r.URL.Host + r.URL.Path))
+		}
+	})
+
+	const ascii = "xn--bcher-kva.example"
+	for _, tt := range []struct {
+		start string
+		args  []string
+		dir   string
+	}{
+		{"http://bücher.example/", nil, ascii},
+		// Уже закодированный стартовый URL
+		{"http://xn--bcher-kva.example/", nil, ascii},
+		{"http://bücher.example/", []string{"--idn-dirs", "unicode"}, "bücher.example"},
+		{"http://XN--BCHER-KVA.example/", []string{"--idn-dirs", "unicode"}, "bücher.example"},
+	} {
+		v.mu.Lock()
+		v.requests = nil
+		v.mu.Unlock()
+		dir := t.TempDir()
+		args := append([]string{"-e", "robots=off", "-l", "1", "-p", "--proxy", v.URL}, tt.args...)
+		stats, err := testMirror(t, dir, append(args, tt.start)...)
+		if err != nil || stats.Failed != 0 {
+			t.Fatalf("%s %q: Failed = %d, %v", tt.start, tt.args, stats.Failed, err)
+		}
+
+		want := []string{"/", "/bild.png", "/encoded.html", "/gross.html", "/seite.html"}
+		for i, path := range want {
+			want[i] = "http://" + ascii + path
+		}
+		if got := v.requested(); !slices.Equal(got, want) {
+			t.Errorf("%s %q: requested\n%q\nwant\n%q", tt.start, tt.args, got, want)
+		}
+		files := slices.DeleteFunc(mirrorFiles(t, dir), func(name string) bool { return strings.HasPrefix(name, ".") })
+		wantFiles := []string{"bild.png", "encoded.html", "gross.html", "index.html", "seite.html"}
+		for i, name := range wantFiles {
+			wantFiles[i] = tt.dir + "/" + name
+		}
+		if !slices.Equal(files, wantFiles) {
+			t.Errorf("%s %q: files %q, want %q", tt.start, tt.args, files, wantFiles)
+		}
+		if got := readMirrorFile(t, dir, tt.dir+"/seite.html"); got != ascii+"/seite.html" {
+			t.Errorf("%s %q: seite.html = %q", tt.start, tt.args, got)
+		}
+
+		index := readMirrorFile(t, dir, tt.dir+"/index.html")
+		for _, link := range []string{`href="seite.html"`, `href="gross.html"`, `href="encoded.html"`, `src="bild.png"`,
+			`href="http://xn--strae-oqa.example/other.html"`} {
+			if !strings.Contains(index, link) {
+				t.Errorf("%s %q: index.html has no %s:\n%s", tt.start, tt.args, link, index)
+			}
+		}
+	}
+
+	if _, _, err := parseArgs([]string{"--idn-dirs", "utf8", "http://example.com/"}, io.Discard, io.Discard); err == nil {
+		t.Error("--idn-dirs utf8 accepted")
+	}
+}
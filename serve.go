@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// serveShutdownTimeout - сколько ждать окончания начатых ответов при выходе
+const serveShutdownTimeout = 5 * time.Second
+
+// runServe выполняет "webmirror serve [dir]": раздает скачанное зеркало по
+// HTTP для просмотра. Возвращает код выхода
+func runServe(args []string, output io.Writer) int {
+	fs := flag.NewFlagSet("webmirror serve", flag.ContinueOnError)
+	fs.SetOutput(output)
+	port := fs.Int("port", 8080, "`port` to listen on (0 picks a free one)")
+	bind := fs.String("bind", "127.0.0.1", "`address` to listen on")
+	verbose := fs.Bool("v", false, "log every request")
+	logFormat := fs.String("log-format", "text", "log `format`: text, or json for one JSON object per event")
+	fs.Usage = func() {
+		fmt.Fprintln(output, "Usage: ./webmirror serve [options] [dir]")
+		fmt.Fprintln(output, "Serves a downloaded mirror over HTTP; dir is the download directory or one host directory in it (default downloads)")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+	level := levelInfo
+	if *verbose {
+		level = levelVerbose
+	}
+	logger, err := newLogger(*logFormat, output, level, nil)
+	if err != nil {
+		fmt.Fprintln(output, err)
+		return 2
+	}
+
+	dir := defaultOptions().downloadDir
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	root, err := serveRoot(dir)
+	if err != nil {
+		logEvent(logger, levelError, logEntry{event: "serve", err: err}, "Cannot serve %s: %v", dir, err)
+		return 1
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(*bind, strconv.Itoa(*port)))
+	if err != nil {
+		logEvent(logger, levelError, logEntry{event: "serve", err: err}, "Failed to listen: %v", err)
+		return 1
+	}
+	srv := &http.Server{
+		Handler:           &previewHandler{root: root, log: logger},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	addr := "http://" + ln.Addr().String() + "/"
+	logEvent(logger, levelInfo, logEntry{event: "serve", url: addr}, "Serving %s at %s (press Ctrl-C to stop)", root, addr)
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		logEvent(logger, levelError, logEntry{event: "serve", err: err}, "Server failed: %v", err)
+		return 1
+	}
+	logEvent(logger, levelInfo, logEntry{event: "serve"}, "Server stopped")
+	return 0
+}
+
+// serveRoot выбирает корень раздачи. Зеркало одного сайта раздается из
+// каталога его хоста, чтобы страницы открывались с /. Если хостов
+// несколько, раздается сам каталог загрузки: ссылки между хостами
+// в зеркале относительные и ведут через ../
+func serveRoot(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+		return dir, nil
+	}
+
+	var hosts []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			hosts = append(hosts, e.Name())
+		}
+	}
+	if len(hosts) == 1 {
+		return filepath.Join(dir, hosts[0]), nil
+	}
+	return dir, nil
+}
+
+// previewHandler отдает файлы зеркала. URL запроса переводится в имя файла
+// по тем же правилам, что и при сохранении (savedName): index.html для
+// каталогов, .html для путей без расширения и query в имени файла. URL
+// перед этим нормализуется, как в обходе с настройками по умолчанию:
+// %2F внутри сегмента остается частью имени, а параметры query
+// упорядочиваются. Если такого файла нет, путь берется как есть - так
+// открываются ссылки, уже переписанные на локальные имена
+type previewHandler struct {
+	root string
+	log  *slog.Logger // запросы пишутся на уровне -v
+}
+
+func (h *previewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logEvent(h.log, levelVerbose, logEntry{event: "request", url: r.URL.RequestURI()}, "%s %s", r.Method, r.URL.RequestURI())
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Clean от корня не дает выйти за пределы зеркала через ..
+	clean := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") && clean != "/" {
+		clean += "/"
+	}
+	// Имя файла строится по пути с экранированием, как при сохранении.
+	// Сегменты .. в нем тоже не поднимаются выше корня
+	u := &url.URL{Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	(&downloader{unicodeFileNames: defaultOptions().unicodeFileNames}).normalizeURL(u)
+
+	for _, name := range []string{savedName(u), strings.TrimPrefix(clean, "/")} {
+		if file := h.file(name); file != "" {
+			h.serveFile(w, r, file)
+			return
+		}
+	}
+
+	// /docs без слэша сохранен как docs/index.html, если ссылка вела на каталог
+	if !strings.HasSuffix(clean, "/") && h.file(strings.TrimPrefix(clean, "/")+"/index.html") != "" {
+		target := &url.URL{Path: clean + "/", RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// file возвращает путь к обычному файлу зеркала с именем name через /
+// или "", если такого файла нет
+func (h *previewHandler) file(name string) string {
+	if name == "" {
+		return ""
+	}
+	file := filepath.Join(h.root, filepath.FromSlash(name))
+	if info, err := os.Stat(file); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return file
+}
+
+func (h *previewHandler) serveFile(w http.ResponseWriter, r *http.Request, file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Тип определяется по расширению, а без него - по содержимому
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
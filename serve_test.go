@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestPreviewHandlerLog(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "index.html"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, level := range []struct {
+		name  string
+		level slog.Level
+		want  string
+	}{
+		{"default", levelInfo, ""},
+		{"verbose", levelVerbose, "request"},
+	} {
+		var buf bytes.Buffer
+		l, err := newLogger("json", &buf, level.level, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewServer(&previewHandler{root: root, log: l})
+		resp, err := http.Get(srv.URL + "/")
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hi" {
+			t.Errorf("%s: body %q", level.name, body)
+		}
+		if got := strings.Join(logEvents(t, buf.Bytes()), ","); got != level.want {
+			t.Errorf("%s: events %q, want %q", level.name, got, level.want)
+		}
+	}
+}
+
+func TestRunServeMissingDir(t *testing.T) {
+	var buf bytes.Buffer
+	if code := runServe([]string{"--log-format", "json", filepath.Join(t.TempDir(), "missing")}, &buf); code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+	if got := strings.Join(logEvents(t, buf.Bytes()), ","); got != "serve" {
+		t.Errorf("events %q, want serve", got)
+	}
+	if code := runServe([]string{"--log-format", "xml"}, io.Discard); code != 2 {
+		t.Errorf("bad --log-format: exit code %d, want 2", code)
+	}
+}
+
+func TestPreviewHandlerNames(t *testing.T) {
+	links := []string{"/about", "/docs/", "/list?page=2", "/list?page=3", "/a%20b.html", "/caf%C3%A9", "/find?q=a%2Fb&lang=ru", "/dir%2Fname"}
+	// marker - текст страницы: путь и параметры query в любом порядке
+	marker := func(uri string) string {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "<p>" + html.EscapeString(u.Path+" "+u.Query().Encode()) + "</p>"
+	}
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			for _, link := range links {
+				w.Write([]byte(`<a href="` + link + `">link</a>`))
+			}
+			return
+		}
+		w.Write([]byte(marker(r.URL.RequestURI())))
+	}))
+	defer origin.Close()
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", origin.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	root, err := serveRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := newLogger("text", io.Discard, levelInfo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&previewHandler{root: root, log: l})
+	defer srv.Close()
+
+	get := func(uri string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// Исходные URL сайта, с query и экранированием, открывают сохраненные
+	// для них файлы
+	for _, link := range links {
+		if status, body := get(link); status != http.StatusOK || !strings.Contains(body, marker(link)) {
+			t.Errorf("GET %s = %d %q, want the page saved for it", link, status, body)
+		}
+	}
+
+	// Ссылки сохраненной страницы уже переписаны на имена файлов и
+	// открываются как есть
+	status, index := get("/")
+	if status != http.StatusOK {
+		t.Fatalf("GET / = %d", status)
+	}
+	doc, err := html.Parse(strings.NewReader(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var local []string
+	walkLinks(doc, func(_ *html.Node, attr *html.Attribute, _ resourceKind) {
+		local = append(local, attr.Val)
+	})
+	if len(local) != len(links) {
+		t.Fatalf("links %q, want %d", local, len(links))
+	}
+	for i, ref := range local {
+		if strings.HasPrefix(ref, "http") {
+			t.Errorf("link %q was not rewritten", ref)
+			continue
+		}
+		if status, body := get("/" + ref); status != http.StatusOK || !strings.Contains(body, marker(links[i])) {
+			t.Errorf("GET /%s = %d %q, want the page saved for %s", ref, status, body, links[i])
+		}
+	}
+
+	// Каталог без слэша ведет на свой index.html, а выйти за корень нельзя
+	resp, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}).Get(srv.URL + "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/docs/" {
+		t.Errorf("GET /docs = %d to %q, want a redirect to /docs/", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if status, _ := get("/../../" + filepath.Base(dir) + "/" + indexFileName); status != http.StatusNotFound {
+		t.Errorf("GET outside the root = %d, want 404", status)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// maxCSSImportDepth ограничивает вложенность @import при встраивании,
+// в том числе на случай циклических импортов
+const maxCSSImportDepth = 5
+
+// errTooLargeToInline - ресурс больше --max-inline-size
+var errTooLargeToInline = errors.New("larger than --max-inline-size")
+
+// downloadSingleFile скачивает страницу для --single-file и сохраняет ее
+// в -O одним HTML-файлом: картинки и шрифты встраиваются как data: URI,
+// стили - элементами <style>, скрипты - в сами <script>. Ресурсы больше
+// --max-inline-size и те, что не удалось скачать, остаются абсолютными
+// ссылками
+func (d *downloader) downloadSingleFile(j job, u *url.URL) {
+	rawURL := j.url
+	d.verbosef(logEntry{event: "download", url: rawURL}, "Downloading: %s", rawURL)
+	start := time.Now()
+
+	header := make(http.Header)
+	if referer := d.refererFor(j, u); referer != "" {
+		header.Set("Referer", referer)
+	}
+
+	resp, attempts, err := d.fetch(http.MethodGet, rawURL, u.Host, header)
+	if err != nil {
+		d.fail(rawURL, attempts, err)
+		return
+	}
+	content, err := d.readBody(resp, 0)
+	resp.Body.Close()
+	// Слот хоста освобождается до загрузки ресурсов с того же хоста
+	d.release(u.Host)
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to read response body: %w", err))
+		return
+	}
+
+	if mediaType(resp.Header.Get("Content-Type")) == "text/html" {
+		in := &inliner{d: d, page: resp.Request.URL, cache: make(map[string]string)}
+		content = in.inlinePage(content)
+	}
+
+	n, err := writeDocument(d.outputDocument, bytes.NewReader(content))
+	d.addBytes(n)
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to write %s: %w", d.outputDocument, err))
+		return
+	}
+	d.saved(rawURL, resp.Request.URL.String(), resp.StatusCode, d.outputDocument, n, resp.Header, true)
+	d.infof(logEntry{event: "saved", url: rawURL, status: resp.StatusCode, bytes: n, duration: time.Since(start)}, "Saved %s to %s (%d bytes)", rawURL, d.outputDocument, n)
+}
+
+// readBody читает распакованное тело ответа целиком. Если limit больше
+// нуля, тело длиннее limit не читается и возвращается errTooLargeToInline
+func (d *downloader) readBody(resp *http.Response, limit int64) ([]byte, error) {
+	if limit > 0 && resp.ContentLength > limit {
+		return nil, errTooLargeToInline
+	}
+	if err := decodeBody(resp); err != nil {
+		return nil, err
+	}
+	body := d.limitBody(resp.Body)
+	if limit <= 0 {
+		return io.ReadAll(body)
+	}
+	content, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err == nil && int64(len(content)) > limit {
+		return nil, errTooLargeToInline
+	}
+	return content, err
+}
+
+// inliner встраивает ресурсы одной страницы. Каждый ресурс скачивается
+// один раз, даже если на него несколько ссылок
+type inliner struct {
+	d     *downloader
+	page  *url.URL
+	base  *url.URL          // <base href> страницы или page
+	cache map[string]string // URL -> data: URI, "" - не встраивается
+}
+
+func (in *inliner) inlinePage(content []byte) []byte {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		in.d.errorf(logEntry{event: "parse", url: in.page.String(), err: err}, "Failed to parse HTML: %v", err)
+		return content
+	}
+	// Ссылки становятся абсолютными, а <base> убирается: иначе якоря
+	// страницы (#top) вели бы на сайт
+	var baseNode *html.Node
+	in.base, baseNode = documentBase(doc, in.page)
+	in.walk(doc)
+	dropBase(baseNode)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		in.d.errorf(logEntry{event: "parse", url: in.page.String(), err: err}, "Failed to render HTML: %v", err)
+		return content
+	}
+	return buf.Bytes()
+}
+
+// walk обходит документ. Следующий узел запоминается заранее, потому что
+// <link> заменяется на <style>
+func (in *inliner) walk(n *html.Node) {
+	if n.Type == html.ElementNode {
+		in.inlineElement(n)
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		in.walk(c)
+		c = next
+	}
+}
+
+func (in *inliner) inlineElement(n *html.Node) {
+	switch n.Data {
+	case "img":
+		// srcset перекрыл бы встроенный src
+		if in.inlineAttr(n, "src") {
+			removeAttr(n, "srcset")
+		} else {
+			in.absolutizeSrcset(n)
+		}
+	case "source", "video", "audio", "track":
+		// Видео и звук не встраиваются: ссылки на них остаются на сайт
+		in.absolutize(n, "src")
+		in.absolutize(n, "poster")
+		in.absolutizeSrcset(n)
+	case "link":
+		switch {
+		case hasRel(n, "stylesheet"):
+			in.inlineStylesheet(n)
+		case isRequisiteLink(n):
+			in.inlineAttr(n, "href")
+		default:
+			in.absolutize(n, "href")
+		}
+	case "script":
+		in.inlineScript(n)
+	case "style":
+		if c := n.FirstChild; c != nil && c.Type == html.TextNode {
+			c.Data = in.inlineCSS(c.Data, in.base, 0)
+		}
+	case "a":
+		in.absolutize(n, "href")
+	case "iframe", "frame", "embed":
+		in.absolutize(n, "src")
+	case "object":
+		resolveCodebase(n)
+		in.absolutize(n, "data")
+	case "form":
+		in.absolutize(n, "action")
+	}
+
+	for i := range n.Attr {
+		if n.Attr[i].Key == "style" {
+			n.Attr[i].Val = in.inlineCSS(n.Attr[i].Val, in.base, 0)
+		}
+	}
+}
+
+// inlineAttr заменяет ссылку в атрибуте на data: URI. Возвращает false,
+// если ресурс не встроен и ссылка стала абсолютной
+func (in *inliner) inlineAttr(n *html.Node, key string) bool {
+	for i := range n.Attr {
+		if n.Attr[i].Key != key || n.Attr[i].Val == "" {
+			continue
+		}
+		ref, err := in.base.Parse(n.Attr[i].Val)
+		if err != nil {
+			return false
+		}
+		if data := in.dataURI(ref, 0); data != "" {
+			n.Attr[i].Val = data
+			return true
+		}
+		n.Attr[i].Val = ref.String()
+	}
+	return false
+}
+
+// absolutize делает ссылку абсолютной, чтобы она работала вне сайта
+func (in *inliner) absolutize(n *html.Node, key string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key != key || n.Attr[i].Val == "" || strings.HasPrefix(n.Attr[i].Val, "#") {
+			continue
+		}
+		if ref, err := in.base.Parse(n.Attr[i].Val); err == nil {
+			n.Attr[i].Val = ref.String()
+		}
+	}
+}
+
+// absolutizeSrcset делает абсолютными ссылки в srcset
+func (in *inliner) absolutizeSrcset(n *html.Node) {
+	for i := range n.Attr {
+		if n.Attr[i].Key != "srcset" {
+			continue
+		}
+		n.Attr[i].Val = rewriteSrcset(n.Attr[i].Val, func(ref string) string {
+			if u, err := in.base.Parse(ref); err == nil {
+				return u.String()
+			}
+			return ref
+		})
+	}
+}
+
+// inlineStylesheet заменяет <link rel="stylesheet"> на <style> с текстом
+// стиля, в котором ссылки тоже встроены. media сохраняется
+func (in *inliner) inlineStylesheet(n *html.Node) {
+	href := attrValue(n, "href")
+	ref, err := in.base.Parse(href)
+	if href == "" || err != nil {
+		return
+	}
+	css, _, err := in.fetch(ref)
+	if err != nil {
+		in.d.verbosef(logEntry{event: "inline", url: ref.String(), err: err}, "Not inlining %s: %v", ref, err)
+		in.absolutize(n, "href")
+		return
+	}
+
+	style := &html.Node{Type: html.ElementNode, Data: "style"}
+	if media := attrValue(n, "media"); media != "" {
+		style.Attr = []html.Attribute{{Key: "media", Val: media}}
+	}
+	text := in.inlineCSS(string(css), ref, 1)
+	style.AppendChild(&html.Node{Type: html.TextNode, Data: strings.ReplaceAll(text, "</style", `<\/style`)})
+	n.Parent.InsertBefore(style, n)
+	n.Parent.RemoveChild(n)
+}
+
+// inlineScript переносит текст внешнего скрипта внутрь <script>
+func (in *inliner) inlineScript(n *html.Node) {
+	src := attrValue(n, "src")
+	ref, err := in.base.Parse(src)
+	if src == "" || err != nil {
+		return
+	}
+	script, _, err := in.fetch(ref)
+	if err != nil {
+		in.d.verbosef(logEntry{event: "inline", url: ref.String(), err: err}, "Not inlining %s: %v", ref, err)
+		in.absolutize(n, "src")
+		return
+	}
+
+	removeAttr(n, "src")
+	for c := n.FirstChild; c != nil; c = n.FirstChild {
+		n.RemoveChild(c)
+	}
+	text := strings.ReplaceAll(string(script), "</script", `<\/script`)
+	n.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+}
+
+// inlineCSS встраивает ссылки из CSS, разрешая их относительно base
+func (in *inliner) inlineCSS(css string, base *url.URL, depth int) string {
+	return rewriteCSSURLs(css, func(raw string) string {
+		ref, err := base.Parse(raw)
+		if err != nil {
+			return raw
+		}
+		if data := in.dataURI(ref, depth); data != "" {
+			return data
+		}
+		return ref.String()
+	})
+}
+
+// dataURI скачивает ресурс и возвращает его как data: URI или "", если
+// ресурс не встраивается. Стили из url() и @import встраиваются вместе
+// со своими ссылками
+func (in *inliner) dataURI(ref *url.URL, depth int) string {
+	ref.Fragment = ""
+	key := ref.String()
+	if data, ok := in.cache[key]; ok {
+		return data
+	}
+	in.cache[key] = ""
+	if ref.Scheme != "http" && ref.Scheme != "https" {
+		return ""
+	}
+
+	content, contentType, err := in.fetch(ref)
+	if err != nil {
+		in.d.verbosef(logEntry{event: "inline", url: key, err: err}, "Not inlining %s: %v", key, err)
+		return ""
+	}
+	if contentType == "text/css" {
+		if depth >= maxCSSImportDepth {
+			return ""
+		}
+		content = []byte(in.inlineCSS(string(content), ref, depth+1))
+	}
+
+	data := "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(content)
+	in.cache[key] = data
+	return data
+}
+
+func (in *inliner) fetch(ref *url.URL) ([]byte, string, error) {
+	content, contentType, err := in.d.fetchResource(ref, in.page, in.d.maxInlineSize)
+	if err == nil {
+		in.d.verbosef(logEntry{event: "inline", url: ref.String(), bytes: int64(len(content))}, "Inlined %s (%d bytes)", ref, len(content))
+	}
+	return content, contentType, err
+}
+
+// fetchResource скачивает ресурс страницы page (не больше limit байт,
+// если limit больше нуля) и возвращает его с типом содержимого
+func (d *downloader) fetchResource(ref *url.URL, page *url.URL, limit int64) ([]byte, string, error) {
+	header := make(http.Header)
+	if !d.noReferer {
+		header.Set("Referer", page.String())
+	}
+
+	resp, _, err := d.fetch(http.MethodGet, ref.String(), ref.Host, header)
+	if err != nil {
+		return nil, "", err
+	}
+	defer d.release(ref.Host)
+	defer resp.Body.Close()
+
+	content, err := d.readBody(resp, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	// Без внятного Content-Type тип берется по расширению, а если его
+	// нет - по содержимому
+	contentType := mediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = mediaType(mime.TypeByExtension(path.Ext(ref.Path)))
+		if contentType == "" {
+			contentType = mediaType(http.DetectContentType(content))
+		}
+	}
+	d.addBytes(int64(len(content)))
+	return content, contentType, nil
+}
+
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func removeAttr(n *html.Node, key string) {
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		if attr.Key != key {
+			attrs = append(attrs, attr)
+		}
+	}
+	n.Attr = attrs
+}
+
+// hasRel проверяет, есть ли у <link> значение rel
+func hasRel(n *html.Node, rel string) bool {
+	for _, value := range strings.Fields(strings.ToLower(attrValue(n, "rel"))) {
+		if value == rel {
+			return true
+		}
+	}
+	return false
+}
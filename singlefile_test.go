@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// singleFileSite - страница со стилем, цепочкой @import, картинками,
+// шрифтом, скриптом и иконкой. Она же проверяется и в MHTML
+func singleFileSite(t *testing.T) *httptest.Server {
+	files := map[string]struct{ contentType, body string }{
+		"/": {"text/html", `<html><head>
+<link rel="stylesheet" href="/css/main.css" media="screen">
+<link rel="icon" href="/favicon.ico">
+<script src="/app.js"></script>
+<style>.hero { background: url("img/hero.png") }</style>
+</head><body>
+<img src="img/a.png" srcset="img/a.png 1x, img/b.png 2x">
+<div style="background-image: url('/img/bg.png')">text</div>
+<a href="/other.html">other</a> <a href="#top">top</a>
+<p>Страница с картинками, стилями, шрифтом и скриптом</p>
+</body></html>`},
+		"/css/main.css":       {"text/css", `@import url("fonts.css"); body { background: url(../img/bg.png) }`},
+		"/css/fonts.css":      {"text/css", `@import "deep/icons.css"; @font-face { font-family: f; src: url(/font.woff2) format("woff2") }`},
+		"/css/deep/icons.css": {"text/css", `.icon { background: url(../../img/dot.gif) }`},
+		"/app.js":             {"application/javascript", `console.log("</script>")`},
+		"/favicon.ico":        {"image/x-icon", "ICO"},
+		"/font.woff2":         {"font/woff2", "wOF2"},
+		"/img/a.png":          {"image/png", "\x89PNG a"},
+		"/img/b.png":          {"image/png", "\x89PNG b"},
+		"/img/bg.png":         {"image/png", "\x89PNG bg"},
+		"/img/hero.png":       {"image/png", "\x89PNG hero"},
+		"/img/dot.gif":        {"image/gif", "GIF89a"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", f.contentType)
+		w.Write([]byte(f.body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var (
+	// dataURIPattern находит data: URI и их типы
+	dataURIPattern = regexp.MustCompile(`data:([a-z0-9./+-]+);base64,`)
+	// dataCSSPattern находит встроенные стили
+	dataCSSPattern = regexp.MustCompile(`data:text/css;base64,([A-Za-z0-9+/=]+)`)
+)
+
+// countDataURIs считает data: URI в s по типам
+func countDataURIs(s string, types map[string]int) {
+	for _, m := range dataURIPattern.FindAllStringSubmatch(s, -1) {
+		types[m[1]]++
+	}
+}
+
+// checkInlinedCSS проверяет, что в CSS не осталось ссылок, кроме data:
+// URI, и рекурсивно проверяет встроенные @import. Типы встроенных
+// ресурсов считаются в types
+func checkInlinedCSS(t *testing.T, css string, types map[string]int) {
+	t.Helper()
+	rewriteCSSURLs(css, func(ref string) string {
+		t.Errorf("CSS still links %q:\n%s", ref, css)
+		return ref
+	})
+	countDataURIs(css, types)
+	for _, m := range dataCSSPattern.FindAllStringSubmatch(css, -1) {
+		data, err := base64.StdEncoding.DecodeString(m[1])
+		if err != nil {
+			t.Fatalf("bad base64 in data:text/css: %v", err)
+		}
+		checkInlinedCSS(t, string(data), types)
+	}
+}
+
+func TestSingleFile(t *testing.T) {
+	srv := singleFileSite(t)
+	out := filepath.Join(t.TempDir(), "page.html")
+	if _, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-O", out, "--single-file", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := html.Parse(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Во всем документе нет ссылок, по которым браузер стал бы что-то
+	// загружать: картинки, иконка и шрифты - data: URI, стили - <style>,
+	// скрипты - внутри <script>. Навигация по <a> остается абсолютной
+	types := make(map[string]int)
+	styles := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, attr := range n.Attr {
+				switch {
+				case attr.Key == "style":
+					checkInlinedCSS(t, attr.Val, types)
+				case attr.Key == "srcset":
+					t.Errorf("<%s> keeps srcset %q", n.Data, attr.Val)
+				case attr.Key == "src" || (attr.Key == "href" && n.Data != "a"):
+					if !strings.HasPrefix(attr.Val, "data:") {
+						t.Errorf("<%s %s=%q> is not inlined", n.Data, attr.Key, attr.Val)
+					}
+					countDataURIs(attr.Val, types)
+				}
+			}
+			switch n.Data {
+			case "style":
+				styles++
+				if c := n.FirstChild; c != nil {
+					checkInlinedCSS(t, c.Data, types)
+				}
+			case "script":
+				if c := n.FirstChild; c == nil || !strings.Contains(c.Data, "console.log") {
+					t.Errorf("script not inlined")
+				}
+			case "a":
+				if href := attrValue(n, "href"); href != srv.URL+"/other.html" && href != "#top" {
+					t.Errorf("<a href=%q>, want an absolute link or the fragment", href)
+				}
+			case "base":
+				t.Error("<base> left in the document")
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	// Стиль со своим media и стиль страницы
+	if styles != 2 {
+		t.Errorf("%d <style> elements, want 2", styles)
+	}
+	if !strings.Contains(string(data), `<style media="screen">`) {
+		t.Error("media of the inlined stylesheet was lost")
+	}
+	// Цепочка main.css -> fonts.css -> deep/icons.css встроена целиком
+	want := map[string]int{"text/css": 2, "image/png": 4, "font/woff2": 1, "image/gif": 1, "image/x-icon": 1}
+	for contentType, n := range want {
+		if types[contentType] < n {
+			t.Errorf("%d data: URIs of type %s, want at least %d (all: %v)", types[contentType], contentType, n, types)
+		}
+	}
+}
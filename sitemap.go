@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sitemapAuto - значение --sitemap без URL: sitemap.xml в корне каждого
+// стартового хоста
+const sitemapAuto = "auto"
+
+// maxSitemapDepth ограничивает вложенность индексов sitemap. По стандарту
+// индекс ссылается только на обычные sitemap, но встречается и иначе
+const maxSitemapDepth = 3
+
+// maxSitemapSize - предел sitemap после распаковки, как в протоколе
+// sitemaps.org. Защищает от gzip-бомб
+const maxSitemapSize = 50 << 20
+
+// sitemapFlag - флаг --sitemap: без значения включает sitemapAuto, со
+// значением задает URL sitemap или индекса sitemap
+type sitemapFlag string
+
+func (s *sitemapFlag) String() string {
+	return string(*s)
+}
+
+func (s *sitemapFlag) Set(value string) error {
+	if on, err := strconv.ParseBool(value); err == nil {
+		*s = ""
+		if on {
+			*s = sitemapAuto
+		}
+		return nil
+	}
+	if value != sitemapAuto {
+		if _, err := parseStartURL(value); err != nil {
+			return err
+		}
+	}
+	*s = sitemapFlag(value)
+	return nil
+}
+
+func (s *sitemapFlag) IsBoolFlag() bool {
+	return true
+}
+
+// sitemapEntry - <url> из sitemap или <sitemap> из индекса
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod"`
+}
+
+// sitemapTimeLayouts - форматы <lastmod> (W3C Datetime)
+var sitemapTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseLastmod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range sitemapTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// seedSitemaps ставит в очередь страницы из sitemap на глубине 0. Sitemap
+// читаются в фоне, а очередь до конца чтения удерживается открытой
+func (d *downloader) seedSitemaps() {
+	var sitemaps []string
+	if d.sitemap == sitemapAuto {
+		seen := make(map[string]bool)
+		for _, seed := range d.seeds {
+			u, err := url.Parse(seed)
+			if err != nil {
+				continue
+			}
+			sitemapURL := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/sitemap.xml"}).String()
+			if !seen[sitemapURL] {
+				seen[sitemapURL] = true
+				sitemaps = append(sitemaps, sitemapURL)
+			}
+		}
+	} else {
+		sitemaps = []string{d.sitemap}
+	}
+
+	d.queue.hold()
+	go func() {
+		defer d.queue.done()
+		seen := make(map[string]bool)
+		for _, sitemapURL := range sitemaps {
+			d.readSitemap(sitemapURL, 0, seen)
+		}
+	}()
+}
+
+// readSitemap скачивает sitemap и ставит в очередь его страницы, а для
+// индекса sitemap - читает вложенные sitemap
+func (d *downloader) readSitemap(rawURL string, depth int, seen map[string]bool) {
+	if seen[rawURL] || depth > maxSitemapDepth || d.ctx.Err() != nil {
+		return
+	}
+	seen[rawURL] = true
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		d.errorf(logEntry{event: "sitemap", url: rawURL, err: err}, "Invalid sitemap URL %q: %v", rawURL, err)
+		return
+	}
+	resp, _, err := d.fetch(http.MethodGet, rawURL, u.Host, make(http.Header))
+	if err != nil {
+		// Без явного --sitemap отсутствие sitemap.xml - не ошибка
+		if d.sitemap == sitemapAuto && statusOf(err) == http.StatusNotFound {
+			d.verbosef(logEntry{event: "sitemap", url: rawURL, status: http.StatusNotFound}, "No sitemap at %s", rawURL)
+		} else {
+			d.errorf(logEntry{event: "sitemap", url: rawURL, status: statusOf(err), err: err}, "Failed to fetch sitemap %s: %v", rawURL, err)
+		}
+		return
+	}
+
+	var nested []string
+	urls := 0
+	err = readSitemapEntries(resp, func(index bool, e sitemapEntry) {
+		ref, err := resp.Request.URL.Parse(strings.TrimSpace(e.Loc))
+		if err != nil || e.Loc == "" {
+			return
+		}
+		ref.Fragment = ""
+		if index {
+			nested = append(nested, ref.String())
+			return
+		}
+		urls++
+		j := job{url: ref.String(), sitemap: true}
+		if d.timestamping {
+			j.lastmod = parseLastmod(e.Lastmod)
+		}
+		if err := d.enqueue(j); err != nil {
+			d.verbosef(logEntry{event: "sitemap", url: ref.String(), err: err}, "Ignoring sitemap entry: %v", err)
+		}
+	})
+	resp.Body.Close()
+	// Слот хоста освобождается до чтения вложенных sitemap с того же хоста
+	d.release(u.Host)
+	if err != nil {
+		d.errorf(logEntry{event: "sitemap", url: rawURL, err: err}, "Failed to read sitemap %s: %v", rawURL, err)
+	}
+	if len(nested) > 0 {
+		d.infof(logEntry{event: "sitemap", url: rawURL}, "Sitemap index %s lists %d sitemaps", rawURL, len(nested))
+	} else {
+		d.infof(logEntry{event: "sitemap", url: rawURL}, "Sitemap %s lists %d URLs", rawURL, urls)
+	}
+
+	for _, sitemapURL := range nested {
+		d.readSitemap(sitemapURL, depth+1, seen)
+	}
+}
+
+// readSitemapEntries читает sitemap потоком и вызывает fn для каждой
+// записи: index сообщает, что запись - sitemap из индекса. Сжатые gzip
+// sitemap распознаются по содержимому, а не по имени. Больше
+// maxSitemapSize после распаковки не читается
+func readSitemapEntries(resp *http.Response, fn func(index bool, e sitemapEntry)) error {
+	if err := decodeBody(resp); err != nil {
+		return err
+	}
+	br := bufio.NewReader(resp.Body)
+	var body io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	// Лишний байт отличает sitemap ровно в предел от превысившего его
+	limited := &io.LimitedReader{R: body, N: maxSitemapSize + 1}
+
+	dec := xml.NewDecoder(bufio.NewReader(limited))
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if limited.N <= 0 {
+			return fmt.Errorf("sitemap is larger than %d bytes uncompressed", maxSitemapSize)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || (start.Name.Local != "url" && start.Name.Local != "sitemap") {
+			continue
+		}
+		var e sitemapEntry
+		if err := dec.DecodeElement(&e, &start); err != nil {
+			return fmt.Errorf("bad <%s> entry: %w", start.Name.Local, err)
+		}
+		fn(start.Name.Local == "sitemap", e)
+	}
+}
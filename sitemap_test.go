@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSitemapIndexWithGzippedChild(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, "<html><body>home</body></html>")
+		case "/sitemap_index.xml":
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%[1]s/plain.xml</loc></sitemap>
+  <sitemap><loc>%[1]s/pages.xml.gz</loc></sitemap>
+</sitemapindex>`, srv.URL)
+		case "/plain.xml":
+			fmt.Fprint(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>/a.html</loc></url></urlset>`)
+		case "/pages.xml.gz":
+			// Без Content-Encoding: сжатие распознается по содержимому
+			w.Header().Set("Content-Type", "application/x-gzip")
+			w.Write(gzipBytes(t, []byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>/b.html</loc><lastmod>2024-01-02</lastmod></url>
+  <url><loc>`+srv.URL+`/c.html</loc></url>
+</urlset>`)))
+		case "/a.html", "/b.html", "/c.html":
+			fmt.Fprintf(w, "<html><body>%s</body></html>", r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	stats, err := testMirror(t, dir, "-l", "0", "--sitemap="+srv.URL+"/sitemap_index.xml", srv.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Failed != 0 {
+		t.Errorf("Failed = %d", stats.Failed)
+	}
+	for _, name := range []string{"a.html", "b.html", "c.html"} {
+		if got := readMirrorFile(t, hostDirOf(dir, srv), name); !strings.Contains(got, "/"+name) {
+			t.Errorf("%s = %q", name, got)
+		}
+	}
+	// Сами sitemap в зеркало не сохраняются
+	if _, err := os.Stat(filepath.Join(hostDirOf(dir, srv), "pages.xml.gz")); err == nil {
+		t.Error("sitemap saved to the mirror")
+	}
+}
+
+func TestReadSitemapEntriesLimit(t *testing.T) {
+	entries := func(body []byte) (int, error) {
+		n := 0
+		resp := &http.Response{Header: make(http.Header), Body: io.NopCloser(bytes.NewReader(body))}
+		err := readSitemapEntries(resp, func(_ bool, _ sitemapEntry) { n++ })
+		return n, err
+	}
+
+	small := []byte(`<urlset><url><loc>/a</loc></url><url><loc>/b</loc></url></urlset>`)
+	if n, err := entries(gzipBytes(t, small)); err != nil || n != 2 {
+		t.Errorf("gzipped sitemap: %d entries, %v", n, err)
+	}
+
+	// Несколько килобайт gzip распаковываются в больше чем maxSitemapSize
+	huge := append([]byte("<urlset><url><loc>/a</loc></url>"), bytes.Repeat([]byte(" "), maxSitemapSize)...)
+	huge = append(huge, "<url><loc>/b</loc></url></urlset>"...)
+	compressed := gzipBytes(t, huge)
+	if len(compressed) > 1<<20 {
+		t.Fatalf("compressed size %d", len(compressed))
+	}
+	if _, err := entries(compressed); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("oversized sitemap: err = %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// spiderResult - результат проверки одного URL в режиме --spider
+type spiderResult struct {
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"` // -1, если размер неизвестен
+	Error       string `json:"error,omitempty"`
+}
+
+// spiderURL проверяет URL, ничего не записывая на диск. Страницы, по
+// которым продолжается обход, запрашиваются через GET ради ссылок, все
+// остальное - через HEAD
+func (d *downloader) spiderURL(j job, u *url.URL) {
+	rawURL := j.url
+	d.verbosef(logEntry{event: "check", url: rawURL}, "Checking: %s (depth %d)", rawURL, j.depth)
+
+	header := make(http.Header)
+	if referer := d.refererFor(j, u); referer != "" {
+		header.Set("Referer", referer)
+	}
+
+	recurse := j.kind != kindRequisite && d.withinDepth(j.depth, kindPage)
+	method := http.MethodHead
+	if recurse && mayBeHTML(u) {
+		method = http.MethodGet
+	}
+
+	resp, attempts, err := d.fetch(method, rawURL, u.Host, header)
+	// Страница, которую не угадали по пути, все равно нужна целиком
+	if err == nil && method == http.MethodHead && recurse && mediaType(resp.Header.Get("Content-Type")) == "text/html" {
+		resp.Body.Close()
+		d.release(u.Host)
+		method = http.MethodGet
+		var more int
+		resp, more, err = d.fetch(method, rawURL, u.Host, header)
+		attempts += more
+	}
+	if err != nil {
+		result := spiderResult{URL: rawURL, Size: -1, Error: err.Error()}
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			result.Status = statusErr.status
+		}
+		d.addSpiderResult(result)
+		d.fail(rawURL, attempts, err)
+		return
+	}
+	defer d.release(u.Host)
+	defer resp.Body.Close()
+	d.countFile()
+
+	result := spiderResult{
+		URL:         rawURL,
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+	}
+	defer func() {
+		d.addSpiderResult(result)
+		d.emit(progressEvent{Event: "finished", URL: rawURL, Status: result.Status, Bytes: max(result.Size, 0)})
+	}()
+
+	if method != http.MethodGet || mediaType(result.ContentType) != "text/html" {
+		return
+	}
+
+	pageURL := resp.Request.URL
+	if final := pageURL.String(); final != rawURL {
+		// Конечный URL редиректа проверяется один раз
+		if !d.markVisited(final) {
+			return
+		}
+		recurse = recurse && d.inScope(pageURL)
+	}
+
+	if err := decodeBody(resp); err != nil {
+		result.Error = err.Error()
+		return
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	if result.Size < 0 {
+		result.Size = int64(len(content))
+	}
+	if recurse {
+		d.processHTML(content, pageURL, d.getSavePath(pageURL), j.depth, recurse, resp.Header)
+	}
+}
+
+func (d *downloader) addSpiderResult(result spiderResult) {
+	d.spiderMutex.Lock()
+	defer d.spiderMutex.Unlock()
+	d.spiderResults = append(d.spiderResults, result)
+}
+
+// SpiderResults возвращает результаты --spider, упорядоченные по URL
+func (d *downloader) SpiderResults() []spiderResult {
+	d.spiderMutex.Lock()
+	results := append([]spiderResult(nil), d.spiderResults...)
+	d.spiderMutex.Unlock()
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].URL < results[b].URL
+	})
+	return results
+}
+
+// writeSpiderResults выводит результаты построчно (статус, размер, тип, URL
+// через табуляцию) или, с format=json, одним JSON-массивом
+func writeSpiderResults(w io.Writer, results []spiderResult, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if results == nil {
+			results = []spiderResult{}
+		}
+		return enc.Encode(results)
+	}
+
+	for _, r := range results {
+		contentType := r.ContentType
+		if contentType == "" {
+			contentType = "-"
+		}
+		line := fmt.Sprintf("%d\t%d\t%s\t%s", r.Status, r.Size, contentType, r.URL)
+		if r.Error != "" {
+			line += "\t" + strings.ReplaceAll(r.Error, "\t", " ")
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
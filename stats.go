@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runStats - итоги одного запуска: что скачано, что взято из уже
+// сохраненных файлов и какие были ошибки. Его же возвращает Wait
+type runStats struct {
+	Pages       int64          `json:"pages"`        // сохраненные HTML-страницы
+	Assets      int64          `json:"assets"`       // остальные сохраненные файлы
+	Bytes       int64          `json:"bytes"`        // записано на диск
+	Unchanged   int64          `json:"unchanged"`    // 304 и файлы, оставленные --no-clobber
+	CachedBytes int64          `json:"cached_bytes"` // размер этих файлов
+	Failed      int            `json:"failed"`
+	Errors      map[string]int `json:"errors"` // ошибки по категориям
+	Skipped     map[string]int `json:"skipped"`
+	Elapsed     float64        `json:"elapsed"` // секунды
+	Speed       float64        `json:"speed"`   // байт в секунду
+}
+
+// Категории ошибок в итогах
+const (
+	errorHTTP       = "http"
+	errorTimeout    = "timeout"
+	errorNetwork    = "network"
+	errorFilesystem = "filesystem"
+	errorOther      = "other"
+)
+
+// errorCategory относит ошибку загрузки к одной из категорий итогов
+func errorCategory(err error) string {
+	var netErr net.Error
+	isNet := errors.As(err, &netErr)
+	switch {
+	case statusOf(err) != 0:
+		return errorHTTP
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, new(*stallError)), isNet && netErr.Timeout():
+		return errorTimeout
+	case errors.As(err, new(*fs.PathError)), errors.As(err, new(*os.LinkError)):
+		return errorFilesystem
+	case isNet:
+		return errorNetwork
+	}
+	return errorOther
+}
+
+// addUnchanged учитывает файл, который не скачивался заново, потому что
+// сохраненная копия актуальна
+func (d *downloader) addUnchanged(savePath string) {
+	d.unchanged.Add(1)
+	if info, err := d.statSaved(savePath); err == nil {
+		d.cachedBytes.Add(info.Size())
+	}
+}
+
+// Stats собирает итоги запуска. Счетчики атомарные, поэтому вызывать
+// можно и во время обхода
+func (d *downloader) Stats() runStats {
+	s := runStats{
+		Pages:       d.pagesSaved.Load(),
+		Assets:      d.assetsSaved.Load(),
+		Bytes:       d.bytesSaved.Load(),
+		Unchanged:   d.unchanged.Load(),
+		CachedBytes: d.cachedBytes.Load(),
+		Errors:      make(map[string]int),
+		Skipped:     d.Skipped(),
+	}
+	for _, f := range d.Failures() {
+		s.Failed++
+		s.Errors[errorCategory(f.err)]++
+	}
+
+	elapsed := d.elapsed
+	if elapsed == 0 && !d.started.IsZero() {
+		elapsed = time.Since(d.started)
+	}
+	s.Elapsed = elapsed.Seconds()
+	if s.Elapsed > 0 {
+		s.Speed = float64(s.Bytes) / s.Elapsed
+	}
+	return s
+}
+
+// writeSummary выводит итоги для --summary=json одним объектом JSON
+func writeSummary(w io.Writer, s runStats) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// summaryLines - итоги для людей: что скачано, за сколько и какие ошибки
+func summaryLines(s runStats) []string {
+	elapsed := time.Duration(s.Elapsed * float64(time.Second)).Round(time.Millisecond)
+	lines := []string{fmt.Sprintf("Fetched %d pages and %d other files, %s in %s (%s/s)",
+		s.Pages, s.Assets, formatSize(s.Bytes), elapsed, formatSize(int64(s.Speed)))}
+	if s.Unchanged > 0 {
+		lines = append(lines, fmt.Sprintf("%d files unchanged, %s not downloaded again", s.Unchanged, formatSize(s.CachedBytes)))
+	}
+	if s.Failed > 0 {
+		categories := make([]string, 0, len(s.Errors))
+		for category := range s.Errors {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		counts := make([]string, len(categories))
+		for i, category := range categories {
+			counts[i] = fmt.Sprintf("%d %s", s.Errors[category], category)
+		}
+		lines = append(lines, fmt.Sprintf("%d errors: %s", s.Failed, strings.Join(counts, ", ")))
+	}
+	return lines
+}
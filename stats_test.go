@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunStats(t *testing.T) {
+	const assets = 30
+	image := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 250)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<a href="/a.html">a</a><link rel="stylesheet" href="/style.css"><img src="/missing.png">`)
+			for i := 0; i < assets; i++ {
+				fmt.Fprintf(w, `<img src="/img%d.png">`, i)
+			}
+		case r.URL.Path == "/a.html":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<p>page a</p>`)
+		case r.URL.Path == "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `body { color: red }`)
+		case strings.HasPrefix(r.URL.Path, "/img"):
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(image)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	args := []string{"-e", "robots=off", "--tries", "1", "--concurrency", "8", srv.URL + "/"}
+	stats, err := testMirror(t, dir, args...)
+	if err == nil {
+		t.Error("missing image did not fail the run")
+	}
+	var size int64
+	for _, name := range mirrorFiles(t, hostDirOf(dir, srv)) {
+		info, err := os.Stat(filepath.Join(hostDirOf(dir, srv), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	if stats.Pages != 2 || stats.Assets != assets+1 || stats.Bytes != size {
+		t.Errorf("Pages = %d, Assets = %d, Bytes = %d; want 2, %d, %d", stats.Pages, stats.Assets, stats.Bytes, assets+1, size)
+	}
+	if stats.Failed != 1 || !reflect.DeepEqual(stats.Errors, map[string]int{errorHTTP: 1}) {
+		t.Errorf("Failed = %d, Errors = %v; want 1 http error", stats.Failed, stats.Errors)
+	}
+	if stats.Unchanged != 0 || stats.CachedBytes != 0 {
+		t.Errorf("Unchanged = %d, CachedBytes = %d on the first run", stats.Unchanged, stats.CachedBytes)
+	}
+	if stats.Elapsed <= 0 || stats.Speed <= 0 {
+		t.Errorf("Elapsed = %v, Speed = %v", stats.Elapsed, stats.Speed)
+	}
+
+	// Повторный запуск с --no-clobber ничего не скачивает заново
+	stats, _ = testMirror(t, dir, append([]string{"--no-clobber"}, args...)...)
+	if stats.Pages != 0 || stats.Assets != 0 || stats.Bytes != 0 {
+		t.Errorf("second run: Pages = %d, Assets = %d, Bytes = %d; want nothing downloaded", stats.Pages, stats.Assets, stats.Bytes)
+	}
+	if stats.Unchanged != assets+3 || stats.CachedBytes != size {
+		t.Errorf("second run: Unchanged = %d, CachedBytes = %d; want %d, %d", stats.Unchanged, stats.CachedBytes, assets+3, size)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := runStats{
+		Pages:       3,
+		Assets:      10,
+		Bytes:       3 << 20,
+		Unchanged:   2,
+		CachedBytes: 2048,
+		Failed:      3,
+		Errors:      map[string]int{errorTimeout: 1, errorHTTP: 2},
+		Skipped:     map[string]int{},
+		Elapsed:     1.5,
+		Speed:       2 << 20,
+	}
+	want := []string{
+		"Fetched 3 pages and 10 other files, " + formatSize(3<<20) + " in 1.5s (" + formatSize(2<<20) + "/s)",
+		"2 files unchanged, " + formatSize(2048) + " not downloaded again",
+		"3 errors: 2 http, 1 timeout",
+	}
+	if got := summaryLines(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("summaryLines = %q\nwant %q", got, want)
+	}
+	if got := summaryLines(runStats{Pages: 1}); len(got) != 1 {
+		t.Errorf("summary of a clean run = %q, want one line", got)
+	}
+
+	var buf bytes.Buffer
+	if err := writeSummary(&buf, s); err != nil {
+		t.Fatal(err)
+	}
+	var decoded runStats
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, s) {
+		t.Errorf("JSON summary %s decodes to %+v", buf.Bytes(), decoded)
+	}
+	for _, key := range []string{`"pages": 3`, `"cached_bytes": 2048`, `"errors": {`, `"elapsed": 1.5`} {
+		if !strings.Contains(buf.String(), key) {
+			t.Errorf("JSON summary has no %s:\n%s", key, buf.String())
+		}
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&statusError{status: 404}, errorHTTP},
+		{fmt.Errorf("get: %w", &stallError{}), errorTimeout},
+		{os.ErrDeadlineExceeded, errorTimeout},
+		{&os.PathError{Op: "open", Path: "x", Err: os.ErrPermission}, errorFilesystem},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, errorNetwork},
+		{errors.New("something else"), errorOther},
+	}
+	for _, tt := range tests {
+		if got := errorCategory(tt.err); got != tt.want {
+			t.Errorf("errorCategory(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// structuredDataKeys - ключи JSON-LD, значения которых - URL картинок,
+// логотипов и медиафайлов
+var structuredDataKeys = map[string]bool{
+	"image":        true,
+	"logo":         true,
+	"url":          true,
+	"contenturl":   true,
+	"thumbnailurl": true,
+}
+
+// isStructuredData проверяет, содержит ли <script> JSON-LD
+func isStructuredData(n *html.Node) bool {
+	if n.Type != html.ElementNode || n.Data != "script" {
+		return false
+	}
+	typ, _, _ := strings.Cut(attrValue(n, "type"), ";")
+	return strings.EqualFold(strings.TrimSpace(typ), "application/ld+json")
+}
+
+// walkStructuredData вызывает fn для каждой строки под ключами
+// structuredDataKeys в блоках JSON-LD документа и подставляет в JSON то,
+// что fn вернет. Блоки с ошибками в JSON не меняются
+func walkStructuredData(n *html.Node, fn func(ref string) string) {
+	if isStructuredData(n) {
+		if text := n.FirstChild; text != nil && text.Type == html.TextNode {
+			rewritten, err := rewriteJSON([]byte(text.Data), func(stack []jsonFrame, value string) string {
+				if !structuredDataKeys[strings.ToLower(jsonKey(stack))] {
+					return value
+				}
+				return fn(value)
+			})
+			if err == nil {
+				text.Data = string(rewritten)
+			}
+		}
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkStructuredData(c, fn)
+	}
+}
+
+// jsonKey - ключ, под которым лежит значение: ключ ближайшего объекта,
+// в том числе для элементов массивов ("image": ["a.jpg", "b.jpg"])
+func jsonKey(stack []jsonFrame) string {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].object {
+			return stack[i].key
+		}
+	}
+	return ""
+}
+
+// looksLikeURL отличает ссылки от текста под теми же ключами: берутся
+// только абсолютные URL http(s) и пути от корня сайта
+func looksLikeURL(value string) bool {
+	if strings.ContainsAny(value, " \t\n") {
+		return false
+	}
+	if strings.HasPrefix(value, "/") {
+		return true
+	}
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// processStructuredData ставит в очередь ресурсы из JSON-LD страницы как
+// ее ресурсы и переписывает ссылки на них. Ссылки за пределами зеркала
+// остаются как есть
+func (d *downloader) processStructuredData(doc *html.Node, base *url.URL, pageURL *url.URL, savePath string, depth int) {
+	walkStructuredData(doc, func(ref string) string {
+		if !looksLikeURL(ref) {
+			return ref
+		}
+		u, err := base.Parse(ref)
+		if err != nil || !d.inScope(u) {
+			return ref
+		}
+		return d.rewriteLink(u.String(), pageURL, savePath, depth, true, kindRequisite)
+	})
+}
+
+// followStructuredData продолжает обход по JSON-LD уже сохраненной
+// страницы. Переписанные ссылки - относительные пути к файлам зеркала
+func (d *downloader) followStructuredData(doc *html.Node, pageURL *url.URL, savePath string, depth int) {
+	walkStructuredData(doc, func(ref string) string {
+		if !looksLikeURL(ref) {
+			u, err := url.Parse(ref)
+			if err != nil || u.Path == "" {
+				return ref
+			}
+			if _, err := d.statSaved(filepath.Join(filepath.Dir(savePath), filepath.FromSlash(u.Path))); err != nil {
+				return ref
+			}
+		}
+		d.followLocalLink(ref, pageURL, savePath, depth, kindRequisite)
+		return ref
+	})
+}
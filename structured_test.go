@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func TestRewriteJSON(t *testing.T) {
+	doc := `{
+  "@type": "Article",
+  "image": ["/a.jpg", {"url": "/b.jpg", "caption": "b"}],
+  "count": 2, "ok": true, "none": null,
+  "author": {"name": "x \"quoted\"", "logo": "/l\u002fogo.png"}
+}`
+	var seen []string
+	got, err := rewriteJSON([]byte(doc), func(stack []jsonFrame, value string) string {
+		seen = append(seen, jsonKey(stack)+"="+value)
+		if strings.HasPrefix(value, "/") {
+			return "local" + value + "?a&b<c"
+		}
+		return value
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "@type=Article image=/a.jpg url=/b.jpg caption=b name=x \"quoted\" logo=/l/ogo.png"
+	if strings.Join(seen, " ") != want {
+		t.Errorf("fn saw %q, want %q", strings.Join(seen, " "), want)
+	}
+	// Правятся только строки, остальное форматирование остается
+	wantDoc := `{
+  "@type": "Article",
+  "image": ["local/a.jpg?a&b<c", {"url": "local/b.jpg?a&b<c", "caption": "b"}],
+  "count": 2, "ok": true, "none": null,
+  "author": {"name": "x \"quoted\"", "logo": "local/l/ogo.png?a&b<c"}
+}`
+	if string(got) != wantDoc {
+		t.Errorf("rewriteJSON =\n%s\nwant\n%s", got, wantDoc)
+	}
+
+	for _, broken := range []string{`{"image": "/a.jpg",}`, `{"image": "/a.jpg"`, `[1, 2] 3`, ``} {
+		called := false
+		got, err := rewriteJSON([]byte(broken), func(stack []jsonFrame, value string) string {
+			called = true
+			return "changed"
+		})
+		if err == nil || called || string(got) != broken {
+			t.Errorf("rewriteJSON(%q) = %q, %v; fn called %v", broken, got, err, called)
+		}
+	}
+}
+
+func TestLooksLikeURL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"/img/a.jpg", true},
+		{"https://example.com/a.jpg", true},
+		{"http://example.com", true},
+		{"img/a.jpg", false},
+		{"ftp://example.com/a.jpg", false},
+		{"mailto:a@example.com", false},
+		{"https:///a.jpg", false},
+		{"/ two words", false},
+		{"Company logo", false},
+	}
+	for _, tt := range tests {
+		if got := looksLikeURL(tt.value); got != tt.want {
+			t.Errorf("looksLikeURL(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWalkStructuredData(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><head>
+<script type="application/ld+json">{"Image": "/a.jpg", "name": "/not-a-key.jpg", "@id": "/id"}</script>
+<script type="Application/LD+JSON; charset=utf-8">[{"thumbnailUrl": "/t.jpg"}, {"contentUrl": ["/c1.mp4", "/c2.mp4"]}]</script>
+<script type="application/json">{"image": "/plain-json.jpg"}</script>
+<script>var x = {"image": "/script.jpg"}</script>
+</head><body><script type="application/ld+json">{"logo": "/body.png"}</script></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs []string
+	walkStructuredData(doc, func(ref string) string {
+		refs = append(refs, ref)
+		return ref
+	})
+	if got, want := strings.Join(refs, " "), "/a.jpg /t.jpg /c1.mp4 /c2.mp4 /body.png"; got != want {
+		t.Errorf("refs = %q, want %q", got, want)
+	}
+}
+
+func TestStructuredDataMirror(t *testing.T) {
+	broken := `{"@type": "Product", "image": ["/img/broken.jpg",], "name": "trailing comma"}`
+	page := `<html><head>
+<script type="application/ld+json">
+{
+  "@context": "https://schema.org",
+  "@type": "Product",
+  "name": "Chair",
+  "url": "/shop/chair.html",
+  "image": [
+    "/img/chair-1.jpg",
+    ["/img/chair-2.jpg", {"@type": "ImageObject", "contentUrl": "/img/chair-3.jpg", "thumbnailUrl": "https://cdn.example.net/t.jpg"}]
+  ],
+  "brand": {"@type": "Brand", "logo": "/img/logo.png", "description": "Logo and images"}
+}
+</script>
+<script type="application/ld+json">` + broken + `</script>
+</head><body><p>chair</p></body></html>`
+
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/shop/chair.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(page))
+		case strings.HasPrefix(r.URL.Path, "/img/"):
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("image " + r.URL.Path))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	images := []string{"/img/chair-1.jpg", "/img/chair-2.jpg", "/img/chair-3.jpg", "/img/logo.png"}
+
+	// Без --structured-data блоки не читаются
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "-p", srv.URL+"/shop/chair.html"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for _, path := range images {
+		if requested[path] {
+			t.Errorf("%s requested without --structured-data", path)
+		}
+	}
+	mu.Unlock()
+	if got := readMirrorFile(t, hostDirOf(dir, srv), "shop/chair.html"); !strings.Contains(got, `"logo": "/img/logo.png"`) {
+		t.Errorf("JSON-LD rewritten without --structured-data:\n%s", got)
+	}
+
+	dir = t.TempDir()
+	stats, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "-p", "--structured-data", srv.URL+"/shop/chair.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Failed != 0 {
+		t.Errorf("Failed = %d", stats.Failed)
+	}
+	mu.Lock()
+	for _, path := range images {
+		if !requested[path] {
+			t.Errorf("%s not requested", path)
+		}
+	}
+	if requested["/img/broken.jpg"] {
+		t.Error("URL from invalid JSON requested")
+	}
+	mu.Unlock()
+
+	host := hostDirOf(dir, srv)
+	for _, path := range images {
+		if got := readMirrorFile(t, host, strings.TrimPrefix(path, "/")); got != "image "+path {
+			t.Errorf("%s = %q", path, got)
+		}
+	}
+	got := readMirrorFile(t, host, "shop/chair.html")
+	for _, want := range []string{
+		`"@context": "https://schema.org"`,
+		`"url": "chair.html"`,
+		`"../img/chair-1.jpg",`,
+		`["../img/chair-2.jpg", {"@type": "ImageObject", "contentUrl": "../img/chair-3.jpg", "thumbnailUrl": "https://cdn.example.net/t.jpg"}]`,
+		`"logo": "../img/logo.png", "description": "Logo and images"`,
+		// Блок с ошибкой в JSON сохраняется байт в байт
+		`<script type="application/ld+json">` + broken + `</script>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("chair.html has no %q:\n%s", want, got)
+		}
+	}
+}
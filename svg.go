@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// svgHrefPattern находит href и xlink:href (с любым префиксом) в тексте тега
+var svgHrefPattern = regexp.MustCompile(`\s(?:[\w.-]+:)?href\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// svgStylePattern находит атрибут style в тексте тега
+var svgStylePattern = regexp.MustCompile(`\sstyle\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// rewriteSVG вызывает fn для каждой ссылки SVG и подставляет в текст то,
+// что fn вернет. Ссылки берутся из href и xlink:href любых элементов
+// (<a> ведет на страницу, остальное - ресурсы: <image>, <use>, <feImage>,
+// <script>), из url() и @import в <style> и атрибутах style и из
+// <?xml-stylesheet?>. data: и ссылки на фрагмент (#gradient) не трогаются
+func rewriteSVG(content []byte, fn func(ref string, kind resourceKind) string) ([]byte, error) {
+	dec := newXMLDecoder(content)
+
+	var edits []xmlEdit
+	// replace переписывает значение атрибута value по смещению start.
+	// Значение в тексте экранировано по правилам XML
+	replace := func(start int, value string, kind resourceKind) {
+		ref := strings.TrimSpace(html.UnescapeString(value))
+		if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(strings.ToLower(ref), "data:") {
+			return
+		}
+		if rewritten := fn(ref, kind); rewritten != ref {
+			edits = append(edits, xmlEdit{start, start + len(value), escapeXML(rewritten)})
+		}
+	}
+	rewriteCSS := func(css string) string {
+		return rewriteCSSURLs(css, func(ref string) string {
+			return fn(ref, kindRequisite)
+		})
+	}
+	styleStart := -1
+
+	for {
+		offset := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return content, err
+		}
+		end := int(dec.InputOffset())
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			tag := content[offset:end]
+			kind := kindRequisite
+			if t.Name.Local == "a" {
+				kind = kindPage
+			}
+			for _, m := range svgHrefPattern.FindAllSubmatchIndex(tag, -1) {
+				start, stop := m[2], m[3]
+				if start < 0 {
+					start, stop = m[4], m[5]
+				}
+				replace(offset+start, string(tag[start:stop]), kind)
+			}
+			if m := svgStylePattern.FindSubmatchIndex(tag); m != nil {
+				start, stop := m[2], m[3]
+				if start < 0 {
+					start, stop = m[4], m[5]
+				}
+				css := html.UnescapeString(string(tag[start:stop]))
+				if rewritten := rewriteCSS(css); rewritten != css {
+					edits = append(edits, xmlEdit{offset + start, offset + stop, escapeXML(rewritten)})
+				}
+			}
+			if t.Name.Local == "style" {
+				styleStart = end
+			}
+		case xml.EndElement:
+			if t.Name.Local != "style" || styleStart < 0 {
+				continue
+			}
+			// В CDATA текст стиля записан как есть, иначе - экранирован
+			css := string(content[styleStart:offset])
+			var rewritten string
+			if strings.Contains(css, "<![CDATA[") {
+				rewritten = rewriteCSS(css)
+			} else {
+				rewritten = rewriteCSSURLs(css, func(ref string) string {
+					return escapeXML(fn(html.UnescapeString(ref), kindRequisite))
+				})
+			}
+			if rewritten != css {
+				edits = append(edits, xmlEdit{styleStart, offset, rewritten})
+			}
+			styleStart = -1
+		case xml.ProcInst:
+			if t.Target != "xml-stylesheet" {
+				continue
+			}
+			tag := content[offset:end]
+			if m := svgHrefPattern.FindSubmatchIndex(tag); m != nil {
+				start, stop := m[2], m[3]
+				if start < 0 {
+					start, stop = m[4], m[5]
+				}
+				replace(offset+start, string(tag[start:stop]), kindRequisite)
+			}
+		}
+	}
+	return applyXMLEdits(content, edits), nil
+}
+
+// processSVG ставит в очередь картинки, стили и шрифты SVG-файла и
+// переписывает ссылки на них относительно файла savePath. Как и для CSS,
+// нужны ли ресурсы на этой глубине, решает enqueue
+func (d *downloader) processSVG(content []byte, baseURL *url.URL, savePath string, depth int) []byte {
+	rewritten, err := rewriteSVG(content, func(ref string, kind resourceKind) string {
+		return d.rewriteLink(ref, baseURL, savePath, depth, true, kind)
+	})
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: baseURL.String(), err: err}, "Failed to parse SVG: %v", err)
+		return content
+	}
+	return rewritten
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRewriteSVG(t *testing.T) {
+	svg := `<?xml version="1.0"?>
+<?xml-stylesheet type="text/css" href="/css/svg.css"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 10 10">
+  <defs><linearGradient id="gradient"/></defs>
+  <style><![CDATA[ .a { fill: url(#gradient); background: url("img/bg.png") } ]]></style>
+  <style> @import 'theme.css'; .b { mask: url(/masks/m.svg?a=1&amp;b=2) } </style>
+  <image href="/img/photo.jpg" width="10"/>
+  <image xlink:href='img/old.png'/>
+  <use xlink:href="#gradient"/>
+  <use href="sprites.svg#icon"/>
+  <image href="data:image/png;base64,iVBORw0KGgo="/>
+  <rect style="fill: url(#gradient); filter: url(filters.svg#blur)"/>
+  <a href="/page.html?x=1&amp;y=2"><text>link</text></a>
+</svg>`
+	var refs []string
+	got, err := rewriteSVG([]byte(svg), func(ref string, kind resourceKind) string {
+		name := "requisite"
+		if kind == kindPage {
+			name = "page"
+		}
+		refs = append(refs, name+" "+ref)
+		return "local/" + strings.TrimPrefix(ref, "/")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRefs := []string{
+		"requisite /css/svg.css",
+		"requisite img/bg.png",
+		"requisite theme.css",
+		"requisite /masks/m.svg?a=1&b=2",
+		"requisite /img/photo.jpg",
+		"requisite img/old.png",
+		"requisite sprites.svg#icon",
+		"requisite filters.svg#blur",
+		"page /page.html?x=1&y=2",
+	}
+	if strings.Join(refs, "\n") != strings.Join(wantRefs, "\n") {
+		t.Errorf("refs:\n%s\nwant:\n%s", strings.Join(refs, "\n"), strings.Join(wantRefs, "\n"))
+	}
+	for _, want := range []string{
+		`<?xml-stylesheet type="text/css" href="local/css/svg.css"?>`,
+		`fill: url(#gradient); background: url("local/img/bg.png")`,
+		`@import 'local/theme.css'`,
+		`mask: url(local/masks/m.svg?a=1&amp;b=2)`,
+		`<image href="local/img/photo.jpg" width="10"/>`,
+		`<image xlink:href='local/img/old.png'/>`,
+		`<use xlink:href="#gradient"/>`,
+		`<use href="local/sprites.svg#icon"/>`,
+		`<image href="data:image/png;base64,iVBORw0KGgo="/>`,
+		`style="fill: url(#gradient); filter: url(local/filters.svg#blur)"`,
+		`<a href="local/page.html?x=1&amp;y=2">`,
+		`xmlns:xlink="http://www.w3.org/1999/xlink"`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("rewritten SVG has no %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestSVGMirror(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<img src="/icons/logo.svg"><object data="/charts/chart.xml"></object>`))
+		case "/icons/logo.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">
+<image href="raster.png"/><image xlink:href="/img/old.png"/><use href="#shape"/></svg>`))
+		case "/charts/chart.xml":
+			// SVG с общим типом XML распознается по корневому элементу
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><image href="bars.png"/></svg>`))
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "-p", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for _, path := range []string{"/icons/raster.png", "/img/old.png", "/charts/bars.png"} {
+		if !requested[path] {
+			t.Errorf("%s not requested", path)
+		}
+	}
+	mu.Unlock()
+
+	host := hostDirOf(dir, srv)
+	logo := readMirrorFile(t, host, "icons/logo.svg")
+	for _, want := range []string{`<image href="raster.png"/>`, `<image xlink:href="../img/old.png"/>`, `<use href="#shape"/>`} {
+		if !strings.Contains(logo, want) {
+			t.Errorf("logo.svg has no %q:\n%s", want, logo)
+		}
+	}
+	if chart := readMirrorFile(t, host, "charts/chart.xml"); !strings.Contains(chart, `<image href="bars.png"/>`) {
+		t.Errorf("chart.xml = %s", chart)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// directDialer соединяется с хостом напрямую, отдельно ограничивая время
+// разрешения имени (--dns-timeout) и установки соединения (--connect-timeout)
+func directDialer(dnsTimeout, connectTimeout time.Duration) dialFunc {
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || dnsTimeout <= 0 || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, dnsTimeout)
+		ips, err := net.DefaultResolver.LookupIPAddr(lookupCtx, host)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+		}
+
+		// Адреса пробуем по очереди, каждый со своим --connect-timeout
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// stallError - тело ответа перестало поступать дольше --read-timeout
+type stallError struct {
+	timeout time.Duration
+}
+
+func (e *stallError) Error() string {
+	return fmt.Sprintf("no data received for %v", e.timeout)
+}
+
+// stallBody обрывает чтение тела, если очередной Read ждет данных дольше
+// timeout. Время между чтениями (например, паузы --limit-rate) не считается
+type stallBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	stalled atomic.Bool
+}
+
+func (b *stallBody) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(b.timeout, func() {
+		b.stalled.Store(true)
+		b.ReadCloser.Close()
+	})
+	n, err := b.ReadCloser.Read(p)
+	timer.Stop()
+
+	if b.stalled.Load() {
+		return n, &stallError{timeout: b.timeout}
+	}
+	return n, err
+}
+
+// watchBody добавляет к телу ответа контроль --read-timeout
+func (d *downloader) watchBody(body io.ReadCloser) io.ReadCloser {
+	if d.readTimeout <= 0 {
+		return body
+	}
+	return &stallBody{ReadCloser: body, timeout: d.readTimeout}
+}
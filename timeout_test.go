@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStallBody(t *testing.T) {
+	r, w := io.Pipe()
+	body := &stallBody{ReadCloser: r, timeout: 50 * time.Millisecond}
+	go func() {
+		w.Write([]byte("first"))
+		// Дальше данные не поступают, а соединение не закрывается
+	}()
+
+	buf := make([]byte, 16)
+	if n, err := body.Read(buf); err != nil || string(buf[:n]) != "first" {
+		t.Fatalf("first Read = %q, %v", buf[:n], err)
+	}
+	// Пауза между чтениями не считается простоем
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	_, err := body.Read(buf)
+	var stall *stallError
+	if !errors.As(err, &stall) || stall.timeout != 50*time.Millisecond {
+		t.Fatalf("stalled Read error %v, want stallError", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stall detected after %v", elapsed)
+	}
+	if errorCategory(err) != errorTimeout {
+		t.Errorf("category %q, want %q", errorCategory(err), errorTimeout)
+	}
+}
+
+// slowSite отдает /stalled.bin, который замирает на середине, /slow.bin,
+// который приходит по кусочку, и /late.bin с долгим ожиданием заголовков
+func slowSite(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pause := func(d time.Duration) bool {
+			select {
+			case <-time.After(d):
+				return true
+			case <-r.Context().Done():
+				return false
+			}
+		}
+		switch r.URL.Path {
+		case "/stalled.bin":
+			w.Header().Set("Content-Length", "10")
+			w.Write([]byte("12345"))
+			w.(http.Flusher).Flush()
+			pause(10 * time.Second)
+		case "/slow.bin":
+			for i := 0; i < 10; i++ {
+				w.Write([]byte("x"))
+				w.(http.Flusher).Flush()
+				if !pause(50 * time.Millisecond) {
+					return
+				}
+			}
+		case "/late.bin":
+			if pause(time.Second) {
+				w.Write([]byte("late"))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReadTimeouts(t *testing.T) {
+	srv := slowSite(t)
+	tests := []struct {
+		args []string
+		path string
+		ok   bool
+	}{
+		{[]string{"--read-timeout", "0.2"}, "/stalled.bin", false},
+		// Медленная, но не замершая загрузка не ограничена по общему времени
+		{[]string{"--read-timeout", "0.2"}, "/slow.bin", true},
+		{[]string{"--read-timeout", "0.2", "--total-timeout", "0.2"}, "/slow.bin", false},
+		{[]string{"--response-header-timeout", "0.2"}, "/late.bin", false},
+		{[]string{"--response-header-timeout", "2"}, "/late.bin", true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		start := time.Now()
+		stats, err := testMirror(t, dir, append(append([]string{"-l", "0", "-e", "robots=off", "--tries", "1"}, tt.args...), srv.URL+tt.path)...)
+		elapsed := time.Since(start)
+		if tt.ok {
+			if err != nil || stats.Failed != 0 {
+				t.Errorf("%q %s: Failed = %d, %v", tt.args, tt.path, stats.Failed, err)
+			}
+			continue
+		}
+		if err == nil || stats.Failed != 1 || stats.Errors[errorTimeout] != 1 {
+			t.Errorf("%q %s: Failed = %d, Errors = %v, %v; want 1 timeout", tt.args, tt.path, stats.Failed, stats.Errors, err)
+		}
+		if elapsed > 2*time.Second {
+			t.Errorf("%q %s: gave up after %v", tt.args, tt.path, elapsed)
+		}
+		// Оборванный файл остается только в .part
+		if _, err := os.Stat(filepath.Join(hostDirOf(dir, srv), tt.path)); err == nil {
+			t.Errorf("%q %s: incomplete file saved", tt.args, tt.path)
+		}
+	}
+}
+
+func TestTLSTimeout(t *testing.T) {
+	// Сервер принимает соединения, но на рукопожатие не отвечает
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	start := time.Now()
+	stats, err := testMirror(t, t.TempDir(), "-l", "0", "-e", "robots=off", "--tries", "1", "--tls-timeout", "0.2", "https://"+ln.Addr().String()+"/")
+	if err == nil || stats.Errors[errorTimeout] != 1 {
+		t.Errorf("Errors = %v, %v; want 1 timeout", stats.Errors, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+}
+
+func TestParseSeconds(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"30":    30 * time.Second,
+		"0.5":   500 * time.Millisecond,
+		"0":     0,
+		"150ms": 150 * time.Millisecond,
+		"2m":    2 * time.Minute,
+	} {
+		if got, err := parseSeconds(value); err != nil || got != want {
+			t.Errorf("parseSeconds(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"-1", "soon", ""} {
+		if _, err := parseSeconds(value); err == nil {
+			t.Errorf("parseSeconds(%q) accepted", value)
+		}
+	}
+}
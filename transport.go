@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// newTransport строит транспорт загрузчика. По умолчанию прокси берется из
+// переменных окружения, --proxy заменяет его для http и https, но хосты из
+// NO_PROXY по-прежнему запрашиваются напрямую
+func newTransport(opts options) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.DialContext = directDialer(opts.dnsTimeout, opts.connectTimeout)
+	transport.TLSHandshakeTimeout = opts.tlsTimeout
+	transport.ResponseHeaderTimeout = opts.responseHeaderTimeout
+
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	if opts.proxy == "" {
+		return transport, nil
+	}
+
+	proxyURL, err := url.Parse(opts.proxy)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q", opts.proxy)
+	}
+
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  proxyURL.String(),
+		HTTPSProxy: proxyURL.String(),
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+
+	switch proxyURL.Scheme {
+	case "http", "https":
+		// Учетные данные из userinfo транспорт сам отправляет в
+		// Proxy-Authorization, в том числе для CONNECT
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	case "socks5", "socks5h":
+		dialContext, err := socksDialer(proxyURL, proxyFunc, transport.DialContext)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = nil
+		transport.DialContext = dialContext
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (want http, https, socks5 or socks5h)", proxyURL.Scheme)
+	}
+
+	return transport, nil
+}
+
+// newTLSConfig применяет --no-check-certificate, --ca-certificate и
+// клиентский сертификат
+func newTLSConfig(opts options) (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if opts.noCheckCertificate {
+		config.InsecureSkipVerify = true
+	}
+
+	if opts.caCertificate != "" {
+		pem, err := os.ReadFile(opts.caCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+		// Свой CA дополняет системные, чтобы публичные сайты продолжали работать
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %q", opts.caCertificate)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.certificate != "" {
+		// Ключ может лежать в одном файле с сертификатом
+		keyFile := opts.privateKey
+		if keyFile == "" {
+			keyFile = opts.certificate
+		}
+		cert, err := tls.LoadX509KeyPair(opts.certificate, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if opts.privateKey != "" {
+		return nil, fmt.Errorf("--private-key requires --certificate")
+	}
+
+	return config, nil
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// socksDialer соединяется через SOCKS5-прокси. С socks5h имя хоста
+// разрешает прокси, с socks5 - мы сами, и прокси получает только IP
+func socksDialer(proxyURL *url.URL, proxyFunc func(*url.URL) (*url.URL, error), direct dialFunc) (dialFunc, error) {
+	dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOCKS proxy %q: %v", proxyURL.Redacted(), err)
+	}
+	socks, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("SOCKS proxy %q does not support contexts", proxyURL.Redacted())
+	}
+	remoteDNS := proxyURL.Scheme == "socks5h"
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// Хосты из NO_PROXY соединяются напрямую
+		if viaProxy, err := proxyFunc(&url.URL{Scheme: "http", Host: addr}); err == nil && viaProxy == nil {
+			return direct(ctx, network, addr)
+		}
+
+		if !remoteDNS {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if net.ParseIP(host) == nil {
+				ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+				if err != nil {
+					return nil, err
+				}
+				addr = net.JoinHostPort(ips[0].IP.String(), port)
+			}
+		}
+		return socks.DialContext(ctx, network, addr)
+	}, nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingProxy - HTTP-прокси, который сам отвечает на запросы в
+// absolute-form и запоминает их вместе с Proxy-Authorization
+type recordingProxy struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+	auth     []string
+}
+
+func newRecordingProxy(t *testing.T) *recordingProxy {
+	p := &recordingProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.requests = append(p.requests, r.Method+" "+r.RequestURI)
+		p.auth = append(p.auth, r.Header.Get("Proxy-Authorization"))
+		p.mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<a href="/page.html">page</a>`))
+		case "/page.html":
+			w.Write([]byte("via proxy"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestHTTPProxy(t *testing.T) {
+	p := newRecordingProxy(t)
+	proxyURL := strings.Replace(p.URL, "http://", "http://proxyuser:proxypass@", 1)
+
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "--proxy", proxyURL, "http://mirror.test/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMirrorFile(t, dir, "mirror.test/page.html"); got != "via proxy" {
+		t.Errorf("page.html = %q", got)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if got := strings.Join(p.requests, ", "); got != "GET http://mirror.test/, GET http://mirror.test/page.html" {
+		t.Errorf("proxy got %s", got)
+	}
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("proxyuser:proxypass"))
+	for _, got := range p.auth {
+		if got != want {
+			t.Errorf("Proxy-Authorization = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestProxyNoProxy(t *testing.T) {
+	t.Setenv("NO_PROXY", "direct.test,.internal.test")
+	opts, _, err := parseArgs([]string{"--proxy", "http://127.0.0.1:3128", "http://mirror.test/"}, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := newTransport(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://mirror.test/", "http://127.0.0.1:3128"},
+		{"https://mirror.test/", "http://127.0.0.1:3128"},
+		{"http://direct.test/", ""},
+		{"http://a.internal.test/", ""},
+		{"http://localhost/", ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := transport.Proxy(req)
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("proxy for %s = %q, %v; want %q", tt.url, got, err, tt.want)
+		}
+	}
+
+	if _, err := newTransport(options{proxy: "ftp://proxy.test:21"}); err == nil {
+		t.Error("ftp:// proxy accepted")
+	}
+	if _, err := newTransport(options{proxy: "not a url"}); err == nil {
+		t.Error("invalid proxy URL accepted")
+	}
+}
+
+// socksServer - минимальный SOCKS5-сервер: проверяет логин и пароль, если
+// они заданы, запоминает запрошенные адреса и соединяет любой CONNECT с
+// target
+type socksServer struct {
+	addr     string
+	mu       sync.Mutex
+	requests []string
+}
+
+func newSocksServer(t *testing.T, target, user, password string) *socksServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &socksServer{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, target, user, password)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) serve(conn net.Conn, target, user, password string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil
+		}
+		return buf
+	}
+
+	head := read(2)
+	if head == nil || head[0] != 5 || read(int(head[1])) == nil {
+		return
+	}
+	if user == "" {
+		conn.Write([]byte{5, 0})
+	} else {
+		conn.Write([]byte{5, 2})
+		// RFC 1929: версия, логин и пароль с длинами
+		head := read(2)
+		if head == nil {
+			return
+		}
+		gotUser := read(int(head[1]))
+		n := read(1)
+		if n == nil {
+			return
+		}
+		gotPassword := read(int(n[0]))
+		if string(gotUser) != user || string(gotPassword) != password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	req := read(4)
+	if req == nil || req[1] != 1 {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		n := read(1)
+		if n == nil {
+			return
+		}
+		host = "domain " + string(read(int(n[0])))
+	case 4:
+		host = net.IP(read(16)).String()
+	}
+	port := read(2)
+	if port == nil {
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, fmt.Sprintf("%s:%d", host, int(port[0])<<8|int(port[1])))
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, r)
+	io.Copy(conn, upstream)
+}
+
+func TestSocksProxy(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("via socks " + r.Host))
+	}))
+	defer site.Close()
+	target := strings.TrimPrefix(site.URL, "http://")
+
+	tests := []struct {
+		proxy, url string
+		request    string
+	}{
+		// С socks5h имя разрешает прокси
+		{"socks5h://%s", "http://mirror.test/", "domain mirror.test:80"},
+		// С socks5 прокси получает только IP
+		{"socks5://%s", "http://192.0.2.10/", "192.0.2.10:80"},
+		{"socks5h://user:pass@%s", "http://mirror.test/", "domain mirror.test:80"},
+	}
+	for _, tt := range tests {
+		user, password := "", ""
+		if strings.Contains(tt.proxy, "@") {
+			user, password = "user", "pass"
+		}
+		s := newSocksServer(t, target, user, password)
+		proxyURL := fmt.Sprintf(tt.proxy, s.addr)
+
+		dir := t.TempDir()
+		if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "0", "--proxy", proxyURL, tt.url); err != nil {
+			t.Fatalf("%s: %v", proxyURL, err)
+		}
+		host := strings.TrimSuffix(strings.TrimPrefix(tt.url, "http://"), "/")
+		if got := readMirrorFile(t, dir, host+"/index.html"); got != "via socks "+host {
+			t.Errorf("%s: index.html = %q", proxyURL, got)
+		}
+		s.mu.Lock()
+		if got := strings.Join(s.requests, ", "); got != tt.request {
+			t.Errorf("%s: proxy got %q, want %q", proxyURL, got, tt.request)
+		}
+		s.mu.Unlock()
+	}
+
+	// Неверный пароль - ошибка загрузки
+	s := newSocksServer(t, target, "user", "pass")
+	stats, err := testMirror(t, t.TempDir(), "-e", "robots=off", "-l", "0", "--tries", "1", "--proxy", "socks5h://user:wrong@"+s.addr, "http://mirror.test/")
+	if err == nil || stats.Failed != 1 {
+		t.Errorf("wrong SOCKS password: Failed = %d, %v; want 1 failure", stats.Failed, err)
+	}
+}
+
+// testCA - удостоверяющий центр для тестов TLS
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue выпускает сертификат сервера для 127.0.0.1 или клиента и
+// возвращает его и ключ в PEM
+func (ca *testCA) issue(t *testing.T, serial int64, client bool) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if client {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTLSOptions(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, 2, false)
+	clientCert, clientKey := ca.issue(t, 3, true)
+	files := t.TempDir()
+	caFile := writeTestFile(t, files, "ca.pem", ca.pem)
+	certFile := writeTestFile(t, files, "client.pem", clientCert)
+	keyFile := writeTestFile(t, files, "client.key", clientKey)
+	bundleFile := writeTestFile(t, files, "bundle.pem", append(append([]byte(nil), clientCert...), clientKey...))
+
+	pair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newServer := func(requireClient bool) *httptest.Server {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("secure"))
+		}))
+		srv.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+		srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+		if requireClient {
+			pool := x509.NewCertPool()
+			pool.AddCert(ca.cert)
+			srv.TLS.ClientCAs = pool
+			srv.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	plain, mutual := newServer(false), newServer(true)
+
+	tests := []struct {
+		srv  *httptest.Server
+		args []string
+		ok   bool
+	}{
+		{plain, nil, false},
+		{plain, []string{"--no-check-certificate"}, true},
+		{plain, []string{"--ca-certificate", caFile}, true},
+		{mutual, []string{"--ca-certificate", caFile}, false},
+		{mutual, []string{"--ca-certificate", caFile, "--certificate", certFile, "--private-key", keyFile}, true},
+		// Ключ в одном файле с сертификатом
+		{mutual, []string{"--ca-certificate", caFile, "--certificate", bundleFile}, true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		args := append([]string{"-e", "robots=off", "-l", "0", "--tries", "1"}, tt.args...)
+		stats, err := testMirror(t, dir, append(args, tt.srv.URL+"/")...)
+		if ok := err == nil && stats.Failed == 0; ok != tt.ok {
+			t.Errorf("%q: Failed = %d, %v; want success %v", tt.args, stats.Failed, err, tt.ok)
+			continue
+		}
+		if tt.ok {
+			name := strings.TrimPrefix(tt.srv.URL, "https://") + "/index.html"
+			if got := readMirrorFile(t, dir, name); got != "secure" {
+				t.Errorf("%q: index.html = %q", tt.args, got)
+			}
+		}
+	}
+
+	for _, opts := range []options{
+		{privateKey: keyFile},
+		{caCertificate: keyFile},
+		{caCertificate: filepath.Join(files, "missing.pem")},
+		{certificate: certFile, privateKey: caFile},
+	} {
+		if _, err := newTLSConfig(opts); err == nil {
+			t.Errorf("newTLSConfig(%+v) accepted", opts)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warcWriter дописывает записи WARC 1.0 в PREFIX.warc.gz, каждую отдельным
+// членом gzip. С --warc-max-size файлы нумеруются (PREFIX-00000.warc.gz) и
+// следующий начинается, когда текущий достиг лимита. Каждый файл
+// начинается с записи warcinfo
+type warcWriter struct {
+	mu      sync.Mutex
+	prefix  string
+	maxSize int64
+	part    int
+	f       *os.File
+	size    int64
+	err     error // первая ошибка записи; после нее записи отбрасываются
+}
+
+func newWARCWriter(prefix string, maxSize int64) (*warcWriter, error) {
+	w := &warcWriter{prefix: prefix, maxSize: maxSize}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *warcWriter) fileName() string {
+	if w.maxSize > 0 {
+		return fmt.Sprintf("%s-%05d.warc.gz", w.prefix, w.part)
+	}
+	return w.prefix + ".warc.gz"
+}
+
+// open открывает текущий файл для дописывания. Заполненные файлы прежних
+// запусков пропускаются
+func (w *warcWriter) open() error {
+	for {
+		f, err := os.OpenFile(w.fileName(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if w.maxSize > 0 && info.Size() >= w.maxSize {
+			f.Close()
+			w.part++
+			continue
+		}
+		w.f, w.size = f, info.Size()
+		return w.writeInfo()
+	}
+}
+
+// writeInfo записывает warcinfo в начало файла
+func (w *warcWriter) writeInfo() error {
+	fields := "software: webmirror\r\nformat: WARC File Format 1.0\r\n" +
+		"conformsTo: http://bibnum.bnf.fr/WARC/WARC_ISO_28500_version1_latestdraft.pdf\r\n"
+	sum := sha1.Sum([]byte(fields))
+	return w.writeRecord(warcRecord{
+		typ:         "warcinfo",
+		date:        time.Now(),
+		contentType: "application/warc-fields",
+		filename:    filepath.Base(w.fileName()),
+		block:       strings.NewReader(fields),
+		length:      int64(len(fields)),
+		blockDigest: warcDigest(sum[:]),
+	})
+}
+
+// rotate переходит к следующему файлу, если текущий достиг --warc-max-size
+func (w *warcWriter) rotate() error {
+	if w.maxSize <= 0 || w.size < w.maxSize {
+		return nil
+	}
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.part++
+	return w.open()
+}
+
+// warcRecord - запись WARC. block читается один раз при записи
+type warcRecord struct {
+	typ           string
+	id            string
+	date          time.Time
+	targetURI     string
+	contentType   string
+	concurrentTo  string
+	filename      string
+	truncated     string
+	block         io.Reader
+	length        int64
+	blockDigest   string
+	payloadDigest string
+}
+
+// writeRecord сжимает запись отдельным членом gzip и дописывает в файл.
+// Вызывается под mu
+func (w *warcWriter) writeRecord(r warcRecord) error {
+	if r.id == "" {
+		r.id = newRecordID()
+	}
+	var head strings.Builder
+	head.WriteString("WARC/1.0\r\n")
+	fmt.Fprintf(&head, "WARC-Type: %s\r\n", r.typ)
+	fmt.Fprintf(&head, "WARC-Record-ID: %s\r\n", r.id)
+	fmt.Fprintf(&head, "WARC-Date: %s\r\n", r.date.UTC().Format(time.RFC3339))
+	if r.targetURI != "" {
+		fmt.Fprintf(&head, "WARC-Target-URI: %s\r\n", r.targetURI)
+	}
+	if r.concurrentTo != "" {
+		fmt.Fprintf(&head, "WARC-Concurrent-To: %s\r\n", r.concurrentTo)
+	}
+	if r.filename != "" {
+		fmt.Fprintf(&head, "WARC-Filename: %s\r\n", r.filename)
+	}
+	if r.truncated != "" {
+		fmt.Fprintf(&head, "WARC-Truncated: %s\r\n", r.truncated)
+	}
+	fmt.Fprintf(&head, "WARC-Block-Digest: %s\r\n", r.blockDigest)
+	if r.payloadDigest != "" {
+		fmt.Fprintf(&head, "WARC-Payload-Digest: %s\r\n", r.payloadDigest)
+	}
+	fmt.Fprintf(&head, "Content-Type: %s\r\n", r.contentType)
+	fmt.Fprintf(&head, "Content-Length: %d\r\n\r\n", r.length)
+
+	counter := &countingWriter{w: w.f}
+	zw := gzip.NewWriter(counter)
+	io.WriteString(zw, head.String())
+	if _, err := io.Copy(zw, r.block); err != nil {
+		return err
+	}
+	io.WriteString(zw, "\r\n\r\n")
+	err := zw.Close()
+	w.size += counter.n
+	return err
+}
+
+// writeExchange записывает запрос и ответ одной выборки подряд, в один файл
+func (w *warcWriter) writeExchange(request, response warcRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return
+	}
+	if w.err = w.rotate(); w.err != nil {
+		return
+	}
+	response.id = newRecordID()
+	request.concurrentTo = response.id
+	if w.err = w.writeRecord(request); w.err != nil {
+		return
+	}
+	w.err = w.writeRecord(response)
+}
+
+// close закрывает текущий файл и возвращает первую ошибку записи
+func (w *warcWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.f.Close(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newRecordID возвращает WARC-Record-ID вида <urn:uuid:...> (UUID версии 4)
+func newRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// warcDigest записывает SHA-1 в принятом в WARC виде sha1:BASE32
+func warcDigest(sum []byte) string {
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum)
+}
+
+// warcTransport записывает каждую выборку в WARC: запрос с заголовками и
+// ответ со строкой статуса, заголовками и телом в том виде, в каком оно
+// пришло, до распаковки. Так в архив попадают и редиректы, и robots.txt
+type warcTransport struct {
+	next http.RoundTripper
+	warc *warcWriter
+}
+
+func (t *warcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	date := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	spool, err := os.CreateTemp("", "webmirror-warc-*")
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to buffer response for WARC: %w", err)
+	}
+	os.Remove(spool.Name())
+
+	head := responseHead(resp)
+	body := &warcBody{
+		ReadCloser: resp.Body,
+		transport:  t,
+		req:        req,
+		date:       date,
+		head:       head,
+		spool:      spool,
+		payload:    sha1.New(),
+		block:      sha1.New(),
+		// У HEAD, 204 и 304 тела нет, даже если оно не читалось
+		complete: req.Method == http.MethodHead || resp.ContentLength == 0 ||
+			resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified,
+	}
+	body.block.Write(head)
+	resp.Body = body
+	return resp, nil
+}
+
+// warcBody копирует тело ответа во временный файл по мере чтения и при
+// закрытии записывает выборку в WARC. Недочитанное тело записывается
+// с WARC-Truncated
+type warcBody struct {
+	io.ReadCloser
+	transport *warcTransport
+	req       *http.Request
+	date      time.Time
+	head      []byte
+	spool     *os.File
+	payload   hash.Hash
+	block     hash.Hash
+	length    int64
+	complete  bool
+	once      sync.Once
+}
+
+func (b *warcBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.spool.Write(p[:n])
+		b.payload.Write(p[:n])
+		b.block.Write(p[:n])
+		b.length += int64(n)
+	}
+	if errors.Is(err, io.EOF) {
+		b.complete = true
+	}
+	return n, err
+}
+
+func (b *warcBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.record)
+	return err
+}
+
+// record записывает запрос и ответ в WARC и освобождает временный файл
+func (b *warcBody) record() {
+	defer b.spool.Close()
+
+	requestHead := requestHead(b.req)
+	sum := sha1.Sum(requestHead)
+	request := warcRecord{
+		typ:         "request",
+		id:          newRecordID(),
+		date:        b.date,
+		targetURI:   b.req.URL.String(),
+		contentType: "application/http;msgtype=request",
+		block:       bytes.NewReader(requestHead),
+		length:      int64(len(requestHead)),
+		blockDigest: warcDigest(sum[:]),
+	}
+
+	if _, err := b.spool.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	response := warcRecord{
+		typ:           "response",
+		date:          b.date,
+		targetURI:     b.req.URL.String(),
+		contentType:   "application/http;msgtype=response",
+		block:         io.MultiReader(bytes.NewReader(b.head), io.LimitReader(b.spool, b.length)),
+		length:        int64(len(b.head)) + b.length,
+		blockDigest:   warcDigest(b.block.Sum(nil)),
+		payloadDigest: warcDigest(b.payload.Sum(nil)),
+	}
+	if !b.complete {
+		response.truncated = "unspecified"
+	}
+	b.transport.warc.writeExchange(request, response)
+}
+
+// requestHead восстанавливает строку запроса и заголовки в виде HTTP/1.1
+func requestHead(req *http.Request) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(&b, "Host: %s\r\n", host)
+	req.Header.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// responseHead восстанавливает строку статуса и заголовки ответа. Тело
+// в записи уже без chunked, поэтому Transfer-Encoding не выводится
+func responseHead(resp *http.Response) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWARCRecord - запись WARC, прочитанная readWARC
+type testWARCRecord struct {
+	header textproto.MIMEHeader
+	block  []byte
+}
+
+// readWARC читает файл .warc.gz и проверяет его формат: каждая запись -
+// отдельный член gzip с версией, обязательными полями, блоком длиной
+// Content-Length и верным WARC-Block-Digest
+func readWARC(t *testing.T, path string) []testWARCRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	var records []testWARCRecord
+	for {
+		zr.Multistream(false)
+		member, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%s: record %d: %v", path, len(records), err)
+		}
+		r := bufio.NewReader(bytes.NewReader(member))
+		if version, _ := r.ReadString('\n'); version != "WARC/1.0\r\n" {
+			t.Fatalf("%s: record %d starts with %q", path, len(records), version)
+		}
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err != nil {
+			t.Fatalf("%s: record %d: %v", path, len(records), err)
+		}
+		for _, name := range []string{"WARC-Type", "WARC-Record-ID", "WARC-Date", "Content-Length"} {
+			if header.Get(name) == "" {
+				t.Errorf("%s: record %d has no %s", path, len(records), name)
+			}
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			t.Fatalf("%s: record %d: bad Content-Length %q", path, len(records), header.Get("Content-Length"))
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r, block); err != nil {
+			t.Fatalf("%s: record %d: %v", path, len(records), err)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != "\r\n\r\n" {
+			t.Errorf("%s: record %d ends with %q", path, len(records), rest)
+		}
+		sum := sha1.Sum(block)
+		if got := header.Get("WARC-Block-Digest"); got != warcDigest(sum[:]) {
+			t.Errorf("%s: record %d: WARC-Block-Digest %s, want %s", path, len(records), got, warcDigest(sum[:]))
+		}
+		records = append(records, testWARCRecord{header: header, block: block})
+
+		if err := zr.Reset(br); errors.Is(err, io.EOF) {
+			return records
+		} else if err != nil {
+			t.Fatalf("%s: after record %d: %v", path, len(records), err)
+		}
+	}
+}
+
+func TestWARC(t *testing.T) {
+	page := []byte(strings.Repeat("<p>archived page</p>", 50) + `<img src="/logo.png"><a href="/old">old</a>`)
+	logo := bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0}, 200)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			// В WARC тело попадает сжатым, как пришло по сети
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compress(t, "gzip", page))
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(logo)
+		case "/old":
+			http.Redirect(w, r, "/new.html", http.StatusMovedPermanently)
+		case "/new.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("new"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "crawl")
+	if _, err := testMirror(t, filepath.Join(dir, "tree"), "-l", "1", "--warc-file", prefix, srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	records := readWARC(t, prefix+".warc.gz")
+
+	if len(records) == 0 || records[0].header.Get("WARC-Type") != "warcinfo" || records[0].header.Get("WARC-Filename") != "crawl.warc.gz" {
+		t.Fatalf("WARC does not start with warcinfo: %v", records)
+	}
+	uuid := regexp.MustCompile(`^<urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}>$`)
+	ids := make(map[string]bool)
+	responses := make(map[string]*http.Response)
+	payloads := make(map[string][]byte)
+	var truncatedURIs []string
+	for i, rec := range records {
+		id := rec.header.Get("WARC-Record-ID")
+		if !uuid.MatchString(id) || ids[id] {
+			t.Errorf("record %d: bad or repeated WARC-Record-ID %q", i, id)
+		}
+		ids[id] = true
+		if _, err := time.Parse(time.RFC3339, rec.header.Get("WARC-Date")); err != nil {
+			t.Errorf("record %d: %v", i, err)
+		}
+
+		switch rec.header.Get("WARC-Type") {
+		case "request":
+			// Запрос стоит перед своим ответом и ссылается на него
+			if i+1 >= len(records) || records[i+1].header.Get("WARC-Record-ID") != rec.header.Get("WARC-Concurrent-To") {
+				t.Errorf("record %d: request is not followed by its response", i)
+			}
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(rec.block)))
+			if err != nil {
+				t.Errorf("record %d: %v", i, err)
+			} else if target := rec.header.Get("WARC-Target-URI"); srv.URL+req.URL.RequestURI() != target {
+				t.Errorf("record %d: request for %s recorded as %s", i, req.URL, target)
+			}
+		case "response":
+			target := rec.header.Get("WARC-Target-URI")
+			if rec.header.Get("Content-Type") != "application/http;msgtype=response" {
+				t.Errorf("record %d: Content-Type %q", i, rec.header.Get("Content-Type"))
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rec.block)), nil)
+			if err != nil {
+				t.Errorf("record %d: %v", i, err)
+				continue
+			}
+			// Недочитанное тело (например, ответ 404 на robots.txt) короче
+			// заявленного и помечено WARC-Truncated
+			payload, err := io.ReadAll(resp.Body)
+			if truncated := rec.header.Get("WARC-Truncated") != ""; err != nil && !(truncated && errors.Is(err, io.ErrUnexpectedEOF)) {
+				t.Errorf("record %d: %v", i, err)
+			} else if truncated {
+				truncatedURIs = append(truncatedURIs, target)
+			}
+			sum := sha1.Sum(payload)
+			if got := rec.header.Get("WARC-Payload-Digest"); got != warcDigest(sum[:]) {
+				t.Errorf("record %d: WARC-Payload-Digest %s, want %s", i, got, warcDigest(sum[:]))
+			}
+			responses[target], payloads[target] = resp, payload
+		default:
+			if i != 0 {
+				t.Errorf("record %d: unexpected type %q", i, rec.header.Get("WARC-Type"))
+			}
+		}
+	}
+
+	// В архив попадают robots.txt и редиректы
+	for path, status := range map[string]int{"/robots.txt": 404, "/": 200, "/logo.png": 200, "/old": 301, "/new.html": 200} {
+		if resp := responses[srv.URL+path]; resp == nil || resp.StatusCode != status {
+			t.Errorf("%s: response %v, want status %d", path, resp, status)
+		}
+	}
+	for _, uri := range truncatedURIs {
+		if uri != srv.URL+"/robots.txt" {
+			t.Errorf("%s recorded as truncated", uri)
+		}
+	}
+	if got := payloads[srv.URL+"/"]; !bytes.Equal(got, compress(t, "gzip", page)) {
+		t.Errorf("index payload is not the compressed body (%d bytes)", len(got))
+	}
+	if resp := responses[srv.URL+"/"]; resp != nil && resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("index headers %v", resp.Header)
+	}
+	if got := payloads[srv.URL+"/logo.png"]; !bytes.Equal(got, logo) {
+		t.Errorf("logo payload has %d bytes, want %d", len(got), len(logo))
+	}
+	// Дерево файлов без --warc-only сохраняется как обычно
+	if got := readMirrorFile(t, hostDirOf(filepath.Join(dir, "tree"), srv), "logo.png"); got != string(logo) {
+		t.Errorf("logo.png in the tree has %d bytes", len(got))
+	}
+}
+
+func TestWARCMaxSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			for i := 0; i < 6; i++ {
+				w.Write([]byte(`<a href="/f` + strconv.Itoa(i) + `.bin">f</a>`))
+			}
+			return
+		}
+		w.Write(bytes.Repeat([]byte(r.URL.Path), 1000))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "crawl")
+	if _, err := testMirror(t, filepath.Join(dir, "tree"), "-e", "robots=off", "-l", "1", "--warc-file", prefix, "--warc-max-size", "1k", srv.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(prefix + "-*.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("%d WARC files, want several", len(files))
+	}
+	responses := 0
+	for _, file := range files {
+		records := readWARC(t, file)
+		if records[0].header.Get("WARC-Type") != "warcinfo" || records[0].header.Get("WARC-Filename") != filepath.Base(file) {
+			t.Errorf("%s does not start with its warcinfo", file)
+		}
+		for _, rec := range records {
+			if rec.header.Get("WARC-Type") == "response" {
+				responses++
+			}
+		}
+	}
+	if responses != 7 {
+		t.Errorf("%d responses in %d files, want 7", responses, len(files))
+	}
+}
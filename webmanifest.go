@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// noteWebManifest запоминает, что rawURL - манифест веб-приложения из
+// <link rel="manifest">: его ссылки переписываются, даже если сервер отдает
+// его как обычный application/json
+func (d *downloader) noteWebManifest(rawURL string) {
+	key, ok := d.linkKey(rawURL)
+	if !ok {
+		return
+	}
+	// Манифест без расширения - не страница
+	d.keepName(key)
+
+	d.namesMutex.Lock()
+	defer d.namesMutex.Unlock()
+	d.webManifests[key] = true
+}
+
+// isWebManifest определяет по типу ответа и по ссылке на него, является ли
+// rawURL манифестом веб-приложения
+func (d *downloader) isWebManifest(rawURL string, contentType string) bool {
+	switch mediaType(contentType) {
+	case "application/manifest+json":
+		return true
+	case "application/json", "text/plain", "":
+		d.namesMutex.Lock()
+		defer d.namesMutex.Unlock()
+		return d.webManifests[rawURL]
+	}
+	return false
+}
+
+// isSavedWebManifest определяет, является ли уже сохраненный файл
+// манифестом веб-приложения
+func (d *downloader) isSavedWebManifest(rawURL string, savePath string) bool {
+	if strings.ToLower(filepath.Ext(savePath)) == ".webmanifest" {
+		return true
+	}
+	e, _ := d.index.get(rawURL)
+	return d.isWebManifest(rawURL, e.ContentType)
+}
+
+// requestFavicon ставит в очередь /favicon.ico хоста страницы pageURL:
+// браузер запрашивает его сам, даже если страница на него не ссылается
+func (d *downloader) requestFavicon(pageURL *url.URL, depth int) {
+	if d.noFavicon || d.spider {
+		return
+	}
+	favicon := &url.URL{Scheme: pageURL.Scheme, Host: pageURL.Host, Path: "/favicon.ico"}
+	d.enqueue(job{url: favicon.String(), depth: depth + 1, referer: pageURL.String(), kind: kindRequisite, implied: true})
+}
+
+// rewriteWebManifest вызывает fn для start_url и src каждой иконки из
+// icons манифеста и подставляет в текст то, что fn вернет
+func rewriteWebManifest(content []byte, fn func(ref string, kind resourceKind) string) ([]byte, error) {
+	return rewriteJSON(content, func(stack []jsonFrame, value string) string {
+		kind, ok := webManifestLink(stack)
+		if ref := strings.TrimSpace(value); ok && ref != "" {
+			return fn(ref, kind)
+		}
+		return value
+	})
+}
+
+// webManifestLink сообщает, содержит ли строка по пути stack ссылку:
+// start_url верхнего уровня (страница) или icons[].src (ресурс)
+func webManifestLink(stack []jsonFrame) (resourceKind, bool) {
+	switch {
+	case len(stack) == 1 && stack[0].object && stack[0].key == "start_url":
+		return kindPage, true
+	case len(stack) == 3 && stack[0].object && stack[0].key == "icons" &&
+		!stack[1].object && stack[2].object && stack[2].key == "src":
+		return kindRequisite, true
+	}
+	return kindPage, false
+}
+
+// processWebManifest ставит в очередь иконки манифеста и start_url (если
+// recurse) и переписывает ссылки на них относительно файла манифеста
+func (d *downloader) processWebManifest(content []byte, baseURL *url.URL, savePath string, depth int, recurse bool) []byte {
+	rewritten, err := rewriteWebManifest(content, func(ref string, kind resourceKind) string {
+		// Иконки, как и ресурсы стилей, нужны на любой глубине
+		return d.rewriteLink(ref, baseURL, savePath, depth, recurse || kind == kindRequisite, kind)
+	})
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: baseURL.String(), err: err}, "Failed to parse web app manifest: %v", err)
+		return content
+	}
+	return rewritten
+}
+
+// saveWebManifest переписывает ссылки манифеста и сохраняет его
+func (d *downloader) saveWebManifest(rawURL string, attempts int, content []byte, pageURL *url.URL, savePath string, depth int, recurse bool, resp *http.Response, start time.Time) {
+	content = d.processWebManifest(content, pageURL, savePath, depth, recurse)
+
+	savePath, n, err := d.saveContent(rawURL, savePath, content, resp.Header, false)
+	d.addBytes(n)
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to save %q: %w", savePath, err))
+		return
+	}
+	d.saved(rawURL, pageURL.String(), resp.StatusCode, savePath, n, resp.Header, false)
+	d.verbosef(logEntry{event: "saved", url: rawURL, status: resp.StatusCode, bytes: n, duration: time.Since(start)},
+		"Saved %s (%d bytes)", savePath, n)
+}
+
+// processLocalWebManifest продолжает обход по уже сохраненному манифесту
+func (d *downloader) processLocalWebManifest(savePath string, pageURL *url.URL, depth int) {
+	content, err := d.readSaved(savePath)
+	if err != nil {
+		d.errorf(logEntry{event: "parse", url: pageURL.String(), err: err}, "Failed to read %q: %v", savePath, err)
+		return
+	}
+	rewriteWebManifest(content, func(ref string, kind resourceKind) string {
+		d.followLocalLink(ref, pageURL, savePath, depth, kind)
+		return ref
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRewriteWebManifest(t *testing.T) {
+	manifest := `{
+  "name": "App with \"quotes\"",
+  "start_url": "\/app\/?source=pwa",
+  "src": "not-an-icon.png",
+  "icons": [
+    {"src": "icons/192.png", "sizes": "192x192", "type": "image/png"},
+    {"sizes": "512x512", "purpose": "any maskable", "src": " /icons/512.png "},
+    {"src": "data:image/png;base64,AAAA"}
+  ],
+  "shortcuts": [{"url": "/today", "icons": [{"src": "/icons/today.png"}]}],
+  "screenshots": [{"src": "/shots/1.png"}]
+}`
+	var refs []string
+	got, err := rewriteWebManifest([]byte(manifest), func(ref string, kind resourceKind) string {
+		name := "requisite"
+		if kind == kindPage {
+			name = "page"
+		}
+		refs = append(refs, name+" "+ref)
+		if strings.HasPrefix(ref, "data:") {
+			return ref
+		}
+		return "local/" + strings.TrimPrefix(ref, "/")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"page /app/?source=pwa", "requisite icons/192.png", "requisite /icons/512.png", "requisite data:image/png;base64,AAAA"}
+	if strings.Join(refs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("refs:\n%s\nwant:\n%s", strings.Join(refs, "\n"), strings.Join(want, "\n"))
+	}
+
+	var parsed struct {
+		Name     string `json:"name"`
+		StartURL string `json:"start_url"`
+		Src      string `json:"src"`
+		Icons    []struct {
+			Src, Sizes, Purpose string
+		} `json:"icons"`
+		Shortcuts []struct {
+			URL   string `json:"url"`
+			Icons []struct{ Src string }
+		} `json:"shortcuts"`
+	}
+	if err := json.Unmarshal(got, &parsed); err != nil {
+		t.Fatalf("rewritten manifest is not JSON: %v\n%s", err, got)
+	}
+	if parsed.Name != `App with "quotes"` || parsed.StartURL != "local/app/?source=pwa" || parsed.Src != "not-an-icon.png" {
+		t.Errorf("rewritten manifest: %+v", parsed)
+	}
+	if len(parsed.Icons) != 3 || parsed.Icons[0].Src != "local/icons/192.png" || parsed.Icons[1].Src != "local/icons/512.png" ||
+		parsed.Icons[1].Purpose != "any maskable" || parsed.Icons[1].Sizes != "512x512" || parsed.Icons[2].Src != "data:image/png;base64,AAAA" {
+		t.Errorf("icons: %+v", parsed.Icons)
+	}
+	if len(parsed.Shortcuts) != 1 || parsed.Shortcuts[0].URL != "/today" || parsed.Shortcuts[0].Icons[0].Src != "/icons/today.png" {
+		t.Errorf("shortcuts changed: %+v", parsed.Shortcuts)
+	}
+	// Форматирование сохраняется
+	if !strings.Contains(string(got), "\n    {\"src\": \"local/icons/192.png\", \"sizes\": \"192x192\"") {
+		t.Errorf("formatting lost:\n%s", got)
+	}
+}
+
+func TestFaviconAndManifest(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/site/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<link rel="icon" href="/static/icon.png" sizes="32x32"><link rel="apple-touch-icon" href="/static/apple.png">
+<link rel="manifest" href="/site/app.json">`))
+		case "/site/app.json":
+			// Обычный JSON: манифестом его делает ссылка rel="manifest"
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"start_url": "/site/", "icons": [{"src": "icons/any.png", "sizes": "192x192", "purpose": "any"}, {"src": "/static/mask.png", "sizes": "512x512", "purpose": "maskable"}]}`))
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("icon"))
+		}
+	}))
+	defer srv.Close()
+
+	// Иконки манифеста лежат глубже -l 1 и, как ресурсы стилей, нужны -p
+	dir := t.TempDir()
+	if _, err := testMirror(t, dir, "-e", "robots=off", "-l", "1", "-p", "--no-favicon=false", srv.URL+"/site/"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	for _, path := range []string{"/favicon.ico", "/static/icon.png", "/static/apple.png", "/site/app.json", "/site/icons/any.png", "/static/mask.png"} {
+		if !requested[path] {
+			t.Errorf("%s not requested", path)
+		}
+	}
+	mu.Unlock()
+
+	host := hostDirOf(dir, srv)
+	page := readMirrorFile(t, host, "site/index.html")
+	for _, want := range []string{`href="../static/icon.png"`, `href="../static/apple.png"`, `href="app.json"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("index.html has no %s:\n%s", want, page)
+		}
+	}
+	if got := readMirrorFile(t, host, "site/app.json"); got != `{"start_url": "index.html", "icons": [{"src": "icons/any.png", "sizes": "192x192", "purpose": "any"}, {"src": "../static/mask.png", "sizes": "512x512", "purpose": "maskable"}]}` {
+		t.Errorf("app.json = %s", got)
+	}
+	if got := readMirrorFile(t, host, "favicon.ico"); got != "icon" {
+		t.Errorf("favicon.ico = %q", got)
+	}
+}
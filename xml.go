@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// xmlTypes - типы содержимого, ответы с которыми могут оказаться лентой
+// или SVG. Для общего XML это определяется по корневому элементу
+var xmlTypes = map[string]bool{
+	"application/rss+xml":  true,
+	"application/atom+xml": true,
+	"application/rdf+xml":  true,
+	"application/xml":      true,
+	"text/xml":             true,
+	"image/svg+xml":        true,
+}
+
+// mayBeXML проверяет, стоит ли читать ответ целиком и искать в нем ссылки
+func mayBeXML(contentType string) bool {
+	return xmlTypes[mediaType(contentType)]
+}
+
+// newXMLDecoder читает XML без перекодирования: смещения токенов должны
+// указывать в исходный текст, а ссылки и так ASCII
+func newXMLDecoder(content []byte) *xml.Decoder {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+	dec.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return dec
+}
+
+// xmlRoot возвращает локальное имя корневого элемента или "", если это
+// не XML
+func xmlRoot(content []byte) string {
+	dec := newXMLDecoder(content)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+// xmlEdit - замена участка текста XML-документа
+type xmlEdit struct {
+	start, end int
+	value      string
+}
+
+// applyXMLEdits применяет замены к тексту. Документ правится на месте,
+// поэтому пространства имен, CDATA и форматирование сохраняются
+func applyXMLEdits(content []byte, edits []xmlEdit) []byte {
+	sort.Slice(edits, func(a, b int) bool {
+		return edits[a].start < edits[b].start
+	})
+	var buf bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		buf.Write(content[pos:e.start])
+		buf.WriteString(e.value)
+		pos = e.end
+	}
+	buf.Write(content[pos:])
+	return buf.Bytes()
+}
+
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// saveXML сохраняет XML-ответ. Лента и SVG сохраняются с переписанными
+// ссылками, лента учитывается как страница, прочий XML - как обычный файл
+func (d *downloader) saveXML(rawURL string, attempts int, content []byte, pageURL *url.URL, savePath string, depth int, recurse bool, resp *http.Response, start time.Time) {
+	page := false
+	switch root := xmlRoot(content); {
+	case isFeedRoot(root):
+		content = d.processFeed(content, pageURL, savePath, depth, recurse)
+		page = true
+	case root == "svg":
+		content = d.processSVG(content, pageURL, savePath, depth)
+	}
+
+	savePath, n, err := d.saveContent(rawURL, savePath, content, resp.Header, false)
+	d.addBytes(n)
+	if err != nil {
+		d.fail(rawURL, attempts, fmt.Errorf("failed to save %q: %w", savePath, err))
+		return
+	}
+	d.saved(rawURL, pageURL.String(), resp.StatusCode, savePath, n, resp.Header, page)
+	d.verbosef(logEntry{event: "saved", url: rawURL, status: resp.StatusCode, bytes: n, duration: time.Since(start)},
+		"Saved %s (%d bytes)", savePath, n)
+}
+
+// processSaved продолжает обход по уже сохраненной странице, стилю, ленте,
+// SVG, манифесту веб-приложения или скрипту (с --scan-js)
+func (d *downloader) processSaved(rawURL string, savePath string, pageURL *url.URL, depth int) {
+	if d.isSavedHTML(rawURL, savePath) {
+		d.processLocalHTML(savePath, pageURL, depth)
+		return
+	}
+	if d.isSavedCSS(rawURL, savePath) {
+		d.processLocalCSS(savePath, pageURL, depth)
+		return
+	}
+	if d.isSavedWebManifest(rawURL, savePath) {
+		d.processLocalWebManifest(savePath, pageURL, depth)
+		return
+	}
+	if d.scanJS && d.isSavedJS(rawURL, savePath) {
+		d.processLocalJS(savePath, pageURL, depth)
+		return
+	}
+
+	if e, ok := d.index.get(rawURL); !ok || !mayBeXML(e.ContentType) {
+		switch strings.ToLower(filepath.Ext(savePath)) {
+		case ".xml", ".rss", ".atom", ".svg":
+		default:
+			return
+		}
+	}
+	content, err := d.readSaved(savePath)
+	if err != nil {
+		return
+	}
+	follow := func(ref string, kind resourceKind) string {
+		d.followLocalLink(ref, pageURL, savePath, depth, kind)
+		return ref
+	}
+	switch root := xmlRoot(content); {
+	case isFeedRoot(root):
+		rewriteFeed(content, follow)
+	case root == "svg":
+		rewriteSVG(content, follow)
+	}
+}